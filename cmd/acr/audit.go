@@ -0,0 +1,166 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/pkg/errors"
+)
+
+// The reasons a tag or a manifest was selected for deletion, as written in the audit records.
+const (
+	selectionAge      = "age"
+	selectionFilter   = "filter"
+	selectionMaxTags  = "max-tags"
+	selectionExplicit = "explicit"
+	selectionDangling = "dangling"
+	selectionCascade  = "cascade"
+	selectionReferrer = "referrer"
+)
+
+// auditHeader is the first line of a CSV audit file, its columns are part of the CLI interface so they mustn't be
+// reordered.
+var auditHeader = []string{"timestamp", "registry", "repository", "tag", "digest", "size", "reason", "archived"}
+
+// auditRecord is a deleted tag or manifest in an audit file. Size is the size of the config and layers of a deleted
+// manifest, it's not set for a tag since untagging doesn't free any storage by itself. Archived is always false,
+// moving the items to an archive repository isn't supported.
+type auditRecord struct {
+	Timestamp  string `json:"timestamp"`
+	Registry   string `json:"registry"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	Size       *int64 `json:"size,omitempty"`
+	Reason     string `json:"reason"`
+	Archived   bool   `json:"archived"`
+}
+
+// auditLog appends a record to an --audit-file for every deletion, as JSON lines when the file name ends with .jsonl
+// and as CSV otherwise. It's safe to use from the deletion workers. Every record is written to the file as soon as
+// the deletion succeeded, with a single write, so an interrupted run still leaves the records of what it deleted.
+type auditLog struct {
+	mu    sync.Mutex
+	file  *os.File
+	jsonl bool
+	// err is the first write error, the deletions go on and it's returned by close.
+	err error
+}
+
+// openAuditLog opens the audit file at path for appending, the CSV header is only written to a new or empty file.
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open the audit file")
+	}
+	audit := &auditLog{file: file, jsonl: strings.EqualFold(filepath.Ext(path), ".jsonl")}
+	info, err := file.Stat()
+	if err == nil && !audit.jsonl && info.Size() == 0 {
+		audit.writeCSV(auditHeader)
+		err = audit.err
+	}
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "unable to write the audit file")
+	}
+	return audit, nil
+}
+
+// write appends record to the audit file.
+func (l *auditLog) write(record auditRecord) {
+	if l.jsonl {
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return
+		}
+		l.writeLine(append(encoded, '\n'))
+		return
+	}
+	size := ""
+	if record.Size != nil {
+		size = strconv.FormatInt(*record.Size, 10)
+	}
+	l.writeCSV([]string{record.Timestamp, record.Registry, record.Repository, record.Tag, record.Digest, size, record.Reason,
+		strconv.FormatBool(record.Archived)})
+}
+
+func (l *auditLog) writeCSV(fields []string) {
+	var line bytes.Buffer
+	writer := csv.NewWriter(&line)
+	writer.Write(fields)
+	writer.Flush()
+	l.writeLine(line.Bytes())
+}
+
+func (l *auditLog) writeLine(line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(line); err != nil && l.err == nil {
+		l.err = err
+	}
+}
+
+// close closes the audit file and returns the first error of the run.
+func (l *auditLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.Close(); err != nil && l.err == nil {
+		l.err = err
+	}
+	if l.err != nil {
+		return errors.Wrap(l.err, "unable to write the audit file")
+	}
+	return nil
+}
+
+// newAuditRecord returns the audit record of the deleted result.
+func (r *purgeResults) newAuditRecord(result purgeResult) auditRecord {
+	record := auditRecord{
+		Timestamp:  time.Now().UTC().Format(time.RFC3339Nano),
+		Registry:   r.loginURL,
+		Repository: result.Repository,
+		Tag:        result.Tag,
+		Digest:     result.Digest,
+		Size:       result.size,
+		Reason:     result.selection,
+	}
+	if len(record.Digest) == 0 {
+		record.Digest = result.tagDigest
+	}
+	return record
+}
+
+// manifestSize returns the size of the config and layers of the manifest digest, or nil when it can't be pulled.
+// It's only called when auditing since it costs a request per deleted manifest.
+func manifestSize(ctx context.Context, acrClient api.AcrCLIClientInterface, repoName string, digest string) *int64 {
+	manifest, err := acrClient.AcrGetManifest(ctx, repoName, digest)
+	if err != nil {
+		return nil
+	}
+	size := sumManifestSize(manifest)
+	return &size
+}
+
+// sumManifestSize returns the size of the config and layers of manifest, the layers shared with other images count.
+func sumManifestSize(manifest *api.Manifest) int64 {
+	var size int64
+	if manifest.Config != nil {
+		size += manifest.Config.Size
+	}
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size
+}
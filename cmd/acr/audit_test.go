@@ -0,0 +1,115 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPurgeAuditFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.csv")
+
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "v1", "v2")
+	registry.addManifest("repo", testDigest(2), old)
+	registry.setLayers("repo", testDigest(2), map[string]int64{"sha256:l1": 100, "sha256:l2": 20})
+	registry.addManifest("repo", testDigest(3), old, "v3")
+	registry.lock("repo", "v3")
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", output: outputText, logFormat: logFormatText, auditFile: path}
+	if err := runPurge(context.Background(), registry, ioutil.Discard, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// A second run appends to the file without repeating the header.
+	registry.addManifest("repo", testDigest(4), old, "v4")
+	parameters.filter = "^v4$"
+	if err := runPurge(context.Background(), registry, ioutil.Discard, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(records[0], auditHeader) {
+		t.Fatalf("expected the header first, got %v", records[0])
+	}
+	var rows []string
+	for _, record := range records[1:] {
+		if _, err := time.Parse(time.RFC3339Nano, record[0]); err != nil {
+			t.Fatalf("invalid timestamp in %v", record)
+		}
+		rows = append(rows, strings.Join(record[1:], ","))
+	}
+	if len(rows) != 6 {
+		t.Fatalf("expected 6 audit records, got\n%s", strings.Join(rows, "\n"))
+	}
+	// The items are deleted concurrently so the records of a run may be in any order.
+	sort.Strings(rows[:4])
+	sort.Strings(rows[4:])
+	expected := []string{
+		"registry.azurecr.io,repo,," + testDigest(1) + ",0,dangling,false",
+		"registry.azurecr.io,repo,," + testDigest(2) + ",120,dangling,false",
+		"registry.azurecr.io,repo,v1," + testDigest(1) + ",,age,false",
+		"registry.azurecr.io,repo,v2," + testDigest(1) + ",,age,false",
+		"registry.azurecr.io,repo,," + testDigest(4) + ",0,dangling,false",
+		"registry.azurecr.io,repo,v4," + testDigest(4) + ",,filter,false",
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("audit records incorrect, got\n%s\nexpected\n%s", strings.Join(rows, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestPurgeAuditFileJSONLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now().Add(-72*time.Hour), "v1")
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", tags: []string{"v1", "missing"}, output: outputText, logFormat: logFormatText, auditFile: path}
+	if err := runPurge(context.Background(), registry, ioutil.Discard, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("only the deleted tag should be audited, got %q", content)
+	}
+	var record auditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	record.Timestamp = ""
+	if !reflect.DeepEqual(record, auditRecord{Registry: "registry.azurecr.io", Repository: "repo", Tag: "v1", Reason: selectionExplicit}) {
+		t.Fatalf("audit record incorrect, got %+v", record)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+// The benchmarks run against benchmarkRegistry, which answers without any latency and without storing the
+// deletions, so they measure the CPU and allocation overhead of the purge pipeline rather than the network. Every
+// iteration purges a repository of benchmarkItems old items listed in pages of benchmarkPageSize. Run them with
+//
+//	go test -run NONE -bench 'PurgeTags$|PurgeDanglingManifests$' -benchmem ./cmd/acr
+//
+// On a single core VM the baselines were, per iteration:
+//
+//	BenchmarkPurgeTags/concurrency-1                 about 7ms, 3MB and 160 allocations
+//	BenchmarkPurgeTags/concurrency-100               about 5ms, 3MB and 260 allocations
+//	BenchmarkPurgeDanglingManifests/concurrency-1    about 11ms, 5.4MB and 10k allocations
+//	BenchmarkPurgeDanglingManifests/concurrency-100  about 13ms, 5.4MB and 10k allocations
+//
+// The timings vary by a few milliseconds between runs. The stub doesn't wait, so the differences between the
+// concurrencies come from the scheduling of the goroutines rather than from overlapping requests.
+const (
+	benchmarkItems    = 5000
+	benchmarkPageSize = 100
+)
+
+var benchmarkConcurrencies = []int{1, 10, defaultConcurrency}
+
+// benchmarkRegistry serves benchmarkItems old tags, each on its own dangling manifest, from pages built once and
+// accepts every deletion.
+type benchmarkRegistry struct {
+	api.AcrCLIClientInterface
+	tags      []acrapi.TagAttributesBase
+	manifests []acrapi.ManifestAttributesBase
+	tagIndex  map[string]int
+	digests   map[string]int
+}
+
+func newBenchmarkRegistry() *benchmarkRegistry {
+	registry := &benchmarkRegistry{tagIndex: map[string]int{}, digests: map[string]int{}}
+	lastUpdateTime := time.Now().Add(-72 * time.Hour).UTC().Format(time.RFC3339Nano)
+	for i := 0; i < benchmarkItems; i++ {
+		name := fmt.Sprintf("v%05d", i)
+		digest := testDigest(i)
+		registry.tags = append(registry.tags, acrapi.TagAttributesBase{Name: &name, Digest: &digest, LastUpdateTime: &lastUpdateTime})
+		registry.manifests = append(registry.manifests, acrapi.ManifestAttributesBase{Digest: &digest, LastUpdateTime: &lastUpdateTime})
+		registry.tagIndex[name] = i + 1
+		registry.digests[digest] = i + 1
+	}
+	return registry
+}
+
+// benchmarkPage returns the bounds of the page following the item at index last, 0 being before the first item.
+func benchmarkPage(last int) (int, int) {
+	end := last + benchmarkPageSize
+	if end > benchmarkItems {
+		end = benchmarkItems
+	}
+	return last, end
+}
+
+func (r *benchmarkRegistry) AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error) {
+	start, end := benchmarkPage(r.tagIndex[last])
+	if start == end {
+		return &acrapi.TagAttributeList{}, nil
+	}
+	tags := r.tags[start:end]
+	return &acrapi.TagAttributeList{Tags: &tags}, nil
+}
+
+func (r *benchmarkRegistry) AcrDeleteTag(ctx context.Context, repoName string, reference string) error {
+	return nil
+}
+
+func (r *benchmarkRegistry) AcrListManifests(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.ManifestAttributeList, error) {
+	start, end := benchmarkPage(r.digests[last])
+	if start == end {
+		return &acrapi.ManifestAttributeList{}, nil
+	}
+	manifests := r.manifests[start:end]
+	return &acrapi.ManifestAttributeList{Manifests: &manifests}, nil
+}
+
+func (r *benchmarkRegistry) DeleteManifest(ctx context.Context, repoName string, reference string) error {
+	return nil
+}
+
+func BenchmarkPurgeTags(b *testing.B) {
+	registry := newBenchmarkRegistry()
+	for _, concurrency := range benchmarkConcurrencies {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+				deleted, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d", concurrency: concurrency})
+				if err != nil || deleted != benchmarkItems {
+					b.Fatalf("expected %d deleted tags, got %d and %v", benchmarkItems, deleted, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPurgeDanglingManifests(b *testing.B) {
+	registry := newBenchmarkRegistry()
+	for _, concurrency := range benchmarkConcurrencies {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+				deleted, err := PurgeDanglingManifests(context.Background(), registry, results, "repo", manifestPurgeOptions{ago: "1d", concurrency: concurrency})
+				if err != nil || deleted != benchmarkItems {
+					b.Fatalf("expected %d deleted manifests, got %d and %v", benchmarkItems, deleted, err)
+				}
+			}
+		})
+	}
+}
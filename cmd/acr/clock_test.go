@@ -0,0 +1,118 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// pinNow makes nowFunc return now until the returned function is called.
+func pinNow(now time.Time) func() {
+	previous := nowFunc
+	nowFunc = func() time.Time { return now }
+	return func() { nowFunc = previous }
+}
+
+func TestPurgeTagsBoundaryAge(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	defer pinNow(now)()
+	cutoff := now.Add(-24 * time.Hour)
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), cutoff.Add(-time.Nanosecond), "before")
+	registry.addManifest("repo", testDigest(2), cutoff, "at")
+	registry.addManifest("repo", testDigest(3), cutoff.Add(time.Nanosecond), "after")
+	registry.addManifest("repo", testDigest(4), now.Add(-72*time.Hour), "window-start")
+	registry.addManifest("repo", testDigest(5), now.Add(-72*time.Hour-time.Nanosecond), "older")
+
+	// The tags last updated strictly before the cutoff are deleted, down to the start of the --newer-than window.
+	since, err := tagWindowStart("1d", "3d", nowFunc())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	deleted, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d", since: since})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	deletedTags := append([]string(nil), registry.deletedTags["repo"]...)
+	sort.Strings(deletedTags)
+	if deleted != 2 || !reflect.DeepEqual(deletedTags, []string{"before", "window-start"}) {
+		t.Fatalf("expected before and window-start to be deleted, got %d deleted: %v", deleted, deletedTags)
+	}
+
+	// The ages of --where are counted from the same time.
+	where, err := parseWhere("age >= 72h")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	deleted, err = PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "0d", where: where})
+	if err != nil || deleted != 1 || registry.deletedTags["repo"][2] != "older" {
+		t.Fatalf("expected older to be deleted, got %d deleted: %v, %v", deleted, registry.deletedTags["repo"], err)
+	}
+}
+
+func TestPurgeDanglingManifestsBoundaryAge(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	defer pinNow(now)()
+	cutoff := now.Add(-12 * time.Hour)
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), cutoff.Add(-time.Nanosecond))
+	registry.addManifest("repo", testDigest(2), cutoff)
+	registry.addManifest("repo", testDigest(3), cutoff.Add(time.Nanosecond))
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	deleted, err := PurgeDanglingManifests(context.Background(), registry, results, "repo", manifestPurgeOptions{ago: "12h"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deleted != 1 || !reflect.DeepEqual(registry.deletedManifests["repo"], []string{testDigest(1)}) {
+		t.Fatalf("expected only the manifest updated before the cutoff to be deleted, got %d deleted: %v", deleted, registry.deletedManifests["repo"])
+	}
+}
+
+func TestPurgeAgeSkew(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	defer pinNow(now)()
+	skew := 5 * time.Second
+	cutoff := now.Add(-24 * time.Hour)
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), cutoff.Add(-skew-time.Nanosecond), "clearly-old")
+	registry.addManifest("repo", testDigest(2), cutoff.Add(-skew), "skew")
+	registry.addManifest("repo", testDigest(3), cutoff.Add(-time.Nanosecond), "boundary")
+	registry.addManifest("repo", testDigest(4), cutoff.Add(-skew-time.Nanosecond))
+	registry.addManifest("repo", testDigest(5), cutoff.Add(-time.Nanosecond))
+
+	// Only the items updated before the cutoff moved back by the skew are deleted.
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	deleted, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d", skew: skew})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deleted != 1 || !reflect.DeepEqual(registry.deletedTags["repo"], []string{"clearly-old"}) {
+		t.Fatalf("expected only clearly-old to be deleted, got %d deleted: %v", deleted, registry.deletedTags["repo"])
+	}
+	deleted, err = PurgeDanglingManifests(context.Background(), registry, results, "repo", manifestPurgeOptions{ago: "1d", skew: skew})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	deletedManifests := append([]string(nil), registry.deletedManifests["repo"]...)
+	sort.Strings(deletedManifests)
+	if deleted != 2 || !reflect.DeepEqual(deletedManifests, []string{testDigest(1), testDigest(4)}) {
+		t.Fatalf("expected the manifests updated before the skewed cutoff to be deleted, got %d deleted: %v", deleted, deletedManifests)
+	}
+
+	// A cutoff given as a time isn't moved.
+	deleted, err = PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: cutoff.Format(time.RFC3339Nano), skew: skew})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sort.Strings(registry.deletedTags["repo"])
+	if deleted != 2 || !reflect.DeepEqual(registry.deletedTags["repo"], []string{"boundary", "clearly-old", "skew"}) {
+		t.Fatalf("expected skew and boundary to be deleted, got %d deleted: %v", deleted, registry.deletedTags["repo"])
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	completionLongMessage = `acr completion: print the shell completion script for bash, zsh, fish or powershell.
+
+The --repository values are completed by listing the repositories of the registry given with --registry, using the
+credentials of the command line. Nothing is proposed when they aren't available. This isn't supported by zsh and
+powershell.`
+	completionExampleMessage = `
+Load the completion in the current bash shell
+  source <(acr completion bash)
+
+Install the completion for fish
+  acr completion fish > ~/.config/fish/completions/acr.fish`
+
+	// completeRepositoriesCmd is the hidden command printing the repositories for the completion scripts.
+	completeRepositoriesCmd = "__repositories"
+	// completionTimeout bounds the time the shell waits for the repositories.
+	completionTimeout = 5 * time.Second
+)
+
+// bashCompletionFunctions are the custom completions referenced by the flag annotations. The command line is passed
+// to the hidden command so it can use the registry and the credentials already typed.
+const bashCompletionFunctions = `
+__acr_get_repositories()
+{
+    local out
+    if out=$(acr ` + completeRepositoriesCmd + ` "${words[@]:1}" 2>/dev/null); then
+        COMPREPLY=( $( compgen -W "${out}" -- "$cur" ) )
+    fi
+}
+
+__acr_get_filter_hints()
+{
+    COMPREPLY=( $( compgen -W "'^v[0-9]+' '^latest$' '-rc[0-9]*$' '^dev-'" -- "$cur" ) )
+}
+`
+
+// fishCompletions are the fish equivalent of the bash custom completions.
+var fishCompletions = map[string]string{
+	"__acr_get_repositories": "(acr " + completeRepositoriesCmd + " (commandline -opc)[2..-1])",
+	"__acr_get_filter_hints": "'^v[0-9]+' '^latest$' '-rc[0-9]*$' '^dev-'",
+}
+
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+func newCompletionCmd(out io.Writer) *cobra.Command {
+	return &cobra.Command{
+		Use:       "completion [bash|zsh|fish|powershell]",
+		Short:     "Print the shell completion script.",
+		Long:      completionLongMessage,
+		Example:   completionExampleMessage,
+		ValidArgs: completionShells,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return newInvalidArgumentsError("expected one shell, %s", strings.Join(completionShells, ", "))
+			}
+			root := cmd.Root()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletion(out)
+			case "zsh":
+				return root.GenZshCompletion(out)
+			case "fish":
+				return genFishCompletion(root, out)
+			case "powershell":
+				return root.GenPowerShellCompletion(out)
+			}
+			return newInvalidArgumentsError("unsupported shell %q, expected one of %s", args[0], strings.Join(completionShells, ", "))
+		},
+	}
+}
+
+// markRepositoryCompletion completes the --repository flag of cmd with the repositories of the registry.
+func markRepositoryCompletion(cmd *cobra.Command) {
+	cobra.MarkFlagCustom(cmd.Flags(), "repository", "__acr_get_repositories")
+}
+
+// markFilterCompletion proposes common patterns for the --filter flag of cmd.
+func markFilterCompletion(cmd *cobra.Command) {
+	cobra.MarkFlagCustom(cmd.Flags(), "filter", "__acr_get_filter_hints")
+}
+
+// newCompleteRepositoriesCmd returns the hidden command used by the completion scripts, it parses the command line
+// being completed and prints the repositories of its registry. Any failure, like missing credentials, prints nothing
+// so the shell simply doesn't propose anything.
+func newCompleteRepositoriesCmd(out io.Writer, rootParams *rootParameters) *cobra.Command {
+	return &cobra.Command{
+		Use:                completeRepositoriesCmd,
+		Hidden:             true,
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := pflag.NewFlagSet(completeRepositoriesCmd, pflag.ContinueOnError)
+			flags.ParseErrorsWhitelist.UnknownFlags = true
+			flags.SetOutput(ioutil.Discard)
+			flags.AddFlagSet(cmd.Root().PersistentFlags())
+			// The word being completed can be a flag without its value, the flags before it are still parsed.
+			_ = flags.Parse(args)
+			loginURL, err := rootParams.loginURL()
+			if err != nil {
+				return nil
+			}
+			acrClient, err := rootParams.newAcrClient(loginURL, ioutil.Discard)
+			if err != nil {
+				return nil
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+			defer cancel()
+			repositories, _ := listRepositories(ctx, acrClient)
+			for _, repoName := range repositories {
+				fmt.Fprintln(out, repoName)
+			}
+			return nil
+		},
+	}
+}
+
+// listRepositories returns the names of every repository of the registry, page after page. The Distribution catalog
+// is used when the registry doesn't have the ACR one.
+func listRepositories(ctx context.Context, acrClient api.AcrCLIClientInterface) ([]string, error) {
+	var repositories []string
+	list := acrClient.AcrListRepositories
+	last := ""
+	for {
+		page, err := list(ctx, last)
+		if len(last) == 0 && isCatalogMissing(err) {
+			list = acrClient.AcrListRepositoriesV2
+			page, err = list(ctx, last)
+		}
+		if err != nil {
+			return repositories, err
+		}
+		if page == nil || len(page.Repositories) == 0 {
+			return repositories, nil
+		}
+		repositories = append(repositories, page.Repositories...)
+		last = page.Repositories[len(page.Repositories)-1]
+	}
+}
+
+// isCatalogMissing reports whether err means the registry doesn't implement the catalog endpoint that was called.
+func isCatalogMissing(err error) bool {
+	registryError, ok := err.(*api.RegistryError)
+	return ok && (registryError.StatusCode == http.StatusNotFound || registryError.StatusCode == http.StatusMethodNotAllowed)
+}
+
+// genFishCompletion writes a fish completion script for the subcommands of root and their flags, cobra doesn't
+// generate one.
+func genFishCompletion(root *cobra.Command, w io.Writer) error {
+	var script strings.Builder
+	name := root.Name()
+	fmt.Fprintf(&script, "# fish completion for %s\ncomplete -c %s -f\n", name, name)
+	writeFishFlags(&script, name, "", root.PersistentFlags())
+	for _, cmd := range root.Commands() {
+		if cmd.Hidden {
+			continue
+		}
+		fmt.Fprintf(&script, "complete -c %s -n '__fish_use_subcommand' -a %s -d %s\n", name, cmd.Name(), fishQuote(cmd.Short))
+		condition := "__fish_seen_subcommand_from " + cmd.Name()
+		if len(cmd.ValidArgs) > 0 {
+			fmt.Fprintf(&script, "complete -c %s -n %s -a %s\n", name, fishQuote(condition), fishQuote(strings.Join(cmd.ValidArgs, " ")))
+		}
+		writeFishFlags(&script, name, condition, cmd.LocalNonPersistentFlags())
+		writeFishFlags(&script, name, condition, cmd.PersistentFlags())
+	}
+	_, err := io.WriteString(w, script.String())
+	return err
+}
+
+func writeFishFlags(script *strings.Builder, name string, condition string, flags *pflag.FlagSet) {
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Hidden {
+			return
+		}
+		fmt.Fprintf(script, "complete -c %s", name)
+		if len(condition) > 0 {
+			fmt.Fprintf(script, " -n %s", fishQuote(condition))
+		}
+		fmt.Fprintf(script, " -l %s", flag.Name)
+		if len(flag.Shorthand) > 0 {
+			fmt.Fprintf(script, " -s %s", flag.Shorthand)
+		}
+		if flag.Value.Type() != "bool" {
+			fmt.Fprint(script, " -r")
+		}
+		for _, function := range flag.Annotations[cobra.BashCompCustom] {
+			if completion, ok := fishCompletions[function]; ok {
+				fmt.Fprintf(script, " -a %s", fishQuote(completion))
+			}
+		}
+		fmt.Fprintf(script, " -d %s\n", fishQuote(flag.Usage))
+	})
+}
+
+// fishQuote quotes s for a fish script.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,116 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/spf13/cobra"
+)
+
+func TestCompletion(t *testing.T) {
+	for _, shell := range completionShells {
+		var out bytes.Buffer
+		root := newRootCmd(nil)
+		root.SetArgs([]string{"completion", shell})
+		root.SetOutput(ioutil.Discard)
+		completion, _, err := root.Find([]string{"completion"})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		// The commands write to the output captured when the root command was created.
+		root.RemoveCommand(completion)
+		root.AddCommand(newCompletionCmd(&out))
+		if err := root.Execute(); err != nil {
+			t.Fatalf("%s: unexpected error %v", shell, err)
+		}
+		if out.Len() == 0 || !strings.Contains(out.String(), "purge") {
+			t.Fatalf("%s: the completion script should cover the purge command, got %q", shell, out.String())
+		}
+		if shell == "bash" && !strings.Contains(out.String(), "__acr_get_repositories") {
+			t.Fatalf("the bash script should complete the repositories")
+		}
+		if shell == "fish" && !strings.Contains(out.String(), "-l repository -r -a '(acr __repositories (commandline -opc)[2..-1])'") {
+			t.Fatalf("the fish script should complete the repositories, got %s", out.String())
+		}
+	}
+}
+
+func TestCompleteRepositories(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != api.BasicAuth("user", "password") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("last") == "" {
+			w.Write([]byte(`{"repositories":["hello-world","nginx"]}`))
+			return
+		}
+		w.Write([]byte(`{"repositories":[]}`))
+	}))
+	defer server.Close()
+	loginURL := strings.TrimPrefix(server.URL, "https://")
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"purge", "-r", loginURL, "-u", "user", "-p", "password", "--insecure", "--ago", "1d", "--repository"}, "hello-world\nnginx\n"},
+		{[]string{"purge", "-r", loginURL, "-u", "user", "-p", "wrong", "--insecure", "--repository"}, ""},
+		{[]string{"purge", "-r", loginURL, "--insecure", "--repository"}, ""},
+		{[]string{"purge", "--repository"}, ""},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		var rootParams rootParameters
+		root := &cobra.Command{Use: "acr"}
+		rootParams.addFlags(root.PersistentFlags())
+		root.AddCommand(newCompleteRepositoriesCmd(&out, &rootParams))
+		root.SetArgs(append([]string{completeRepositoriesCmd}, test.args...))
+		root.SetOutput(ioutil.Discard)
+		if err := root.Execute(); err != nil {
+			t.Fatalf("%v: unexpected error %v", test.args, err)
+		}
+		if out.String() != test.expected {
+			t.Fatalf("%v: repositories incorrect, got %q, expected %q", test.args, out.String(), test.expected)
+		}
+	}
+}
+
+func TestListRepositories(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.pageSize = 2
+	for _, repoName := range []string{"c", "a", "d", "b", "e"} {
+		registry.addManifest(repoName, testDigest(1), time.Now())
+	}
+	repositories, err := listRepositories(context.Background(), registry)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(repositories, []string{"a", "b", "c", "d", "e"}) {
+		t.Fatalf("repositories incorrect, got %v", repositories)
+	}
+	registry.listedRepositories = nil
+	registry.failOn("AcrListRepositories", &api.RegistryError{StatusCode: http.StatusNotFound})
+	repositories, err = listRepositories(context.Background(), registry)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(repositories, []string{"a", "b", "c", "d", "e"}) {
+		t.Fatalf("repositories incorrect with the Distribution catalog, got %v", repositories)
+	}
+	expected := []string{"AcrListRepositories", "AcrListRepositoriesV2", "AcrListRepositoriesV2", "AcrListRepositoriesV2", "AcrListRepositoriesV2"}
+	if !reflect.DeepEqual(registry.listedRepositories, expected) {
+		t.Fatalf("the Distribution catalog should be used for every page, called %v", registry.listedRepositories)
+	}
+}
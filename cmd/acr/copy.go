@@ -0,0 +1,239 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	copyLongMessage = `acr cp: copy an image to another repository of the same registry.
+
+The blobs of the image are mounted into the destination repository instead of being uploaded again, then the manifest
+is pushed unchanged so the copy has the same digest. The manifests of a multi-arch image index are copied along with
+it. The blobs of a manifest are mounted concurrently, and the manifest is only pushed once all of them are mounted.
+The source is never modified.`
+	// defaultCopyConcurrency is the default maximum number of blobs mounted at the same time.
+	defaultCopyConcurrency = 5
+
+	copyExampleMessage = `
+Copy a tag to another repository, keeping its name
+  acr cp -r MyRegistry --source hello-world:v1 --dest archive/hello-world
+
+Copy a manifest by digest and tag the copy
+  acr cp -r MyRegistry --source hello-world@sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae --dest hello-world:stable`
+)
+
+type copyParameters struct {
+	source      string
+	dest        string
+	concurrency int
+}
+
+func newCopyCmd(out io.Writer, rootParams *rootParameters) *cobra.Command {
+	var parameters copyParameters
+	cmd := &cobra.Command{
+		Use:     "cp",
+		Short:   "Copy an image between repositories.",
+		Long:    copyLongMessage,
+		Example: copyExampleMessage,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			source, err := parseImageReference(parameters.source)
+			if err != nil {
+				return newInvalidArgumentsError("invalid --source: %v", err)
+			}
+			if len(source.reference) == 0 {
+				return newInvalidArgumentsError("--source must be a repository:tag or a repository@digest")
+			}
+			dest, err := parseImageReference(parameters.dest)
+			if err != nil {
+				return newInvalidArgumentsError("invalid --dest: %v", err)
+			}
+			if dest.isDigest() {
+				return newInvalidArgumentsError("--dest must be a repository or a repository:tag, the digest of the copy is the digest of the source")
+			}
+			if len(dest.reference) == 0 && !source.isDigest() {
+				dest.reference = source.reference
+			}
+			if dest == source {
+				return newInvalidArgumentsError("--source and --dest are the same image")
+			}
+			if parameters.concurrency < 1 {
+				return newInvalidArgumentsError("--concurrency must be at least 1")
+			}
+			ctx, cancel := signalContext()
+			defer cancel()
+			loginURL, err := rootParams.loginURL()
+			if err != nil {
+				return err
+			}
+			acrClient, err := rootParams.newAcrClient(loginURL, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			digest, err := copyImage(ctx, acrClient, source, dest, parameters.concurrency)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Copied %s/%s to %s/%s@%s\n", loginURL, source, loginURL, dest, digest)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&parameters.source, "source", "", "The image to copy, as repository:tag or repository@digest")
+	cmd.Flags().StringVar(&parameters.dest, "dest", "", "The repository to copy the image to, optionally followed by :tag, the tag of the source is used when it's omitted")
+	cmd.Flags().IntVar(&parameters.concurrency, "concurrency", defaultCopyConcurrency, "The maximum number of blobs mounted at the same time")
+	cmd.MarkFlagRequired("source")
+	cmd.MarkFlagRequired("dest")
+
+	return cmd
+}
+
+// imageReference is a repository with an optional tag or digest.
+type imageReference struct {
+	repoName  string
+	reference string
+}
+
+// parseImageReference parses repository, repository:tag or repository@digest.
+func parseImageReference(value string) (imageReference, error) {
+	var image imageReference
+	if i := strings.Index(value, "@"); i >= 0 {
+		image = imageReference{repoName: value[:i], reference: value[i+1:]}
+		if err := api.ValidateDigest(image.reference); err != nil {
+			return image, err
+		}
+	} else if i := strings.LastIndex(value, ":"); i > strings.LastIndex(value, "/") {
+		image = imageReference{repoName: value[:i], reference: value[i+1:]}
+		if err := validateTagName(image.reference); err != nil {
+			return image, err
+		}
+	} else {
+		image = imageReference{repoName: value}
+	}
+	if len(image.repoName) == 0 {
+		return image, errors.Errorf("%q has no repository", value)
+	}
+	return image, nil
+}
+
+// isDigest reports whether the image is identified by digest.
+func (i imageReference) isDigest() bool {
+	return strings.HasPrefix(i.reference, "sha256:")
+}
+
+func (i imageReference) String() string {
+	switch {
+	case len(i.reference) == 0:
+		return i.repoName
+	case i.isDigest():
+		return i.repoName + "@" + i.reference
+	default:
+		return i.repoName + ":" + i.reference
+	}
+}
+
+// copyImage copies the image source to dest and returns its digest, dest is only tagged when it has a tag. At most
+// concurrency blobs are mounted at the same time.
+func copyImage(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	source imageReference,
+	dest imageReference,
+	concurrency int) (string, error) {
+	copier := &imageCopier{
+		acrClient:   acrClient,
+		source:      source.repoName,
+		dest:        dest.repoName,
+		mounted:     map[string]bool{},
+		concurrency: concurrency,
+	}
+	return copier.copyManifest(ctx, source.reference, dest.reference)
+}
+
+// imageCopier copies manifests from the source repository to the dest repository, the blobs referenced by several
+// manifests are only mounted once.
+type imageCopier struct {
+	acrClient   api.AcrCLIClientInterface
+	source      string
+	dest        string
+	mounted     map[string]bool
+	concurrency int
+}
+
+// copyManifest copies the manifest identified by reference, with the manifests and blobs it references first so the
+// registry accepts it, and pushes it with destReference or by digest when destReference is empty.
+func (c *imageCopier) copyManifest(ctx context.Context, reference string, destReference string) (string, error) {
+	manifest, err := c.acrClient.AcrGetManifestContent(ctx, c.source, reference)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to pull %s from %s", reference, c.source)
+	}
+	var decoded api.Manifest
+	if err := json.Unmarshal(manifest.Content, &decoded); err != nil {
+		return "", errors.Wrapf(err, "unable to parse manifest %s", manifest.Digest)
+	}
+	for _, child := range decoded.Manifests {
+		if _, err := c.copyManifest(ctx, child.Digest, ""); err != nil {
+			return "", err
+		}
+	}
+	if c.source != c.dest {
+		blobs := decoded.Layers
+		if decoded.Config != nil {
+			blobs = append([]api.Descriptor{*decoded.Config}, blobs...)
+		}
+		if err := c.mountBlobs(ctx, blobs); err != nil {
+			return "", err
+		}
+	}
+	if len(destReference) == 0 {
+		destReference = manifest.Digest
+	}
+	if err := c.acrClient.AcrPutManifest(ctx, c.dest, destReference, manifest); err != nil {
+		return "", errors.Wrapf(err, "unable to push %s to %s", manifest.Digest, c.dest)
+	}
+	return manifest.Digest, nil
+}
+
+// mountBlobs mounts the blobs that weren't mounted yet into the dest repository, at most c.concurrency at the same
+// time. Every mount is waited for, the first failure is returned so the manifest referencing the blobs isn't pushed.
+func (c *imageCopier) mountBlobs(ctx context.Context, blobs []api.Descriptor) error {
+	var digests []string
+	for _, blob := range blobs {
+		if !c.mounted[blob.Digest] {
+			c.mounted[blob.Digest] = true
+			digests = append(digests, blob.Digest)
+		}
+	}
+	errs := make([]error, len(digests))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, c.concurrency)
+	for i, digest := range digests {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, digest string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			if err := c.acrClient.AcrMountBlob(ctx, c.dest, c.source, digest); err != nil {
+				errs[i] = errors.Wrapf(err, "unable to mount blob %s into %s", digest, c.dest)
+			}
+		}(i, digest)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			delete(c.mounted, digests[i])
+			return err
+		}
+	}
+	return nil
+}
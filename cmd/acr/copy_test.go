@@ -0,0 +1,182 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+func TestParseImageReference(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected imageReference
+	}{
+		{"repo", imageReference{repoName: "repo"}},
+		{"team/repo:v1", imageReference{repoName: "team/repo", reference: "v1"}},
+		{"localhost/team/repo", imageReference{repoName: "localhost/team/repo"}},
+		{"repo@" + testDigest(1), imageReference{repoName: "repo", reference: testDigest(1)}},
+	}
+	for _, test := range tests {
+		image, err := parseImageReference(test.value)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.value, err)
+		}
+		if image != test.expected || image.String() != test.value {
+			t.Fatalf("reference of %s incorrect, got %+v", test.value, image)
+		}
+	}
+	for _, value := range []string{"", ":v1", "repo:-v1", "repo@sha256:abc"} {
+		if _, err := parseImageReference(value); err == nil {
+			t.Fatalf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestCopyImage(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now(), "v1")
+	registry.setLayers("repo", testDigest(1), map[string]int64{"sha256:l1": 10})
+	content := registry.content("repo", testDigest(1))
+	content.Config = &api.Descriptor{Digest: "sha256:c1", Size: 1}
+	registry.setContent("repo", testDigest(1), content)
+
+	digest, err := copyImage(context.Background(), registry, imageReference{"repo", "v1"}, imageReference{"archive/repo", "v1"}, defaultCopyConcurrency)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if digest != testDigest(1) {
+		t.Fatalf("the copy should have the digest of the source, got %s", digest)
+	}
+	// The blobs are mounted concurrently so they may be in any order.
+	sort.Strings(registry.mountedBlobs["archive/repo"])
+	if !reflect.DeepEqual(registry.mountedBlobs["archive/repo"], []string{"repo@sha256:c1", "repo@sha256:l1"}) {
+		t.Fatalf("the config and the layers should be mounted, got %v", registry.mountedBlobs["archive/repo"])
+	}
+	if !reflect.DeepEqual(registry.pushedManifests["archive/repo"], []string{"v1"}) {
+		t.Fatalf("the manifest should be pushed with the tag, got %v", registry.pushedManifests["archive/repo"])
+	}
+	if len(registry.deletedTags) != 0 || len(registry.deletedManifests) != 0 {
+		t.Fatalf("the source should be kept, deleted %v and %v", registry.deletedTags, registry.deletedManifests)
+	}
+
+	// A copy within the repository only tags the manifest again.
+	if _, err := copyImage(context.Background(), registry, imageReference{"repo", testDigest(1)}, imageReference{"repo", "stable"}, defaultCopyConcurrency); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(registry.mountedBlobs["repo"]) != 0 || !reflect.DeepEqual(registry.pushedManifests["repo"], []string{"stable"}) {
+		t.Fatalf("expected only a push of the tag, mounted %v and pushed %v", registry.mountedBlobs["repo"], registry.pushedManifests["repo"])
+	}
+}
+
+func TestCopyImageIndex(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now(), "multi")
+	registry.addManifest("repo", testDigest(2), time.Now())
+	registry.addManifest("repo", testDigest(3), time.Now())
+	// Both platforms share a layer, it's only mounted once.
+	registry.setLayers("repo", testDigest(2), map[string]int64{"sha256:shared": 10})
+	registry.setLayers("repo", testDigest(3), map[string]int64{"sha256:shared": 10})
+	arm := registry.content("repo", testDigest(3))
+	arm.Layers = append(arm.Layers, api.Descriptor{Digest: "sha256:arm", Size: 5})
+	registry.setContent("repo", testDigest(3), arm)
+	registry.setContent("repo", testDigest(1), &api.Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.index.v1+json",
+		Manifests:     []api.Descriptor{{Digest: testDigest(2)}, {Digest: testDigest(3)}},
+	})
+
+	if _, err := copyImage(context.Background(), registry, imageReference{"repo", "multi"}, imageReference{"mirror", "multi"}, defaultCopyConcurrency); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(registry.pushedManifests["mirror"], []string{testDigest(2), testDigest(3), "multi"}) {
+		t.Fatalf("the platform manifests should be pushed by digest before the index, got %v", registry.pushedManifests["mirror"])
+	}
+	sort.Strings(registry.mountedBlobs["mirror"])
+	if !reflect.DeepEqual(registry.mountedBlobs["mirror"], []string{"repo@sha256:arm", "repo@sha256:shared"}) {
+		t.Fatalf("every blob should be mounted once, got %v", registry.mountedBlobs["mirror"])
+	}
+
+	registry.failOn("AcrMountBlob other sha256:arm", &api.RegistryError{StatusCode: 403, Code: "DENIED"})
+	if _, err := copyImage(context.Background(), registry, imageReference{"repo", "multi"}, imageReference{"other", "multi"}, defaultCopyConcurrency); err == nil {
+		t.Fatalf("expected an error when a blob can't be mounted")
+	}
+	if !reflect.DeepEqual(registry.pushedManifests["other"], []string{testDigest(2)}) {
+		t.Fatalf("the index should not be pushed without all its manifests, pushed %v", registry.pushedManifests["other"])
+	}
+}
+
+func TestCopyImageConcurrentMounts(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now(), "v1")
+	layers := map[string]int64{}
+	var expected []string
+	for i := 0; i < 20; i++ {
+		layers[fmt.Sprintf("sha256:l%02d", i)] = 10
+		expected = append(expected, fmt.Sprintf("repo@sha256:l%02d", i))
+	}
+	registry.setLayers("repo", testDigest(1), layers)
+
+	if _, err := copyImage(context.Background(), registry, imageReference{"repo", "v1"}, imageReference{"mirror", "v1"}, 4); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sort.Strings(registry.mountedBlobs["mirror"])
+	if !reflect.DeepEqual(registry.mountedBlobs["mirror"], expected) {
+		t.Fatalf("every layer should be mounted once, got %v", registry.mountedBlobs["mirror"])
+	}
+
+	registry.failOn("AcrMountBlob other sha256:l07", &api.RegistryError{StatusCode: 403, Code: "DENIED"})
+	if _, err := copyImage(context.Background(), registry, imageReference{"repo", "v1"}, imageReference{"other", "v1"}, 4); err == nil {
+		t.Fatalf("expected an error when a layer can't be mounted")
+	}
+	if len(registry.mountedBlobs["other"]) != len(expected)-1 || len(registry.pushedManifests["other"]) != 0 {
+		t.Fatalf("the other layers should be mounted without pushing the manifest, mounted %v and pushed %v",
+			registry.mountedBlobs["other"], registry.pushedManifests["other"])
+	}
+}
+
+func TestCopyPurgeRoundTrip(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "v1")
+	registry.addManifest("repo", testDigest(2), old, "v2")
+	registry.setLayers("repo", testDigest(1), map[string]int64{"sha256:l1": 10})
+
+	// The image is copied to a backup repository before the purge and copied back afterwards.
+	if _, err := copyImage(context.Background(), registry, imageReference{"repo", "v1"}, imageReference{"backup/repo", "v1"}, defaultCopyConcurrency); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", output: outputText, logFormat: logFormatText}
+	if err := runPurge(context.Background(), registry, ioutil.Discard, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if tags, manifests := registry.store.Tags("repo"), registry.store.Manifests("repo"); len(tags) != 0 || len(manifests) != 0 {
+		t.Fatalf("the repository should be empty after the purge, tags %v and manifests %v", tags, manifests)
+	}
+	if _, err := copyImage(context.Background(), registry, imageReference{"backup/repo", "v1"}, imageReference{"repo", "v1"}, defaultCopyConcurrency); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	tags, err := registry.AcrListTags(context.Background(), "repo", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(*tags.Tags) != 1 || *(*tags.Tags)[0].Name != "v1" || *(*tags.Tags)[0].Digest != testDigest(1) {
+		t.Fatalf("the tag should be restored with its digest, got %v", *tags.Tags)
+	}
+	manifest, err := registry.AcrGetManifest(context.Background(), "repo", testDigest(1))
+	if err != nil || len(manifest.Layers) != 1 || manifest.Layers[0].Digest != "sha256:l1" {
+		t.Fatalf("the manifest should be restored with its layers, got %v and %v", manifest, err)
+	}
+	if !reflect.DeepEqual(registry.mountedBlobs["repo"], []string{"backup/repo@sha256:l1"}) {
+		t.Fatalf("the layer should be mounted back from the backup, got %v", registry.mountedBlobs["repo"])
+	}
+}
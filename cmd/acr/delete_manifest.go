@@ -0,0 +1,140 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/spf13/cobra"
+)
+
+const (
+	deleteManifestLongMessage = `acr delete-manifest: delete specific manifests by digest.
+
+Deleting a manifest also deletes every tag that references it.`
+	deleteManifestExampleMessage = `
+Delete a manifest, asking for confirmation first
+  acr delete-manifest -r MyRegistry --repository MyRepository --digest sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae
+
+Delete two manifests without asking for confirmation
+  acr delete-manifest -r MyRegistry --repository MyRepository --digest sha256:... --digest sha256:... --yes
+
+Show what would be deleted
+  acr delete-manifest -r MyRegistry --repository MyRepository --digest sha256:... --dry-run`
+)
+
+type deleteManifestParameters struct {
+	repoName string
+	digests  []string
+	dryRun   bool
+	yes      bool
+}
+
+func newDeleteManifestCmd(out io.Writer, rootParams *rootParameters) *cobra.Command {
+	var parameters deleteManifestParameters
+	cmd := &cobra.Command{
+		Use:     "delete-manifest",
+		Short:   "Delete manifests by digest.",
+		Long:    deleteManifestLongMessage,
+		Example: deleteManifestExampleMessage,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(parameters.digests) == 0 {
+				return newInvalidArgumentsError("at least one --digest must be specified")
+			}
+			for _, digest := range parameters.digests {
+				if err := api.ValidateDigest(digest); err != nil {
+					return &invalidArgumentsError{err: err}
+				}
+			}
+			loginURL, err := rootParams.loginURL()
+			if err != nil {
+				return err
+			}
+			if parameters.dryRun {
+				for _, digest := range parameters.digests {
+					fmt.Fprintf(out, "Would delete %s/%s@%s\n", loginURL, parameters.repoName, digest)
+				}
+				return nil
+			}
+			if !parameters.yes {
+				question := fmt.Sprintf("Delete %d manifests and their tags from %s/%s?", len(parameters.digests), loginURL, parameters.repoName)
+				confirmed, err := confirm(cmd.InOrStdin(), out, question)
+				if err != nil {
+					return err
+				}
+				if !confirmed {
+					fmt.Fprintln(out, "Nothing was deleted")
+					return nil
+				}
+			}
+			ctx, cancel := signalContext()
+			defer cancel()
+			acrClient, err := rootParams.newAcrClient(loginURL, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			results := newPurgeResults(out, loginURL, outputText)
+			_, err = deleteManifests(ctx, acrClient, results, parameters.repoName, parameters.digests)
+			if summaryErr := results.writeSummary(out, false); summaryErr != nil && err == nil {
+				return summaryErr
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&parameters.repoName, "repository", "", "The repository of the manifests")
+	cmd.MarkFlagRequired("repository")
+	markRepositoryCompletion(cmd)
+	cmd.Flags().StringArrayVar(&parameters.digests, "digest", nil, "The digest of a manifest to delete, can be repeated")
+	cmd.Flags().BoolVar(&parameters.dryRun, "dry-run", false, "Print the manifests that would be deleted without deleting them")
+	cmd.Flags().BoolVarP(&parameters.yes, "yes", "y", false, "Don't ask for confirmation")
+
+	return cmd
+}
+
+// deleteManifests deletes the given manifests one after the other and returns the number of deleted manifests. A
+// manifest that doesn't exist is reported in the results but isn't an error, a failed deletion doesn't stop the
+// others unless the credentials were rejected.
+func deleteManifests(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	results *purgeResults,
+	repoName string,
+	digests []string) (int, error) {
+	deletedManifests := 0
+	var deleteErr error
+	for _, digest := range digests {
+		err := acrClient.DeleteManifest(ctx, repoName, digest)
+		switch results.record(purgeResult{Repository: repoName, Digest: digest}, err) {
+		case outcomeDeleted:
+			deletedManifests++
+		case outcomeFailed:
+			if isUnauthorized(err) {
+				return deletedManifests, err
+			}
+			if deleteErr == nil {
+				deleteErr = err
+			}
+		}
+	}
+	if deleteErr != nil {
+		return deletedManifests, newPartialFailureError(deleteErr)
+	}
+	return deletedManifests, nil
+}
+
+// confirm asks question on out and reports whether the answer read from in is yes.
+func confirm(in io.Reader, out io.Writer, question string) (bool, error) {
+	fmt.Fprintf(out, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
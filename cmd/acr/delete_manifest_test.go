@@ -0,0 +1,75 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+func TestDeleteManifestCommand(t *testing.T) {
+	base := []string{"-r", "registry", "-u", "user", "-p", "password", "--repository", "repo"}
+	tests := []struct {
+		name     string
+		args     []string
+		input    string
+		expected int
+		output   string
+	}{
+		{"no digest", nil, "", exitCodeInvalidArguments, ""},
+		{"invalid digest", []string{"--digest", testDigest(1), "--digest", "sha256:abc"}, "", exitCodeInvalidArguments, ""},
+		{"missing colon", []string{"--digest", strings.Replace(testDigest(1), ":", "", 1)}, "", exitCodeInvalidArguments, ""},
+		{"dry run", []string{"--digest", testDigest(1), "--digest", testDigest(2), "--dry-run"}, "", exitCodeSuccess,
+			"Would delete registry.azurecr.io/repo@" + testDigest(1) + "\nWould delete registry.azurecr.io/repo@" + testDigest(2) + "\n"},
+		{"declined", []string{"--digest", testDigest(1)}, "n\n", exitCodeSuccess,
+			"Delete 1 manifests and their tags from registry.azurecr.io/repo? [y/N]: Nothing was deleted\n"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		var rootParams rootParameters
+		cmd := newDeleteManifestCmd(&out, &rootParams)
+		rootParams.addFlags(cmd.PersistentFlags())
+		cmd.SetArgs(append(append([]string(nil), base...), test.args...))
+		cmd.SetOutput(ioutil.Discard)
+		cmd.SetIn(strings.NewReader(test.input))
+		err := cmd.Execute()
+		if code := exitCode(err); code != test.expected {
+			t.Fatalf("%s: exit code incorrect, got %d (%v), expected %d", test.name, code, err, test.expected)
+		}
+		if len(test.output) > 0 && out.String() != test.output {
+			t.Fatalf("%s: output incorrect, got %q, expected %q", test.name, out.String(), test.output)
+		}
+	}
+}
+
+func TestDeleteManifests(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now(), "v1")
+	registry.addManifest("repo", testDigest(3), time.Now())
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	deleted, err := deleteManifests(context.Background(), registry, results, "repo", []string{testDigest(1), testDigest(2)})
+	if err != nil {
+		t.Fatalf("a missing manifest shouldn't fail, got %v", err)
+	}
+	if deleted != 1 || !reflect.DeepEqual(registry.deletedManifests["repo"], []string{testDigest(1)}) {
+		t.Fatalf("deleted %d %v, expected %s", deleted, registry.deletedManifests["repo"], testDigest(1))
+	}
+	report := results.report(false)
+	if len(report.NotFound) != 1 || report.NotFound[0].Digest != testDigest(2) {
+		t.Fatalf("missing manifest not reported, got %+v", report.NotFound)
+	}
+
+	registry.failOn("DeleteManifest repo "+testDigest(3), &api.RegistryError{StatusCode: http.StatusInternalServerError})
+	if _, err := deleteManifests(context.Background(), registry, results, "repo", []string{testDigest(3)}); exitCode(err) != exitCodePartialFailure {
+		t.Fatalf("expected a partial failure, got %v", err)
+	}
+}
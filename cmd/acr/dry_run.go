@@ -0,0 +1,131 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+// dryRunClient doesn't send the deletions made through the wrapped client, it remembers them and hides the deleted
+// tags and manifests from the following listings instead. A manifest whose tags were all deleted is listed as
+// dangling, so a dry run selects the same manifests as the real run would.
+type dryRunClient struct {
+	api.AcrCLIClientInterface
+	mu        sync.Mutex
+	tags      map[string]map[string]bool
+	manifests map[string]map[string]bool
+}
+
+func newDryRunClient(acrClient api.AcrCLIClientInterface) *dryRunClient {
+	return &dryRunClient{AcrCLIClientInterface: acrClient, tags: map[string]map[string]bool{}, manifests: map[string]map[string]bool{}}
+}
+
+func (c *dryRunClient) AcrDeleteTag(ctx context.Context, repoName string, reference string) error {
+	c.remember(c.tags, repoName, reference)
+	return nil
+}
+
+func (c *dryRunClient) DeleteManifest(ctx context.Context, repoName string, reference string) error {
+	c.remember(c.manifests, repoName, reference)
+	return nil
+}
+
+// AcrCheckDeletePermission doesn't probe the registry, the probe sends a deletion and a dry run doesn't need the
+// permission.
+func (c *dryRunClient) AcrCheckDeletePermission(ctx context.Context, repoName string) error {
+	return nil
+}
+
+func (c *dryRunClient) remember(deleted map[string]map[string]bool, repoName string, reference string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if deleted[repoName] == nil {
+		deleted[repoName] = map[string]bool{}
+	}
+	deleted[repoName][reference] = true
+}
+
+func (c *dryRunClient) isDeleted(deleted map[string]map[string]bool, repoName string, reference string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return deleted[repoName][reference]
+}
+
+// AcrListTags skips the deleted tags, a page left empty is replaced by the next one so the listing doesn't end early.
+func (c *dryRunClient) AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error) {
+	for {
+		tags, err := c.AcrCLIClientInterface.AcrListTags(ctx, repoName, orderBy, last)
+		if err != nil || tags == nil || tags.Tags == nil || len(*tags.Tags) == 0 {
+			return tags, err
+		}
+		var remaining []acrapi.TagAttributesBase
+		for _, tag := range *tags.Tags {
+			if !c.isDeleted(c.tags, repoName, *tag.Name) {
+				remaining = append(remaining, tag)
+			}
+		}
+		if len(remaining) > 0 {
+			page := *tags
+			page.Tags = &remaining
+			return &page, nil
+		}
+		last = *(*tags.Tags)[len(*tags.Tags)-1].Name
+	}
+}
+
+// AcrListManifests skips the deleted manifests and removes the deleted tags from the others, a page left empty is
+// replaced by the next one so the listing doesn't end early.
+func (c *dryRunClient) AcrListManifests(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.ManifestAttributeList, error) {
+	for {
+		manifests, err := c.AcrCLIClientInterface.AcrListManifests(ctx, repoName, orderBy, last)
+		if err != nil || manifests == nil || manifests.Manifests == nil || len(*manifests.Manifests) == 0 {
+			return manifests, err
+		}
+		var remaining []acrapi.ManifestAttributesBase
+		for _, manifest := range *manifests.Manifests {
+			if c.isDeleted(c.manifests, repoName, *manifest.Digest) {
+				continue
+			}
+			if manifest.Tags != nil {
+				var tags []string
+				for _, tag := range *manifest.Tags {
+					if !c.isDeleted(c.tags, repoName, tag) {
+						tags = append(tags, tag)
+					}
+				}
+				manifest.Tags = nil
+				if len(tags) > 0 {
+					manifest.Tags = &tags
+				}
+			}
+			remaining = append(remaining, manifest)
+		}
+		if len(remaining) > 0 {
+			page := *manifests
+			page.Manifests = &remaining
+			return &page, nil
+		}
+		last = *(*manifests.Manifests)[len(*manifests.Manifests)-1].Digest
+	}
+}
+
+// AcrListReferrers skips the deleted referrers.
+func (c *dryRunClient) AcrListReferrers(ctx context.Context, repoName string, digest string) (*api.ReferrerList, error) {
+	referrers, err := c.AcrCLIClientInterface.AcrListReferrers(ctx, repoName, digest)
+	if err != nil || referrers == nil {
+		return referrers, err
+	}
+	list := *referrers
+	list.Manifests = nil
+	for _, referrer := range referrers.Manifests {
+		if !c.isDeleted(c.manifests, repoName, referrer.Digest) {
+			list.Manifests = append(list.Manifests, referrer)
+		}
+	}
+	return &list, nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestPurgeDryRun(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.pageSize = 2
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "v1", "v2")
+	registry.addManifest("repo", testDigest(2), old, "v3")
+	registry.addManifest("repo", testDigest(3), time.Now(), "latest")
+	registry.addManifest("repo", testDigest(4), old)
+	registry.addManifest("repo", testDigest(5), time.Now())
+	// The permission probe sends a deletion, a dry run must not send it.
+	registry.failOn("AcrCheckDeletePermission repo", errors.New("the permission probe was sent"))
+
+	var out bytes.Buffer
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", output: outputText, dryRun: true}
+	if err := runPurge(context.Background(), newDryRunClient(registry), &out, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(registry.deletedTags) != 0 || len(registry.deletedManifests) != 0 {
+		t.Fatalf("a dry run shouldn't delete anything, deleted the tags %v and the manifests %v", registry.deletedTags, registry.deletedManifests)
+	}
+	// The manifests the tags would leave dangling are listed with the dangling one.
+	expected := []string{
+		"Would delete registry.azurecr.io/repo:v1",
+		"Would delete registry.azurecr.io/repo:v2",
+		"Would delete registry.azurecr.io/repo:v3",
+		"Would delete registry.azurecr.io/repo@" + testDigest(1),
+		"Would delete registry.azurecr.io/repo@" + testDigest(2),
+		"Would delete registry.azurecr.io/repo@" + testDigest(4),
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	sort.Strings(lines)
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("dry run output incorrect, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	parameters.quiet = true
+	if err := runPurge(context.Background(), newDryRunClient(registry), &out, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if out.String() != "Would delete 3 tags and 3 manifests\n" {
+		t.Fatalf("quiet dry run summary incorrect, got %q", out.String())
+	}
+}
+
+func TestPurgeDryRunMaxDelete(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	for i := 0; i < 10; i++ {
+		registry.addManifest("repo", testDigest(i), old, fmt.Sprintf("v%d", i))
+	}
+	var out bytes.Buffer
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", output: outputText, dryRun: true, maxDelete: 15, quiet: true}
+	err := runPurge(context.Background(), newDryRunClient(registry), &out, "registry.azurecr.io", parameters)
+	// The whole run is planned before reporting that it exceeds the limit.
+	if exitCode(err) != exitCodeDeletionCapReached || !strings.Contains(err.Error(), "would delete 20 tags and manifests") {
+		t.Fatalf("expected the dry run to report the limit, got %v", err)
+	}
+	if out.String() != "Would delete 10 tags and 10 manifests\n" || len(registry.deletedTags) != 0 {
+		t.Fatalf("the dry run should plan every deletion without deleting, got %q and deleted %v", out.String(), registry.deletedTags)
+	}
+
+	parameters.maxDelete = 20
+	if err := runPurge(context.Background(), newDryRunClient(registry), &out, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("a dry run within the limit should succeed, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/pkg/errors"
+)
+
+// Exit codes returned by the CLI so automation can react to the different kinds of failures.
+const (
+	exitCodeSuccess              = 0
+	exitCodeError                = 1
+	exitCodeInvalidArguments     = 2
+	exitCodeAuthenticationFailed = 3
+	exitCodePartialFailure       = 4
+	exitCodeNothingDeleted       = 5
+	exitCodeDeletionCapReached   = 6
+)
+
+const exitCodesMessage = `
+Exit codes:
+  0  Success
+  1  Unexpected error
+  2  Invalid arguments
+  3  Authentication failed, the credentials were rejected or lack the needed permissions
+  4  Partial failure, some deletions failed
+  5  Nothing was deleted (only with --fail-if-nothing-deleted)
+  6  The run was stopped by --max-delete, or would have been with --dry-run`
+
+// errNothingDeleted is returned by purge when --fail-if-nothing-deleted is set and the run didn't delete anything.
+var errNothingDeleted = errors.New("nothing was deleted")
+
+// cobraArgumentErrors are the prefixes of the errors cobra returns for malformed command lines that don't go
+// through the flag error function.
+var cobraArgumentErrors = []string{
+	"required flag(s)",
+	"unknown command",
+}
+
+// invalidArgumentsError is returned when the command line can't be used as given.
+type invalidArgumentsError struct {
+	err error
+}
+
+func newInvalidArgumentsError(format string, args ...interface{}) error {
+	return &invalidArgumentsError{err: fmt.Errorf(format, args...)}
+}
+
+func (e *invalidArgumentsError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the underlying error.
+func (e *invalidArgumentsError) Cause() error {
+	return e.err
+}
+
+// deletionCapError is returned instead of deleting once a run tried to delete max items, or at the end of a dry run
+// that would have deleted planned items, more than max.
+type deletionCapError struct {
+	max     int64
+	planned int64
+}
+
+func (e *deletionCapError) Error() string {
+	if e.planned > 0 {
+		return fmt.Sprintf("the run would delete %d tags and manifests, more than the --max-delete limit of %d", e.planned, e.max)
+	}
+	return fmt.Sprintf("stopped after %d deletions, the --max-delete limit", e.max)
+}
+
+// partialFailureError is returned when a deletion failed, the deletions that happened before are not undone.
+type partialFailureError struct {
+	err error
+}
+
+func newPartialFailureError(err error) error {
+	return &partialFailureError{err: err}
+}
+
+func (e *partialFailureError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the underlying error.
+func (e *partialFailureError) Cause() error {
+	return e.err
+}
+
+// listingError is returned when listing a page of the tags failed after the previous pages were listed, the tags
+// selected on those pages were deleted and are in the results.
+type listingError struct {
+	completed int
+	err       error
+}
+
+func (e *listingError) Error() string {
+	return fmt.Sprintf("completed %d items before a listing error: %v", e.completed, e.err)
+}
+
+// Cause returns the underlying error.
+func (e *listingError) Cause() error {
+	return e.err
+}
+
+// exitCode maps the error returned by a command to the process exit code. Authentication failures take precedence
+// because they're the most actionable, even when they caused a deletion to fail.
+func exitCode(err error) int {
+	if err == nil {
+		return exitCodeSuccess
+	}
+	code := exitCodeError
+	for current := err; current != nil; {
+		switch e := current.(type) {
+		case *api.RegistryError:
+			if e.IsUnauthorized() {
+				return exitCodeAuthenticationFailed
+			}
+		case *deletionCapError:
+			return exitCodeDeletionCapReached
+		case *invalidArgumentsError:
+			code = firstExitCode(code, exitCodeInvalidArguments)
+		case *partialFailureError:
+			code = firstExitCode(code, exitCodePartialFailure)
+		}
+		if current == errNothingDeleted {
+			code = firstExitCode(code, exitCodeNothingDeleted)
+		}
+		causer, ok := current.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		current = causer.Cause()
+	}
+	if code == exitCodeError {
+		for _, prefix := range cobraArgumentErrors {
+			if strings.HasPrefix(err.Error(), prefix) {
+				return exitCodeInvalidArguments
+			}
+		}
+	}
+	return code
+}
+
+// firstExitCode keeps the code found closest to the top of the error chain.
+func firstExitCode(current int, found int) int {
+	if current != exitCodeError {
+		return current
+	}
+	return found
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/pkg/errors"
+)
+
+func TestExitCodeCommandLine(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--unknown"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "--repository", "repo"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--orderby", "name"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository-glob", "team/["}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--repository-glob", "team/*"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--log-format", "xml"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--log-format", "json", "--output", "table"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--dangling-ago", "7x"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--dangling-ago", "7d", "--dangling-any-age"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--max-delete", "-1"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--retry", "-1"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--jitter", "-1s"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--dry-run", "--state-file", "state.json"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--semver-keep", "patch:latest"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--semver-purge-others"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--max-age-skew", "-1s"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--filter-mode", "exact"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--where", "size > 30d"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--report-remaining"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--tag-age", "pulled"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--cascade", "--dangling"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--tags", "v1", "--ago", "1d"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--format", "{{.Foo}}"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--format", "{{.Tag}}", "--output", "json"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--tags", "v1,-v2"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository-glob", "team/*", "--tags", "v1"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--all-repositories"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--all-repositories", "--tags", "v1"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--registry-type", "harbor"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--registry-type", "generic", "--dangling"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "--aad-token", "token", "--repository", "repo", "--registry-type", "generic"}, exitCodeInvalidArguments},
+		{[]string{"stats", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--registry-type", "generic"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--ago", "30d", "--newer-than", "7d"}, exitCodeInvalidArguments},
+		{[]string{"purge", "-r", "registry", "-u", "user", "-p", "password", "--repository", "repo", "--dangling", "--newer-than", "90d"}, exitCodeInvalidArguments},
+		{[]string{"cp", "-r", "registry", "-u", "user", "-p", "password", "--source", "repo:v1", "--dest", "mirror", "--concurrency", "0"}, exitCodeInvalidArguments},
+		{[]string{"cp", "-r", "registry", "-u", "user", "-p", "password", "--source", "repo", "--dest", "other"}, exitCodeInvalidArguments},
+		{[]string{"cp", "-r", "registry", "-u", "user", "-p", "password", "--source", "repo:v1", "--dest", "other@sha256:abc"}, exitCodeInvalidArguments},
+		{[]string{"cp", "-r", "registry", "-u", "user", "-p", "password", "--source", "repo:v1", "--dest", "repo"}, exitCodeInvalidArguments},
+		{[]string{"unknown"}, exitCodeInvalidArguments},
+		{[]string{"version"}, exitCodeSuccess},
+	}
+	for _, test := range tests {
+		cmd := newRootCmd(nil)
+		cmd.SetArgs(test.args)
+		cmd.SetOutput(ioutil.Discard)
+		err := cmd.Execute()
+		if code := exitCode(err); code != test.expected {
+			t.Fatalf("exit code of %v incorrect, got %d (%v), expected %d", test.args, code, err, test.expected)
+		}
+	}
+}
+
+func TestExitCodePurge(t *testing.T) {
+	old := time.Now().Add(-72 * time.Hour)
+	unauthorized := &api.RegistryError{StatusCode: http.StatusUnauthorized, Code: "UNAUTHORIZED", Message: "authentication required"}
+	tests := []struct {
+		name     string
+		setup    func(registry *fakeRegistry)
+		ago      string
+		expected int
+	}{
+		{"success", func(registry *fakeRegistry) {}, "1d", exitCodeSuccess},
+		{"invalid ago", func(registry *fakeRegistry) {}, "1x", exitCodeInvalidArguments},
+		{"auth failure", func(registry *fakeRegistry) {
+			registry.failOn("AcrListTags repo", unauthorized)
+		}, "1d", exitCodeAuthenticationFailed},
+		{"auth failure on delete", func(registry *fakeRegistry) {
+			registry.failOn("AcrDeleteTag repo v2", unauthorized)
+		}, "1d", exitCodeAuthenticationFailed},
+		{"authentication expired on delete", func(registry *fakeRegistry) {
+			registry.failOn("AcrDeleteTag repo v2", &api.AuthenticationExpiredError{Err: unauthorized})
+		}, "1d", exitCodeAuthenticationFailed},
+		{"partial failure", func(registry *fakeRegistry) {
+			registry.failOn("AcrDeleteTag repo v2", &api.RegistryError{StatusCode: http.StatusInternalServerError})
+		}, "1d", exitCodePartialFailure},
+		{"deleted by someone else", func(registry *fakeRegistry) {
+			registry.failOn("AcrDeleteTag repo v2", &api.RegistryError{StatusCode: http.StatusNotFound, Code: "TAG_UNKNOWN"})
+		}, "1d", exitCodeSuccess},
+		{"unexpected error", func(registry *fakeRegistry) {
+			registry.failOn("AcrListManifests repo", errors.New("connection reset"))
+		}, "1d", exitCodeError},
+	}
+	for _, test := range tests {
+		registry := newFakeRegistry()
+		registry.addManifest("repo", testDigest(1), old, "v1")
+		registry.addManifest("repo", testDigest(2), old, "v2")
+		test.setup(registry)
+		_, _, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: test.ago})
+		if code := exitCode(err); code != test.expected {
+			t.Fatalf("%s: exit code incorrect, got %d (%v), expected %d", test.name, code, err, test.expected)
+		}
+	}
+}
+
+func TestExitCodeNothingDeleted(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now(), "latest")
+	parameters := purgeParameters{concurrency: defaultConcurrency, ago: "1d", failIfNone: true}
+	err := purgeRepositories(context.Background(), registry, ioutil.Discard, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, []repositoryEntry{{name: "repo"}}, parameters)
+	if code := exitCode(err); code != exitCodeNothingDeleted {
+		t.Fatalf("exit code incorrect, got %d (%v), expected %d", code, err, exitCodeNothingDeleted)
+	}
+	if code := exitCode(errors.Wrap(newPartialFailureError(errors.New("failed")), "repo")); code != exitCodePartialFailure {
+		t.Fatalf("exit code of a wrapped partial failure incorrect, got %d", code)
+	}
+}
+
+func TestPrintErrorRedacted(t *testing.T) {
+	auth := api.BasicAuth("user", "password")
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now().Add(-72*time.Hour), "v1")
+	registry.failOn("AcrDeleteTag repo v1", errors.Errorf("request with header Authorization: %s failed", auth))
+	var out bytes.Buffer
+	results := newPurgeResults(&out, "registry.azurecr.io", outputText)
+	_, _, err := purgeRepository(context.Background(), registry, results, nil, purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d"})
+	if err == nil {
+		t.Fatalf("expected the deletion to fail")
+	}
+	if err := results.writeSummary(&out, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	printError(&out, err)
+	secret := strings.TrimPrefix(auth, "Basic ")
+	if strings.Contains(out.String(), secret) || !strings.Contains(out.String(), "Authorization: Basic [redacted]") {
+		t.Fatalf("the credentials should be redacted, got %q", out.String())
+	}
+}
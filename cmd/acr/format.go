@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"io/ioutil"
+	"text/template"
+)
+
+// formatItem is the data given to the --format template for every tag or manifest, its fields are part of the CLI
+// interface so they mustn't be renamed. Tag is empty for a manifest and Digest is empty for a tag that wasn't listed,
+// like the ones given with --tags. Reason is empty for a deleted item.
+type formatItem struct {
+	Registry       string
+	Repo           string
+	Tag            string
+	Digest         string
+	Outcome        string
+	Reason         string
+	LastUpdateTime string
+}
+
+// parseFormat parses a --format template. The template is executed once with an empty item so an unknown field is
+// reported before anything is deleted instead of on the first item.
+func parseFormat(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return nil, newInvalidArgumentsError("invalid --format: %v", err)
+	}
+	if err := tmpl.Execute(ioutil.Discard, formatItem{}); err != nil {
+		return nil, newInvalidArgumentsError("invalid --format: %v", err)
+	}
+	return tmpl, nil
+}
+
+// newFormatItem returns the template data of result.
+func (r *purgeResults) newFormatItem(result purgeResult) formatItem {
+	item := formatItem{
+		Registry:       r.loginURL,
+		Repo:           result.Repository,
+		Tag:            result.Tag,
+		Digest:         result.Digest,
+		Outcome:        r.outcomeName(result.outcome),
+		Reason:         result.Reason,
+		LastUpdateTime: result.LastUpdateTime,
+	}
+	if len(item.Digest) == 0 {
+		item.Digest = result.tagDigest
+	}
+	return item
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range []string{"{{.Repo}", "{{.Repository}}", "{{.Tag.Name}}"} {
+		if _, err := parseFormat(format); exitCode(err) != exitCodeInvalidArguments {
+			t.Fatalf("expected %q to be an invalid argument, got %v", format, err)
+		}
+	}
+	if _, err := parseFormat("{{.Registry}}/{{.Repo}}@{{.Digest}} {{.LastUpdateTime}} {{.Reason}}"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestPurgeResultsFormat(t *testing.T) {
+	tests := []struct {
+		format   string
+		quiet    bool
+		expected string
+	}{
+		{"{{.Repo}}:{{.Tag}} {{.Digest}}", false, "repo:v1 sha256:1\nrepo: sha256:2\nrepo:v2 \n"},
+		{"{{.Outcome}} {{.Registry}}/{{.Repo}}{{if .Tag}}:{{.Tag}}{{end}}{{if .Reason}} ({{.Reason}}){{end}}", true,
+			"failed registry.azurecr.io/repo:v2 (unexpected response code: 500)\n"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		results := newPurgeResults(&out, "registry.azurecr.io", outputText)
+		results.quiet = test.quiet
+		format, err := parseFormat(test.format)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		results.format = format
+		results.record(purgeResult{Repository: "repo", Tag: "v1", tagDigest: "sha256:1"}, nil)
+		results.record(purgeResult{Repository: "repo", Digest: "sha256:2"}, nil)
+		results.record(purgeResult{Repository: "repo", Tag: "v2"}, &api.RegistryError{StatusCode: http.StatusInternalServerError})
+		if err := results.writeSummary(&out, false); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if out.String() != test.expected {
+			t.Fatalf("output of %q incorrect, got %q, expected %q", test.format, out.String(), test.expected)
+		}
+	}
+}
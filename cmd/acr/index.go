@@ -0,0 +1,48 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+// indexMediaTypes are the media types of the manifests that reference other manifests of the repository, like the
+// platforms of a multi-arch image.
+var indexMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// isIndex reports whether mediaType is the media type of an image index.
+func isIndex(mediaType string) bool {
+	return containsString(indexMediaTypes, mediaType)
+}
+
+// indexChildren pulls the image indexes of repoName identified by indexes and returns the digests of the manifests
+// they reference, directly or through a nested index. Every index is pulled once.
+func indexChildren(ctx context.Context, acrClient api.AcrCLIClientInterface, repoName string, indexes []string) (map[string]bool, error) {
+	children := map[string]bool{}
+	pulled := map[string]bool{}
+	for len(indexes) > 0 {
+		digest := indexes[len(indexes)-1]
+		indexes = indexes[:len(indexes)-1]
+		if pulled[digest] {
+			continue
+		}
+		pulled[digest] = true
+		index, err := acrClient.AcrGetManifest(ctx, repoName, digest)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range index.Manifests {
+			children[child.Digest] = true
+			if isIndex(child.MediaType) {
+				indexes = append(indexes, child.Digest)
+			}
+		}
+	}
+	return children, nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// sleeper waits for d, or until ctx is done in which case it returns the error of ctx.
+type sleeper func(ctx context.Context, d time.Duration) error
+
+// sleepContext is the sleeper used outside of the tests.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newJitterRand returns a random source seeded from the system randomness, so the jobs of a fleet started at the
+// same time don't pick the same delay. The time is used when the system randomness isn't available.
+func newJitterRand() *rand.Rand {
+	var seed [8]byte
+	if _, err := cryptorand.Read(seed[:]); err != nil {
+		return rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(seed[:]))))
+}
+
+// waitJitter sleeps a random duration between 0 and jitter, both included, after writing it to out, and returns it. A
+// zero jitter doesn't sleep. The wait stops early with the error of ctx when it's done, like when the run is
+// interrupted.
+func waitJitter(ctx context.Context, out io.Writer, jitter time.Duration, random *rand.Rand, sleep sleeper) (time.Duration, error) {
+	if jitter <= 0 {
+		return 0, nil
+	}
+	bound := int64(jitter) + 1
+	if bound < 0 {
+		bound = int64(jitter)
+	}
+	delay := time.Duration(random.Int63n(bound))
+	fmt.Fprintf(out, "Waiting %s before starting, --jitter is %s\n", delay.Round(time.Millisecond), jitter)
+	return delay, sleep(ctx, delay)
+}
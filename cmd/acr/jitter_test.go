@@ -0,0 +1,68 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitJitter(t *testing.T) {
+	var slept []time.Duration
+	sleep := func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	random := rand.New(rand.NewSource(1))
+	jitter := 10 * time.Second
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 1000; i++ {
+		delay, err := waitJitter(context.Background(), ioutil.Discard, jitter, random, sleep)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if delay < 0 || delay > jitter {
+			t.Fatalf("expected a delay between 0 and %s, got %s", jitter, delay)
+		}
+		if slept[len(slept)-1] != delay {
+			t.Fatalf("expected to sleep %s, slept %s", delay, slept[len(slept)-1])
+		}
+		seen[delay/time.Second] = true
+	}
+	// The delays are spread over the whole range.
+	if len(seen) != 10 {
+		t.Fatalf("expected delays in every second of the jitter, got %v", seen)
+	}
+
+	// There is no wait by default.
+	slept = nil
+	var out bytes.Buffer
+	if delay, err := waitJitter(context.Background(), &out, 0, random, sleep); err != nil || delay != 0 || len(slept) != 0 || out.Len() != 0 {
+		t.Fatalf("expected no wait, got %s, %v, %v and %q", delay, err, slept, out.String())
+	}
+	if _, err := waitJitter(context.Background(), &out, time.Minute, random, sleep); err != nil || !strings.HasPrefix(out.String(), "Waiting ") {
+		t.Fatalf("expected the wait to be reported, got %v and %q", err, out.String())
+	}
+	if delay, err := waitJitter(context.Background(), ioutil.Discard, math.MaxInt64, random, sleep); err != nil || delay < 0 {
+		t.Fatalf("expected a positive delay, got %s and %v", delay, err)
+	}
+}
+
+func TestWaitJitterCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if _, err := waitJitter(ctx, ioutil.Discard, time.Hour, newJitterRand(), sleepContext); err != context.Canceled {
+		t.Fatalf("expected the canceled context error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the wait to stop when the context is canceled, it took %s", elapsed)
+	}
+}
@@ -3,11 +3,25 @@
 
 package main
 
-import "os"
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
 
 func main() {
 	cmd := newRootCmd(os.Args[1:])
+	// The errors are printed here so the credentials they could contain are redacted.
+	cmd.SilenceErrors = true
 	if err := cmd.Execute(); err != nil {
-		os.Exit(1)
+		printError(os.Stderr, err)
+		os.Exit(exitCode(err))
 	}
 }
+
+// printError writes err to w the way cobra does, without the credentials it contains.
+func printError(w io.Writer, err error) {
+	fmt.Fprintln(w, "Error:", api.Redact(err.Error()))
+}
@@ -0,0 +1,45 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+// deletionCapClient refuses the deletions beyond max through the wrapped client, the count is shared by the tag and
+// manifest deletions of every repository of the run. Every attempt counts, even when the deletion fails.
+type deletionCapClient struct {
+	api.AcrCLIClientInterface
+	max       int64
+	deletions int64
+}
+
+func newDeletionCapClient(acrClient api.AcrCLIClientInterface, max int64) *deletionCapClient {
+	return &deletionCapClient{AcrCLIClientInterface: acrClient, max: max}
+}
+
+// reserve counts a deletion and returns a deletionCapError when it's beyond max.
+func (c *deletionCapClient) reserve() error {
+	if atomic.AddInt64(&c.deletions, 1) > c.max {
+		return &deletionCapError{max: c.max}
+	}
+	return nil
+}
+
+func (c *deletionCapClient) AcrDeleteTag(ctx context.Context, repoName string, reference string) error {
+	if err := c.reserve(); err != nil {
+		return err
+	}
+	return c.AcrCLIClientInterface.AcrDeleteTag(ctx, repoName, reference)
+}
+
+func (c *deletionCapClient) DeleteManifest(ctx context.Context, repoName string, reference string) error {
+	if err := c.reserve(); err != nil {
+		return err
+	}
+	return c.AcrCLIClientInterface.DeleteManifest(ctx, repoName, reference)
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestPurgeMaxDelete(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.pageSize = 3
+	old := time.Now().Add(-72 * time.Hour)
+	for i := 0; i < 10; i++ {
+		registry.addManifest("repo", testDigest(i), old, fmt.Sprintf("v%d", i))
+	}
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	parameters := purgeParameters{concurrency: 4, repoName: "repo", ago: "1d"}
+	_, _, err := purgeRepository(context.Background(), newDeletionCapClient(registry, 4), results, nil, parameters)
+	if code := exitCode(err); code != exitCodeDeletionCapReached {
+		t.Fatalf("exit code incorrect, got %d (%v), expected %d", code, err, exitCodeDeletionCapReached)
+	}
+	if len(registry.deletedTags["repo"]) != 4 || len(registry.deletedManifests["repo"]) != 0 {
+		t.Fatalf("only 4 deletions should happen, deleted the tags %v and the manifests %v", registry.deletedTags["repo"], registry.deletedManifests["repo"])
+	}
+	report := results.report(false)
+	if len(report.Deleted) != 4 || len(report.Failed) != 0 {
+		t.Fatalf("the deletions that weren't attempted shouldn't be reported, got %+v", report)
+	}
+}
+
+func TestPurgeRepositoriesMaxDelete(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo1", testDigest(1), old, "v1")
+	registry.addManifest("repo2", testDigest(2), old, "v2")
+	registry.addManifest("repo3", testDigest(3), old, "v3")
+	entries := []repositoryEntry{{name: "repo1"}, {name: "repo2"}, {name: "repo3"}}
+	parameters := purgeParameters{concurrency: defaultConcurrency, ago: "1d"}
+	var out bytes.Buffer
+	err := purgeRepositories(context.Background(), newDeletionCapClient(registry, 3), &out, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, entries, parameters)
+	if exitCode(err) != exitCodeDeletionCapReached {
+		t.Fatalf("expected the run to stop, got %v", err)
+	}
+	// The tag and the dangling manifest of repo1, then the tag of repo2.
+	if len(registry.deletedManifests["repo1"]) != 1 || len(registry.deletedTags["repo2"]) != 1 || len(registry.deletedManifests["repo2"]) != 0 {
+		t.Fatalf("deletions incorrect, deleted the tags %v and the manifests %v", registry.deletedTags, registry.deletedManifests)
+	}
+	if registry.listedTags[len(registry.listedTags)-1] != "repo2" {
+		t.Fatalf("repo3 shouldn't be purged, listed the tags of %v", registry.listedTags)
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+// repositoryMetrics are the counters of a single repository.
+type repositoryMetrics struct {
+	tagsScanned      int
+	tagsDeleted      int
+	manifestsScanned int
+	manifestsDeleted int
+	errors           int
+	// blobs are the sizes of the config and layer blobs of the deleted manifests by digest, so a blob shared by
+	// several deleted manifests is counted once.
+	blobs map[string]int64
+}
+
+// bytesReferenced returns the size of the distinct blobs of the deleted manifests.
+func (c *repositoryMetrics) bytesReferenced() int64 {
+	var size int64
+	for _, blobSize := range c.blobs {
+		size += blobSize
+	}
+	return size
+}
+
+// purgeMetrics collects the metrics of a purge run so they can be exported in the Prometheus text format.
+type purgeMetrics struct {
+	mu           sync.Mutex
+	loginURL     string
+	start        time.Time
+	repositories map[string]*repositoryMetrics
+}
+
+func newPurgeMetrics(loginURL string) *purgeMetrics {
+	return &purgeMetrics{
+		loginURL:     loginURL,
+		start:        time.Now(),
+		repositories: map[string]*repositoryMetrics{},
+	}
+}
+
+// record updates the counters of repoName, it's safe to call from the worker goroutines.
+func (m *purgeMetrics) record(repoName string, update func(counters *repositoryMetrics)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counters, ok := m.repositories[repoName]
+	if !ok {
+		counters = &repositoryMetrics{blobs: map[string]int64{}}
+		m.repositories[repoName] = counters
+	}
+	update(counters)
+}
+
+// metricDescription describes a per repository metric.
+type metricDescription struct {
+	name  string
+	help  string
+	value func(counters *repositoryMetrics) int64
+}
+
+var repositoryMetricDescriptions = []metricDescription{
+	{"acr_purge_tags_scanned", "Number of tags listed by the purge run.", func(c *repositoryMetrics) int64 { return int64(c.tagsScanned) }},
+	{"acr_purge_tags_deleted", "Number of tags deleted by the purge run.", func(c *repositoryMetrics) int64 { return int64(c.tagsDeleted) }},
+	{"acr_purge_tags_skipped", "Number of listed tags that weren't deleted.", func(c *repositoryMetrics) int64 { return int64(c.tagsScanned - c.tagsDeleted) }},
+	{"acr_purge_manifests_scanned", "Number of manifests listed by the purge run.", func(c *repositoryMetrics) int64 { return int64(c.manifestsScanned) }},
+	{"acr_purge_manifests_deleted", "Number of manifests deleted by the purge run.", func(c *repositoryMetrics) int64 { return int64(c.manifestsDeleted) }},
+	{"acr_purge_bytes_referenced", "Size of the distinct config and layer blobs of the manifests deleted by the purge run, the blobs still referenced by other manifests aren't reclaimed.", func(c *repositoryMetrics) int64 { return c.bytesReferenced() }},
+	{"acr_purge_errors", "Number of tag, manifest and referrer listings and deletions that failed during the purge run.", func(c *repositoryMetrics) int64 { return int64(c.errors) }},
+}
+
+// writeTo writes the metrics in the Prometheus text exposition format. The values describe a single run so they're
+// exported as gauges.
+func (m *purgeMetrics) writeTo(w io.Writer, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	repoNames := make([]string, 0, len(m.repositories))
+	for repoName := range m.repositories {
+		repoNames = append(repoNames, repoName)
+	}
+	sort.Strings(repoNames)
+	registry := escapeLabelValue(m.loginURL)
+	var buffer bytes.Buffer
+	for _, description := range repositoryMetricDescriptions {
+		fmt.Fprintf(&buffer, "# HELP %s %s\n# TYPE %s gauge\n", description.name, description.help, description.name)
+		for _, repoName := range repoNames {
+			fmt.Fprintf(&buffer, "%s{registry=\"%s\",repository=\"%s\"} %d\n",
+				description.name, registry, escapeLabelValue(repoName), description.value(m.repositories[repoName]))
+		}
+	}
+	fmt.Fprintf(&buffer, "# HELP acr_purge_duration_seconds Duration of the purge run.\n# TYPE acr_purge_duration_seconds gauge\n")
+	fmt.Fprintf(&buffer, "acr_purge_duration_seconds{registry=\"%s\"} %g\n", registry, now.Sub(m.start).Seconds())
+	fmt.Fprintf(&buffer, "# HELP acr_purge_last_run_timestamp_seconds Time the purge run finished.\n# TYPE acr_purge_last_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(&buffer, "acr_purge_last_run_timestamp_seconds{registry=\"%s\"} %d\n", registry, now.Unix())
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// publish writes the metrics to metricsFile and pushes them to pushgateway through httpClient, each of them is skipped
+// when empty.
+func (m *purgeMetrics) publish(metricsFile string, pushgateway string, httpClient *http.Client) error {
+	var buffer bytes.Buffer
+	if err := m.writeTo(&buffer, time.Now()); err != nil {
+		return err
+	}
+	if len(metricsFile) > 0 {
+		if err := writeFileAtomically(metricsFile, buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+	if len(pushgateway) > 0 {
+		url := strings.TrimSuffix(pushgateway, "/") + "/metrics/job/acr_purge"
+		req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(buffer.Bytes()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("pushgateway returned unexpected response code: %v", resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomically replaces path with content through a rename, so collectors never read a partial file.
+func writeFileAtomically(path string, content []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// remainingListingKey marks the context of the listings of --report-remaining, their tags and manifests were already
+// counted by the purge listings.
+type remainingListingKey struct{}
+
+// withRemainingListing returns a context whose listings aren't counted as scanned.
+func withRemainingListing(ctx context.Context) context.Context {
+	return context.WithValue(ctx, remainingListingKey{}, true)
+}
+
+func isRemainingListing(ctx context.Context) bool {
+	remaining, _ := ctx.Value(remainingListingKey{}).(bool)
+	return remaining
+}
+
+// metricsClient records the metrics of the calls made through the wrapped client. The manifests are pulled before
+// they're deleted to read the sizes of their blobs, which costs a request per deleted manifest when the metrics are
+// enabled.
+type metricsClient struct {
+	api.AcrCLIClientInterface
+	metrics *purgeMetrics
+}
+
+func newMetricsClient(acrClient api.AcrCLIClientInterface, metrics *purgeMetrics) *metricsClient {
+	return &metricsClient{AcrCLIClientInterface: acrClient, metrics: metrics}
+}
+
+func (c *metricsClient) AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error) {
+	tags, err := c.AcrCLIClientInterface.AcrListTags(ctx, repoName, orderBy, last)
+	c.metrics.record(repoName, func(counters *repositoryMetrics) {
+		if err != nil {
+			counters.errors++
+		} else if tags != nil && tags.Tags != nil && !isRemainingListing(ctx) {
+			counters.tagsScanned += len(*tags.Tags)
+		}
+	})
+	return tags, err
+}
+
+func (c *metricsClient) AcrDeleteTag(ctx context.Context, repoName string, reference string) error {
+	err := c.AcrCLIClientInterface.AcrDeleteTag(ctx, repoName, reference)
+	c.metrics.record(repoName, func(counters *repositoryMetrics) {
+		if err != nil {
+			counters.errors++
+		} else {
+			counters.tagsDeleted++
+		}
+	})
+	return err
+}
+
+func (c *metricsClient) AcrListManifests(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.ManifestAttributeList, error) {
+	manifests, err := c.AcrCLIClientInterface.AcrListManifests(ctx, repoName, orderBy, last)
+	c.metrics.record(repoName, func(counters *repositoryMetrics) {
+		if err != nil {
+			counters.errors++
+		} else if manifests != nil && manifests.Manifests != nil && !isRemainingListing(ctx) {
+			counters.manifestsScanned += len(*manifests.Manifests)
+		}
+	})
+	return manifests, err
+}
+
+func (c *metricsClient) DeleteManifest(ctx context.Context, repoName string, reference string) error {
+	manifest, _ := c.AcrCLIClientInterface.AcrGetManifest(ctx, repoName, reference)
+	err := c.AcrCLIClientInterface.DeleteManifest(ctx, repoName, reference)
+	c.metrics.record(repoName, func(counters *repositoryMetrics) {
+		if err != nil {
+			counters.errors++
+			return
+		}
+		counters.manifestsDeleted++
+		if manifest == nil {
+			return
+		}
+		if manifest.Config != nil {
+			counters.blobs[manifest.Config.Digest] = manifest.Config.Size
+		}
+		for _, layer := range manifest.Layers {
+			counters.blobs[layer.Digest] = layer.Size
+		}
+	})
+	return err
+}
+
+func (c *metricsClient) AcrListReferrers(ctx context.Context, repoName string, digest string) (*api.ReferrerList, error) {
+	referrers, err := c.AcrCLIClientInterface.AcrListReferrers(ctx, repoName, digest)
+	if err != nil {
+		c.metrics.record(repoName, func(counters *repositoryMetrics) { counters.errors++ })
+	}
+	return referrers, err
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/pkg/errors"
+)
+
+func TestPurgeMetrics(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "v1")
+	registry.addManifest("repo", testDigest(2), old, "v2")
+	registry.addManifest("repo", testDigest(3), time.Now(), "latest")
+	registry.addManifest("repo", testDigest(4), old)
+	registry.setLayers("repo", testDigest(1), map[string]int64{"sha256:layer1": 1000, "sha256:layer2": 234})
+	// The layer shared by the deleted manifests is counted once.
+	registry.setLayers("repo", testDigest(4), map[string]int64{"sha256:layer1": 1000, "sha256:layer3": 4000})
+	registry.failOn("AcrDeleteTag repo v2", errors.New("TAG_UNKNOWN"))
+
+	metrics := newPurgeMetrics("registry.azurecr.io")
+	acrClient := newMetricsClient(registry, metrics)
+	// The listings of --report-remaining aren't counted as scanned again.
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", reportRemaining: true}
+	if _, _, err := purgeRepository(context.Background(), acrClient, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputJSON), nil, parameters); err == nil {
+		t.Fatalf("expected the failed delete to be reported")
+	}
+	registry.failOn("AcrListReferrers repo "+testDigest(3), errors.New("NAME_UNKNOWN"))
+	if _, err := acrClient.AcrListReferrers(context.Background(), "repo", testDigest(3)); err == nil {
+		t.Fatalf("expected the referrers listing to fail")
+	}
+	var out bytes.Buffer
+	if err := metrics.writeTo(&out, metrics.start.Add(1500*time.Millisecond)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, line := range []string{
+		"# TYPE acr_purge_tags_scanned gauge",
+		`acr_purge_tags_scanned{registry="registry.azurecr.io",repository="repo"} 3`,
+		`acr_purge_tags_deleted{registry="registry.azurecr.io",repository="repo"} 1`,
+		`acr_purge_tags_skipped{registry="registry.azurecr.io",repository="repo"} 2`,
+		`acr_purge_manifests_scanned{registry="registry.azurecr.io",repository="repo"} 4`,
+		`acr_purge_manifests_deleted{registry="registry.azurecr.io",repository="repo"} 2`,
+		`acr_purge_bytes_referenced{registry="registry.azurecr.io",repository="repo"} 5234`,
+		`acr_purge_errors{registry="registry.azurecr.io",repository="repo"} 2`,
+		`acr_purge_duration_seconds{registry="registry.azurecr.io"} 1.5`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("metrics %q don't contain %q", out.String(), line)
+		}
+	}
+}
+
+func TestPurgeMetricsPublish(t *testing.T) {
+	// The push goes through the proxy of the HTTP client, like the registry requests.
+	var pushed string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Host != "pushgateway.example.com:9091" || r.URL.Path != "/metrics/job/acr_purge" {
+			t.Errorf("unexpected push %s %s %s", r.Method, r.Host, r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		pushed = string(body)
+	}))
+	defer proxy.Close()
+	httpClient, err := api.NewHTTPClient(api.TransportOptions{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	metrics := newPurgeMetrics("registry.azurecr.io")
+	metrics.record(`team/"quoted"`, func(counters *repositoryMetrics) { counters.manifestsDeleted = 2 })
+	metricsFile := filepath.Join(dir, "acr.prom")
+	if err := metrics.publish(metricsFile, "http://pushgateway.example.com:9091/", httpClient); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	written, err := ioutil.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := `acr_purge_manifests_deleted{registry="registry.azurecr.io",repository="team/\"quoted\""} 2`
+	if !strings.Contains(string(written), expected) || !strings.Contains(pushed, expected) {
+		t.Fatalf("published metrics don't contain %q, file: %q, pushed: %q", expected, written, pushed)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("temporary metrics file left behind, got %d files", len(files))
+	}
+}
@@ -0,0 +1,10 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+//go:build !race
+// +build !race
+
+package main
+
+// raceEnabled reports whether the tests run with the race detector, which adds allocations of its own.
+const raceEnabled = false
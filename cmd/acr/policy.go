@@ -0,0 +1,99 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/pkg/errors"
+)
+
+// retentionPolicyKey is the metadata key of a repository under which its retention policy is stored.
+const retentionPolicyKey = "acr-purge-policy"
+
+// policyGroupRegex puts every tag in the same --keep-per-group group, so the keep of a retention policy keeps the
+// newest tags of the repository.
+const policyGroupRegex = "()"
+
+// retentionPolicy is the retention policy of a repository, stored as JSON in its metadata like
+// {"ago": "30d", "keep": 5, "filter": "^dev-"}. Ago and filter have the meaning of the purge flags, keep is the number
+// of newest tags kept whatever their age.
+type retentionPolicy struct {
+	Ago    string `json:"ago,omitempty"`
+	Keep   int    `json:"keep,omitempty"`
+	Filter string `json:"filter,omitempty"`
+}
+
+// policyDefaults are the purge settings that weren't given on the command line, so the retention policy of a
+// repository can set them.
+type policyDefaults struct {
+	ago    bool
+	filter bool
+	keep   bool
+}
+
+func (d policyDefaults) any() bool {
+	return d.ago || d.filter || d.keep
+}
+
+// applyRetentionPolicy returns parameters with the settings of the retention policy of parameters.repoName, for the
+// ones in parameters.policyDefaults. A repository without a policy, or a registry without repository metadata, keeps
+// the parameters as they are.
+func applyRetentionPolicy(ctx context.Context, acrClient api.AcrCLIClientInterface, parameters purgeParameters) (purgeParameters, error) {
+	if !parameters.policyDefaults.any() {
+		return parameters, nil
+	}
+	value, err := acrClient.AcrGetRepositoryMetadata(ctx, parameters.repoName, retentionPolicyKey)
+	if isPolicyMissing(err) {
+		return parameters, nil
+	}
+	if err != nil {
+		return parameters, errors.Wrap(err, "unable to read the retention policy")
+	}
+	var policy retentionPolicy
+	if err := json.Unmarshal(value, &policy); err != nil {
+		return parameters, newInvalidArgumentsError("invalid retention policy of %s: %v", parameters.repoName, err)
+	}
+	if len(policy.Ago) > 0 {
+		if _, err := cutoffTime(policy.Ago, nowFunc()); err != nil {
+			return parameters, newInvalidArgumentsError("invalid ago %q in the retention policy of %s: %v", policy.Ago, parameters.repoName, err)
+		}
+	}
+	if _, err := regexp.Compile(policy.Filter); err != nil {
+		return parameters, newInvalidArgumentsError("invalid filter %q in the retention policy of %s: %s", policy.Filter, parameters.repoName, describeRegexError(policy.Filter, err))
+	}
+	if policy.Keep < 0 {
+		return parameters, newInvalidArgumentsError("invalid keep %d in the retention policy of %s, it must not be negative", policy.Keep, parameters.repoName)
+	}
+	if len(policy.Ago) > 0 && parameters.policyDefaults.ago {
+		parameters.ago = policy.Ago
+	}
+	if len(policy.Filter) > 0 && parameters.policyDefaults.filter {
+		parameters.filter = policy.Filter
+	}
+	if policy.Keep > 0 && parameters.policyDefaults.keep {
+		if parameters.sinceLastRun {
+			return parameters, newInvalidArgumentsError("the retention policy of %s keeps the newest tags, which can't be used with --since-last-run", parameters.repoName)
+		}
+		parameters.keepPerGroup = policy.Keep
+		parameters.groupRegex = policyGroupRegex
+	}
+	return parameters, nil
+}
+
+// isPolicyMissing reports whether err means the repository has no retention policy, because the key isn't set or
+// the registry doesn't support repository metadata.
+func isPolicyMissing(err error) bool {
+	switch err := errors.Cause(err).(type) {
+	case *api.RegistryError:
+		return err.StatusCode == http.StatusNotFound || err.StatusCode == http.StatusMethodNotAllowed
+	case *api.UnsupportedError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+func newPolicyRegistry() *fakeRegistry {
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now().Add(-10*24*time.Hour), "dev-1")
+	registry.addManifest("repo", testDigest(2), time.Now().Add(-9*24*time.Hour), "dev-2")
+	registry.addManifest("repo", testDigest(3), time.Now().Add(-8*24*time.Hour), "dev-3")
+	registry.addManifest("repo", testDigest(4), time.Now().Add(-3*24*time.Hour), "v1")
+	return registry
+}
+
+func purgePolicy(t *testing.T, registry *fakeRegistry, parameters purgeParameters) []string {
+	t.Helper()
+	if err := purge(context.Background(), registry, ioutil.Discard, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	deleted := registry.deletedTags["repo"]
+	sort.Strings(deleted)
+	return deleted
+}
+
+func TestPurgeRetentionPolicy(t *testing.T) {
+	allDefaults := policyDefaults{ago: true, filter: true, keep: true}
+	tests := []struct {
+		name       string
+		policy     string
+		parameters purgeParameters
+		expected   []string
+	}{
+		{"no policy", "", purgeParameters{ago: "1d", policyDefaults: allDefaults}, []string{"dev-1", "dev-2", "dev-3", "v1"}},
+		{"policy", `{"ago": "7d", "keep": 1, "filter": "^dev-"}`, purgeParameters{ago: "1d", policyDefaults: allDefaults}, []string{"dev-1", "dev-2"}},
+		{"flags first", `{"ago": "7d", "filter": "^dev-"}`, purgeParameters{ago: "1d", filter: "^v", policyDefaults: policyDefaults{ago: true}}, []string{}},
+		{"ago flag", `{"ago": "7d", "filter": "^dev-"}`, purgeParameters{ago: "1d", policyDefaults: policyDefaults{filter: true, keep: true}}, []string{"dev-1", "dev-2", "dev-3"}},
+		{"disabled", `{"ago": "7d", "filter": "^dev-"}`, purgeParameters{ago: "1d"}, []string{"dev-1", "dev-2", "dev-3", "v1"}},
+	}
+	for _, test := range tests {
+		registry := newPolicyRegistry()
+		if len(test.policy) > 0 {
+			registry.setMetadata("repo", retentionPolicyKey, test.policy)
+		}
+		test.parameters.repoName = "repo"
+		test.parameters.concurrency = defaultConcurrency
+		deleted := purgePolicy(t, registry, test.parameters)
+		if len(deleted) == 0 {
+			deleted = []string{}
+		}
+		if !reflect.DeepEqual(deleted, test.expected) {
+			t.Fatalf("%s: deleted tags incorrect, got %v, expected %v", test.name, deleted, test.expected)
+		}
+	}
+}
+
+func TestPurgeRetentionPolicyErrors(t *testing.T) {
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", policyDefaults: policyDefaults{ago: true, filter: true, keep: true}}
+	for _, policy := range []string{`not json`, `{"ago": "soon"}`, `{"filter": "["}`, `{"keep": -1}`} {
+		registry := newPolicyRegistry()
+		registry.setMetadata("repo", retentionPolicyKey, policy)
+		err := purge(context.Background(), registry, ioutil.Discard, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), parameters)
+		if _, ok := err.(*invalidArgumentsError); !ok {
+			t.Fatalf("expected an invalid arguments error for %s, got %T %v", policy, err, err)
+		}
+		if len(registry.deletedTags["repo"]) != 0 {
+			t.Fatalf("nothing should be deleted with the invalid policy %s, deleted %v", policy, registry.deletedTags["repo"])
+		}
+	}
+
+	// A registry without repository metadata falls back to the flags.
+	registry := newPolicyRegistry()
+	registry.failOn("AcrGetRepositoryMetadata repo", &api.RegistryError{StatusCode: http.StatusMethodNotAllowed})
+	if deleted := purgePolicy(t, registry, parameters); len(deleted) != 4 {
+		t.Fatalf("expected every tag older than the --ago default to be deleted, got %v", deleted)
+	}
+	registry = newPolicyRegistry()
+	registry.failOn("AcrGetRepositoryMetadata repo", &api.RegistryError{StatusCode: http.StatusForbidden})
+	if err := purge(context.Background(), registry, ioutil.Discard, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), parameters); err == nil {
+		t.Fatalf("expected an error when the policy can't be read")
+	}
+}
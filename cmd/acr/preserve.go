@@ -0,0 +1,98 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/pkg/errors"
+)
+
+// preservedReason is the reason reported for the tags and manifests skipped because of --preserve-digests-from-file.
+const preservedReason = "the digest is listed in the preserve file"
+
+// preserveList is the content of a --preserve-digests-from-file file, the digests to preserve and the tags whose
+// digest is preserved.
+type preserveList struct {
+	digests []string
+	tags    []preservedTag
+}
+
+// preservedTag is a repository:tag line of a preserve file.
+type preservedTag struct {
+	repository string
+	tag        string
+}
+
+// parsePreserveFile reads one digest like sha256:<hex> or one repository:tag per line. Blank lines and lines starting
+// with # are ignored.
+func parsePreserveFile(r io.Reader) (preserveList, error) {
+	var list preserveList
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "sha256:") || strings.HasPrefix(line, "sha512:") {
+			if err := api.ValidateDigest(line); err != nil {
+				return list, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			list.digests = append(list.digests, line)
+			continue
+		}
+		separator := strings.LastIndex(line, ":")
+		if separator <= 0 {
+			return list, fmt.Errorf("line %d: %q is neither a digest nor a repository:tag reference", lineNumber, line)
+		}
+		tag := preservedTag{repository: line[:separator], tag: line[separator+1:]}
+		if err := validateTagName(tag.tag); err != nil {
+			return list, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		list.tags = append(list.tags, tag)
+	}
+	if err := scanner.Err(); err != nil {
+		return list, err
+	}
+	return list, nil
+}
+
+// loadPreservedDigests reads the preserve file at path and returns the preserved digests, the tags it lists are
+// resolved to the digest they reference now. A listed tag that doesn't exist is an error, so the manifest it was meant
+// to preserve isn't deleted because of a typo.
+func loadPreservedDigests(ctx context.Context, acrClient api.AcrCLIClientInterface, path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	list, err := parsePreserveFile(file)
+	if err != nil {
+		return nil, &invalidArgumentsError{err: errors.Wrapf(err, "unable to parse %s", path)}
+	}
+	preserved := make(map[string]bool, len(list.digests)+len(list.tags))
+	for _, digest := range list.digests {
+		preserved[digest] = true
+	}
+	for _, tag := range list.tags {
+		manifest, err := acrClient.AcrGetManifestContent(ctx, tag.repository, tag.tag)
+		if registryError, ok := err.(*api.RegistryError); ok && registryError.StatusCode == http.StatusNotFound {
+			return nil, newInvalidArgumentsError("the tag %s:%s listed in %s doesn't exist", tag.repository, tag.tag, path)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to resolve the tag %s:%s listed in %s", tag.repository, tag.tag, path)
+		}
+		preserved[manifest.Digest] = true
+	}
+	return preserved, nil
+}
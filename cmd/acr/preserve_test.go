@@ -0,0 +1,135 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePreserveFile(t *testing.T) {
+	list, err := parsePreserveFile(strings.NewReader("# golden images\n" + testDigest(1) + "\n\n  base/ubuntu:22.04  \nregistry.io:5000/repo:v1\n"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(list.digests, []string{testDigest(1)}) {
+		t.Fatalf("parsePreserveFile digests incorrect, got %v", list.digests)
+	}
+	expectedTags := []preservedTag{{repository: "base/ubuntu", tag: "22.04"}, {repository: "registry.io:5000/repo", tag: "v1"}}
+	if !reflect.DeepEqual(list.tags, expectedTags) {
+		t.Fatalf("parsePreserveFile tags incorrect, got %v", list.tags)
+	}
+	for _, content := range []string{"sha256:abc\n", "repo\n", ":v1\n", "repo:-v1\n"} {
+		if _, err := parsePreserveFile(strings.NewReader("# line 1\n" + content)); err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Fatalf("%q: expected an error on line 2, got %v", content, err)
+		}
+	}
+}
+
+func TestPurgePreservedDigests(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "v1", "v2")
+	registry.addManifest("repo", testDigest(2), old, "v3")
+	registry.addManifest("repo", testDigest(3), old, "golden")
+	registry.addManifest("repo", testDigest(4), old)
+	registry.addManifest("repo", testDigest(5), old)
+	preserved := map[string]bool{testDigest(1): true, testDigest(3): true, testDigest(4): true}
+
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	deleted, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d", filter: "^(v|golden)", preserved: preserved})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deleted != 1 || !reflect.DeepEqual(registry.deletedTags["repo"], []string{"v3"}) {
+		t.Fatalf("expected only v3 to be deleted, got %d deleted: %v", deleted, registry.deletedTags["repo"])
+	}
+	// The tags are kept by --max-tags as well.
+	deleted, err = PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d", maxTags: 1, preserved: preserved})
+	if err != nil || deleted != 0 {
+		t.Fatalf("expected no deletion, got %d deleted and %v", deleted, err)
+	}
+
+	deleted, err = PurgeDanglingManifests(context.Background(), registry, results, "repo", manifestPurgeOptions{ago: "1d", preserved: preserved})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	deletedManifests := append([]string(nil), registry.deletedManifests["repo"]...)
+	sort.Strings(deletedManifests)
+	if !reflect.DeepEqual(deletedManifests, []string{testDigest(2), testDigest(5)}) {
+		t.Fatalf("expected the manifests that aren't preserved to be deleted, got %d deleted: %v", deleted, deletedManifests)
+	}
+
+	var preservedItems []string
+	for _, result := range results.report(false).Preserved {
+		if result.Reason != preservedReason {
+			t.Fatalf("unexpected reason %q", result.Reason)
+		}
+		preservedItems = append(preservedItems, results.reference(result))
+	}
+	sort.Strings(preservedItems)
+	expected := []string{
+		"registry.azurecr.io/repo:golden",
+		"registry.azurecr.io/repo:golden",
+		"registry.azurecr.io/repo:v1",
+		"registry.azurecr.io/repo:v1",
+		"registry.azurecr.io/repo:v2",
+		"registry.azurecr.io/repo:v2",
+		"registry.azurecr.io/repo@" + testDigest(4),
+	}
+	if !reflect.DeepEqual(preservedItems, expected) {
+		t.Fatalf("preserved items incorrect, got %v", preservedItems)
+	}
+}
+
+func TestPurgePreserveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preserve")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "golden.txt")
+	if err := ioutil.WriteFile(path, []byte(testDigest(2)+"\nrepo:latest\n"), 0644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "v1", "latest")
+	registry.addManifest("repo", testDigest(2), old, "v2")
+	registry.addManifest("repo", testDigest(3), old, "v3")
+	var out bytes.Buffer
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", output: outputText, quiet: true, preserveFile: path}
+	if err := runPurge(context.Background(), registry, &out, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(registry.deletedTags["repo"], []string{"v3"}) {
+		t.Fatalf("expected only v3 to be deleted, got %v", registry.deletedTags["repo"])
+	}
+	expected := "Deleted 1 tags and 1 manifests\n" +
+		"Preserved 3 items listed in the preserve file:\n" +
+		"  registry.azurecr.io/repo:latest\n" +
+		"  registry.azurecr.io/repo:v1\n" +
+		"  registry.azurecr.io/repo:v2\n"
+	if out.String() != expected {
+		t.Fatalf("unexpected summary:\n%s", out.String())
+	}
+
+	// A listed tag that doesn't exist fails the run before anything is deleted.
+	if err := ioutil.WriteFile(path, []byte("repo:missing\n"), 0644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = runPurge(context.Background(), registry, ioutil.Discard, "registry.azurecr.io", parameters)
+	if exitCode(err) != exitCodeInvalidArguments || !strings.Contains(err.Error(), "repo:missing") {
+		t.Fatalf("expected an invalid arguments error naming the tag, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// progressBarWidth is the number of characters of the bar between the brackets.
+	progressBarWidth = 30
+	// progressRedrawInterval is the minimum time between two redraws of the bar on a terminal.
+	progressRedrawInterval = 100 * time.Millisecond
+	// progressLineInterval is the minimum time between two progress lines when the output isn't a terminal.
+	progressLineInterval = 5 * time.Second
+)
+
+// progressBar reports how many of the items selected for deletion were processed, with the rate and the time left.
+// The total grows as the pages are listed and the items selected. On a terminal the bar is redrawn in place, otherwise
+// a line is written every progressLineInterval. It's safe to use from the deletion workers.
+type progressBar struct {
+	mu        sync.Mutex
+	out       io.Writer
+	terminal  bool
+	now       func() time.Time
+	start     time.Time
+	lastDraw  time.Time
+	total     int
+	completed int
+	// cleared is set when the bar was erased to write an item line, it's drawn again on the next update.
+	cleared bool
+	// drawn is set once the progress was written.
+	drawn bool
+}
+
+func newProgressBar(out io.Writer, terminal bool, now func() time.Time) *progressBar {
+	start := now()
+	return &progressBar{out: out, terminal: terminal, now: now, start: start, lastDraw: start}
+}
+
+// isTerminal reports whether out is a terminal, where the progress bar can be redrawn in place.
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// add adds n items selected for deletion to the total.
+func (p *progressBar) add(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total += n
+	p.draw(false)
+}
+
+// done counts n items as processed, whatever their outcome.
+func (p *progressBar) done(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.completed += n
+	p.draw(false)
+}
+
+// clear erases the bar from a terminal before a line is written to it, so the line isn't mixed with the bar.
+func (p *progressBar) clear() {
+	if p == nil || !p.terminal {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.cleared {
+		fmt.Fprint(p.out, "\r\x1b[K")
+		p.cleared = true
+	}
+}
+
+// finish draws the final state of the progress, the bar is ended with a new line. Without a terminal the final line
+// is only written when the run was long enough to write a progress line before.
+func (p *progressBar) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.terminal && !p.drawn {
+		return
+	}
+	p.draw(true)
+	if p.terminal {
+		fmt.Fprintln(p.out)
+	}
+}
+
+// draw writes the progress unless it was written less than an interval ago, the caller holds the lock.
+func (p *progressBar) draw(force bool) {
+	now := p.now()
+	interval := progressLineInterval
+	if p.terminal {
+		interval = progressRedrawInterval
+	}
+	if !force && !p.cleared && now.Sub(p.lastDraw) < interval {
+		return
+	}
+	p.lastDraw = now
+	p.cleared = false
+	p.drawn = true
+	status := fmt.Sprintf("%d/%d", p.completed, p.total)
+	elapsed := now.Sub(p.start).Seconds()
+	if elapsed > 0 && p.completed > 0 {
+		rate := float64(p.completed) / elapsed
+		eta := time.Duration(float64(p.total-p.completed) / rate * float64(time.Second)).Round(time.Second)
+		status = fmt.Sprintf("%s %.1f/s ETA %s", status, rate, eta)
+	}
+	if !p.terminal {
+		fmt.Fprintf(p.out, "Progress: %s\n", status)
+		return
+	}
+	filled := 0
+	if p.total > 0 {
+		filled = progressBarWidth * p.completed / p.total
+	}
+	fmt.Fprintf(p.out, "\r\x1b[K[%s%s] %s", strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), status)
+}
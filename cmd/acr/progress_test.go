@@ -0,0 +1,102 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a time that moves forward by step at every call.
+func fakeClock(step time.Duration) func() time.Time {
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		now = now.Add(step)
+		return now
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	var out bytes.Buffer
+	progress := newProgressBar(&out, true, fakeClock(time.Second))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				progress.add(1)
+				progress.done(1)
+			}
+		}()
+	}
+	wg.Wait()
+	progress.finish()
+	if progress.completed != 100 || progress.total != 100 {
+		t.Fatalf("expected 100/100 items, got %d/%d", progress.completed, progress.total)
+	}
+	lines := strings.Split(out.String(), "\r\x1b[K")
+	last := lines[len(lines)-1]
+	if !strings.HasPrefix(last, "[==============================] 100/100 ") || !strings.HasSuffix(last, " ETA 0s\n") {
+		t.Fatalf("unexpected final bar %q", last)
+	}
+}
+
+func TestProgressBarLines(t *testing.T) {
+	var out bytes.Buffer
+	progress := newProgressBar(&out, false, fakeClock(time.Second))
+	progress.add(10)
+	for i := 0; i < 10; i++ {
+		progress.done(1)
+	}
+	progress.finish()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	// A line is written every 5 seconds of the clock, and once at the end.
+	expected := []string{"Progress: 4/10 0.8/s ETA 8s", "Progress: 9/10 0.9/s ETA 1s", "Progress: 10/10 0.8/s ETA 0s"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Fatalf("progress lines incorrect, got %q expected %q", lines, expected)
+	}
+
+	// A short run doesn't write anything.
+	out.Reset()
+	progress = newProgressBar(&out, false, fakeClock(time.Millisecond))
+	progress.add(1)
+	progress.done(1)
+	progress.finish()
+	if out.Len() > 0 {
+		t.Fatalf("expected no progress, got %q", out.String())
+	}
+}
+
+func TestPurgeProgress(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	for i := 1; i <= 20; i++ {
+		registry.addManifest("repo", testDigest(i), old, fmt.Sprintf("v%02d", i))
+	}
+	registry.addManifest("repo", testDigest(21), old)
+	registry.addManifest("repo", testDigest(22), old, "locked")
+	registry.lock("repo", "locked")
+	var out bytes.Buffer
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	results.progress = newProgressBar(&out, true, fakeClock(time.Second))
+	parameters := purgeParameters{concurrency: 3, repoName: "repo", ago: "1d", output: outputText, logFormat: logFormatText}
+	if err := purge(context.Background(), registry, ioutil.Discard, results, parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	results.progress.finish()
+	// The 21 tags, including the locked one, and the 21 manifests left dangling.
+	if results.progress.completed != 42 || results.progress.total != 42 {
+		t.Fatalf("expected 42/42 items, got %d/%d", results.progress.completed, results.progress.total)
+	}
+	if !strings.Contains(out.String(), "] 42/42 ") {
+		t.Fatalf("expected the final bar to show 42/42, got %q", out.String())
+	}
+}
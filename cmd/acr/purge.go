@@ -7,15 +7,45 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
+	"net/http"
+	"os"
+	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	acrapi "github.com/AzureCR/acr-cli/acr"
 	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
+// orderByValues are the orders of the tag listing accepted by --orderby, the registry sorts by name by default.
+var orderByValues = []string{"timedesc", "timeasc"}
+
+// genericUnsupportedFlags are the purge flags that need the ACR API, the Distribution API can't list the manifests of
+// a repository or sort its tags by time.
+var genericUnsupportedFlags = []string{"dangling", "dangling-ago", "dangling-any-age", "manifest-filter", "include-media-types",
+	"exclude-media-types", "purge-referrers", "orderby", "report-remaining", "cascade"}
+
+// The --filter-mode values, how much of a tag name --filter has to match.
+const (
+	filterModeContains = "contains"
+	filterModeFull     = "full"
+	filterModePrefix   = "prefix"
+)
+
+var filterModes = []string{filterModeContains, filterModeFull, filterModePrefix}
+
+// defaultAgeSkew is the default --max-age-skew, it covers the usual difference between the local and registry clocks.
+const defaultAgeSkew = 5 * time.Second
+
+// defaultConcurrency is the default maximum number of tags or manifests deleted at the same time.
+const defaultConcurrency = 100
+
 const (
 	purgeLongMessage = `acr purge: untag old images and delete dangling manifests.`
 	exampleMessage   = `
@@ -25,21 +55,147 @@ Delete all tags that are older than 1 day
 Delete all tags that are older than 1 day and begin with hello
   acr purge -r MyRegistry --repository MyRepository --ago 1d --filter "^hello.*"
 
-Delete all dangling manifests
-  acr purge -r MyRegistry --repository MyRepository --dangling`
+Delete all tags that are older than 1 day and named exactly latest or stable, but not latest-build
+  acr purge -r MyRegistry --repository MyRepository --ago 1d --filter "latest|stable" --filter-mode full
+
+Delete all dangling manifests that are older than 1 day
+  acr purge -r MyRegistry --repository MyRepository --dangling
+
+Delete all dangling manifests, including the ones that were just pushed
+  acr purge -r MyRegistry --repository MyRepository --dangling --dangling-any-age
+
+Delete the dangling Helm chart manifests that are older than 7 days
+  acr purge -r MyRegistry --repository MyRepository --dangling --ago 7d --manifest-filter "helm"
+
+Delete the tags that are older than 30 days and the dangling manifests that are older than 7 days
+  acr purge -r MyRegistry --repository MyRepository --ago 30d --dangling-ago 7d
+
+Delete the tags that are older than 30 days and the manifests they leave untagged, even if they were updated since
+  acr purge -r MyRegistry --repository MyRepository --ago 30d --cascade
+
+Delete all dangling manifests that are older than 1 day except the Helm charts
+  acr purge -r MyRegistry --repository MyRepository --dangling --exclude-media-types application/vnd.cncf.helm.config.v1+json
+
+Delete all dangling manifests that are older than 1 day with their signatures and SBOMs
+  acr purge -r MyRegistry --repository MyRepository --dangling --purge-referrers
+
+Keep the 3 newest tags of every branch (tags like main-42 or dev-7) and delete the rest that are older than 7 days
+  acr purge -r MyRegistry --repository MyRepository --ago 7d --keep-per-group 3 --group-regex "^(.*)-[0-9]+$"
+
+Keep the latest patch of every minor version and the 2 latest minor versions of every major, deleting the other versions older than 30 days
+  acr purge -r MyRegistry --repository MyRepository --ago 30d --semver-keep minor:latest,major:2
+
+Keep at most 500 tags, deleting the oldest ones beyond that as well as the ones older than 30 days
+  acr purge -r MyRegistry --repository MyRepository --ago 30d --max-tags 500
+
+Show the tags that are older than 30 days and the dangling manifests a purge would delete, exiting with code 6 if
+there are more than 1000 of them
+  acr purge -r MyRegistry --repository MyRepository --ago 30d --dry-run --max-delete 1000
+
+Delete three known tags, whatever their age
+  acr purge -r MyRegistry --repository MyRepository --tags ci-1234,ci-1235,ci-1236
+
+Purge every repository listed in a file, one per line with optional ago= and filter= overrides
+  acr purge -r MyRegistry --repositories-from-file repositories.txt --ago 7d
+
+Purge every repository of the team namespace and every staging repository of the registry
+  acr purge -r MyRegistry --repository-glob "team/*" --repository-glob "*-staging" --ago 7d
+
+Delete the tags that are older than 30 days from every repository of the registry
+  acr purge -r MyRegistry --all-repositories --ago 30d
+
+Delete all tags that are older than 7 days from a Harbor registry, through the Distribution API
+  acr purge -r harbor.example.com --registry-type generic --repository library/MyRepository --ago 7d
+
+Delete all tags that are older than 1 day and show the results in a table with the full digests
+  acr purge -r MyRegistry --repository MyRepository --ago 1d --output table --no-trunc
+
+Delete all tags that are older than 1 day and print every deleted tag with its digest
+  acr purge -r MyRegistry --repository MyRepository --ago 1d --format "{{.Repo}}:{{.Tag}} {{.Digest}} {{.Outcome}}"
+
+Delete the tags that are older than 30 days but newer than 90 days, keeping the older release tags
+  acr purge -r MyRegistry --repository MyRepository --ago 30d --newer-than 90d
+
+Delete the tags that are older than 30 days and larger than 500MB, except the release ones
+  acr purge -r MyRegistry --repository MyRepository --where 'age > 30d && !name.matches("^release-") && size > 500MB'
+
+Delete all tags that are older than 30 days except the ones referencing the golden images listed in a file
+  acr purge -r MyRegistry --repository MyRepository --ago 30d --preserve-digests-from-file golden-images.txt
+
+Delete all tags that are older than 7 days from a scheduled job, starting within 10 minutes of the schedule
+  acr purge -r MyRegistry --repository MyRepository --ago 7d --jitter 10m
+
+Delete all tags that are older than 1 day, only evaluating the tags that changed since the last run
+  acr purge -r MyRegistry --repository MyRepository --ago 1d --since-last-run --state-file purge-state.json
+
+Delete all tags that are older than 1 day and the dangling manifests, keeping a CSV record of every deletion
+  acr purge -r MyRegistry --repository MyRepository --ago 1d --dangling-ago 7d --audit-file purge-audit.csv`
 )
 
 type purgeParameters struct {
-	registryName string
-	username     string
-	password     string
-	ago          string
-	dangling     bool
-	filter       string
-	repoName     string
+	ago              string
+	dangling         bool
+	filter           string
+	filterMode       string
+	repoName         string
+	reposFile        string
+	repoGlobs        []string
+	allRepositories  bool
+	failIfNone       bool
+	keepPerGroup     int
+	groupRegex       string
+	metricsFile      string
+	pushgateway      string
+	manifestFilter   string
+	mediaTypes       mediaTypeFilter
+	anyAge           bool
+	danglingAgo      string
+	output           string
+	logFormat        string
+	format           string
+	includeLocked    bool
+	reportRemaining  bool
+	purgeReferrers   bool
+	quiet            bool
+	maxTags          int
+	maxDelete        int64
+	dryRun           bool
+	stateFile        string
+	sinceLastRun     bool
+	concurrency      int
+	operationTimeout time.Duration
+	jitter           time.Duration
+	verbose          bool
+	ageSkew          time.Duration
+	semverKeep       string
+	semverOthers     bool
+	noTrunc          bool
+	orderBy          string
+	tagAge           string
+	cascade          bool
+	tags             []string
+	tagsFile         string
+	registryType     string
+	auditFile        string
+	newerThan        string
+	noRepoPolicy     bool
+	policyDefaults   policyDefaults
+	noProgress       bool
+	listRetries      int
+	skipPermission   bool
+	whereSource      string
+	preserveFile     string
+	// preserved are the digests read from --preserve-digests-from-file, they're never deleted.
+	preserved map[string]bool
+	// semverRetention is the parsed --semver-keep, nil when it isn't given.
+	semverRetention *semverRetention
+	// where is the parsed --where expression, nil when it isn't given.
+	where *whereExpression
+	// progressOut is where the progress is reported, there is no progress when it's nil.
+	progressOut io.Writer
 }
 
-func newPurgeCmd(out io.Writer) *cobra.Command {
+func newPurgeCmd(out io.Writer, rootParams *rootParameters) *cobra.Command {
 	var parameters purgeParameters
 	cmd := &cobra.Command{
 		Use:     "purge",
@@ -47,91 +203,882 @@ func newPurgeCmd(out io.Writer) *cobra.Command {
 		Long:    purgeLongMessage,
 		Example: exampleMessage,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			ctx := context.Background()
-			loginURL := api.LoginURL(parameters.registryName)
-			auth := api.BasicAuth(parameters.username, parameters.password)
-			if !parameters.dangling {
-				err := PurgeTags(ctx, loginURL, auth, parameters.repoName, parameters.ago, parameters.filter)
+			sources := 0
+			for _, given := range []bool{len(parameters.repoName) > 0, len(parameters.reposFile) > 0, len(parameters.repoGlobs) > 0, parameters.allRepositories} {
+				if given {
+					sources++
+				}
+			}
+			if sources != 1 {
+				return newInvalidArgumentsError("exactly one of --repository, --repositories-from-file, --repository-glob or --all-repositories must be specified")
+			}
+			for _, glob := range parameters.repoGlobs {
+				if _, err := path.Match(glob, ""); err != nil {
+					return newInvalidArgumentsError("invalid --repository-glob %q: %v", glob, err)
+				}
+			}
+			if parameters.output != outputText && !isDocumentOutput(parameters.output) && parameters.output != outputTable {
+				return newInvalidArgumentsError("--output must be %s, %s, %s or %s", outputText, outputJSON, outputYAML, outputTable)
+			}
+			if parameters.logFormat != logFormatText && parameters.logFormat != logFormatJSON {
+				return newInvalidArgumentsError("--log-format must be %s or %s", logFormatText, logFormatJSON)
+			}
+			if parameters.logFormat == logFormatJSON && parameters.output != outputText {
+				return newInvalidArgumentsError("--log-format %s can only be used with --output %s", logFormatJSON, outputText)
+			}
+			if len(parameters.format) > 0 {
+				if parameters.output != outputText || parameters.logFormat != logFormatText {
+					return newInvalidArgumentsError("--format can only be used with --output %s and --log-format %s", outputText, logFormatText)
+				}
+				if _, err := parseFormat(parameters.format); err != nil {
+					return err
+				}
+			}
+			if len(parameters.danglingAgo) > 0 {
+				if parameters.anyAge {
+					return newInvalidArgumentsError("--dangling-ago can't be used with --dangling-any-age")
+				}
+				if _, err := cutoffTime(parameters.danglingAgo, nowFunc()); err != nil {
+					return newInvalidArgumentsError("invalid --dangling-ago %q: %v", parameters.danglingAgo, err)
+				}
+			}
+			if len(parameters.tags) > 0 || len(parameters.tagsFile) > 0 {
+				if len(parameters.repoName) == 0 {
+					return newInvalidArgumentsError("--tags and --tags-from-file require --repository")
+				}
+				for _, name := range explicitTagsConflictingFlags {
+					if cmd.Flags().Changed(name) {
+						return newInvalidArgumentsError("--%s can't be used with --tags or --tags-from-file, the listed tags are deleted whatever their age", name)
+					}
+				}
+				for _, tag := range parameters.tags {
+					if err := validateTagName(tag); err != nil {
+						return &invalidArgumentsError{err: err}
+					}
+				}
+			}
+			if len(parameters.newerThan) > 0 {
+				if parameters.dangling {
+					return newInvalidArgumentsError("--newer-than can't be used with --dangling, no tag is deleted")
+				}
+				if _, err := tagWindowStart(parameters.ago, parameters.newerThan, nowFunc()); err != nil {
+					return err
+				}
+			}
+			if parameters.cascade && parameters.dangling {
+				return newInvalidArgumentsError("--cascade can't be used with --dangling, no tag is deleted")
+			}
+			if parameters.reportRemaining && !isDocumentOutput(parameters.output) {
+				return newInvalidArgumentsError("--report-remaining requires --output %s or %s", outputJSON, outputYAML)
+			}
+			if parameters.keepPerGroup < 0 {
+				return newInvalidArgumentsError("--keep-per-group must not be negative")
+			}
+			// The regular expressions are checked before the registry is called, so a typo fails right away.
+			if _, err := compileFlagRegex("--filter", parameters.filter); err != nil {
+				return err
+			}
+			if _, err := compileFlagRegex("--manifest-filter", parameters.manifestFilter); err != nil {
+				return err
+			}
+			if len(parameters.groupRegex) > 0 {
+				if _, err := compileGroupRegex(parameters.groupRegex); err != nil {
+					return err
+				}
+			}
+			if !containsString(filterModes, parameters.filterMode) {
+				return newInvalidArgumentsError("--filter-mode must be %s", strings.Join(filterModes, ", "))
+			}
+			if len(parameters.orderBy) > 0 && !containsString(orderByValues, parameters.orderBy) {
+				return newInvalidArgumentsError("--orderby must be %s", strings.Join(orderByValues, " or "))
+			}
+			if _, ok := newTagAgeResolver(parameters.tagAge); !ok {
+				return newInvalidArgumentsError("--tag-age must be %s", strings.Join(tagAgeSources(), " or "))
+			}
+			if err := parameters.mediaTypes.validate(); err != nil {
+				return err
+			}
+			if parameters.concurrency < 1 {
+				return newInvalidArgumentsError("--concurrency must be at least 1")
+			}
+			if parameters.operationTimeout < 0 {
+				return newInvalidArgumentsError("--operation-timeout must not be negative")
+			}
+			if parameters.ageSkew < 0 {
+				return newInvalidArgumentsError("--max-age-skew must not be negative")
+			}
+			if parameters.jitter < 0 {
+				return newInvalidArgumentsError("--jitter must not be negative")
+			}
+			if parameters.listRetries < 0 {
+				return newInvalidArgumentsError("--retry must not be negative")
+			}
+			if parameters.maxDelete < 0 {
+				return newInvalidArgumentsError("--max-delete must not be negative")
+			}
+			if parameters.dryRun {
+				for _, name := range []string{"audit-file", "state-file", "metrics-file", "metrics-pushgateway"} {
+					if cmd.Flags().Changed(name) {
+						return newInvalidArgumentsError("--dry-run can't be used with --%s, it records deletions", name)
+					}
+				}
+			}
+			if parameters.maxTags < 0 {
+				return newInvalidArgumentsError("--max-tags must not be negative")
+			}
+			if len(parameters.groupRegex) > 0 && parameters.keepPerGroup == 0 {
+				return newInvalidArgumentsError("--group-regex requires --keep-per-group")
+			}
+			if parameters.sinceLastRun && len(parameters.stateFile) == 0 {
+				return newInvalidArgumentsError("--since-last-run requires --state-file")
+			}
+			if parameters.sinceLastRun && (parameters.keepPerGroup > 0 || parameters.maxTags > 0 || len(parameters.semverKeep) > 0) {
+				return newInvalidArgumentsError("--since-last-run can't be used with --keep-per-group, --semver-keep or --max-tags, they need every tag")
+			}
+			if len(parameters.semverKeep) > 0 {
+				semverRetention, err := parseSemverKeep(parameters.semverKeep, parameters.semverOthers)
 				if err != nil {
 					return err
 				}
+				parameters.semverRetention = semverRetention
+			} else if parameters.semverOthers {
+				return newInvalidArgumentsError("--semver-purge-others requires --semver-keep")
+			}
+			if len(parameters.whereSource) > 0 {
+				if parameters.sinceLastRun {
+					return newInvalidArgumentsError("--since-last-run can't be used with --where, a tag it didn't select can meet it later")
+				}
+				where, err := parseWhere(parameters.whereSource)
+				if err != nil {
+					return err
+				}
+				parameters.where = where
+				// The expression is the only age condition when --ago isn't given.
+				if !cmd.Flags().Changed("ago") {
+					parameters.ago = "0d"
+				}
 			}
-			err := PurgeDanglingManifests(ctx, loginURL, auth, parameters.repoName)
+			if rootParams.isGeneric() {
+				for _, name := range genericUnsupportedFlags {
+					if cmd.Flags().Changed(name) {
+						return newInvalidArgumentsError("--%s isn't supported with --registry-type %s, it needs the ACR API", name, registryTypeGeneric)
+					}
+				}
+			}
+			parameters.registryType = rootParams.registryType
+			if !parameters.noProgress {
+				parameters.progressOut = cmd.ErrOrStderr()
+			}
+			if !parameters.noRepoPolicy {
+				parameters.policyDefaults = policyDefaults{
+					ago:    !cmd.Flags().Changed("ago") && parameters.where == nil,
+					filter: !cmd.Flags().Changed("filter"),
+					keep:   !cmd.Flags().Changed("keep-per-group"),
+				}
+			}
+			ctx, cancel := signalContext()
+			defer cancel()
+			loginURL, err := rootParams.loginURL()
 			if err != nil {
 				return err
 			}
-
-			return nil
+			if _, err := waitJitter(ctx, cmd.ErrOrStderr(), parameters.jitter, newJitterRand(), sleepContext); err != nil {
+				return err
+			}
+			client, err := rootParams.newAcrClient(loginURL, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			var acrClient api.AcrCLIClientInterface = client
+			if parameters.dryRun {
+				acrClient = newDryRunClient(acrClient)
+			}
+			var timings *purgeTimings
+			if parameters.verbose {
+				timings = newPurgeTimings(nowFunc)
+				acrClient = newTimingClient(acrClient, timings)
+			}
+			if parameters.operationTimeout > 0 {
+				acrClient = newTimeoutClient(acrClient, parameters.operationTimeout)
+			}
+			if parameters.listRetries > 0 {
+				acrClient = newListRetryClient(acrClient, parameters.listRetries)
+			}
+			var metrics *purgeMetrics
+			if len(parameters.metricsFile) > 0 || len(parameters.pushgateway) > 0 {
+				metrics = newPurgeMetrics(loginURL)
+				acrClient = newMetricsClient(acrClient, metrics)
+			}
+			// A dry run goes to the end and reports whether it would have been stopped.
+			if parameters.maxDelete > 0 && !parameters.dryRun {
+				acrClient = newDeletionCapClient(acrClient, parameters.maxDelete)
+			}
+			err = runPurge(ctx, acrClient, out, loginURL, parameters)
+			if timings != nil {
+				if timingsErr := timings.write(cmd.ErrOrStderr()); timingsErr != nil && err == nil {
+					err = timingsErr
+				}
+			}
+			if metrics != nil {
+				// The Pushgateway is reached through the same proxy and certificates as the registry.
+				httpClient, metricsErr := rootParams.newHTTPClient()
+				if metricsErr == nil {
+					metricsErr = metrics.publish(parameters.metricsFile, parameters.pushgateway, httpClient)
+				}
+				if metricsErr != nil {
+					if err == nil {
+						return metricsErr
+					}
+					fmt.Fprintf(cmd.ErrOrStderr(), "Unable to publish metrics: %s\n", api.Redact(metricsErr.Error()))
+				}
+			}
+			return err
 		},
 	}
 
-	cmd.PersistentFlags().StringVarP(&parameters.registryName, "registry", "r", "", "Registry name")
-	cmd.MarkPersistentFlagRequired("registry")
-	cmd.PersistentFlags().StringVarP(&parameters.username, "username", "u", "", "Registry username")
-	cmd.MarkPersistentFlagRequired("username")
-	cmd.PersistentFlags().StringVarP(&parameters.password, "password", "p", "", "Registry password")
-	cmd.MarkPersistentFlagRequired("password")
-
-	cmd.Flags().StringVar(&parameters.ago, "ago", "1d", "The images that were created before this timeStamp will be deleted")
+	cmd.Flags().StringVar(&parameters.ago, "ago", "1d", "The images that were last updated before this duration ago, like 7d or P30D, or this RFC 3339 time will be deleted")
 	cmd.Flags().BoolVar(&parameters.dangling, "dangling", false, "Just remove dangling manifests")
 	cmd.Flags().StringVarP(&parameters.filter, "filter", "f", "", "Given as a regular expression, if a tag matches the pattern and is older than the time specified in ago it gets deleted.")
+	cmd.Flags().StringVar(&parameters.filterMode, "filter-mode", filterModeContains, "How much of a tag name --filter has to match, contains, full or prefix")
+	cmd.Flags().BoolVar(&parameters.anyAge, "dangling-any-age", false, "Delete dangling manifests regardless of their age, including the ones that were just pushed")
+	cmd.Flags().StringVar(&parameters.danglingAgo, "dangling-ago", "", "The dangling manifests that were last updated before this duration ago or time will be deleted, --ago when empty")
+	cmd.Flags().BoolVar(&parameters.cascade, "cascade", false, "Also delete the manifests left without tags by this run, whatever their age")
+	cmd.Flags().StringVar(&parameters.whereSource, "where", "", "Only delete the tags and dangling manifests meeting this condition, like 'age > 30d && size > 500MB'")
+	cmd.Flags().StringVar(&parameters.preserveFile, "preserve-digests-from-file", "", "A file listing the digests or repository:tag references that are never deleted, one per line")
+	cmd.Flags().StringVar(&parameters.manifestFilter, "manifest-filter", "", "Given as a regular expression, only the dangling manifests whose media type or digest match the pattern get deleted")
+	cmd.Flags().StringSliceVar(&parameters.mediaTypes.include, "include-media-types", nil, "Only delete the dangling manifests with one of these media types, comma separated or repeated")
+	cmd.Flags().StringSliceVar(&parameters.mediaTypes.exclude, "exclude-media-types", nil, "Never delete the dangling manifests with one of these media types, comma separated or repeated")
+	cmd.Flags().BoolVar(&parameters.purgeReferrers, "purge-referrers", false, "Delete the artifacts that reference a manifest through the referrers API, like signatures and SBOMs, before deleting the manifest")
 	cmd.Flags().StringVar(&parameters.repoName, "repository", "", "The repository which will be purged.")
-	cmd.MarkFlagRequired("repository")
+	cmd.Flags().StringSliceVar(&parameters.tags, "tags", nil, "Only delete these tags of --repository, comma separated or repeated, whatever their age")
+	cmd.Flags().StringVar(&parameters.tagsFile, "tags-from-file", "", "A file listing tags to delete like --tags, one per line")
+	cmd.Flags().StringVar(&parameters.orderBy, "orderby", "", "The order the tags are listed and deleted in, timedesc or timeasc, by name when empty")
+	cmd.Flags().StringVar(&parameters.tagAge, "tag-age", tagAgeLastUpdate, "The time the age of a tag is computed from, lastupdate for its last push or created for its first push")
+	cmd.Flags().IntVar(&parameters.keepPerGroup, "keep-per-group", 0, "Keep the newest N tags of every group defined by --group-regex")
+	cmd.Flags().StringVar(&parameters.groupRegex, "group-regex", "", "Given as a regular expression with a capture group, tags with the same captured value belong to the same --keep-per-group group")
+	cmd.Flags().DurationVar(&parameters.ageSkew, "max-age-skew", defaultAgeSkew, "Move the duration cutoffs of --ago and --dangling-ago back by this duration to allow for clock skew")
+	cmd.Flags().StringVar(&parameters.semverKeep, "semver-keep", "", "Keep the highest semantic versions among the tags, as comma separated rules like major:latest,minor:3")
+	cmd.Flags().BoolVar(&parameters.semverOthers, "semver-purge-others", false, "With --semver-keep, delete the tags that aren't semantic versions instead of keeping them")
+	cmd.Flags().StringVar(&parameters.newerThan, "newer-than", "", "Only delete the tags that were last updated after this duration ago or time")
+	cmd.Flags().IntVar(&parameters.maxTags, "max-tags", 0, "Keep at most N tags matching the filter, the oldest ones beyond N are deleted")
+	cmd.Flags().Int64Var(&parameters.maxDelete, "max-delete", 0, "Stop the run before deleting more than N tags and manifests in total, no limit when 0")
+	cmd.Flags().BoolVar(&parameters.dryRun, "dry-run", false, "Print the tags and manifests that would be deleted without deleting them")
+	cmd.Flags().IntVar(&parameters.concurrency, "concurrency", defaultConcurrency, "The maximum number of tags or manifests deleted at the same time")
+	cmd.Flags().DurationVar(&parameters.operationTimeout, "operation-timeout", 0, "The maximum duration of a single registry request, like 30s")
+	cmd.Flags().DurationVar(&parameters.jitter, "jitter", 0, "Wait a random duration up to this one, like 5m, before starting the run")
+	cmd.Flags().IntVar(&parameters.listRetries, "retry", 0, "Retry a failed page of the tag or manifest listing up to N times")
+	cmd.Flags().BoolVar(&parameters.skipPermission, "skip-permission-check", false, "Don't check that the credentials can delete from every repository before purging it")
+	cmd.Flags().BoolVar(&parameters.failIfNone, "fail-if-nothing-deleted", false, "Exit with a distinct code when the run didn't delete anything")
+	cmd.Flags().StringVar(&parameters.metricsFile, "metrics-file", "", "Write the metrics of the run to this file in the Prometheus text format, for the node exporter textfile collector")
+	cmd.Flags().StringVar(&parameters.pushgateway, "metrics-pushgateway", "", "Push the metrics of the run to this Prometheus Pushgateway URL")
+	cmd.Flags().StringVarP(&parameters.output, "output", "o", outputText, "Output format, text, json, yaml or table")
+	cmd.Flags().StringVar(&parameters.logFormat, "log-format", logFormatText, "The format of the line written for every item in text output, text or json")
+	cmd.Flags().StringVar(&parameters.format, "format", "", "A Go template rendered for every item in text output, like '{{.Repo}}:{{.Tag}} {{.Outcome}}'")
+	cmd.Flags().BoolVar(&parameters.noTrunc, "no-trunc", false, "Don't truncate the digests in the table output")
+	cmd.Flags().BoolVarP(&parameters.quiet, "quiet", "q", false, "Don't print every deleted tag and manifest, only the summary")
+	cmd.Flags().BoolVar(&parameters.verbose, "verbose", false, "Write the timings of every repository and the throughput of the run on stderr")
+	cmd.Flags().BoolVar(&parameters.noProgress, "no-progress", false, "Don't report the progress of the deletions on stderr")
+	cmd.Flags().BoolVar(&parameters.reportRemaining, "report-remaining", false, "Include the tags and manifests left in every repository in the json or yaml output")
+	cmd.Flags().BoolVar(&parameters.includeLocked, "include-locked", false, "List the locked tags and manifests that were skipped in the summary")
+	cmd.Flags().StringVar(&parameters.auditFile, "audit-file", "", "Append a record of every deleted tag and manifest to this file, as JSON lines for a .jsonl name and CSV otherwise")
+	cmd.Flags().StringVar(&parameters.stateFile, "state-file", "", "Record the time of the last successful run of every repository in this file")
+	cmd.Flags().BoolVar(&parameters.sinceLastRun, "since-last-run", false, "Only evaluate the tags updated since the last successful run recorded in --state-file")
+	cmd.Flags().BoolVar(&parameters.noRepoPolicy, "no-repository-policy", false, "Don't read the retention policy stored in the metadata of the repositories")
+	cmd.Flags().StringArrayVar(&parameters.repoGlobs, "repository-glob", nil, "Purge every repository of the registry matching this shell pattern, like team/*, can be repeated")
+	cmd.Flags().BoolVar(&parameters.allRepositories, "all-repositories", false, "Purge every repository of the registry, including the nested ones")
+	cmd.Flags().StringVar(&parameters.reposFile, "repositories-from-file", "", "A file listing the repositories to purge, one per line, with optional ago= and filter= overrides")
+	markRepositoryCompletion(cmd)
+	markFilterCompletion(cmd)
 
 	return cmd
 }
 
-// PurgeTags deletes all tags that are older than the ago value and that match the filter string (if present).
-func PurgeTags(ctx context.Context, loginURL string, auth string, repoName string, ago string, filter string) error {
-	var wg sync.WaitGroup
-	agoDuration, err := ParseDuration(ago)
+// writesItemLines reports whether the text output only has a line per item, with --log-format json or --format, so
+// no summary is written in between.
+func (p purgeParameters) writesItemLines() bool {
+	return p.logFormat == logFormatJSON || len(p.format) > 0
+}
+
+// runPurge purges the repository or the repositories file given in parameters and writes the summary of the items
+// that couldn't be deleted to out.
+func runPurge(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	out io.Writer,
+	loginURL string,
+	parameters purgeParameters) error {
+	results := newPurgeResults(out, loginURL, parameters.output)
+	results.quiet = parameters.quiet
+	results.noTrunc = parameters.noTrunc
+	results.logFormat = parameters.logFormat
+	results.dryRun = parameters.dryRun
+	if len(parameters.format) > 0 {
+		format, err := parseFormat(parameters.format)
+		if err != nil {
+			return err
+		}
+		results.format = format
+	}
+	if len(parameters.auditFile) > 0 {
+		audit, err := openAuditLog(parameters.auditFile)
+		if err != nil {
+			return err
+		}
+		results.audit = audit
+	}
+	if len(parameters.preserveFile) > 0 {
+		preserved, err := loadPreservedDigests(ctx, acrClient, parameters.preserveFile)
+		if err != nil {
+			return err
+		}
+		parameters.preserved = preserved
+	}
+	if parameters.progressOut != nil && !isDocumentOutput(parameters.output) && !parameters.quiet {
+		results.progress = newProgressBar(parameters.progressOut, isTerminal(parameters.progressOut), time.Now)
+	}
+	err := purge(ctx, acrClient, out, results, parameters)
+	results.progress.finish()
+	if results.audit != nil {
+		if auditErr := results.audit.close(); auditErr != nil && err == nil {
+			err = auditErr
+		}
+	}
+	if exitCode(err) == exitCodeInvalidArguments {
+		return err
+	}
+	if summaryErr := results.writeSummary(out, parameters.includeLocked); summaryErr != nil && err == nil {
+		return summaryErr
+	}
+	if planned := int64(len(results.report(false).Deleted)); parameters.dryRun && parameters.maxDelete > 0 && planned > parameters.maxDelete && err == nil {
+		return &deletionCapError{max: parameters.maxDelete, planned: planned}
+	}
+	return err
+}
+
+// purge purges the repository or the repositories file given in parameters. When a state file is given it's updated
+// with the repositories that were purged successfully, even if the others failed.
+func purge(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	out io.Writer,
+	results *purgeResults,
+	parameters purgeParameters) error {
+	if len(parameters.stateFile) == 0 {
+		return purgeWithState(ctx, acrClient, out, results, nil, parameters)
+	}
+	state, err := loadPurgeState(parameters.stateFile)
+	if err != nil {
+		return err
+	}
+	err = purgeWithState(ctx, acrClient, out, results, state, parameters)
+	if exitCode(err) == exitCodeInvalidArguments {
+		return err
+	}
+	if stateErr := state.save(parameters.stateFile); stateErr != nil && err == nil {
+		return errors.Wrap(stateErr, "unable to save the state file")
+	}
+	return err
+}
+
+func purgeWithState(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	out io.Writer,
+	results *purgeResults,
+	state *purgeState,
+	parameters purgeParameters) error {
+	if len(parameters.reposFile) > 0 {
+		file, err := os.Open(parameters.reposFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		entries, err := parseRepositoriesFile(file)
+		if err != nil {
+			return &invalidArgumentsError{err: errors.Wrapf(err, "unable to parse %s", parameters.reposFile)}
+		}
+		return purgeRepositories(ctx, acrClient, out, results, state, entries, parameters)
+	}
+	if len(parameters.repoGlobs) > 0 {
+		repositories, err := listRepositories(ctx, acrClient)
+		if err != nil {
+			return errors.Wrap(err, "unable to list the repositories")
+		}
+		entries := matchRepositories(repositories, parameters.repoGlobs)
+		if len(entries) == 0 {
+			if !parameters.writesItemLines() {
+				fmt.Fprintf(out, "No repository matches %s\n", strings.Join(parameters.repoGlobs, " or "))
+			}
+			if parameters.failIfNone {
+				return errNothingDeleted
+			}
+			return nil
+		}
+		return purgeRepositories(ctx, acrClient, out, results, state, entries, parameters)
+	}
+	if parameters.allRepositories {
+		repositories, err := listRepositories(ctx, acrClient)
+		if err != nil {
+			return errors.Wrap(err, "unable to list the repositories")
+		}
+		if len(repositories) == 0 {
+			if !parameters.writesItemLines() {
+				fmt.Fprintln(out, "The registry has no repository")
+			}
+			if parameters.failIfNone {
+				return errNothingDeleted
+			}
+			return nil
+		}
+		entries := make([]repositoryEntry, 0, len(repositories))
+		for _, repository := range repositories {
+			entries = append(entries, repositoryEntry{name: repository})
+		}
+		return purgeRepositories(ctx, acrClient, out, results, state, entries, parameters)
+	}
+	if len(parameters.tags) > 0 || len(parameters.tagsFile) > 0 {
+		tags, err := explicitTags(parameters.tags, parameters.tagsFile)
+		if err != nil {
+			return err
+		}
+		deletedTags, err := deleteTags(ctx, acrClient, results, parameters.repoName, tags, !parameters.skipPermission, parameters.concurrency)
+		if err != nil {
+			return err
+		}
+		if parameters.failIfNone && deletedTags == 0 {
+			return errNothingDeleted
+		}
+		return nil
+	}
+	deletedTags, deletedManifests, err := purgeRepository(ctx, acrClient, results, state, parameters)
 	if err != nil {
 		return err
 	}
-	timeToCompare := time.Now().UTC()
-	timeToCompare = timeToCompare.Add(agoDuration)
-	regex, err := regexp.Compile(filter)
+	if parameters.failIfNone && deletedTags+deletedManifests == 0 {
+		return errNothingDeleted
+	}
+	return nil
+}
+
+// purgeRepository untags old images (unless only dangling manifests were requested) and then deletes the dangling
+// manifests of parameters.repoName, it returns the number of deleted tags and manifests. Failed deletions don't stop
+// the dangling manifests from being purged, a generic registry only has its tags purged. Unless
+// --skip-permission-check is given, nothing is deleted when the credentials can't delete from the repository. When
+// state isn't nil a successful run is recorded in it, and with --since-last-run the tags evaluated by the last
+// recorded run are skipped. With --report-remaining the repository is listed again at the end, unless the run was
+// stopped.
+func purgeRepository(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	results *purgeResults,
+	state *purgeState,
+	parameters purgeParameters) (int, int, error) {
+	start := nowFunc().UTC()
+	if err := checkRepositoryExists(ctx, acrClient, results.loginURL, parameters.repoName); err != nil {
+		return 0, 0, err
+	}
+	if !parameters.skipPermission {
+		if err := acrClient.AcrCheckDeletePermission(ctx, parameters.repoName); err != nil {
+			return 0, 0, err
+		}
+	}
+	parameters, err := applyRetentionPolicy(ctx, acrClient, parameters)
+	if err != nil {
+		return 0, 0, err
+	}
+	parameters.filter = anchorFilter(parameters.filter, parameters.filterMode)
+	deletedTags := 0
+	var tagsErr error
+	if !parameters.dangling {
+		ageResolver, ok := newTagAgeResolver(parameters.tagAge)
+		if !ok {
+			return 0, 0, newInvalidArgumentsError("unknown tag age %q", parameters.tagAge)
+		}
+		since, err := tagWindowStart(parameters.ago, parameters.newerThan, nowFunc())
+		if err != nil {
+			return 0, 0, err
+		}
+		if state != nil && parameters.sinceLastRun {
+			if lastRun := state.since(results.loginURL, parameters.repoName, parameters.ago, parameters.filter, parameters.ageSkew); lastRun.After(since) {
+				since = lastRun
+			}
+		}
+		deletedTags, tagsErr = PurgeTags(ctx, acrClient, results, parameters.repoName, tagPurgeOptions{
+			ago:          parameters.ago,
+			filter:       parameters.filter,
+			orderBy:      parameters.orderBy,
+			keepPerGroup: parameters.keepPerGroup,
+			groupRegex:   parameters.groupRegex,
+			maxTags:      parameters.maxTags,
+			semverKeep:   parameters.semverRetention,
+			since:        since,
+			ageResolver:  ageResolver,
+			where:        parameters.where,
+			preserved:    parameters.preserved,
+			skew:         parameters.ageSkew,
+			concurrency:  parameters.concurrency,
+		})
+		if _, ok := tagsErr.(*partialFailureError); tagsErr != nil && (!ok || stopsRun(tagsErr)) {
+			return deletedTags, 0, tagsErr
+		}
+	}
+	if parameters.registryType == registryTypeGeneric {
+		if tagsErr == nil && state != nil {
+			state.update(results.loginURL, parameters.repoName, parameters.ago, parameters.filter, parameters.ageSkew, start)
+		}
+		return deletedTags, 0, tagsErr
+	}
+	danglingAgo := parameters.ago
+	if len(parameters.danglingAgo) > 0 {
+		danglingAgo = parameters.danglingAgo
+	}
+	if parameters.anyAge {
+		danglingAgo = ""
+	}
+	var orphaned map[string]bool
+	if parameters.cascade {
+		orphaned = results.untaggedDigests(parameters.repoName)
+	}
+	deletedManifests, err := PurgeDanglingManifests(ctx, acrClient, results, parameters.repoName, manifestPurgeOptions{
+		ago:            danglingAgo,
+		manifestFilter: parameters.manifestFilter,
+		mediaTypes:     parameters.mediaTypes,
+		purgeReferrers: parameters.purgeReferrers,
+		orphaned:       orphaned,
+		where:          parameters.where,
+		preserved:      parameters.preserved,
+		skew:           parameters.ageSkew,
+		concurrency:    parameters.concurrency,
+	})
+	if tagsErr != nil {
+		return deletedTags, deletedManifests, tagsErr
+	}
+	if err == nil && state != nil && !parameters.dangling {
+		state.update(results.loginURL, parameters.repoName, parameters.ago, parameters.filter, parameters.ageSkew, start)
+	}
+	if parameters.reportRemaining && !stopsRun(err) {
+		if remainingErr := recordRemaining(ctx, acrClient, results, parameters.repoName); remainingErr != nil && err == nil {
+			return deletedTags, deletedManifests, errors.Wrap(remainingErr, "unable to list the remaining items")
+		}
+	}
+	return deletedTags, deletedManifests, err
+}
+
+// recordRemaining lists the tags and manifests left in repoName and stores them in results.
+func recordRemaining(ctx context.Context, acrClient api.AcrCLIClientInterface, results *purgeResults, repoName string) error {
+	ctx = withRemainingListing(ctx)
+	remaining := remainingItems{Repository: repoName, Tags: []string{}, Manifests: []string{}}
+	err := listTags(ctx, acrClient, repoName, "", func(tag acrapi.TagAttributesBase) error {
+		remaining.Tags = append(remaining.Tags, *tag.Name)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	var matches bool
-	var lastUpdateTime time.Time
-	var errorChannel = make(chan error, 100)
-	defer close(errorChannel)
-	lastTag := ""
-	resultTags, err := api.AcrListTags(ctx, loginURL, auth, repoName, "", lastTag)
+	err = listManifests(ctx, acrClient, repoName, func(manifest acrapi.ManifestAttributesBase) error {
+		remaining.Manifests = append(remaining.Manifests, *manifest.Digest)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	for resultTags != nil && resultTags.Tags != nil {
-		tags := *resultTags.Tags
-		for _, tag := range tags {
+	results.recordRemaining(remaining)
+	return nil
+}
+
+// checkRepositoryExists returns an invalid arguments error naming the repository and the registry when repoName doesn't
+// exist, instead of the not found error the first listing would fail with.
+func checkRepositoryExists(ctx context.Context, acrClient api.AcrCLIClientInterface, loginURL string, repoName string) error {
+	_, err := acrClient.AcrGetRepositoryAttributes(ctx, repoName)
+	if registryError, ok := err.(*api.RegistryError); ok && registryError.StatusCode == http.StatusNotFound {
+		return newInvalidArgumentsError("repository '%s' not found in registry '%s'", repoName, loginURL)
+	}
+	return err
+}
+
+// purgeRepositories purges every repository in entries one after the other, the ago and filter parameters are used
+// for the entries that don't override them. A failure on one repository doesn't stop the others from being purged,
+// unless --max-delete was reached, a summary for every repository is written to out at the end in text output.
+func purgeRepositories(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	out io.Writer,
+	results *purgeResults,
+	state *purgeState,
+	entries []repositoryEntry,
+	parameters purgeParameters) error {
+	summaries := make([]string, 0, len(entries))
+	failed := 0
+	totalDeleted := 0
+	for _, entry := range entries {
+		repoParameters := parameters
+		repoParameters.repoName = entry.name
+		if len(entry.ago) > 0 {
+			repoParameters.ago = entry.ago
+			repoParameters.policyDefaults.ago = false
+		}
+		if len(entry.filter) > 0 {
+			repoParameters.filter = entry.filter
+			repoParameters.policyDefaults.filter = false
+		}
+		deletedTags, deletedManifests, err := purgeRepository(ctx, acrClient, results, state, repoParameters)
+		totalDeleted += deletedTags + deletedManifests
+		if isDeletionCapReached(err) {
+			return err
+		}
+		if err != nil {
+			failed++
+			summaries = append(summaries, fmt.Sprintf("%s: failed after deleting %d tags and %d manifests: %v", entry.name, deletedTags, deletedManifests, err))
+			continue
+		}
+		summaries = append(summaries, fmt.Sprintf("%s: %d tags deleted, %d manifests deleted", entry.name, deletedTags, deletedManifests))
+	}
+	if !isDocumentOutput(parameters.output) && !parameters.writesItemLines() {
+		fmt.Fprintln(out, "Repository summary:")
+		for _, summary := range summaries {
+			fmt.Fprintf(out, "  %s\n", summary)
+		}
+	}
+	if failed > 0 {
+		return newPartialFailureError(fmt.Errorf("failed to purge %d of %d repositories", failed, len(entries)))
+	}
+	if parameters.failIfNone && totalDeleted == 0 {
+		return errNothingDeleted
+	}
+	return nil
+}
+
+// tagPurgeOptions selects the tags deleted by PurgeTags. Only ago is required, a nil ageResolver compares the last
+// update time of the tags and a zero concurrency is the default one.
+type tagPurgeOptions struct {
+	// ago is the age of the deleted tags, as a duration or a time, its cutoff is moved back by skew when it's a
+	// duration.
+	ago  string
+	skew time.Duration
+	// filter and where narrow the tags to the ones matching both when they're set.
+	filter string
+	where  *whereExpression
+	// orderBy is the order the registry lists, and so deletes, the tags in.
+	orderBy string
+	// When keepPerGroup is positive the tags are grouped by the first capture group of groupRegex and the newest
+	// keepPerGroup tags of every group are kept even if they're older than ago.
+	keepPerGroup int
+	groupRegex   string
+	// semverKeep keeps the highest semantic versions of every major or minor version, and leaves the other tags alone
+	// unless it purges them. A tag kept by either semverKeep or keepPerGroup is kept.
+	semverKeep *semverRetention
+	// When maxTags is positive only the newest maxTags tags matching the filter are kept, the others are deleted
+	// whatever their age, group or version.
+	maxTags int
+	// The tags last updated before since are skipped, because a previous run evaluated them or because they're older
+	// than --newer-than. A zero since evaluates every tag.
+	since       time.Time
+	ageResolver TagAgeResolver
+	// The tags referencing a preserved digest are never deleted.
+	preserved   map[string]bool
+	concurrency int
+}
+
+func (o *tagPurgeOptions) setDefaults() {
+	if o.ageResolver == nil {
+		o.ageResolver = lastUpdateTimeResolver{}
+	}
+	if o.concurrency <= 0 {
+		o.concurrency = defaultConcurrency
+	}
+}
+
+// PurgeTags deletes the tags of repoName selected by options and returns the number of deleted tags. The time of every
+// tag, compared to ago and since, is the one returned by the age resolver of options. Locked tags are skipped and a
+// failed deletion doesn't stop the others, unless the credentials were rejected. At most concurrency tags are deleted
+// at the same time, while the next pages are being listed except with keepPerGroup, semverKeep or maxTags. When
+// listing a page fails after the previous pages were listed, the tags of those pages are still deleted and a partial
+// failure wrapping a listingError is returned, except with keepPerGroup, semverKeep or maxTags where nothing is
+// deleted from an incomplete listing.
+func PurgeTags(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	results *purgeResults,
+	repoName string,
+	options tagPurgeOptions) (int, error) {
+	options.setDefaults()
+	deletedTags := 0
+	now := nowFunc()
+	timeToCompare, err := cutoffTime(options.ago, now.Add(-options.skew))
+	if err != nil {
+		return deletedTags, &invalidArgumentsError{err: err}
+	}
+	regex, err := compileFlagRegex("--filter", options.filter)
+	if err != nil {
+		return deletedTags, err
+	}
+	var groupPattern *regexp.Regexp
+	if options.keepPerGroup > 0 {
+		if groupPattern, err = compileGroupRegex(options.groupRegex); err != nil {
+			return deletedTags, err
+		}
+	}
+	// The newest tags of each group or of the repository can only be known once every page was listed.
+	collectAll := groupPattern != nil || options.maxTags > 0 || options.semverKeep != nil
+	selection := selectionAge
+	if len(options.filter) > 0 {
+		selection = selectionFilter
+	}
+	var candidates []tagCandidate
+	collectedTags := map[string]acrapi.TagAttributesBase{}
+	pipelineCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// The pages are listed while the tags selected on the previous ones are being deleted.
+	tagsToDelete := make(chan purgeResult, options.concurrency)
+	var listErr error
+	listedTags := 0
+	go func() {
+		defer close(tagsToDelete)
+		listErr = listTags(pipelineCtx, acrClient, repoName, options.orderBy, func(tag acrapi.TagAttributesBase) error {
+			listedTags++
 			tagName := *tag.Name
-			//A regex filter was specified
-			if len(filter) > 0 {
-				matches = regex.MatchString(tagName)
-				if !matches {
-					continue
-				}
+			if len(options.filter) > 0 && !regex.MatchString(tagName) {
+				return nil
 			}
-			lastUpdateTime, err = time.Parse(time.RFC3339Nano, *tag.LastUpdateTime)
+			lastUpdateTime, err := options.ageResolver.TagTime(tag)
 			if err != nil {
 				return err
 			}
-			if lastUpdateTime.Before(timeToCompare) {
-				wg.Add(1)
-				go Untag(ctx, &wg, errorChannel, loginURL, auth, repoName, tagName)
+			if lastUpdateTime.Before(options.since) {
+				return nil
+			}
+			if options.where != nil {
+				item := &whereItem{name: tagName, digest: stringValue(tag.Digest), age: now.Sub(lastUpdateTime),
+					pull: func() (*api.Manifest, error) {
+						return acrClient.AcrGetManifest(pipelineCtx, repoName, stringValue(tag.Digest))
+					}}
+				if matched, err := options.where.matches(item); err != nil || !matched {
+					return err
+				}
+			}
+			if collectAll {
+				candidates = append(candidates, tagCandidate{name: tagName, lastUpdateTime: lastUpdateTime})
+				collectedTags[tagName] = tag
+				return nil
+			}
+			if !lastUpdateTime.Before(timeToCompare) {
+				return nil
+			}
+			result := purgeResult{Repository: repoName, Tag: tagName, LastUpdateTime: *tag.LastUpdateTime, tagDigest: stringValue(tag.Digest), selection: selection}
+			if options.preserved[result.tagDigest] {
+				results.recordPreserved(result)
+				return nil
+			}
+			if isTagLocked(tag.ChangeableAttributes) {
+				results.recordLocked(result)
+				return nil
+			}
+			results.progress.add(1)
+			select {
+			case tagsToDelete <- result:
+				return nil
+			case <-pipelineCtx.Done():
+				return pipelineCtx.Err()
+			}
+		})
+	}()
+	deletedTags, deleteErr := untagStream(pipelineCtx, cancel, acrClient, results, tagsToDelete, options.concurrency)
+	if stopsRun(deleteErr) {
+		return deletedTags, deleteErr
+	}
+	if listErr != nil {
+		// The tags of the pages listed before were deleted, unless the selection needs every page.
+		if listedTags > 0 && !collectAll && !stopsRun(listErr) && ctx.Err() == nil {
+			return deletedTags, newPartialFailureError(&listingError{completed: deletedTags, err: listErr})
+		}
+		return deletedTags, listErr
+	}
+	if collectAll {
+		var selected []string
+		switch {
+		case groupPattern != nil && options.semverKeep != nil:
+			// A tag kept by either retention is kept.
+			selected = intersectTags(selectGroupedTags(candidates, groupPattern, options.keepPerGroup, timeToCompare), options.semverKeep.selectTags(candidates, timeToCompare))
+		case groupPattern != nil:
+			selected = selectGroupedTags(candidates, groupPattern, options.keepPerGroup, timeToCompare)
+		case options.semverKeep != nil:
+			selected = options.semverKeep.selectTags(candidates, timeToCompare)
+		default:
+			selected = selectOlderTags(candidates, timeToCompare)
+		}
+		selectedByAge := map[string]bool{}
+		for _, tagName := range selected {
+			selectedByAge[tagName] = true
+		}
+		if options.maxTags > 0 {
+			selected = unionTags(selected, selectTagsOverLimit(candidates, options.maxTags))
+		}
+		tagsToDelete := make([]purgeResult, 0, len(selected))
+		for _, tagName := range selected {
+			tag := collectedTags[tagName]
+			result := purgeResult{Repository: repoName, Tag: tagName, LastUpdateTime: *tag.LastUpdateTime, tagDigest: stringValue(tag.Digest), selection: selection}
+			if !selectedByAge[tagName] {
+				result.selection = selectionMaxTags
+			}
+			if options.preserved[result.tagDigest] {
+				results.recordPreserved(result)
+				continue
 			}
+			if isTagLocked(tag.ChangeableAttributes) {
+				results.recordLocked(result)
+				continue
+			}
+			tagsToDelete = append(tagsToDelete, result)
 		}
-		wg.Wait()
-		for len(errorChannel) > 0 {
-			err = <-errorChannel
-			if err != nil {
+		deleted, err := untagAll(ctx, acrClient, results, tagsToDelete, options.concurrency)
+		deletedTags += deleted
+		if err != nil && deleteErr == nil {
+			deleteErr = err
+		}
+	}
+	return deletedTags, deleteErr
+}
+
+// deleteTags deletes the given tags of repoName, at most concurrency at the same time, and returns the number of
+// deleted tags. The tags aren't listed first, the ones that don't exist are reported as not found. When
+// checkPermission is set nothing is deleted unless the credentials are allowed to delete from repoName.
+func deleteTags(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	results *purgeResults,
+	repoName string,
+	tags []string,
+	checkPermission bool,
+	concurrency int) (int, error) {
+	if err := checkRepositoryExists(ctx, acrClient, results.loginURL, repoName); err != nil {
+		return 0, err
+	}
+	if checkPermission {
+		if err := acrClient.AcrCheckDeletePermission(ctx, repoName); err != nil {
+			return 0, err
+		}
+	}
+	tagsToDelete := make([]purgeResult, 0, len(tags))
+	for _, tag := range tags {
+		tagsToDelete = append(tagsToDelete, purgeResult{Repository: repoName, Tag: tag, selection: selectionExplicit})
+	}
+	return untagAll(ctx, acrClient, results, tagsToDelete, concurrency)
+}
+
+// listTags calls handleTag for every tag of repoName in orderBy order, page after page, and stops at the first error
+// it returns.
+func listTags(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	repoName string,
+	orderBy string,
+	handleTag func(tag acrapi.TagAttributesBase) error) error {
+	lastTag := ""
+	resultTags, err := acrClient.AcrListTags(ctx, repoName, orderBy, lastTag)
+	if err != nil {
+		return err
+	}
+	for resultTags != nil && resultTags.Tags != nil {
+		tags := *resultTags.Tags
+		for _, tag := range tags {
+			if err := handleTag(tag); err != nil {
 				return err
 			}
 		}
 		lastTag = *tags[len(tags)-1].Name
-		resultTags, err = api.AcrListTags(ctx, loginURL, auth, repoName, "", lastTag)
+		resultTags, err = acrClient.AcrListTags(ctx, repoName, orderBy, lastTag)
 		if err != nil {
 			return err
 		}
@@ -139,8 +1086,224 @@ func PurgeTags(ctx context.Context, loginURL string, auth string, repoName strin
 	return nil
 }
 
-// ParseDuration analog to time.ParseDuration() but with days added.
+// listManifests calls handleManifest for every manifest of repoName, page after page, and stops at the first error it
+// returns.
+func listManifests(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	repoName string,
+	handleManifest func(manifest acrapi.ManifestAttributesBase) error) error {
+	lastManifestDigest := ""
+	resultManifests, err := acrClient.AcrListManifests(ctx, repoName, "", lastManifestDigest)
+	if err != nil {
+		return err
+	}
+	for resultManifests != nil && resultManifests.Manifests != nil {
+		manifests := *resultManifests.Manifests
+		for _, manifest := range manifests {
+			if err := handleManifest(manifest); err != nil {
+				return err
+			}
+		}
+		lastManifestDigest = *manifests[len(manifests)-1].Digest
+		resultManifests, err = acrClient.AcrListManifests(ctx, repoName, "", lastManifestDigest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// untagAll untags the given tags, at most concurrency at the same time, and returns the number of untagged
+// tags. It stops when the credentials are rejected, any other failure is returned once every tag was tried.
+func untagAll(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	results *purgeResults,
+	tags []purgeResult,
+	concurrency int) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results.progress.add(len(tags))
+	tagChannel := make(chan purgeResult, len(tags))
+	for _, tag := range tags {
+		tagChannel <- tag
+	}
+	close(tagChannel)
+	return untagStream(ctx, cancel, acrClient, results, tagChannel, concurrency)
+}
+
+// untagStream untags the tags received from tags with concurrency workers until the channel is closed, and returns the
+// number of untagged tags. When the credentials are rejected or --max-delete is reached cancel is called and the
+// remaining tags are skipped, any other failure is returned once every tag was tried. A tag that is already gone isn't
+// an error.
+func untagStream(ctx context.Context,
+	cancel context.CancelFunc,
+	acrClient api.AcrCLIClientInterface,
+	results *purgeResults,
+	tags <-chan purgeResult,
+	concurrency int) (int, error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	deletedTags := 0
+	var deleteErr error
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tag := range tags {
+				if ctx.Err() != nil {
+					results.progress.done(1)
+					continue
+				}
+				err := acrClient.AcrDeleteTag(ctx, tag.Repository, tag.Tag)
+				outcome := results.record(tag, err)
+				mu.Lock()
+				switch {
+				case outcome == outcomeDeleted:
+					deletedTags++
+				case outcome == outcomeFailed && (deleteErr == nil || (stopsRun(err) && !stopsRun(deleteErr))):
+					deleteErr = err
+				}
+				mu.Unlock()
+				if stopsRun(err) {
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if deleteErr != nil {
+		return deletedTags, newPartialFailureError(deleteErr)
+	}
+	return deletedTags, nil
+}
+
+// manifestCandidate is a manifest selected for deletion and the reason it was selected.
+type manifestCandidate struct {
+	manifest  acrapi.ManifestAttributesBase
+	selection string
+}
+
+// deleteManifestStream deletes the manifests of repoName received from manifests with concurrency workers until the
+// channel is closed, and returns the number of deleted manifests. When the credentials are rejected or --max-delete is
+// reached cancel is called and the remaining manifests are skipped, any other failure is returned once every manifest
+// was tried. A manifest that is already gone isn't an error.
+func deleteManifestStream(ctx context.Context,
+	cancel context.CancelFunc,
+	acrClient api.AcrCLIClientInterface,
+	results *purgeResults,
+	repoName string,
+	manifests <-chan manifestCandidate,
+	purgeReferrers bool,
+	concurrency int) (int, error) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	deletedManifests := 0
+	var deleteErr error
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range manifests {
+				if ctx.Err() != nil {
+					results.progress.done(1)
+					continue
+				}
+				err := HandleManifest(ctx, acrClient, results, repoName, c.manifest, c.selection, purgeReferrers)
+				outcome := deletionOutcome(err)
+				mu.Lock()
+				switch {
+				case outcome == outcomeDeleted:
+					deletedManifests++
+				case outcome == outcomeFailed && (deleteErr == nil || (stopsRun(err) && !stopsRun(deleteErr))):
+					deleteErr = err
+				}
+				mu.Unlock()
+				if stopsRun(err) {
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if deleteErr != nil {
+		return deletedManifests, newPartialFailureError(deleteErr)
+	}
+	return deletedManifests, nil
+}
+
+// anchorFilter returns the regular expression matching the tag names filter selects in mode. A filter matches
+// anywhere in the name in the contains mode, the whole name in the full mode and its start in the prefix mode, an
+// empty filter still selects every tag.
+func anchorFilter(filter string, mode string) string {
+	if len(filter) == 0 {
+		return filter
+	}
+	switch mode {
+	case filterModeFull:
+		return "^(?:" + filter + ")$"
+	case filterModePrefix:
+		return "^(?:" + filter + ")"
+	}
+	return filter
+}
+
+// nowFunc returns the current time the ages of the tags and manifests are computed from, the tests replace it to pin
+// the cutoffs.
+var nowFunc = time.Now
+
+// cutoffTime returns the time the items must have been last updated before to be deleted. ago is either a duration
+// counted back from now, like 7d or 12h, or an absolute RFC 3339 time like 2024-03-10T02:30:00-05:00 or a date like
+// 2024-03-10. The result is in UTC: a time is converted with its own offset and a date is midnight UTC, so the
+// selection doesn't depend on the local time zone or its daylight saving changes.
+func cutoffTime(ago string, now time.Time) (time.Time, error) {
+	if cutoff, err := time.Parse(time.RFC3339Nano, ago); err == nil {
+		return cutoff.UTC(), nil
+	}
+	if cutoff, err := time.Parse("2006-01-02", ago); err == nil {
+		return cutoff.UTC(), nil
+	}
+	agoDuration, err := ParseDuration(ago)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.UTC().Add(agoDuration), nil
+}
+
+// tagWindowStart returns the time before which the tags are kept whatever their age, given by --newer-than, or a
+// zero time when newerThan is empty. It must be before the cutoff of ago, otherwise no tag could be deleted.
+func tagWindowStart(ago string, newerThan string, now time.Time) (time.Time, error) {
+	if len(newerThan) == 0 {
+		return time.Time{}, nil
+	}
+	start, err := cutoffTime(newerThan, now)
+	if err != nil {
+		return time.Time{}, newInvalidArgumentsError("invalid --newer-than %q: %v", newerThan, err)
+	}
+	end, err := cutoffTime(ago, now)
+	if err != nil {
+		return time.Time{}, &invalidArgumentsError{err: err}
+	}
+	if !start.Before(end) {
+		return time.Time{}, newInvalidArgumentsError("--newer-than %s must be further in the past than --ago %s, no tag would be deleted", newerThan, ago)
+	}
+	return start, nil
+}
+
+// isoDurationRegex matches the ISO 8601 durations accepted by ParseDuration, with integer years, months, weeks, days,
+// hours, minutes and seconds.
+var isoDurationRegex = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// isoDurationUnits are the durations of the components of isoDurationRegex in order, a year is 365 days and a month
+// 30 days since a duration ago can't depend on the calendar.
+var isoDurationUnits = []time.Duration{365 * 24 * time.Hour, 30 * 24 * time.Hour, 7 * 24 * time.Hour, 24 * time.Hour,
+	time.Hour, time.Minute, time.Second}
+
+// ParseDuration analog to time.ParseDuration() but with days added. An ISO 8601 duration like P30D, PT12H or P1W is
+// accepted as well.
 func ParseDuration(ago string) (time.Duration, error) {
+	if strings.HasPrefix(ago, "P") {
+		return parseISODuration(ago)
+	}
 	var days int
 	var durationString string
 	if strings.Contains(ago, "d") {
@@ -165,70 +1328,273 @@ func ParseDuration(ago string) (time.Duration, error) {
 	return (-1 * duration), nil
 }
 
-// Untag is the function responsible for untagging an image.
-func Untag(ctx context.Context,
-	wg *sync.WaitGroup,
-	errorChannel chan error,
-	loginURL string,
-	auth string,
-	repoName string,
-	tag string) {
-	defer wg.Done()
-	err := api.AcrDeleteTag(ctx, loginURL, auth, repoName, tag)
-	if err != nil {
-		errorChannel <- err
-		return
+// parseISODuration parses an ISO 8601 duration like ParseDuration, a month is 30 days and a year 365 days. P1M is a
+// month and PT1M a minute.
+func parseISODuration(ago string) (time.Duration, error) {
+	match := isoDurationRegex.FindStringSubmatch(ago)
+	if match == nil || ago == "P" || strings.HasSuffix(ago, "T") {
+		return 0, errors.Errorf("invalid ISO 8601 duration %q, expected a duration like P30D, P1W or PT12H with integer values", ago)
 	}
-	fmt.Printf("%s/%s:%s\n", loginURL, repoName, tag)
+	var duration time.Duration
+	for i, value := range match[1:] {
+		if len(value) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || time.Duration(n) > math.MaxInt64/isoDurationUnits[i] {
+			return 0, errors.Errorf("ISO 8601 duration %q is too long", ago)
+		}
+		duration += time.Duration(n) * isoDurationUnits[i]
+		if duration < 0 {
+			return 0, errors.Errorf("ISO 8601 duration %q is too long", ago)
+		}
+	}
+	return -duration, nil
 }
 
-// PurgeDanglingManifests runs if the dangling flag is specified and deletes all manifests that do not have any tags associated with them.
-func PurgeDanglingManifests(ctx context.Context, loginURL string, auth string, repoName string) error {
-	var errorChannel = make(chan error, 100)
-	defer close(errorChannel)
-	var wg sync.WaitGroup
-	lastManifestDigest := ""
-	resultManifests, err := api.AcrListManifests(ctx, loginURL, auth, repoName, "", lastManifestDigest)
+// manifestPurgeOptions selects the dangling manifests deleted by PurgeDanglingManifests, a zero concurrency is the
+// default one.
+type manifestPurgeOptions struct {
+	// ago is the age of the deleted manifests, as a duration or a time, its cutoff is moved back by skew when it's a
+	// duration. An empty ago deletes the dangling manifests regardless of their age.
+	ago  string
+	skew time.Duration
+	// When manifestFilter is given only the manifests whose media type or digest match it are deleted, mediaTypes
+	// further selects them by their exact media type and where by its condition, a dangling manifest has no name.
+	manifestFilter string
+	mediaTypes     mediaTypeFilter
+	where          *whereExpression
+	// When purgeReferrers is set the artifacts referencing a manifest are deleted first.
+	purgeReferrers bool
+	// The manifests whose digest is in orphaned are deleted regardless of their age, like the ones this run untagged.
+	orphaned map[string]bool
+	// The preserved digests are never deleted.
+	preserved   map[string]bool
+	concurrency int
+}
+
+func (o *manifestPurgeOptions) setDefaults() {
+	if o.concurrency <= 0 {
+		o.concurrency = defaultConcurrency
+	}
+}
+
+// PurgeDanglingManifests runs if the dangling flag is specified and deletes the manifests of repoName that do not have
+// any tags associated with them and that options selects, so with an ago manifests that were just pushed and are about
+// to be tagged are left alone. Locked manifests are skipped and a failed deletion doesn't stop the others, unless the
+// credentials were rejected. The manifests referenced by an image index that is kept, like the platforms of a tagged
+// multi-arch image, are never deleted even though they have no tags, so a kept index is never left broken, which is
+// why every manifest is listed before the first deletion. At most concurrency manifests are deleted at the same time.
+// It returns the number of deleted manifests, without the referrers.
+func PurgeDanglingManifests(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	results *purgeResults,
+	repoName string,
+	options manifestPurgeOptions) (int, error) {
+	options.setDefaults()
+	deletedManifests := 0
+	now := nowFunc().UTC()
+	timeToCompare := now
+	if len(options.ago) > 0 {
+		var err error
+		if timeToCompare, err = cutoffTime(options.ago, now.Add(-options.skew)); err != nil {
+			return deletedManifests, &invalidArgumentsError{err: err}
+		}
+	}
+	regex, err := compileFlagRegex("--manifest-filter", options.manifestFilter)
 	if err != nil {
-		return err
+		return deletedManifests, err
 	}
-	for resultManifests != nil && resultManifests.Manifests != nil {
-		manifests := *resultManifests.Manifests
-		for _, manifest := range manifests {
-			if manifest.Tags == nil {
-				wg.Add(1)
-				go HandleManifest(ctx, &wg, errorChannel, loginURL, auth, repoName, *manifest.Digest)
+	// selectManifest returns the reason a manifest is deleted, or an empty reason when it's kept.
+	selectManifest := func(manifest acrapi.ManifestAttributesBase) (string, error) {
+		if manifest.Tags != nil {
+			return "", nil
+		}
+		if len(options.manifestFilter) > 0 && !matchesManifest(regex, manifest) {
+			return "", nil
+		}
+		if !options.mediaTypes.allows(manifest.MediaType) {
+			return "", nil
+		}
+		if options.where != nil {
+			lastUpdateTime, err := time.Parse(time.RFC3339Nano, *manifest.LastUpdateTime)
+			if err != nil {
+				return "", err
 			}
+			item := &whereItem{digest: *manifest.Digest, mediaType: stringValue(manifest.MediaType), age: now.Sub(lastUpdateTime),
+				pull: func() (*api.Manifest, error) {
+					return acrClient.AcrGetManifest(ctx, repoName, *manifest.Digest)
+				}}
+			if matched, err := options.where.matches(item); err != nil || !matched {
+				return "", err
+			}
+		}
+		if options.orphaned[*manifest.Digest] {
+			return selectionCascade, nil
 		}
-		wg.Wait()
-		for len(errorChannel) > 0 {
-			err = <-errorChannel
+		if len(options.ago) > 0 {
+			lastUpdateTime, err := time.Parse(time.RFC3339Nano, *manifest.LastUpdateTime)
 			if err != nil {
-				return err
+				return "", err
+			}
+			if !lastUpdateTime.Before(timeToCompare) {
+				return "", nil
 			}
 		}
-		lastManifestDigest = *manifests[len(manifests)-1].Digest
-		resultManifests, err = api.AcrListManifests(ctx, loginURL, auth, repoName, "", lastManifestDigest)
+		return selectionDangling, nil
+	}
+	// The whole repository is listed before deleting anything, a child can be listed before the index that keeps it.
+	var candidates []manifestCandidate
+	var keptIndexes []string
+	err = listManifests(ctx, acrClient, repoName, func(manifest acrapi.ManifestAttributesBase) error {
+		selection, err := selectManifest(manifest)
 		if err != nil {
 			return err
 		}
+		// A preserved manifest is kept like one that wasn't selected, with the manifests it references.
+		if len(selection) > 0 && options.preserved[*manifest.Digest] {
+			results.recordPreserved(purgeResult{Repository: repoName, Digest: *manifest.Digest, LastUpdateTime: stringValue(manifest.LastUpdateTime)})
+			selection = ""
+		}
+		if len(selection) > 0 {
+			candidates = append(candidates, manifestCandidate{manifest: manifest, selection: selection})
+		}
+		if manifest.MediaType != nil && isIndex(*manifest.MediaType) && (len(selection) == 0 || isManifestLocked(manifest.ChangeableAttributes)) {
+			keptIndexes = append(keptIndexes, *manifest.Digest)
+		}
+		return nil
+	})
+	if err != nil {
+		return deletedManifests, err
+	}
+	children, err := indexChildren(ctx, acrClient, repoName, keptIndexes)
+	if err != nil {
+		return deletedManifests, errors.Wrap(err, "unable to pull the image indexes")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	manifestChannel := make(chan manifestCandidate, len(candidates))
+	for _, c := range candidates {
+		manifest := c.manifest
+		if children[*manifest.Digest] {
+			continue
+		}
+		if isManifestLocked(manifest.ChangeableAttributes) {
+			results.recordLocked(purgeResult{Repository: repoName, Digest: *manifest.Digest, LastUpdateTime: stringValue(manifest.LastUpdateTime)})
+			continue
+		}
+		results.progress.add(1)
+		manifestChannel <- c
+	}
+	close(manifestChannel)
+	return deleteManifestStream(ctx, cancel, acrClient, results, repoName, manifestChannel, options.purgeReferrers, options.concurrency)
+}
+
+// containsString reports whether values contains value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaTypeFilter selects the dangling manifests by media type. When include isn't empty only the manifests with one
+// of its media types are selected, the manifests with one of the exclude media types never are.
+type mediaTypeFilter struct {
+	include []string
+	exclude []string
+}
+
+func (f mediaTypeFilter) validate() error {
+	for _, mediaType := range f.include {
+		if containsString(f.exclude, mediaType) {
+			return newInvalidArgumentsError("%s can't be both in --include-media-types and --exclude-media-types", mediaType)
+		}
+	}
+	for _, mediaType := range append(f.include, f.exclude...) {
+		if len(strings.TrimSpace(mediaType)) == 0 {
+			return newInvalidArgumentsError("--include-media-types and --exclude-media-types must not contain empty media types")
+		}
 	}
 	return nil
 }
 
-// HandleManifest deletes a manifest, if there is an archive repo and the manifest has existent metadata the manifest is moved instead.
+// allows reports whether a manifest with mediaType is selected, a manifest without a media type is only selected
+// when there is no include list.
+func (f mediaTypeFilter) allows(mediaType *string) bool {
+	if mediaType == nil {
+		return len(f.include) == 0
+	}
+	if len(f.include) > 0 && !containsString(f.include, *mediaType) {
+		return false
+	}
+	return !containsString(f.exclude, *mediaType)
+}
+
+// matchesManifest reports whether the media type or the digest of a manifest match regex.
+func matchesManifest(regex *regexp.Regexp, manifest acrapi.ManifestAttributesBase) bool {
+	if manifest.MediaType != nil && regex.MatchString(*manifest.MediaType) {
+		return true
+	}
+	return regex.MatchString(*manifest.Digest)
+}
+
+// HandleManifest deletes a manifest, records the outcome and returns the error of the deletion. When purgeReferrers is
+// set the referrers of the manifest are deleted first, the manifest is kept if that fails so its referrers are never
+// orphaned. selection is the reason the manifest was selected, written to the audit file.
 func HandleManifest(ctx context.Context,
-	wg *sync.WaitGroup,
-	errorChannel chan error,
-	loginURL string,
-	auth string,
+	acrClient api.AcrCLIClientInterface,
+	results *purgeResults,
+	repoName string,
+	manifest acrapi.ManifestAttributesBase,
+	selection string,
+	purgeReferrers bool) error {
+	digest := *manifest.Digest
+	result := purgeResult{Repository: repoName, Digest: digest, LastUpdateTime: stringValue(manifest.LastUpdateTime), selection: selection}
+	if purgeReferrers {
+		if err := deleteReferrers(ctx, acrClient, results, repoName, digest); err != nil {
+			err = errors.Wrapf(err, "unable to delete the referrers of %s", digest)
+			results.record(result, err)
+			return err
+		}
+	}
+	if results.audit != nil {
+		result.size = manifestSize(ctx, acrClient, repoName, digest)
+	}
+	err := acrClient.DeleteManifest(ctx, repoName, digest)
+	results.record(result, err)
+	return err
+}
+
+// deleteReferrers deletes the artifacts that reference digest, and their own referrers, one after the other. A
+// referrer that is already gone is not an error.
+func deleteReferrers(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	results *purgeResults,
 	repoName string,
-	digest string) {
-	defer wg.Done()
-	err := api.DeleteManifest(ctx, loginURL, auth, repoName, digest)
+	digest string) error {
+	referrers, err := acrClient.AcrListReferrers(ctx, repoName, digest)
 	if err != nil {
-		errorChannel <- err
-		return
+		return err
 	}
-	fmt.Printf("%s/%s@%s\n", loginURL, repoName, digest)
+	for _, referrer := range referrers.Manifests {
+		if err := api.ValidateDigest(referrer.Digest); err != nil {
+			return errors.Wrap(err, "the referrers API returned an invalid digest")
+		}
+		if err := deleteReferrers(ctx, acrClient, results, repoName, referrer.Digest); err != nil {
+			return err
+		}
+		result := purgeResult{Repository: repoName, Digest: referrer.Digest, selection: selectionReferrer}
+		results.progress.add(1)
+		if results.audit != nil {
+			result.size = manifestSize(ctx, acrClient, repoName, referrer.Digest)
+		}
+		err := acrClient.DeleteManifest(ctx, repoName, referrer.Digest)
+		if results.record(result, err) == outcomeFailed {
+			return err
+		}
+	}
+	return nil
 }
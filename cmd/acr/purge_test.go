@@ -0,0 +1,750 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/AzureCR/acr-cli/cmd/api/acrtest"
+	"github.com/pkg/errors"
+)
+
+const (
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	helmManifestMediaType   = "application/vnd.cncf.helm.config.v1+json"
+)
+
+func TestPurgeDanglingManifestsManifestFilter(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old)
+	registry.setMediaType("repo", testDigest(1), dockerManifestMediaType)
+	registry.addManifest("repo", testDigest(2), old)
+	registry.setMediaType("repo", testDigest(2), helmManifestMediaType)
+	registry.addManifest("repo", testDigest(3), old)
+	registry.addManifest("repo", testDigest(4), old, "tagged")
+	registry.setMediaType("repo", testDigest(4), helmManifestMediaType)
+
+	deleted, err := PurgeDanglingManifests(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", manifestPurgeOptions{ago: "1d", manifestFilter: "helm"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deleted != 1 || !reflect.DeepEqual(registry.deletedManifests["repo"], []string{testDigest(2)}) {
+		t.Fatalf("media type filter incorrect, deleted %d %v", deleted, registry.deletedManifests["repo"])
+	}
+
+	deleted, err = PurgeDanglingManifests(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", manifestPurgeOptions{ago: "1d", manifestFilter: "^" + testDigest(3) + "$"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deleted != 1 || registry.deletedManifests["repo"][1] != testDigest(3) {
+		t.Fatalf("digest filter incorrect, deleted %d %v", deleted, registry.deletedManifests["repo"])
+	}
+
+	if _, err = PurgeDanglingManifests(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", manifestPurgeOptions{ago: "1d", manifestFilter: "("}); exitCode(err) != exitCodeInvalidArguments {
+		t.Fatalf("an invalid manifest filter should be rejected, got %v", err)
+	}
+}
+
+func TestPurgeDanglingManifestsMediaTypes(t *testing.T) {
+	newRegistry := func() *fakeRegistry {
+		registry := newFakeRegistry()
+		old := time.Now().Add(-72 * time.Hour)
+		registry.addManifest("repo", testDigest(1), old)
+		registry.setMediaType("repo", testDigest(1), dockerManifestMediaType)
+		registry.addManifest("repo", testDigest(2), old)
+		registry.setMediaType("repo", testDigest(2), helmManifestMediaType)
+		registry.addManifest("repo", testDigest(3), old)
+		return registry
+	}
+	tests := []struct {
+		mediaTypes mediaTypeFilter
+		deleted    []string
+	}{
+		{mediaTypeFilter{}, []string{testDigest(1), testDigest(2), testDigest(3)}},
+		{mediaTypeFilter{include: []string{helmManifestMediaType}}, []string{testDigest(2)}},
+		{mediaTypeFilter{exclude: []string{helmManifestMediaType}}, []string{testDigest(1), testDigest(3)}},
+		{mediaTypeFilter{include: []string{dockerManifestMediaType, helmManifestMediaType}, exclude: []string{dockerManifestMediaType}}, []string{testDigest(2)}},
+	}
+	for _, test := range tests {
+		registry := newRegistry()
+		deleted, err := PurgeDanglingManifests(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", manifestPurgeOptions{ago: "1d", mediaTypes: test.mediaTypes})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		sort.Strings(registry.deletedManifests["repo"])
+		if deleted != len(test.deleted) || !reflect.DeepEqual(registry.deletedManifests["repo"], test.deleted) {
+			t.Fatalf("%+v: expected %v to be deleted, got %d %v", test.mediaTypes, test.deleted, deleted, registry.deletedManifests["repo"])
+		}
+	}
+
+	if err := (mediaTypeFilter{include: []string{helmManifestMediaType}, exclude: []string{helmManifestMediaType}}).validate(); exitCode(err) != exitCodeInvalidArguments {
+		t.Fatalf("a media type both included and excluded should be rejected, got %v", err)
+	}
+}
+
+func TestPurgeDanglingManifestsAge(t *testing.T) {
+	registry := newFakeRegistry()
+	now := time.Now()
+	registry.addManifest("repo", testDigest(1), now.Add(-49*time.Hour))
+	registry.addManifest("repo", testDigest(2), now.Add(-47*time.Hour))
+	registry.addManifest("repo", testDigest(3), now.Add(-time.Minute))
+
+	deleted, err := PurgeDanglingManifests(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", manifestPurgeOptions{ago: "2d"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deleted != 1 || !reflect.DeepEqual(registry.deletedManifests["repo"], []string{testDigest(1)}) {
+		t.Fatalf("age filter incorrect, deleted %d %v", deleted, registry.deletedManifests["repo"])
+	}
+
+	deleted, err = PurgeDanglingManifests(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", manifestPurgeOptions{ago: "1h"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sort.Strings(registry.deletedManifests["repo"])
+	expected := []string{testDigest(1), testDigest(2)}
+	if deleted != 1 || !reflect.DeepEqual(registry.deletedManifests["repo"], expected) {
+		t.Fatalf("age filter incorrect, deleted %d %v, expected %v", deleted, registry.deletedManifests["repo"], expected)
+	}
+}
+
+// barrierRegistry holds the manifest deletions until expected deletions ran at the same time, or for a second at most,
+// and records the most deletions that ran at the same time.
+type barrierRegistry struct {
+	*fakeRegistry
+	expected int
+	mu       sync.Mutex
+	cond     *sync.Cond
+	active   int
+	peak     int
+}
+
+func (b *barrierRegistry) DeleteManifest(ctx context.Context, repoName string, reference string) error {
+	b.mu.Lock()
+	b.active++
+	if b.active > b.peak {
+		b.peak = b.active
+	}
+	b.cond.Broadcast()
+	deadline := time.AfterFunc(time.Second, b.cond.Broadcast)
+	start := time.Now()
+	for b.peak < b.expected && time.Since(start) < time.Second {
+		b.cond.Wait()
+	}
+	deadline.Stop()
+	b.active--
+	b.mu.Unlock()
+	return b.fakeRegistry.DeleteManifest(ctx, repoName, reference)
+}
+
+func TestPurgeDanglingManifestsConcurrency(t *testing.T) {
+	const concurrency = 150
+	registry := &barrierRegistry{fakeRegistry: newFakeRegistry(), expected: concurrency}
+	registry.cond = sync.NewCond(&registry.mu)
+	for i := 0; i < concurrency; i++ {
+		registry.addManifest("repo", testDigest(i), time.Now().Add(-72*time.Hour))
+	}
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	deleted, err := PurgeDanglingManifests(context.Background(), registry, results, "repo", manifestPurgeOptions{ago: "1d", concurrency: concurrency})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deleted != concurrency || registry.peak != concurrency {
+		t.Fatalf("expected %d manifests deleted at the same time, deleted %d with at most %d at the same time", concurrency, deleted, registry.peak)
+	}
+}
+
+func TestPurgeDanglingManifestsStopsOnRejectedCredentials(t *testing.T) {
+	registry := newFakeRegistry()
+	for i := 0; i < 3; i++ {
+		registry.addManifest("repo", testDigest(i), time.Now().Add(-72*time.Hour))
+	}
+	registry.failOn("DeleteManifest repo "+testDigest(0), &api.AuthenticationExpiredError{Err: &api.RegistryError{StatusCode: http.StatusUnauthorized}})
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	deleted, err := PurgeDanglingManifests(context.Background(), registry, results, "repo", manifestPurgeOptions{concurrency: 1})
+	if exitCode(err) != exitCodeAuthenticationFailed {
+		t.Fatalf("expected the rejected credentials to be returned, got %v", err)
+	}
+	if deleted != 0 || len(registry.deletedManifests["repo"]) != 0 {
+		t.Fatalf("expected the remaining manifests to be skipped, deleted %d %v", deleted, registry.deletedManifests["repo"])
+	}
+}
+
+func TestPurgeRepositoryDanglingAge(t *testing.T) {
+	for _, anyAge := range []bool{false, true} {
+		registry := newFakeRegistry()
+		registry.addManifest("repo", testDigest(1), time.Now().Add(-72*time.Hour))
+		registry.addManifest("repo", testDigest(2), time.Now().Add(-time.Second))
+
+		parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", dangling: true, anyAge: anyAge}
+		_, deleted, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, parameters)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		expected := []string{testDigest(1)}
+		if anyAge {
+			expected = append(expected, testDigest(2))
+		}
+		sort.Strings(registry.deletedManifests["repo"])
+		if deleted != len(expected) || !reflect.DeepEqual(registry.deletedManifests["repo"], expected) {
+			t.Fatalf("anyAge %v: deleted %d %v, expected %v", anyAge, deleted, registry.deletedManifests["repo"], expected)
+		}
+	}
+}
+
+func TestPurgeRepositoryDanglingAgo(t *testing.T) {
+	registry := newFakeRegistry()
+	now := time.Now()
+	registry.addManifest("repo", testDigest(1), now.Add(-40*24*time.Hour), "v40")
+	registry.addManifest("repo", testDigest(2), now.Add(-10*24*time.Hour), "v10")
+	registry.addManifest("repo", testDigest(3), now.Add(-10*24*time.Hour))
+	registry.addManifest("repo", testDigest(4), now.Add(-3*24*time.Hour))
+
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "30d", danglingAgo: "7d"}
+	deletedTags, deletedManifests, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, parameters)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deletedTags != 1 || !reflect.DeepEqual(registry.deletedTags["repo"], []string{"v40"}) {
+		t.Fatalf("the tags should be purged with --ago, deleted %d %v", deletedTags, registry.deletedTags["repo"])
+	}
+	sort.Strings(registry.deletedManifests["repo"])
+	expected := []string{testDigest(1), testDigest(3)}
+	if deletedManifests != 2 || !reflect.DeepEqual(registry.deletedManifests["repo"], expected) {
+		t.Fatalf("the dangling manifests should be purged with --dangling-ago, deleted %d %v, expected %v", deletedManifests, registry.deletedManifests["repo"], expected)
+	}
+}
+
+func TestPurgeRepositoryCascade(t *testing.T) {
+	for _, cascade := range []bool{false, true} {
+		registry := newFakeRegistry()
+		now := time.Now()
+		registry.addManifest("repo", testDigest(1), now.Add(-40*24*time.Hour), "v1")
+		registry.addManifest("repo", testDigest(2), now.Add(-40*24*time.Hour), "v2", "latest")
+		// The manifests were updated recently, like by a pull or a metadata change, only their tags are old.
+		registry.store.SetLastUpdateTime("repo", testDigest(1), now.Add(-time.Hour))
+		registry.store.SetLastUpdateTime("repo", testDigest(2), now.Add(-time.Hour))
+
+		parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "30d", filter: "^v", cascade: cascade}
+		deletedTags, deletedManifests, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, parameters)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		expected := []string(nil)
+		if cascade {
+			// The manifest of v2 is still tagged latest so it isn't dangling.
+			expected = []string{testDigest(1)}
+		}
+		if deletedTags != 2 || deletedManifests != len(expected) || !reflect.DeepEqual(registry.deletedManifests["repo"], expected) {
+			t.Fatalf("cascade %v: deleted %d tags and %d manifests %v, expected the manifests %v", cascade, deletedTags, deletedManifests, registry.deletedManifests["repo"], expected)
+		}
+	}
+}
+
+func TestPurgeRepositoryGeneric(t *testing.T) {
+	registry := newFakeRegistry()
+	now := time.Now()
+	registry.addManifest("repo", testDigest(1), now.Add(-40*24*time.Hour), "v40")
+	registry.addManifest("repo", testDigest(2), now.Add(-40*24*time.Hour))
+	registry.failOn("AcrListManifests repo", &api.UnsupportedError{Operation: "listing the manifests of a repository"})
+
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "30d", registryType: registryTypeGeneric}
+	deletedTags, deletedManifests, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.example.com", outputText), nil, parameters)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deletedTags != 1 || deletedManifests != 0 || len(registry.listedManifests) != 0 {
+		t.Fatalf("only the tags of a generic registry should be purged, deleted %d tags and %d manifests, listed manifests of %v", deletedTags, deletedManifests, registry.listedManifests)
+	}
+}
+
+func TestPurgeRepositoryOutput(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.pageSize = 50
+	old := time.Now().Add(-72 * time.Hour)
+	for i := 0; i < 150; i++ {
+		registry.addManifest("repo", testDigest(i), old, fmt.Sprintf("v%d", i))
+	}
+	var out bytes.Buffer
+	results := newPurgeResults(&out, "registry.azurecr.io", outputText)
+	if _, _, err := purgeRepository(context.Background(), registry, results, nil, purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var expected []string
+	for _, tag := range registry.deletedTags["repo"] {
+		expected = append(expected, "registry.azurecr.io/repo:"+tag)
+	}
+	for _, digest := range registry.deletedManifests["repo"] {
+		expected = append(expected, "registry.azurecr.io/repo@"+digest)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	sort.Strings(lines)
+	sort.Strings(expected)
+	if len(expected) != 300 || !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("output incorrect, got %d lines, expected the %d deleted items", len(lines), len(expected))
+	}
+}
+
+func TestPurgeDanglingManifestsReferrers(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old)
+	registry.addManifest("repo", testDigest(2), old, "latest")
+	// The signature and the SBOM of the dangling manifest were just pushed, so the sweep alone wouldn't delete them.
+	registry.addReferrer("repo", testDigest(1), testDigest(3), time.Now(), "application/vnd.dev.cosign.artifact.sig.v1+json")
+	registry.addReferrer("repo", testDigest(1), testDigest(4), time.Now(), "application/spdx+json")
+	registry.addReferrer("repo", testDigest(2), testDigest(5), time.Now(), "application/vnd.dev.cosign.artifact.sig.v1+json")
+
+	deleted, err := PurgeDanglingManifests(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", manifestPurgeOptions{ago: "1d", purgeReferrers: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sort.Strings(registry.deletedManifests["repo"])
+	expected := []string{testDigest(1), testDigest(3), testDigest(4)}
+	if deleted != 1 || !reflect.DeepEqual(registry.deletedManifests["repo"], expected) {
+		t.Fatalf("referrers not purged, deleted %d %v, expected %v", deleted, registry.deletedManifests["repo"], expected)
+	}
+
+	// The manifest is kept when its referrers can't be deleted, so they aren't orphaned.
+	registry = newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), old)
+	registry.addReferrer("repo", testDigest(1), testDigest(3), time.Now(), "application/vnd.dev.cosign.artifact.sig.v1+json")
+	registry.failOn("DeleteManifest repo "+testDigest(3), errors.New("DENIED the manifest is locked"))
+	deleted, err = PurgeDanglingManifests(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", manifestPurgeOptions{ago: "1d", purgeReferrers: true})
+	if exitCode(err) != exitCodePartialFailure || deleted != 0 || len(registry.deletedManifests["repo"]) != 0 {
+		t.Fatalf("expected a partial failure without deletions, got %d %v: %v", deleted, registry.deletedManifests["repo"], err)
+	}
+}
+
+func TestRunPurgeQuiet(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "v1")
+	registry.addManifest("repo", testDigest(2), old, "v2")
+	registry.addManifest("repo", testDigest(3), time.Now(), "latest")
+	var out bytes.Buffer
+	err := runPurge(context.Background(), registry, &out, "registry.azurecr.io", purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", output: outputText, quiet: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if expected := "Deleted 2 tags and 2 manifests\n"; out.String() != expected {
+		t.Fatalf("quiet output incorrect, got %q, expected %q", out.String(), expected)
+	}
+}
+
+func TestPurgeTagsPipeline(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.pageSize = 7
+	now := time.Now()
+	var expected []string
+	for i := 0; i < 100; i++ {
+		tag := fmt.Sprintf("v%03d", i)
+		switch i % 4 {
+		case 0:
+			registry.addManifest("repo", testDigest(i), now, tag)
+		case 1:
+			registry.addManifest("repo", testDigest(i), now.Add(-72*time.Hour), tag)
+			registry.lock("repo", tag)
+		default:
+			registry.addManifest("repo", testDigest(i), now.Add(-72*time.Hour), tag)
+			expected = append(expected, tag)
+		}
+	}
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	deleted, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	deletedTags := append([]string(nil), registry.deletedTags["repo"]...)
+	sort.Strings(deletedTags)
+	if deleted != len(expected) || !reflect.DeepEqual(deletedTags, expected) {
+		t.Fatalf("deleted tags incorrect, got %d %v, expected %v", deleted, deletedTags, expected)
+	}
+	if locked := len(results.report(true).Locked); locked != 25 {
+		t.Fatalf("expected 25 locked tags, got %d", locked)
+	}
+
+	registry = newFakeRegistry()
+	registry.pageSize = 7
+	for i := 0; i < 100; i++ {
+		registry.addManifest("repo", testDigest(i), now.Add(-72*time.Hour), fmt.Sprintf("v%03d", i))
+	}
+	registry.failOn("AcrDeleteTag repo v003", &api.RegistryError{StatusCode: http.StatusUnauthorized})
+	if _, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d"}); !isUnauthorized(err) {
+		t.Fatalf("rejected credentials should stop the pipeline, got %v", err)
+	}
+
+	registry = newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), now.Add(-72*time.Hour), "v1")
+	listErr := errors.New("unavailable")
+	registry.failOn("AcrListTags repo", listErr)
+	if _, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d"}); err != listErr {
+		t.Fatalf("expected the listing error, got %v", err)
+	}
+}
+
+// slowRegistry adds latency to the calls of the fake registry, like the network does.
+type slowRegistry struct {
+	*fakeRegistry
+	latency time.Duration
+}
+
+func (s *slowRegistry) AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error) {
+	time.Sleep(s.latency)
+	return s.fakeRegistry.AcrListTags(ctx, repoName, orderBy, last)
+}
+
+func (s *slowRegistry) AcrDeleteTag(ctx context.Context, repoName string, reference string) error {
+	time.Sleep(s.latency)
+	return s.fakeRegistry.AcrDeleteTag(ctx, repoName, reference)
+}
+
+// BenchmarkPurgeTagsPipeline purges 1000 tags listed in pages of 100 with a 5ms latency on every call. The deletions
+// overlap with the 11 list calls so a run takes about 60ms, listing and deleting one page after the other took 110ms.
+func BenchmarkPurgeTagsPipeline(b *testing.B) {
+	old := time.Now().Add(-72 * time.Hour)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		registry := newFakeRegistry()
+		for j := 0; j < 1000; j++ {
+			registry.addManifest("repo", testDigest(j), old, fmt.Sprintf("v%04d", j))
+		}
+		b.StartTimer()
+		results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+		if _, err := PurgeTags(context.Background(), &slowRegistry{registry, 5 * time.Millisecond}, results, "repo", tagPurgeOptions{ago: "1d"}); err != nil {
+			b.Fatalf("unexpected error %v", err)
+		}
+	}
+}
+
+func TestPurgeTagsAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates on every synchronization")
+	}
+	registry := newBenchmarkRegistry()
+	allocs := testing.AllocsPerRun(5, func() {
+		results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+		if _, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d", filter: "^v"}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+	// The results and the pipeline need a few hundred allocations, none of them should happen for every tag.
+	if allocs > benchmarkItems/10 {
+		t.Fatalf("purging %d tags took %v allocations", benchmarkItems, allocs)
+	}
+}
+
+func TestPurgeTagsOrderBy(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.pageSize = 1
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "v1")
+	registry.addManifest("repo", testDigest(2), old.Add(time.Hour), "v2")
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", orderBy: "timeasc"}
+	if _, _, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(registry.tagsOrderBy, []string{"timeasc", "timeasc", "timeasc"}) {
+		t.Fatalf("every page should be listed with the order, got %v", registry.tagsOrderBy)
+	}
+}
+
+func TestPurgeRepositoryNotFound(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now().Add(-72*time.Hour), "v1")
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "rpeo", ago: "1d"}
+	_, _, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, parameters)
+	if exitCode(err) != exitCodeInvalidArguments || err.Error() != "repository 'rpeo' not found in registry 'registry.azurecr.io'" {
+		t.Fatalf("expected a repository not found error, got %v", err)
+	}
+	if len(registry.listedTags) != 0 || len(registry.listedManifests) != 0 {
+		t.Fatalf("nothing should be listed, listed the tags of %v and the manifests of %v", registry.listedTags, registry.listedManifests)
+	}
+}
+
+func TestCutoffTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.FixedZone("UTC-5", -5*60*60))
+	tests := []struct {
+		ago      string
+		expected time.Time
+	}{
+		{"1d", time.Date(2024, 3, 9, 17, 0, 0, 0, time.UTC)},
+		{"90m", time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC)},
+		{"2024-03-10T02:30:00-05:00", time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC)},
+		{"2024-03-10T09:30:00.5+02:00", time.Date(2024, 3, 10, 7, 30, 0, 500000000, time.UTC)},
+		{"2024-03-10", time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		cutoff, err := cutoffTime(test.ago, now)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.ago, err)
+		}
+		if cutoff != test.expected {
+			t.Fatalf("cutoff of %s incorrect, got %v, expected %v", test.ago, cutoff, test.expected)
+		}
+	}
+	for _, ago := range []string{"", "soon", "2024-03-10T02:30:00", "2024-13-01"} {
+		if _, err := cutoffTime(ago, now); err == nil {
+			t.Fatalf("expected an error for %q", ago)
+		}
+	}
+}
+
+func TestParseISODuration(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		ago      string
+		expected time.Duration
+	}{
+		{"P30D", 30 * day},
+		{"PT12H", 12 * time.Hour},
+		{"P1W", 7 * day},
+		{"P1M", 30 * day},
+		{"PT1M", time.Minute},
+		{"P1Y2M3DT4H5M6S", 365*day + 60*day + 3*day + 4*time.Hour + 5*time.Minute + 6*time.Second},
+		{"P0D", 0},
+	}
+	for _, test := range tests {
+		duration, err := ParseDuration(test.ago)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.ago, err)
+		}
+		if duration != -test.expected {
+			t.Fatalf("duration of %s incorrect, got %v, expected %v", test.ago, duration, -test.expected)
+		}
+	}
+	for _, ago := range []string{"P", "PT", "P1DT", "P1.5D", "P1H", "PT1D", "P1D1W", "P-1D", "P999999999Y"} {
+		if _, err := ParseDuration(ago); err == nil {
+			t.Fatalf("expected an error for %q", ago)
+		}
+	}
+	// The Go style durations still work.
+	if duration, err := ParseDuration("1d12h"); err != nil || duration != -36*time.Hour {
+		t.Fatalf("expected 36 hours, got %v and %v", duration, err)
+	}
+}
+
+func TestPurgeTagsTimeZones(t *testing.T) {
+	// The local time zone must not change the relative cutoff, including across a daylight saving change.
+	local := time.Local
+	defer func() { time.Local = local }()
+	time.Local = time.FixedZone("UTC+13", 13*60*60)
+
+	registry := newFakeRegistry()
+	cutoff := time.Now().UTC().Add(-48 * time.Hour)
+	registry.addManifest("repo", testDigest(1), cutoff, "old-east", "recent-east", "old-west", "recent-west")
+	// The same instants written with different offsets, an hour on either side of the cutoff.
+	times := map[string]time.Time{
+		"old-east":    cutoff.Add(-time.Hour).In(time.FixedZone("UTC+2", 2*60*60)),
+		"recent-east": cutoff.Add(time.Hour).In(time.FixedZone("UTC+2", 2*60*60)),
+		"old-west":    cutoff.Add(-time.Hour).In(time.FixedZone("UTC-5", -5*60*60)),
+		"recent-west": cutoff.Add(time.Hour).In(time.FixedZone("UTC-5", -5*60*60)),
+	}
+	for name, lastUpdateTime := range times {
+		registry.store.SetLastUpdateTime("repo", name, lastUpdateTime)
+	}
+
+	deleted, err := PurgeTags(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", tagPurgeOptions{ago: "2d"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sort.Strings(registry.deletedTags["repo"])
+	if deleted != 2 || !reflect.DeepEqual(registry.deletedTags["repo"], []string{"old-east", "old-west"}) {
+		t.Fatalf("the tags should be compared in UTC, deleted %d %v", deleted, registry.deletedTags["repo"])
+	}
+
+	registry.deletedTags = map[string][]string{}
+	absolute := cutoff.In(time.FixedZone("UTC-3", -3*60*60)).Format(time.RFC3339Nano)
+	deleted, err = PurgeTags(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", tagPurgeOptions{ago: absolute})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deleted != 0 {
+		t.Fatalf("the old tags are already deleted, deleted %d %v", deleted, registry.deletedTags["repo"])
+	}
+}
+
+func TestPurgeRepositoryNewerThan(t *testing.T) {
+	registry := newFakeRegistry()
+	now := time.Now()
+	registry.addManifest("repo", testDigest(1), now.Add(-10*24*time.Hour), "recent")
+	registry.addManifest("repo", testDigest(2), now.Add(-40*24*time.Hour), "in-window")
+	registry.addManifest("repo", testDigest(3), now.Add(-89*24*time.Hour), "window-start")
+	registry.addManifest("repo", testDigest(4), now.Add(-100*24*time.Hour), "release")
+
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "30d", newerThan: "90d"}
+	deletedTags, _, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, parameters)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sort.Strings(registry.deletedTags["repo"])
+	if deletedTags != 2 || !reflect.DeepEqual(registry.deletedTags["repo"], []string{"in-window", "window-start"}) {
+		t.Fatalf("only the tags between the bounds should be deleted, deleted %d %v", deletedTags, registry.deletedTags["repo"])
+	}
+	sort.Strings(registry.deletedManifests["repo"])
+	if !reflect.DeepEqual(registry.deletedManifests["repo"], []string{testDigest(2), testDigest(3)}) {
+		t.Fatalf("the manifests untagged in the window should be dangling, deleted %v", registry.deletedManifests["repo"])
+	}
+
+	for _, newerThan := range []string{"30d", "7d", "soon"} {
+		parameters.newerThan = newerThan
+		if _, _, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, parameters); exitCode(err) != exitCodeInvalidArguments {
+			t.Fatalf("expected an invalid --newer-than %s with --ago 30d, got %v", newerThan, err)
+		}
+	}
+}
+
+func TestPurgeRepositoryFilterMode(t *testing.T) {
+	tests := []struct {
+		mode     string
+		filter   string
+		expected []string
+	}{
+		{filterModeContains, "hello", []string{"hello", "hello-1", "othello"}},
+		{"", "hello", []string{"hello", "hello-1", "othello"}},
+		{filterModeFull, "hello", []string{"hello"}},
+		{filterModePrefix, "hello", []string{"hello", "hello-1"}},
+		{filterModeFull, "hello|othello", []string{"hello", "othello"}},
+		{filterModePrefix, "hello|oth", []string{"hello", "hello-1", "othello"}},
+		{filterModeFull, "", []string{"hello", "hello-1", "othello", "world"}},
+	}
+	for _, test := range tests {
+		registry := newFakeRegistry()
+		old := time.Now().Add(-72 * time.Hour)
+		for i, tag := range []string{"hello", "hello-1", "othello", "world"} {
+			registry.addManifest("repo", testDigest(i), old, tag)
+		}
+		parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", filter: test.filter, filterMode: test.mode}
+		if _, _, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, parameters); err != nil {
+			t.Fatalf("%s %q: unexpected error %v", test.mode, test.filter, err)
+		}
+		sort.Strings(registry.deletedTags["repo"])
+		if !reflect.DeepEqual(registry.deletedTags["repo"], test.expected) {
+			t.Fatalf("%s %q: expected %v to be deleted, got %v", test.mode, test.filter, test.expected, registry.deletedTags["repo"])
+		}
+	}
+}
+
+func TestPurgeRepositoryIndexChildren(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	const index = "application/vnd.oci.image.index.v1+json"
+	// A tagged multi-arch image, one of its platforms was also tagged on its own long ago.
+	registry.addManifest("repo", testDigest(1), time.Now(), "latest")
+	registry.setMediaType("repo", testDigest(1), index)
+	registry.setContent("repo", testDigest(1), &api.Manifest{SchemaVersion: 2, MediaType: index, Manifests: []api.Descriptor{{Digest: testDigest(2)}, {Digest: testDigest(3)}}})
+	registry.addManifest("repo", testDigest(2), old, "amd64-build")
+	registry.addManifest("repo", testDigest(3), old)
+	// An old untagged multi-arch image, it's deleted with its platform.
+	registry.addManifest("repo", testDigest(4), old)
+	registry.setMediaType("repo", testDigest(4), index)
+	registry.setContent("repo", testDigest(4), &api.Manifest{SchemaVersion: 2, MediaType: index, Manifests: []api.Descriptor{{Digest: testDigest(5)}}})
+	registry.addManifest("repo", testDigest(5), old)
+
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "7d", cascade: true}
+	deletedTags, deletedManifests, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, parameters)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sort.Strings(registry.deletedManifests["repo"])
+	if deletedTags != 1 || deletedManifests != 2 || !reflect.DeepEqual(registry.deletedManifests["repo"], []string{testDigest(4), testDigest(5)}) {
+		t.Fatalf("the platforms of the tagged index should be kept, deleted %d tags and %v", deletedTags, registry.deletedManifests["repo"])
+	}
+
+	registry.failOn("AcrGetManifest repo "+testDigest(1), errors.New("unavailable"))
+	if _, _, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, parameters); err == nil {
+		t.Fatalf("expected an error when an index can't be pulled")
+	}
+	if len(registry.deletedManifests["repo"]) != 2 {
+		t.Fatalf("nothing should be deleted when an index can't be pulled, deleted %v", registry.deletedManifests["repo"])
+	}
+}
+
+func TestPurgeTagsRegistryServer(t *testing.T) {
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	old := time.Now().Add(-72 * time.Hour)
+	oldImage := registry.AddImage("repo", old, "v1", "v2")
+	registry.AddImage("repo", old, "locked")
+	registry.Lock("repo", "locked")
+	recent := registry.AddImage("repo", time.Now(), "latest")
+	dangling := registry.AddImage("repo", old)
+	httpClient, err := api.NewHTTPClient(api.TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	acrClient := api.NewAcrCLIClient(registry.LoginURL(), api.BasicAuth("user", "password"), httpClient)
+
+	results := newPurgeResults(ioutil.Discard, registry.LoginURL(), outputText)
+	deleted, err := PurgeTags(context.Background(), acrClient, results, "repo", tagPurgeOptions{ago: "1d"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deleted != 2 || !reflect.DeepEqual(registry.Tags("repo"), []string{"latest", "locked"}) {
+		t.Fatalf("expected the old unlocked tags to be deleted, deleted %d and kept %v", deleted, registry.Tags("repo"))
+	}
+
+	deleted, err = PurgeDanglingManifests(context.Background(), acrClient, results, "repo", manifestPurgeOptions{ago: "1d"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	remaining := registry.Manifests("repo")
+	for _, digest := range remaining {
+		if digest == oldImage || digest == dangling {
+			t.Fatalf("expected the untagged manifests to be deleted, remaining %v", remaining)
+		}
+	}
+	if deleted != 2 || len(remaining) != 2 {
+		t.Fatalf("expected the locked and %s manifests to be kept, deleted %d and kept %v", recent, deleted, remaining)
+	}
+}
+
+func TestPurgePermissionCheck(t *testing.T) {
+	forbidden := &api.PermissionError{Repository: "repo", Action: "delete", Err: &api.RegistryError{StatusCode: http.StatusForbidden, Code: "DENIED", Message: "requested access to the resource is denied"}}
+	tests := []struct {
+		name        string
+		forbidden   bool
+		skip        bool
+		tags        []string
+		expectedErr bool
+		deleted     int
+	}{
+		{"permitted", false, false, nil, false, 1},
+		{"forbidden", true, false, nil, true, 0},
+		{"forbidden explicit tags", true, false, []string{"v1"}, true, 0},
+		{"skipped", true, true, nil, false, 1},
+	}
+	for _, test := range tests {
+		registry := newFakeRegistry()
+		registry.addManifest("repo", testDigest(1), time.Now().Add(-72*time.Hour), "v1")
+		if test.forbidden {
+			registry.failOn("AcrCheckDeletePermission repo", forbidden)
+		}
+		parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", tags: test.tags, skipPermission: test.skip, output: outputText, logFormat: logFormatText}
+		err := purge(context.Background(), registry, ioutil.Discard, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), parameters)
+		if test.expectedErr {
+			if exitCode(err) != exitCodeAuthenticationFailed || !strings.HasPrefix(err.Error(), "credentials lack delete permission on repository repo") {
+				t.Fatalf("%s: expected the permission error, got %v", test.name, err)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: unexpected error %v", test.name, err)
+		}
+		if len(registry.deletedTags["repo"]) != test.deleted {
+			t.Fatalf("%s: expected %d deleted tags, got %v", test.name, test.deleted, registry.deletedTags["repo"])
+		}
+	}
+}
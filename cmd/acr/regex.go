@@ -0,0 +1,33 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+)
+
+// compileFlagRegex compiles the regular expression pattern given with flag, like --filter. A malformed pattern is an
+// invalid arguments error naming the flag and the problem, like invalid --filter "(": missing closing ).
+func compileFlagRegex(flag string, pattern string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, newInvalidArgumentsError("invalid %s %q: %s", flag, pattern, describeRegexError(pattern, err))
+	}
+	return regex, nil
+}
+
+// describeRegexError returns the problem of the malformed pattern that failed to compile with err, without the
+// "error parsing regexp" prefix and with the part of pattern at fault when it isn't the whole pattern.
+func describeRegexError(pattern string, err error) string {
+	syntaxError, ok := err.(*syntax.Error)
+	if !ok {
+		return err.Error()
+	}
+	if len(syntaxError.Expr) == 0 || syntaxError.Expr == pattern {
+		return syntaxError.Code.String()
+	}
+	return fmt.Sprintf("%s at %q", syntaxError.Code, syntaxError.Expr)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCompileFlagRegex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		message string
+	}{
+		{"(", `invalid --filter "(": missing closing )`},
+		{"[a", `invalid --filter "[a": missing closing ]`},
+		{"a**", `invalid --filter "a**": invalid nested repetition operator at "**"`},
+		{"*a", `invalid --filter "*a": missing argument to repetition operator at "*"`},
+		{"x{2,1}", `invalid --filter "x{2,1}": invalid repeat count at "{2,1}"`},
+		{`\p{Foo}`, `invalid --filter "\\p{Foo}": invalid character class range`},
+	}
+	for _, test := range tests {
+		_, err := compileFlagRegex("--filter", test.pattern)
+		if exitCode(err) != exitCodeInvalidArguments {
+			t.Fatalf("%s: expected an invalid arguments error, got %v", test.pattern, err)
+		}
+		if err.Error() != test.message {
+			t.Fatalf("%s: expected %q, got %q", test.pattern, test.message, err.Error())
+		}
+	}
+	if _, err := compileFlagRegex("--filter", "^v[0-9]+$"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestPurgeRegexFlags(t *testing.T) {
+	tests := []struct {
+		args    []string
+		message string
+	}{
+		{[]string{"--filter", "("}, `invalid --filter "(": missing closing )`},
+		{[]string{"--filter", "^v", "--filter-mode", "full", "--manifest-filter", "helm["}, `invalid --manifest-filter "helm[": missing closing ]`},
+		{[]string{"--keep-per-group", "1", "--group-regex", "^(.*-"}, `invalid --group-regex "^(.*-": missing closing )`},
+		{[]string{"--keep-per-group", "1", "--group-regex", "^.*-"}, `--group-regex "^.*-" must contain a capture group`},
+	}
+	for _, test := range tests {
+		cmd := newRootCmd(nil)
+		// The registry isn't reachable, the patterns are checked before it's called.
+		cmd.SetArgs(append([]string{"purge", "-r", "registry.invalid", "-u", "user", "-p", "password", "--repository", "repo"}, test.args...))
+		cmd.SetOutput(ioutil.Discard)
+		err := cmd.Execute()
+		if exitCode(err) != exitCodeInvalidArguments || !strings.Contains(err.Error(), test.message) {
+			t.Fatalf("%v: expected an invalid arguments error with %q, got %v", test.args, test.message, err)
+		}
+	}
+}
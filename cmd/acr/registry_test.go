@@ -0,0 +1,299 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/AzureCR/acr-cli/cmd/api/acrtest"
+)
+
+// fakeRegistry is an api.AcrCLIClientInterface used by the command tests that calls an in-memory acrtest registry in
+// process, so the paging, the locks and the deletions behave like in the tests of the API client. It records the
+// calls the tests check and returns the errors set with failOn instead of calling the registry.
+type fakeRegistry struct {
+	store *acrtest.Registry
+
+	mu                 sync.Mutex
+	pageSize           int
+	listedTags         []string
+	tagsOrderBy        []string
+	listedRepositories []string
+	listedManifests    []string
+	deletedTags        map[string][]string
+	deletedManifests   map[string][]string
+	pushedManifests    map[string][]string
+	mountedBlobs       map[string][]string
+	errors             map[string]error
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		store:            acrtest.NewMemoryRegistry(),
+		pageSize:         100,
+		deletedTags:      map[string][]string{},
+		deletedManifests: map[string][]string{},
+		pushedManifests:  map[string][]string{},
+		mountedBlobs:     map[string][]string{},
+		errors:           map[string]error{},
+	}
+}
+
+// addManifest adds a manifest with the given tags to a repository, its content is an empty image manifest.
+func (f *fakeRegistry) addManifest(repoName string, digest string, lastUpdateTime time.Time, tags ...string) {
+	content, _ := json.Marshal(&api.Manifest{SchemaVersion: 2})
+	f.store.AddManifestDigest(repoName, digest, content, lastUpdateTime, tags...)
+}
+
+// setMediaType sets the media type of a manifest added with addManifest.
+func (f *fakeRegistry) setMediaType(repoName string, digest string, mediaType string) {
+	f.store.SetMediaType(repoName, digest, mediaType)
+}
+
+// addReferrer adds an untagged manifest to a repository that references subject through the referrers API.
+func (f *fakeRegistry) addReferrer(repoName string, subject string, digest string, lastUpdateTime time.Time, artifactType string) {
+	content, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"artifactType":  artifactType,
+		"subject":       api.Descriptor{Digest: subject},
+	})
+	f.store.AddManifestDigest(repoName, digest, content, lastUpdateTime)
+}
+
+// lock disables deleting a tag, or a manifest when reference is a digest.
+func (f *fakeRegistry) lock(repoName string, reference string) {
+	f.store.Lock(repoName, reference)
+}
+
+// setLayers sets the config and layer sizes, by digest, of the content of a manifest.
+func (f *fakeRegistry) setLayers(repoName string, digest string, layers map[string]int64) {
+	manifest := &api.Manifest{SchemaVersion: 2}
+	for layerDigest, size := range layers {
+		manifest.Layers = append(manifest.Layers, api.Descriptor{Digest: layerDigest, Size: size})
+	}
+	f.setContent(repoName, digest, manifest)
+}
+
+// setContent replaces the content of a manifest, its digest is kept.
+func (f *fakeRegistry) setContent(repoName string, digest string, manifest *api.Manifest) {
+	content, _ := json.Marshal(manifest)
+	f.store.SetContent(repoName, digest, content)
+}
+
+// content returns the decoded content of a manifest.
+func (f *fakeRegistry) content(repoName string, digest string) *api.Manifest {
+	manifest, _ := f.AcrGetManifest(context.Background(), repoName, digest)
+	return manifest
+}
+
+// setMetadata stores value under key in the metadata of a repository.
+func (f *fakeRegistry) setMetadata(repoName string, key string, value string) {
+	f.store.SetMetadata(repoName, key, value)
+}
+
+// failOn makes every call whose key is "<operation> <repository>[ <reference>]" return err.
+func (f *fakeRegistry) failOn(key string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors[key] = err
+}
+
+// failure returns the error set with failOn for the call identified by key.
+func (f *fakeRegistry) failure(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.errors[key]
+}
+
+// record appends value to one of the lists of calls.
+func (f *fakeRegistry) record(calls *[]string, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*calls = append(*calls, value)
+}
+
+func (f *fakeRegistry) AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error) {
+	f.record(&f.listedTags, repoName)
+	f.record(&f.tagsOrderBy, orderBy)
+	if err := f.failure("AcrListTags " + repoName); err != nil {
+		return nil, err
+	}
+	tags, err := f.store.ListTags(repoName, orderBy, last, f.pageSize)
+	return tags, registryError(err)
+}
+
+func (f *fakeRegistry) AcrDeleteTag(ctx context.Context, repoName string, reference string) error {
+	if err := f.failure(fmt.Sprintf("AcrDeleteTag %s %s", repoName, reference)); err != nil {
+		return err
+	}
+	if err := f.store.DeleteTag(repoName, reference); err != nil {
+		return registryError(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletedTags[repoName] = append(f.deletedTags[repoName], reference)
+	return nil
+}
+
+func (f *fakeRegistry) AcrListManifests(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.ManifestAttributeList, error) {
+	f.record(&f.listedManifests, repoName)
+	if err := f.failure("AcrListManifests " + repoName); err != nil {
+		return nil, err
+	}
+	manifests, err := f.store.ListManifests(repoName, last, f.pageSize)
+	return manifests, registryError(err)
+}
+
+func (f *fakeRegistry) DeleteManifest(ctx context.Context, repoName string, reference string) error {
+	if err := f.failure(fmt.Sprintf("DeleteManifest %s %s", repoName, reference)); err != nil {
+		return err
+	}
+	if err := f.store.DeleteManifest(repoName, reference); err != nil {
+		return registryError(err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletedManifests[repoName] = append(f.deletedManifests[repoName], reference)
+	return nil
+}
+
+func (f *fakeRegistry) AcrListReferrers(ctx context.Context, repoName string, digest string) (*api.ReferrerList, error) {
+	if err := f.failure(fmt.Sprintf("AcrListReferrers %s %s", repoName, digest)); err != nil {
+		return nil, err
+	}
+	referrers, err := f.store.Referrers(repoName, digest)
+	if err != nil {
+		return nil, registryError(err)
+	}
+	list := &api.ReferrerList{SchemaVersion: 2, MediaType: "application/vnd.oci.image.index.v1+json", Manifests: []api.Descriptor{}}
+	for _, referrer := range referrers {
+		list.Manifests = append(list.Manifests, api.Descriptor{
+			MediaType:    referrer.MediaType,
+			Digest:       referrer.Digest,
+			Size:         referrer.Size,
+			ArtifactType: referrer.ArtifactType,
+		})
+	}
+	return list, nil
+}
+
+func (f *fakeRegistry) AcrListRepositories(ctx context.Context, last string) (*api.RepositoryList, error) {
+	return f.listRepositories("AcrListRepositories", last)
+}
+
+func (f *fakeRegistry) AcrListRepositoriesV2(ctx context.Context, last string) (*api.RepositoryList, error) {
+	return f.listRepositories("AcrListRepositoriesV2", last)
+}
+
+func (f *fakeRegistry) listRepositories(operation string, last string) (*api.RepositoryList, error) {
+	f.record(&f.listedRepositories, operation)
+	if err := f.failure(operation); err != nil {
+		return nil, err
+	}
+	repositories := &api.RepositoryList{Repositories: []string{}}
+	repositories.Repositories = append(repositories.Repositories, f.store.ListRepositories(last, f.pageSize)...)
+	return repositories, nil
+}
+
+func (f *fakeRegistry) AcrGetRepositoryAttributes(ctx context.Context, repoName string) (*acrapi.RepositoryAttributes, error) {
+	if err := f.failure("AcrGetRepositoryAttributes " + repoName); err != nil {
+		return nil, err
+	}
+	attributes, err := f.store.RepositoryAttributes(repoName)
+	return attributes, registryError(err)
+}
+
+func (f *fakeRegistry) AcrCheckDeletePermission(ctx context.Context, repoName string) error {
+	return f.failure("AcrCheckDeletePermission " + repoName)
+}
+
+func (f *fakeRegistry) AcrGetRepositoryMetadata(ctx context.Context, repoName string, key string) ([]byte, error) {
+	if err := f.failure("AcrGetRepositoryMetadata " + repoName); err != nil {
+		return nil, err
+	}
+	value, err := f.store.Metadata(repoName, key)
+	return value, registryError(err)
+}
+
+func (f *fakeRegistry) AcrGetManifest(ctx context.Context, repoName string, reference string) (*api.Manifest, error) {
+	manifest, err := f.AcrGetManifestContent(ctx, repoName, reference)
+	if err != nil {
+		return nil, err
+	}
+	var decoded api.Manifest
+	if err := json.Unmarshal(manifest.Content, &decoded); err != nil {
+		return nil, err
+	}
+	return &decoded, nil
+}
+
+// AcrGetManifestContent returns the content of a manifest. The digest is the one the manifest was added with, not the
+// digest of the content.
+func (f *fakeRegistry) AcrGetManifestContent(ctx context.Context, repoName string, reference string) (*api.ManifestContent, error) {
+	digest, _, content, err := f.store.Manifest(repoName, reference)
+	if err != nil {
+		return nil, registryError(err)
+	}
+	if err := f.failure(fmt.Sprintf("AcrGetManifest %s %s", repoName, digest)); err != nil {
+		return nil, err
+	}
+	var decoded api.Manifest
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return nil, err
+	}
+	return &api.ManifestContent{MediaType: decoded.MediaType, Digest: digest, Content: content}, nil
+}
+
+// AcrPutManifest records the reference manifest was pushed with. The manifest is added to the repository, with
+// reference as its tag unless it's a digest, so it can be listed and pulled afterwards.
+func (f *fakeRegistry) AcrPutManifest(ctx context.Context, repoName string, reference string, manifest *api.ManifestContent) error {
+	if err := f.failure(fmt.Sprintf("AcrPutManifest %s %s", repoName, reference)); err != nil {
+		return err
+	}
+	var decoded api.Manifest
+	if err := json.Unmarshal(manifest.Content, &decoded); err != nil {
+		return err
+	}
+	f.store.PutManifest(repoName, reference, manifest.Digest, manifest.MediaType, manifest.Content)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pushedManifests[repoName] = append(f.pushedManifests[repoName], reference)
+	return nil
+}
+
+// AcrMountBlob records the mounted blobs as "<source repository>@<digest>".
+func (f *fakeRegistry) AcrMountBlob(ctx context.Context, repoName string, sourceRepoName string, digest string) error {
+	if err := f.failure(fmt.Sprintf("AcrMountBlob %s %s", repoName, digest)); err != nil {
+		return err
+	}
+	f.store.MountBlob(repoName, sourceRepoName, digest)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mountedBlobs[repoName] = append(f.mountedBlobs[repoName], sourceRepoName+"@"+digest)
+	return nil
+}
+
+// registryError converts the errors of the acrtest registry into the registry errors of the API client.
+func registryError(err error) error {
+	if registryError, ok := err.(*acrtest.Error); ok {
+		return &api.RegistryError{StatusCode: registryError.StatusCode, Code: registryError.Code, Message: registryError.Message}
+	}
+	return err
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// testDigest returns a well formed sha256 digest that is unique for i.
+func testDigest(i int) string {
+	return fmt.Sprintf("sha256:%064x", i)
+}
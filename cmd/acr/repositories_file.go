@@ -0,0 +1,78 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// repositoryEntry is a repository read from a --repositories-from-file file, ago and filter are empty when the entry
+// doesn't override the values given on the command line.
+type repositoryEntry struct {
+	name   string
+	ago    string
+	filter string
+}
+
+// parseRepositoriesFile reads one repository per line, the repository name can be followed by ago=<duration> and
+// filter=<regex> overrides separated by whitespace. Blank lines and lines starting with # are ignored.
+func parseRepositoriesFile(r io.Reader) ([]repositoryEntry, error) {
+	var entries []repositoryEntry
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		entry := repositoryEntry{name: fields[0]}
+		for _, field := range fields[1:] {
+			keyValue := strings.SplitN(field, "=", 2)
+			if len(keyValue) != 2 || len(keyValue[1]) == 0 {
+				return nil, fmt.Errorf("line %d: expected key=value, got %q", lineNumber, field)
+			}
+			switch keyValue[0] {
+			case "ago":
+				if _, err := cutoffTime(keyValue[1], nowFunc()); err != nil {
+					return nil, fmt.Errorf("line %d: invalid ago %q: %v", lineNumber, keyValue[1], err)
+				}
+				entry.ago = keyValue[1]
+			case "filter":
+				if _, err := regexp.Compile(keyValue[1]); err != nil {
+					return nil, fmt.Errorf("line %d: invalid filter %q: %s", lineNumber, keyValue[1], describeRegexError(keyValue[1], err))
+				}
+				entry.filter = keyValue[1]
+			default:
+				return nil, fmt.Errorf("line %d: unknown override %q, expected ago or filter", lineNumber, keyValue[0])
+			}
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// matchRepositories returns an entry for every repository matching one of the shell patterns of globs, the patterns
+// are expected to be valid.
+func matchRepositories(repositories []string, globs []string) []repositoryEntry {
+	var entries []repositoryEntry
+	for _, repoName := range repositories {
+		for _, glob := range globs {
+			if matched, _ := path.Match(glob, repoName); matched {
+				entries = append(entries, repositoryEntry{name: repoName})
+				break
+			}
+		}
+	}
+	return entries
+}
@@ -0,0 +1,173 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseRepositoriesFile(t *testing.T) {
+	content := `# nightly cleanup
+team/frontend
+
+team/backend ago=7d
+  team/jobs   filter=^ci- ago=1d12h
+`
+	entries, err := parseRepositoriesFile(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []repositoryEntry{
+		{name: "team/frontend"},
+		{name: "team/backend", ago: "7d"},
+		{name: "team/jobs", ago: "1d12h", filter: "^ci-"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("parseRepositoriesFile incorrect, got %+v, expected %+v", entries, expected)
+	}
+}
+
+func TestParseRepositoriesFileErrors(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected string
+	}{
+		{"repo ago", "line 1: expected key=value"},
+		{"repo\nrepo2 ago=", "line 2: expected key=value"},
+		{"repo keep=3", "line 1: unknown override \"keep\""},
+		{"repo ago=5x", "line 1: invalid ago \"5x\""},
+		{"repo filter=v(", "line 1: invalid filter \"v(\": missing closing )"},
+	}
+	for _, test := range tests {
+		_, err := parseRepositoriesFile(strings.NewReader(test.content))
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Fatalf("parseRepositoriesFile(%q) error incorrect, got %v, expected %s", test.content, err, test.expected)
+		}
+	}
+}
+
+func TestPurgeRepositories(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo1", testDigest(1), old, "v1")
+	registry.addManifest("repo2", testDigest(2), old, "ci-1")
+	registry.addManifest("repo2", testDigest(3), old, "v2")
+	registry.addManifest("repo3", testDigest(4), old, "v3")
+	registry.failOn("AcrListTags repo3", errors.New("NAME_UNKNOWN repository not found"))
+
+	entries := []repositoryEntry{
+		{name: "repo1"},
+		{name: "repo2", filter: "^ci-"},
+		{name: "repo3"},
+		{name: "repo4", ago: "1d"},
+	}
+	parameters := purgeParameters{concurrency: defaultConcurrency, ago: "1d"}
+	var out bytes.Buffer
+	err := purgeRepositories(context.Background(), registry, &out, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, entries, parameters)
+	if err == nil || err.Error() != "failed to purge 2 of 4 repositories" {
+		t.Fatalf("purgeRepositories error incorrect, got %v", err)
+	}
+	expectedListed := []string{"repo1", "repo1", "repo2", "repo2", "repo3"}
+	if !reflect.DeepEqual(registry.listedTags, expectedListed) {
+		t.Fatalf("listed tags incorrect, got %v, expected %v", registry.listedTags, expectedListed)
+	}
+	if !reflect.DeepEqual(registry.deletedTags["repo2"], []string{"ci-1"}) {
+		t.Fatalf("per repository filter not applied, deleted %v", registry.deletedTags["repo2"])
+	}
+	summary := out.String()
+	for _, line := range []string{
+		"repo1: 1 tags deleted, 1 manifests deleted",
+		"repo2: 1 tags deleted, 1 manifests deleted",
+		"repo3: failed after deleting 0 tags and 0 manifests: NAME_UNKNOWN repository not found",
+		"repo4: failed after deleting 0 tags and 0 manifests: repository 'repo4' not found in registry 'registry.azurecr.io'",
+	} {
+		if !strings.Contains(summary, line) {
+			t.Fatalf("summary %q doesn't contain %q", summary, line)
+		}
+	}
+}
+
+func TestMatchRepositories(t *testing.T) {
+	catalog := []string{"hello-world", "team/api", "team/api-staging", "team/web/frontend", "web-staging"}
+	tests := []struct {
+		globs    []string
+		expected []string
+	}{
+		{[]string{"team/*"}, []string{"team/api", "team/api-staging"}},
+		{[]string{"team/*/*"}, []string{"team/web/frontend"}},
+		{[]string{"*-staging"}, []string{"web-staging"}},
+		{[]string{"*-staging", "team/*-staging"}, []string{"team/api-staging", "web-staging"}},
+		{[]string{"hello-?orld", "team/[aw]*"}, []string{"hello-world", "team/api", "team/api-staging"}},
+		{[]string{"missing*"}, nil},
+	}
+	for _, test := range tests {
+		var names []string
+		for _, entry := range matchRepositories(catalog, test.globs) {
+			names = append(names, entry.name)
+		}
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Fatalf("matchRepositories(%v) incorrect, got %v, expected %v", test.globs, names, test.expected)
+		}
+	}
+}
+
+func TestPurgeRepositoryGlob(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("team/api", testDigest(1), old, "v1")
+	registry.addManifest("team/web", testDigest(2), old, "v2")
+	registry.addManifest("other", testDigest(3), old, "v3")
+	parameters := purgeParameters{concurrency: defaultConcurrency, ago: "1d", repoGlobs: []string{"team/*"}}
+	var out bytes.Buffer
+	if err := purge(context.Background(), registry, &out, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(registry.deletedTags["team/api"]) != 1 || len(registry.deletedTags["team/web"]) != 1 || len(registry.deletedTags["other"]) != 0 {
+		t.Fatalf("only the matching repositories should be purged, deleted %v", registry.deletedTags)
+	}
+
+	parameters.repoGlobs = []string{"missing/*"}
+	parameters.failIfNone = true
+	out.Reset()
+	err := purge(context.Background(), registry, &out, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), parameters)
+	if err != errNothingDeleted || out.String() != "No repository matches missing/*\n" {
+		t.Fatalf("expected nothing to match, got %v and %q", err, out.String())
+	}
+}
+
+func TestPurgeAllRepositories(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("team/api", testDigest(1), old, "v1")
+	registry.addManifest("other", testDigest(2), old, "v2", "keep")
+	registry.addManifest("recent", testDigest(3), time.Now(), "v3")
+	parameters := purgeParameters{concurrency: defaultConcurrency, ago: "1d", filter: "^v", allRepositories: true}
+	var out bytes.Buffer
+	if err := purge(context.Background(), registry, &out, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(registry.deletedTags["team/api"]) != 1 || len(registry.deletedTags["other"]) != 1 || len(registry.deletedTags["recent"]) != 0 {
+		t.Fatalf("the age and filter should be applied to every repository, deleted %v", registry.deletedTags)
+	}
+	for _, repository := range []string{"team/api", "other", "recent"} {
+		if !strings.Contains(out.String(), repository) {
+			t.Fatalf("expected a summary for %s, got %q", repository, out.String())
+		}
+	}
+
+	parameters.failIfNone = true
+	out.Reset()
+	err := purge(context.Background(), newFakeRegistry(), &out, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), parameters)
+	if err != errNothingDeleted || out.String() != "The registry has no repository\n" {
+		t.Fatalf("expected an empty registry, got %v and %q", err, out.String())
+	}
+}
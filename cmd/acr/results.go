@@ -0,0 +1,458 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/AzureCR/acr-cli/cmd/table"
+	"github.com/pkg/errors"
+)
+
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// outcome is what happened to a tag or a manifest selected for deletion.
+type outcome int
+
+const (
+	outcomeDeleted outcome = iota
+	outcomeLocked
+	outcomeNotFound
+	outcomeFailed
+	outcomePreserved
+)
+
+// purgeResult is the outcome of a tag or a manifest selected for deletion, Tag is empty for manifests.
+type purgeResult struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	// LastUpdateTime is the RFC 3339 time the registry returned, it's empty when the item wasn't listed.
+	LastUpdateTime string `json:"lastUpdateTime,omitempty"`
+	outcome        outcome
+	// tagDigest is the digest of the manifest a tag referenced, it's only used to find the manifests a run untagged.
+	tagDigest string
+	// selection is the reason the item was selected for deletion and size the size of a manifest, they're only
+	// written to the audit file.
+	selection string
+	size      *int64
+}
+
+// outcomeNames are the outcomes as shown in the table output and in the JSON log lines.
+var outcomeNames = map[outcome]string{
+	outcomeDeleted:   "deleted",
+	outcomeLocked:    "locked",
+	outcomeNotFound:  "not found",
+	outcomeFailed:    "failed",
+	outcomePreserved: "preserved",
+}
+
+// outcomeLevels are the levels of the JSON log lines of the outcomes.
+var outcomeLevels = map[outcome]string{
+	outcomeDeleted:   "info",
+	outcomeLocked:    "warning",
+	outcomeNotFound:  "warning",
+	outcomeFailed:    "error",
+	outcomePreserved: "info",
+}
+
+// logLine is a JSON log line, written for every recorded result with --log-format json.
+type logLine struct {
+	Time       string `json:"time"`
+	Level      string `json:"level"`
+	Operation  string `json:"operation"`
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	Outcome    string `json:"outcome"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// remainingItems are the tags and manifests of a repository listed after it was purged.
+type remainingItems struct {
+	Repository string   `json:"repository"`
+	Tags       []string `json:"tags"`
+	Manifests  []string `json:"manifests"`
+}
+
+// purgeReport is the JSON representation of the results of a purge run, Remaining is only set with
+// --report-remaining. With --dry-run DryRun is set and Deleted are the items the run would delete.
+type purgeReport struct {
+	DryRun    bool             `json:"dryRun,omitempty"`
+	Deleted   []purgeResult    `json:"deleted"`
+	Locked    []purgeResult    `json:"locked,omitempty"`
+	NotFound  []purgeResult    `json:"notFound"`
+	Failed    []purgeResult    `json:"failed"`
+	Preserved []purgeResult    `json:"preserved,omitempty"`
+	Remaining []remainingItems `json:"remaining,omitempty"`
+}
+
+// purgeResults collects the outcome of every tag and manifest selected by a purge run, it's safe to use from the
+// deletion workers. In text output every deleted item is written to out as soon as it's recorded, unless quiet is set,
+// the writes are serialized so the lines of concurrent workers don't interleave. With the JSON log format every result
+// is written as a JSON log line instead, and with a format every result is rendered through its template, quiet only
+// drops the deleted ones. The table output shows every result at the end, with the digests truncated unless noTrunc is
+// set. Every deleted item is also appended to the audit log when there is one, and every result is counted by the
+// progress bar when there is one.
+type purgeResults struct {
+	mu        sync.Mutex
+	out       io.Writer
+	loginURL  string
+	output    string
+	quiet     bool
+	noTrunc   bool
+	logFormat string
+	format    *template.Template
+	audit     *auditLog
+	progress  *progressBar
+	// dryRun reports the deleted items as the ones that would be deleted.
+	dryRun    bool
+	results   []purgeResult
+	remaining []remainingItems
+	// line is reused to print the deleted items without allocating.
+	line []byte
+}
+
+func newPurgeResults(out io.Writer, loginURL string, output string) *purgeResults {
+	return &purgeResults{out: out, loginURL: loginURL, output: output}
+}
+
+// record stores the outcome of the deletion of result, err is the error returned by the deletion. It returns the
+// outcome so the workers know whether the error has to be reported. A deletion that wasn't attempted because of
+// --max-delete isn't stored, the item is left as it was.
+func (r *purgeResults) record(result purgeResult, err error) outcome {
+	result.outcome = deletionOutcome(err)
+	if isDeletionCapReached(err) {
+		r.progress.done(1)
+		return result.outcome
+	}
+	if err != nil {
+		result.Reason = api.Redact(err.Error())
+	}
+	r.add(result)
+	return result.outcome
+}
+
+// recordPreserved stores a tag or manifest that wasn't deleted because its digest is preserved.
+func (r *purgeResults) recordPreserved(result purgeResult) {
+	result.outcome = outcomePreserved
+	result.Reason = preservedReason
+	r.progress.add(1)
+	r.add(result)
+}
+
+// recordLocked stores a tag or manifest that wasn't deleted because its delete or write attributes are disabled.
+func (r *purgeResults) recordLocked(result purgeResult) {
+	result.outcome = outcomeLocked
+	result.Reason = "deleting is disabled by the delete-enabled or write-enabled attributes"
+	r.progress.add(1)
+	r.add(result)
+}
+
+func (r *purgeResults) add(result purgeResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.progress.done(1)
+	r.results = append(r.results, result)
+	if r.audit != nil && result.outcome == outcomeDeleted {
+		r.audit.write(r.newAuditRecord(result))
+	}
+	if r.output != outputText {
+		return
+	}
+	if r.logFormat == logFormatJSON {
+		if !r.quiet || result.outcome != outcomeDeleted {
+			r.progress.clear()
+			r.writeLogLine(result)
+		}
+		return
+	}
+	if r.format != nil {
+		if !r.quiet || result.outcome != outcomeDeleted {
+			r.progress.clear()
+			r.writeFormatted(result)
+		}
+		return
+	}
+	if !r.quiet && result.outcome == outcomeDeleted {
+		r.progress.clear()
+		r.line = r.line[:0]
+		if r.dryRun {
+			r.line = append(r.line, "Would delete "...)
+		}
+		r.line = append(r.appendReference(r.line, result), '\n')
+		r.out.Write(r.line)
+	}
+}
+
+// writeLogLine writes result as a JSON log line, the caller holds the lock.
+func (r *purgeResults) writeLogLine(result purgeResult) {
+	line := logLine{
+		Time:       time.Now().UTC().Format(time.RFC3339Nano),
+		Level:      outcomeLevels[result.outcome],
+		Operation:  "delete-manifest",
+		Repository: result.Repository,
+		Tag:        result.Tag,
+		Digest:     result.Digest,
+		Outcome:    r.outcomeName(result.outcome),
+		Reason:     result.Reason,
+	}
+	if len(result.Tag) > 0 {
+		line.Operation = "untag"
+	}
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	r.out.Write(append(encoded, '\n'))
+}
+
+// writeFormatted renders result through the format template followed by a new line, the caller holds the lock.
+func (r *purgeResults) writeFormatted(result purgeResult) {
+	var line bytes.Buffer
+	if err := r.format.Execute(&line, r.newFormatItem(result)); err != nil {
+		return
+	}
+	line.WriteByte('\n')
+	r.out.Write(line.Bytes())
+}
+
+// reference returns the fully qualified reference of the tag or manifest of result.
+func (r *purgeResults) reference(result purgeResult) string {
+	return string(r.appendReference(nil, result))
+}
+
+// appendReference appends the fully qualified reference of the tag or manifest of result to buffer.
+func (r *purgeResults) appendReference(buffer []byte, result purgeResult) []byte {
+	buffer = append(buffer, r.loginURL...)
+	buffer = append(buffer, '/')
+	buffer = append(buffer, result.Repository...)
+	if len(result.Tag) > 0 {
+		buffer = append(buffer, ':')
+		return append(buffer, result.Tag...)
+	}
+	buffer = append(buffer, '@')
+	return append(buffer, result.Digest...)
+}
+
+// recordRemaining stores the items left in a repository after it was purged.
+func (r *purgeResults) recordRemaining(remaining remainingItems) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remaining = append(r.remaining, remaining)
+}
+
+// report groups the results by outcome, locked items are only included when includeLocked is set.
+func (r *purgeResults) report(includeLocked bool) purgeReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := purgeReport{DryRun: r.dryRun, Deleted: []purgeResult{}, NotFound: []purgeResult{}, Failed: []purgeResult{}}
+	for _, result := range r.results {
+		switch result.outcome {
+		case outcomeDeleted:
+			report.Deleted = append(report.Deleted, result)
+		case outcomeLocked:
+			if includeLocked {
+				report.Locked = append(report.Locked, result)
+			}
+		case outcomeNotFound:
+			report.NotFound = append(report.NotFound, result)
+		case outcomeFailed:
+			report.Failed = append(report.Failed, result)
+		case outcomePreserved:
+			report.Preserved = append(report.Preserved, result)
+		}
+	}
+	report.Remaining = r.remaining
+	if includeLocked && report.Locked == nil {
+		report.Locked = []purgeResult{}
+	}
+	return report
+}
+
+// untaggedDigests returns the digests of the manifests referenced by the tags of repoName deleted so far.
+func (r *purgeResults) untaggedDigests(repoName string) map[string]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digests := map[string]bool{}
+	for _, result := range r.results {
+		if result.outcome == outcomeDeleted && result.Repository == repoName && len(result.tagDigest) > 0 {
+			digests[result.tagDigest] = true
+		}
+	}
+	return digests
+}
+
+// writeSummary writes the preserved items and the items the run couldn't delete grouped by reason in text output and
+// every result in JSON, YAML and table output. In quiet text output, where the deleted items weren't printed, it starts
+// with the number of deleted items. Nothing is written with the JSON log format, every item was already logged.
+func (r *purgeResults) writeSummary(out io.Writer, includeLocked bool) error {
+	report := r.report(includeLocked)
+	switch r.output {
+	case outputJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case outputYAML:
+		return writeYAML(out, report)
+	case outputTable:
+		return r.writeTable(out, report)
+	}
+	if r.logFormat == logFormatJSON || r.format != nil {
+		return nil
+	}
+	if r.quiet {
+		deletedTags := 0
+		for _, result := range report.Deleted {
+			if len(result.Tag) > 0 {
+				deletedTags++
+			}
+		}
+		verb := "Deleted"
+		if r.dryRun {
+			verb = "Would delete"
+		}
+		fmt.Fprintf(out, "%s %d tags and %d manifests\n", verb, deletedTags, len(report.Deleted)-deletedTags)
+	}
+	if len(report.Preserved) > 0 {
+		fmt.Fprintf(out, "Preserved %d items listed in the preserve file:\n", len(report.Preserved))
+		for _, result := range report.Preserved {
+			fmt.Fprintf(out, "  %s\n", r.reference(result))
+		}
+	}
+	notDeleted := len(report.Locked) + len(report.NotFound) + len(report.Failed)
+	if notDeleted == 0 {
+		return nil
+	}
+	fmt.Fprintf(out, "Unable to delete %d items:\n", notDeleted)
+	groups := []struct {
+		title   string
+		results []purgeResult
+	}{
+		{"Locked", report.Locked},
+		{"Not found, deleted by someone else", report.NotFound},
+		{"Failed", report.Failed},
+	}
+	for _, group := range groups {
+		if len(group.results) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "  %s (%d):\n", group.title, len(group.results))
+		for _, result := range group.results {
+			if len(result.Reason) > 0 && result.outcome == outcomeFailed {
+				fmt.Fprintf(out, "    %s: %s\n", r.reference(result), result.Reason)
+			} else {
+				fmt.Fprintf(out, "    %s\n", r.reference(result))
+			}
+		}
+	}
+	return nil
+}
+
+// writeTable writes every result of report in aligned columns, grouped by outcome.
+func (r *purgeResults) writeTable(out io.Writer, report purgeReport) error {
+	resultsTable := table.New("REPOSITORY", "TAG", "DIGEST", "LAST UPDATED", "RESULT", "REASON")
+	for _, results := range [][]purgeResult{report.Deleted, report.Locked, report.NotFound, report.Failed, report.Preserved} {
+		for _, result := range results {
+			reason := ""
+			if result.outcome == outcomeFailed {
+				reason = result.Reason
+			}
+			lastUpdated := ""
+			if lastUpdateTime, err := time.Parse(time.RFC3339Nano, result.LastUpdateTime); err == nil {
+				lastUpdated = table.HumanDuration(lastUpdateTime)
+			}
+			resultsTable.AddRow(result.Repository, result.Tag, table.ShortDigest(result.Digest, r.noTrunc), lastUpdated, r.outcomeName(result.outcome), reason)
+		}
+	}
+	return resultsTable.Write(out)
+}
+
+// outcomeName is the name of outcome in the table output, the JSON log lines and the format, a deletion is named
+// would delete in a dry run.
+func (r *purgeResults) outcomeName(o outcome) string {
+	if r.dryRun && o == outcomeDeleted {
+		return "would delete"
+	}
+	return outcomeNames[o]
+}
+
+// deletionOutcome classifies the error returned by a tag or manifest deletion.
+func deletionOutcome(err error) outcome {
+	if err == nil {
+		return outcomeDeleted
+	}
+	if registryError, ok := err.(*api.RegistryError); ok && registryError.StatusCode == http.StatusNotFound {
+		return outcomeNotFound
+	}
+	return outcomeFailed
+}
+
+// isTagLocked reports whether the changeable attributes of a tag prevent deleting it.
+func isTagLocked(attributes *acrapi.TagAttributesBaseChangeableAttributes) bool {
+	if attributes == nil {
+		return false
+	}
+	return isDisabled(attributes.DeleteEnabled) || isDisabled(attributes.WriteEnabled)
+}
+
+// isManifestLocked reports whether the changeable attributes of a manifest prevent deleting it.
+func isManifestLocked(attributes *acrapi.ManifestAttributesBaseChangeableAttributes) bool {
+	if attributes == nil {
+		return false
+	}
+	return isDisabled(attributes.DeleteEnabled) || isDisabled(attributes.WriteEnabled)
+}
+
+func isDisabled(enabled *bool) bool {
+	return enabled != nil && !*enabled
+}
+
+// isUnauthorized reports whether err was caused by the registry rejecting the credentials.
+func isUnauthorized(err error) bool {
+	registryError, ok := errors.Cause(err).(*api.RegistryError)
+	return ok && registryError.IsUnauthorized()
+}
+
+// isDeletionCapReached reports whether err was returned instead of a deletion because of --max-delete.
+func isDeletionCapReached(err error) bool {
+	_, ok := errors.Cause(err).(*deletionCapError)
+	return ok
+}
+
+// stopsRun reports whether err must stop the run instead of only failing an item, because every other deletion
+// would fail the same way.
+func stopsRun(err error) bool {
+	return isUnauthorized(err) || isDeletionCapReached(err)
+}
+
+// isDocumentOutput reports whether output is a single JSON or YAML document, nothing else can be written to out.
+func isDocumentOutput(output string) bool {
+	return output == outputJSON || output == outputYAML
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
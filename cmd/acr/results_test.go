@@ -0,0 +1,203 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+func TestPurgeResults(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "deleted")
+	registry.addManifest("repo", testDigest(2), old, "locked")
+	registry.addManifest("repo", testDigest(3), old, "gone")
+	registry.addManifest("repo", testDigest(4), old, "failing")
+	registry.addManifest("repo", testDigest(5), old)
+	registry.addManifest("repo", testDigest(6), old)
+	registry.lock("repo", "locked")
+	registry.lock("repo", testDigest(6))
+	registry.failOn("AcrDeleteTag repo gone", &api.RegistryError{StatusCode: http.StatusNotFound, Code: "TAG_UNKNOWN"})
+	registry.failOn("AcrDeleteTag repo failing", &api.RegistryError{StatusCode: http.StatusInternalServerError})
+
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputJSON)
+	deletedTags, deletedManifests, err := purgeRepository(context.Background(), registry, results, nil, purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d"})
+	if code := exitCode(err); code != exitCodePartialFailure {
+		t.Fatalf("exit code incorrect, got %d (%v), expected %d", code, err, exitCodePartialFailure)
+	}
+	// The manifest of the deleted tag is dangling once the tag is deleted.
+	if deletedTags != 1 || deletedManifests != 2 {
+		t.Fatalf("deleted %d tags and %d manifests, expected 1 and 2", deletedTags, deletedManifests)
+	}
+
+	var out bytes.Buffer
+	if err := results.writeSummary(&out, true); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var report purgeReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("invalid JSON report %q: %v", out.String(), err)
+	}
+	references := func(results []purgeResult) string {
+		var references []string
+		for _, result := range results {
+			references = append(references, result.Tag+result.Digest)
+		}
+		sort.Strings(references)
+		return strings.Join(references, ",")
+	}
+	expected := []struct {
+		name     string
+		results  []purgeResult
+		expected string
+	}{
+		{"deleted", report.Deleted, strings.Join([]string{"deleted", testDigest(1), testDigest(5)}, ",")},
+		{"locked", report.Locked, strings.Join([]string{"locked", testDigest(6)}, ",")},
+		{"not found", report.NotFound, "gone"},
+		{"failed", report.Failed, "failing"},
+	}
+	for _, test := range expected {
+		if got := references(test.results); got != test.expected {
+			t.Fatalf("%s incorrect, got %s, expected %s", test.name, got, test.expected)
+		}
+	}
+	// The JSON report keeps the time returned by the registry.
+	if _, err := time.Parse(time.RFC3339Nano, report.Failed[0].LastUpdateTime); err != nil {
+		t.Fatalf("the JSON report should include the last update time, got %q", report.Failed[0].LastUpdateTime)
+	}
+	if len(report.Failed[0].Reason) == 0 {
+		t.Fatalf("the failed deletion should have a reason")
+	}
+
+	results.output = outputText
+	out.Reset()
+	if err := results.writeSummary(&out, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	summary := out.String()
+	if strings.Contains(summary, "locked") || !strings.Contains(summary, "registry.azurecr.io/repo:gone") ||
+		!strings.Contains(summary, "registry.azurecr.io/repo:failing: unexpected response code: 500") {
+		t.Fatalf("text summary incorrect, got %q", summary)
+	}
+}
+
+func TestPurgeResultsTable(t *testing.T) {
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputTable)
+	lastUpdateTime := time.Now().Add(-73 * time.Hour).UTC().Format(time.RFC3339Nano)
+	results.record(purgeResult{Repository: "repo", Tag: "v1", LastUpdateTime: lastUpdateTime}, nil)
+	results.record(purgeResult{Repository: "repo", Digest: testDigest(1)}, nil)
+	results.recordLocked(purgeResult{Repository: "repo", Tag: "v2"})
+	results.record(purgeResult{Repository: "repository", Tag: "v3"}, &api.RegistryError{StatusCode: http.StatusInternalServerError})
+	var out bytes.Buffer
+	if err := results.writeSummary(&out, true); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := "" +
+		"REPOSITORY   TAG   DIGEST                LAST UPDATED   RESULT    REASON\n" +
+		"repo         v1    -                     3 days ago     deleted   -\n" +
+		"repo         -     " + testDigest(1)[:19] + "   -              deleted   -\n" +
+		"repo         v2    -                     -              locked    -\n" +
+		"repository   v3    -                     -              failed    unexpected response code: 500\n"
+	if out.String() != expected {
+		t.Fatalf("table incorrect, got\n%s\nexpected\n%s", out.String(), expected)
+	}
+
+	results.noTrunc = true
+	out.Reset()
+	if err := results.writeSummary(&out, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !strings.Contains(out.String(), testDigest(1)+" ") {
+		t.Fatalf("the digests shouldn't be truncated, got\n%s", out.String())
+	}
+}
+
+func TestPurgeResultsJSONLogs(t *testing.T) {
+	var out bytes.Buffer
+	results := newPurgeResults(&out, "registry.azurecr.io", outputText)
+	results.logFormat = logFormatJSON
+	results.record(purgeResult{Repository: "repo", Tag: "v1", Digest: testDigest(1)}, nil)
+	results.record(purgeResult{Repository: "repo", Digest: testDigest(2)}, &api.RegistryError{StatusCode: http.StatusInternalServerError})
+	results.recordLocked(purgeResult{Repository: "repo", Tag: "locked"})
+	if err := results.writeSummary(&out, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a line for every result and no summary, got %q", out.String())
+	}
+	expected := []logLine{
+		{Level: "info", Operation: "untag", Repository: "repo", Tag: "v1", Digest: testDigest(1), Outcome: "deleted"},
+		{Level: "error", Operation: "delete-manifest", Repository: "repo", Digest: testDigest(2), Outcome: "failed", Reason: "unexpected response code: 500"},
+		{Level: "warning", Operation: "untag", Repository: "repo", Tag: "locked", Outcome: "locked", Reason: "deleting is disabled by the delete-enabled or write-enabled attributes"},
+	}
+	for i, line := range lines {
+		var decoded logLine
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Fatalf("invalid JSON log line %q: %v", line, err)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, decoded.Time); err != nil {
+			t.Fatalf("invalid time in %q: %v", line, err)
+		}
+		decoded.Time = ""
+		if decoded != expected[i] {
+			t.Fatalf("log line %d incorrect, got %+v, expected %+v", i, decoded, expected[i])
+		}
+	}
+
+	out.Reset()
+	results.quiet = true
+	results.record(purgeResult{Repository: "repo", Tag: "v2"}, nil)
+	if out.Len() != 0 {
+		t.Fatalf("quiet shouldn't log the deleted items, got %q", out.String())
+	}
+}
+
+func TestPurgeReportRemaining(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.pageSize = 2
+	now := time.Now()
+	registry.addManifest("repo", testDigest(1), now.Add(-72*time.Hour), "old1", "old2")
+	registry.addManifest("repo", testDigest(2), now, "new1", "new2", "new3")
+	registry.addManifest("repo", testDigest(3), now.Add(-72*time.Hour))
+
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputJSON)
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", reportRemaining: true}
+	if _, _, err := purgeRepository(context.Background(), registry, results, nil, parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var out bytes.Buffer
+	if err := results.writeSummary(&out, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var report purgeReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("invalid JSON report %q: %v", out.String(), err)
+	}
+	expected := []remainingItems{{Repository: "repo", Tags: []string{"new1", "new2", "new3"}, Manifests: []string{testDigest(2)}}}
+	if !reflect.DeepEqual(report.Remaining, expected) {
+		t.Fatalf("remaining items incorrect, got %+v, expected %+v", report.Remaining, expected)
+	}
+
+	results = newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputJSON)
+	parameters.reportRemaining = false
+	if _, _, err := purgeRepository(context.Background(), registry, results, nil, parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if out.Reset(); results.writeSummary(&out, false) != nil || strings.Contains(out.String(), "remaining") {
+		t.Fatalf("the remaining items should only be reported when requested, got %s", out.String())
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"regexp"
+	"sort"
+	"time"
+)
+
+// tagCandidate is a tag that matched the purge filter and may be deleted depending on the retention rules.
+type tagCandidate struct {
+	name           string
+	lastUpdateTime time.Time
+}
+
+// compileGroupRegex compiles the --group-regex value, which needs a capture group to define the group of a tag.
+func compileGroupRegex(groupRegex string) (*regexp.Regexp, error) {
+	groupPattern, err := compileFlagRegex("--group-regex", groupRegex)
+	if err != nil {
+		return nil, err
+	}
+	if groupPattern.NumSubexp() == 0 {
+		return nil, newInvalidArgumentsError("--group-regex %q must contain a capture group", groupRegex)
+	}
+	return groupPattern, nil
+}
+
+// selectGroupedTags groups the candidates by the first capture group of groupPattern and returns the names of the
+// tags that are older than timeToCompare and aren't among the keep newest of their group. Tags the pattern doesn't
+// match are grouped together.
+func selectGroupedTags(candidates []tagCandidate, groupPattern *regexp.Regexp, keep int, timeToCompare time.Time) []string {
+	groups := map[string][]tagCandidate{}
+	var keys []string
+	for _, candidate := range candidates {
+		key := ""
+		if match := groupPattern.FindStringSubmatch(candidate.name); match != nil {
+			key = match[1]
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], candidate)
+	}
+	var tagsToDelete []string
+	for _, key := range keys {
+		group := groups[key]
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].lastUpdateTime.After(group[j].lastUpdateTime)
+		})
+		if len(group) <= keep {
+			continue
+		}
+		for _, candidate := range group[keep:] {
+			if candidate.lastUpdateTime.Before(timeToCompare) {
+				tagsToDelete = append(tagsToDelete, candidate.name)
+			}
+		}
+	}
+	return tagsToDelete
+}
+
+// selectOlderTags returns the names of the candidates that are older than timeToCompare.
+func selectOlderTags(candidates []tagCandidate, timeToCompare time.Time) []string {
+	var tagsToDelete []string
+	for _, candidate := range candidates {
+		if candidate.lastUpdateTime.Before(timeToCompare) {
+			tagsToDelete = append(tagsToDelete, candidate.name)
+		}
+	}
+	return tagsToDelete
+}
+
+// selectTagsOverLimit returns the names of the candidates that aren't among the maxTags newest ones.
+func selectTagsOverLimit(candidates []tagCandidate, maxTags int) []string {
+	if len(candidates) <= maxTags {
+		return nil
+	}
+	sorted := append([]tagCandidate(nil), candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].lastUpdateTime.After(sorted[j].lastUpdateTime)
+	})
+	var tagsToDelete []string
+	for _, candidate := range sorted[maxTags:] {
+		tagsToDelete = append(tagsToDelete, candidate.name)
+	}
+	return tagsToDelete
+}
+
+// intersectTags returns the names in first that are also in second.
+func intersectTags(first []string, second []string) []string {
+	inSecond := make(map[string]bool, len(second))
+	for _, name := range second {
+		inSecond[name] = true
+	}
+	var intersection []string
+	for _, name := range first {
+		if inSecond[name] {
+			intersection = append(intersection, name)
+		}
+	}
+	return intersection
+}
+
+// unionTags returns the names in first followed by the ones in second that aren't in first.
+func unionTags(first []string, second []string) []string {
+	seen := make(map[string]bool, len(first))
+	for _, name := range first {
+		seen[name] = true
+	}
+	union := first
+	for _, name := range second {
+		if !seen[name] {
+			seen[name] = true
+			union = append(union, name)
+		}
+	}
+	return union
+}
@@ -0,0 +1,114 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSelectGroupedTags(t *testing.T) {
+	now := time.Now().UTC()
+	day := 24 * time.Hour
+	candidates := []tagCandidate{
+		{"main-1", now.Add(-10 * day)},
+		{"main-2", now.Add(-9 * day)},
+		{"main-3", now.Add(-8 * day)},
+		{"main-4", now.Add(-1 * day)},
+		{"dev-1", now.Add(-30 * day)},
+		{"dev-2", now.Add(-20 * day)},
+		{"release", now.Add(-40 * day)},
+		{"hotfix", now.Add(-50 * day)},
+		{"feature-1", now.Add(-60 * day)},
+	}
+	groupPattern, err := compileGroupRegex("^(.*)-[0-9]+$")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	tagsToDelete := selectGroupedTags(candidates, groupPattern, 2, now.Add(-5*day))
+	sort.Strings(tagsToDelete)
+	// main keeps main-4 and main-3, dev and feature have no more than 2 tags and the ungrouped tags form their own group.
+	expected := []string{"main-1", "main-2"}
+	if !reflect.DeepEqual(tagsToDelete, expected) {
+		t.Fatalf("selectGroupedTags incorrect, got %v, expected %v", tagsToDelete, expected)
+	}
+
+	tagsToDelete = selectGroupedTags(candidates, groupPattern, 1, now.Add(-5*day))
+	sort.Strings(tagsToDelete)
+	expected = []string{"dev-1", "hotfix", "main-1", "main-2", "main-3"}
+	if !reflect.DeepEqual(tagsToDelete, expected) {
+		t.Fatalf("selectGroupedTags incorrect, got %v, expected %v", tagsToDelete, expected)
+	}
+
+	// The newest tags beyond the kept ones survive when they aren't older than ago.
+	tagsToDelete = selectGroupedTags(candidates, groupPattern, 1, now.Add(-9*day-time.Hour))
+	sort.Strings(tagsToDelete)
+	expected = []string{"dev-1", "hotfix", "main-1"}
+	if !reflect.DeepEqual(tagsToDelete, expected) {
+		t.Fatalf("selectGroupedTags incorrect, got %v, expected %v", tagsToDelete, expected)
+	}
+}
+
+func TestCompileGroupRegex(t *testing.T) {
+	for _, groupRegex := range []string{"", "^main-[0-9]+$", "(main"} {
+		if _, err := compileGroupRegex(groupRegex); exitCode(err) != exitCodeInvalidArguments {
+			t.Fatalf("compileGroupRegex(%q) should fail with invalid arguments, got %v", groupRegex, err)
+		}
+	}
+}
+
+func TestPurgeTagsKeepPerGroup(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.pageSize = 2
+	now := time.Now()
+	for i, tag := range []string{"a-1", "a-2", "a-3", "b-1", "b-2", "c-1"} {
+		registry.addManifest("repo", testDigest(i), now.Add(-time.Duration(100-i)*time.Hour), tag)
+	}
+	deleted, err := PurgeTags(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", tagPurgeOptions{ago: "1d", keepPerGroup: 1, groupRegex: "^([a-z]+)-"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sort.Strings(registry.deletedTags["repo"])
+	expected := []string{"a-1", "a-2", "b-1"}
+	if deleted != len(expected) || !reflect.DeepEqual(registry.deletedTags["repo"], expected) {
+		t.Fatalf("PurgeTags incorrect, deleted %d %v, expected %v", deleted, registry.deletedTags["repo"], expected)
+	}
+}
+
+func TestPurgeTagsMaxTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		ago      string
+		filter   string
+		maxTags  int
+		expected []string
+	}{
+		// The tags are 1 to 6 hours old, so ago alone doesn't delete any of them.
+		{"below threshold", "1d", "", 6, nil},
+		{"above threshold", "1d", "", 4, []string{"v1", "v2"}},
+		{"ago still applies", "3h30m", "", 5, []string{"v1", "v2", "v3"}},
+		{"only filtered tags count", "1d", "^v[1-3]$", 1, []string{"v1", "v2"}},
+	}
+	for _, test := range tests {
+		registry := newFakeRegistry()
+		registry.pageSize = 2
+		now := time.Now()
+		for i := 1; i <= 6; i++ {
+			registry.addManifest("repo", testDigest(i), now.Add(-time.Duration(7-i)*time.Hour), fmt.Sprintf("v%d", i))
+		}
+		deleted, err := PurgeTags(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", tagPurgeOptions{ago: test.ago, filter: test.filter, maxTags: test.maxTags})
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", test.name, err)
+		}
+		sort.Strings(registry.deletedTags["repo"])
+		if deleted != len(test.expected) || !reflect.DeepEqual(registry.deletedTags["repo"], test.expected) {
+			t.Fatalf("%s: deleted %d %v, expected %v", test.name, deleted, registry.deletedTags["repo"], test.expected)
+		}
+	}
+}
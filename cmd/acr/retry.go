@@ -0,0 +1,76 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/pkg/errors"
+)
+
+// defaultListRetryDelay is the time waited before the first retry of a failed listing page, it doubles at every retry.
+const defaultListRetryDelay = time.Second
+
+// listRetryClient retries the listing pages that failed through the wrapped client, only the failed page is requested
+// again so the items of the previous pages aren't listed twice. The rejected credentials and the repositories that
+// don't exist aren't retried since another attempt would fail the same way.
+type listRetryClient struct {
+	api.AcrCLIClientInterface
+	retries int
+	delay   time.Duration
+}
+
+func newListRetryClient(acrClient api.AcrCLIClientInterface, retries int) *listRetryClient {
+	return &listRetryClient{AcrCLIClientInterface: acrClient, retries: retries, delay: defaultListRetryDelay}
+}
+
+// retry calls list until it succeeds, returns an error that isn't retried or was retried c.retries times.
+func (c *listRetryClient) retry(ctx context.Context, list func() error) error {
+	delay := c.delay
+	for attempt := 0; ; attempt++ {
+		err := list()
+		if err == nil || attempt == c.retries || !isRetriedListingError(err) {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// isRetriedListingError reports whether another attempt of a listing that failed with err could succeed.
+func isRetriedListingError(err error) bool {
+	if isUnauthorized(err) {
+		return false
+	}
+	registryError, ok := errors.Cause(err).(*api.RegistryError)
+	return !ok || registryError.StatusCode != http.StatusNotFound
+}
+
+func (c *listRetryClient) AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error) {
+	var tags *acrapi.TagAttributeList
+	err := c.retry(ctx, func() error {
+		var err error
+		tags, err = c.AcrCLIClientInterface.AcrListTags(ctx, repoName, orderBy, last)
+		return err
+	})
+	return tags, err
+}
+
+func (c *listRetryClient) AcrListManifests(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.ManifestAttributeList, error) {
+	var manifests *acrapi.ManifestAttributeList
+	err := c.retry(ctx, func() error {
+		var err error
+		manifests, err = c.AcrCLIClientInterface.AcrListManifests(ctx, repoName, orderBy, last)
+		return err
+	})
+	return manifests, err
+}
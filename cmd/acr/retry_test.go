@@ -0,0 +1,104 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/pkg/errors"
+)
+
+// secondPageFailingRegistry fails the listing of every tag page but the first one, failures times.
+type secondPageFailingRegistry struct {
+	*fakeRegistry
+	failures int
+	calls    int
+}
+
+func (r *secondPageFailingRegistry) AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error) {
+	r.calls++
+	if len(last) > 0 && r.failures > 0 {
+		r.failures--
+		return nil, &api.RegistryError{StatusCode: http.StatusServiceUnavailable, Code: "UNAVAILABLE", Message: "service unavailable"}
+	}
+	return r.fakeRegistry.AcrListTags(ctx, repoName, orderBy, last)
+}
+
+func newSecondPageFailingRegistry(failures int) *secondPageFailingRegistry {
+	registry := newFakeRegistry()
+	registry.pageSize = 2
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "v1", "v2", "v3", "v4")
+	return &secondPageFailingRegistry{fakeRegistry: registry, failures: failures}
+}
+
+func TestPurgeTagsListingError(t *testing.T) {
+	registry := newSecondPageFailingRegistry(1)
+	results := newPurgeResults(nil, "registry.azurecr.io", outputJSON)
+	deleted, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d"})
+	if deleted != 2 {
+		t.Fatalf("expected the 2 tags of the first page to be deleted, got %d", deleted)
+	}
+	if exitCode(err) != exitCodePartialFailure {
+		t.Fatalf("expected a partial failure, got %v", err)
+	}
+	if _, ok := errors.Cause(err).(*api.RegistryError); !ok {
+		t.Fatalf("expected the listing error as the cause, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "completed 2 items before a listing error: ") {
+		t.Fatalf("unexpected error message %q", err.Error())
+	}
+	tags := registry.deletedTags["repo"]
+	sort.Strings(tags)
+	if strings.Join(tags, ",") != "v1,v2" {
+		t.Fatalf("expected v1 and v2 to be deleted, got %v", tags)
+	}
+	if report := results.report(false); len(report.Deleted) != 2 {
+		t.Fatalf("expected the 2 deleted tags in the results, got %+v", report)
+	}
+
+	// Nothing is deleted when the selection needs every page.
+	registry = newSecondPageFailingRegistry(1)
+	results = newPurgeResults(nil, "registry.azurecr.io", outputJSON)
+	deleted, err = PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d", maxTags: 1})
+	if deleted != 0 || err == nil || exitCode(err) == exitCodePartialFailure {
+		t.Fatalf("expected the listing error without deletions, got %d deletions and %v", deleted, err)
+	}
+}
+
+func TestPurgeListingRetry(t *testing.T) {
+	registry := newSecondPageFailingRegistry(2)
+	client := newListRetryClient(registry, 2)
+	client.delay = 0
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", output: outputText, logFormat: logFormatText}
+	if err := purge(context.Background(), client, nil, newPurgeResults(nil, "registry.azurecr.io", outputJSON), parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(registry.deletedTags["repo"]) != 4 {
+		t.Fatalf("expected every tag to be deleted, got %v", registry.deletedTags["repo"])
+	}
+	// The first page, the second one three times and the empty last page.
+	if registry.calls != 5 {
+		t.Fatalf("expected only the failed page to be listed again, got %d listings", registry.calls)
+	}
+
+	// A repository that doesn't exist isn't retried.
+	notFound := newFakeRegistry()
+	notFound.failOn("AcrListTags repo", &api.RegistryError{StatusCode: http.StatusNotFound, Code: "NAME_UNKNOWN"})
+	client = newListRetryClient(notFound, 2)
+	client.delay = 0
+	if _, err := client.AcrListTags(context.Background(), "repo", "", ""); err == nil {
+		t.Fatalf("expected the not found error")
+	}
+	if len(notFound.listedTags) != 1 {
+		t.Fatalf("expected a single listing, got %d", len(notFound.listedTags))
+	}
+}
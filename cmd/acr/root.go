@@ -4,27 +4,166 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/AzureCR/acr-cli/version"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	// registryTypeACR uses the ACR API, with the attributes and listings the Distribution API doesn't have.
+	registryTypeACR = "acr"
+	// registryTypeGeneric only uses the Distribution API, for registries like Harbor or GHCR.
+	registryTypeGeneric = "generic"
 )
 
+// rootParameters are the global flags shared by the commands that talk to a registry.
+type rootParameters struct {
+	registryName string
+	username     string
+	password     string
+	insecure     bool
+	caCertFile   string
+	proxy        string
+	userAgent    string
+	aadToken     string
+	aadTenant    string
+	registryType string
+}
+
 func newRootCmd(args []string) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "acr",
 		Short: "The Azure Container Registry CLI",
 		Long: `Welcome to the Azure Container Registry CLI!
 
-To start working with the CLI, run acr --help`,
+To start working with the CLI, run acr --help
+` + exitCodesMessage,
 		SilenceUsage: true,
+		// A version is needed for cobra to add the --version flag, the template prints the full build information.
+		Version:                "dev",
+		BashCompletionFunction: bashCompletionFunctions,
 	}
+	cmd.SetVersionTemplate(versionMessage() + "\n")
+	cmd.SetFlagErrorFunc(func(c *cobra.Command, err error) error {
+		return &invalidArgumentsError{err: err}
+	})
 
+	var rootParams rootParameters
 	flags := cmd.PersistentFlags()
+	rootParams.addFlags(flags)
 	out := cmd.OutOrStdout()
 
 	cmd.AddCommand(
-		newPurgeCmd(out),
+		newPurgeCmd(out, &rootParams),
+		newDeleteManifestCmd(out, &rootParams),
+		newCopyCmd(out, &rootParams),
+		newStatsCmd(out, &rootParams),
 		newVersionCmd(out),
+		newCompletionCmd(out),
+		newCompleteRepositoriesCmd(out, &rootParams),
 	)
 
 	_ = flags.Parse(args)
 	return cmd
 }
+
+// addFlags adds the global flags to flags.
+func (p *rootParameters) addFlags(flags *pflag.FlagSet) {
+	flags.StringVarP(&p.registryName, "registry", "r", "", "Registry name, required by the commands that talk to a registry")
+	flags.StringVarP(&p.username, "username", "u", "", "Registry username")
+	flags.StringVarP(&p.password, "password", "p", "", "Registry password")
+	flags.BoolVar(&p.insecure, "insecure", false, "Skip the verification of the registry TLS certificate, only use it with test registries")
+	flags.StringVar(&p.caCertFile, "ca-cert", "", "A PEM file with the certificate authorities to trust in addition to the system ones")
+	flags.StringVar(&p.proxy, "proxy", "", "The http, https or socks5 proxy URL for the registry requests, overrides the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables")
+	flags.StringVar(&p.userAgent, "user-agent", defaultUserAgent(), "The User-Agent header sent to the registry")
+	flags.StringVar(&p.aadToken, "aad-token", "", "An AAD access token exchanged for registry tokens scoped to each repository, replaces --username and --password")
+	flags.StringVar(&p.aadTenant, "aad-tenant", "", "The tenant that issued --aad-token, when it isn't the tenant of the registry")
+	flags.StringVar(&p.registryType, "registry-type", registryTypeACR, "The API of the registry, acr or generic. A generic registry, like Harbor or GHCR, is only used through the Distribution API and the features that need the ACR API are disabled")
+}
+
+// isGeneric reports whether the registry is only used through the Distribution API.
+func (p *rootParameters) isGeneric() bool {
+	return p.registryType == registryTypeGeneric
+}
+
+// loginURL returns the FQDN of the registry given with --registry, which is only required by the commands that talk
+// to a registry so it can't be marked required on the root command.
+func (p *rootParameters) loginURL() (string, error) {
+	if len(p.registryName) == 0 {
+		return "", newInvalidArgumentsError(`required flag(s) "registry" not set`)
+	}
+	return api.LoginURL(p.registryName), nil
+}
+
+// newAcrClient creates the registry client of loginURL configured by the global flags, it authenticates with the AAD
+// token when one is given and with the username and password otherwise. Warnings are written to errOut.
+func (p *rootParameters) newAcrClient(loginURL string, errOut io.Writer) (api.AcrCLIClientInterface, error) {
+	if p.registryType != registryTypeACR && p.registryType != registryTypeGeneric {
+		return nil, newInvalidArgumentsError("--registry-type must be %s or %s", registryTypeACR, registryTypeGeneric)
+	}
+	if p.isGeneric() && len(p.aadToken) > 0 {
+		return nil, newInvalidArgumentsError("--aad-token can't be used with --registry-type %s", registryTypeGeneric)
+	}
+	if len(p.aadToken) == 0 && (len(p.username) == 0 || len(p.password) == 0) {
+		return nil, newInvalidArgumentsError("--username and --password are required unless --aad-token is given")
+	}
+	if p.insecure {
+		fmt.Fprintln(errOut, "WARNING: --insecure disables the verification of the registry certificate, the credentials can be intercepted")
+	}
+	httpClient, err := p.newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	if p.isGeneric() {
+		return api.NewGenericClient(loginURL, api.BasicAuth(p.username, p.password), httpClient), nil
+	}
+	if len(p.aadToken) > 0 {
+		acrClient := api.NewAcrCLIClient(loginURL, "", httpClient)
+		acrClient.SetTokenCredential(api.NewTokenCredential(loginURL, p.aadTenant, p.aadToken, httpClient))
+		return acrClient, nil
+	}
+	return api.NewAcrCLIClient(loginURL, api.BasicAuth(p.username, p.password), httpClient), nil
+}
+
+// newHTTPClient creates the HTTP client configured by the --insecure, --ca-cert, --proxy and --user-agent global flags.
+func (p *rootParameters) newHTTPClient() (*http.Client, error) {
+	httpClient, err := api.NewHTTPClient(api.TransportOptions{Insecure: p.insecure, CACertFile: p.caCertFile, Proxy: p.proxy, UserAgent: p.userAgent})
+	if err != nil {
+		return nil, &invalidArgumentsError{err: err}
+	}
+	return httpClient, nil
+}
+
+// signalContext returns a context canceled when the process is interrupted or terminated, so a command stops sending
+// requests and still reports what it did. The signals are only caught once, a second one kills the process.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// defaultUserAgent identifies the CLI and its version in the registry logs.
+func defaultUserAgent() string {
+	if len(version.Version) == 0 {
+		return "acr-cli/dev"
+	}
+	return "acr-cli/" + version.Version
+}
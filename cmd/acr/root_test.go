@@ -0,0 +1,107 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRootCommands(t *testing.T) {
+	var names []string
+	for _, cmd := range newRootCmd(nil).Commands() {
+		names = append(names, cmd.Name())
+	}
+	sort.Strings(names)
+	expected := []string{completeRepositoriesCmd, "completion", "cp", "delete-manifest", "purge", "stats", "version"}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("subcommands incorrect, got %v, expected %v", names, expected)
+	}
+}
+
+func TestSignalContext(t *testing.T) {
+	ctx, cancel := signalContext()
+	defer cancel()
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := process.Signal(os.Interrupt); err != nil {
+		t.Skipf("unable to interrupt the test process: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the context should be canceled by the interrupt")
+	}
+}
+
+func TestCommandsHelp(t *testing.T) {
+	root := newRootCmd(nil)
+	for _, cmd := range root.Commands() {
+		if cmd.Hidden || cmd.Name() == "help" {
+			continue
+		}
+		if len(cmd.Long) == 0 || len(cmd.Example) == 0 {
+			t.Fatalf("%s should have a long description and examples", cmd.Name())
+		}
+	}
+
+	requiredFlags := map[string][]string{
+		"purge":           {"--registry", "--username", "--password", "--repository", "--ago"},
+		"delete-manifest": {"--registry", "--username", "--password", "--repository", "--digest"},
+		"stats":           {"--registry", "--username", "--password", "--repository"},
+		"cp":              {"--registry", "--username", "--password", "--source", "--dest", "--concurrency"},
+	}
+	for name, flags := range requiredFlags {
+		var out bytes.Buffer
+		root := newRootCmd(nil)
+		root.SetArgs([]string{name, "--help"})
+		root.SetOutput(&out)
+		if err := root.Execute(); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		for _, flag := range flags {
+			if !strings.Contains(out.String(), flag) {
+				t.Fatalf("the help of %s should list %s, got %s", name, flag, out.String())
+			}
+		}
+	}
+}
+
+func TestSharedRegistryFlags(t *testing.T) {
+	for _, name := range []string{"purge", "delete-manifest", "stats", "cp"} {
+		root := newRootCmd(nil)
+		cmd, _, err := root.Find([]string{name})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if err := cmd.ParseFlags([]string{"-r", "myregistry", "-u", "user", "-p", "secret"}); err != nil {
+			t.Fatalf("%s: unexpected error %v", name, err)
+		}
+		for flag, expected := range map[string]string{"registry": "myregistry", "username": "user", "password": "secret"} {
+			if cmd.Flags().Lookup(flag) != root.PersistentFlags().Lookup(flag) {
+				t.Fatalf("%s should use the --%s flag of the root command", name, flag)
+			}
+			if value := cmd.Flags().Lookup(flag).Value.String(); value != expected {
+				t.Fatalf("%s: --%s incorrect, got %q, expected %q", name, flag, value, expected)
+			}
+		}
+	}
+
+	var rootParams rootParameters
+	if _, err := rootParams.loginURL(); exitCode(err) != exitCodeInvalidArguments {
+		t.Fatalf("a missing --registry should be an invalid argument, got %v", err)
+	}
+	rootParams.registryName = "myregistry"
+	if loginURL, err := rootParams.loginURL(); err != nil || loginURL != "myregistry.azurecr.io" {
+		t.Fatalf("login URL incorrect, got %q and %v", loginURL, err)
+	}
+}
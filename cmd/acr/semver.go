@@ -0,0 +1,191 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// semverPattern is the syntax of a semantic version 2.0.0, optionally prefixed with v like many image tags are.
+var semverPattern = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// semver is a parsed semantic version, the build metadata is dropped since it doesn't change the precedence.
+type semver struct {
+	major, minor, patch uint64
+	prerelease          []string
+}
+
+// parseSemver parses a tag like 1.4.2, v2.0.0-rc.1 or 1.0.0+build.5, it returns false when the tag isn't a semantic
+// version.
+func parseSemver(tag string) (semver, bool) {
+	match := semverPattern.FindStringSubmatch(tag)
+	if match == nil {
+		return semver{}, false
+	}
+	var version semver
+	var err error
+	if version.major, err = strconv.ParseUint(match[1], 10, 64); err != nil {
+		return semver{}, false
+	}
+	if version.minor, err = strconv.ParseUint(match[2], 10, 64); err != nil {
+		return semver{}, false
+	}
+	if version.patch, err = strconv.ParseUint(match[3], 10, 64); err != nil {
+		return semver{}, false
+	}
+	if len(match[4]) > 0 {
+		version.prerelease = strings.Split(match[4], ".")
+	}
+	return version, true
+}
+
+// compareSemver returns -1, 0 or 1 when a has a lower, the same or a higher precedence than b. A pre-release has a
+// lower precedence than its release, and its numeric identifiers are compared as numbers.
+func compareSemver(a semver, b semver) int {
+	for _, pair := range [][2]uint64{{a.major, b.major}, {a.minor, b.minor}, {a.patch, b.patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a.prerelease) == 0 && len(b.prerelease) == 0:
+		return 0
+	case len(a.prerelease) == 0:
+		return 1
+	case len(b.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.prerelease) && i < len(b.prerelease); i++ {
+		if c := comparePrereleaseIdentifier(a.prerelease[i], b.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a.prerelease) < len(b.prerelease):
+		return -1
+	case len(a.prerelease) > len(b.prerelease):
+		return 1
+	}
+	return 0
+}
+
+// comparePrereleaseIdentifier compares two dot separated pre-release identifiers, a numeric identifier has a lower
+// precedence than an alphanumeric one.
+func comparePrereleaseIdentifier(a string, b string) int {
+	aNumber, aErr := strconv.ParseUint(a, 10, 64)
+	bNumber, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		if aNumber == bNumber {
+			return 0
+		}
+		if aNumber < bNumber {
+			return -1
+		}
+		return 1
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// The levels of a --semver-keep rule, the versions are grouped by their major or by their major and minor.
+const (
+	semverLevelMajor = "major"
+	semverLevelMinor = "minor"
+)
+
+// semverKeepRule keeps the count highest versions of every group of the level.
+type semverKeepRule struct {
+	level string
+	count int
+}
+
+// semverRetention is the parsed --semver-keep retention. The tags that aren't semantic versions are kept unless
+// purgeOthers is set, in which case they're deleted when they're older than ago like without --semver-keep.
+type semverRetention struct {
+	rules       []semverKeepRule
+	purgeOthers bool
+}
+
+// parseSemverKeep parses a comma separated list of level:count rules like major:latest,minor:3, where latest is 1.
+func parseSemverKeep(spec string, purgeOthers bool) (*semverRetention, error) {
+	retention := &semverRetention{purgeOthers: purgeOthers}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		parts := strings.SplitN(field, ":", 2)
+		if len(parts) != 2 || (parts[0] != semverLevelMajor && parts[0] != semverLevelMinor) {
+			return nil, newInvalidArgumentsError("invalid --semver-keep rule %q, expected major:<count> or minor:<count> like major:latest,minor:3", field)
+		}
+		count := 1
+		if parts[1] != "latest" {
+			var err error
+			if count, err = strconv.Atoi(parts[1]); err != nil || count < 1 {
+				return nil, newInvalidArgumentsError("invalid --semver-keep rule %q, the count is latest or a positive number", field)
+			}
+		}
+		retention.rules = append(retention.rules, semverKeepRule{level: parts[0], count: count})
+	}
+	return retention, nil
+}
+
+// versionedCandidate is a tag candidate whose name is a semantic version.
+type versionedCandidate struct {
+	tagCandidate
+	version semver
+}
+
+// selectTags returns the names of the candidates older than timeToCompare that no rule keeps. Every rule keeps the
+// count highest versions of every major, or of every major and minor, whatever their age, and a tag kept by any rule
+// is kept. The candidates that aren't semantic versions are only returned with purgeOthers.
+func (r *semverRetention) selectTags(candidates []tagCandidate, timeToCompare time.Time) []string {
+	var versioned []versionedCandidate
+	var tagsToDelete []string
+	for _, candidate := range candidates {
+		version, ok := parseSemver(candidate.name)
+		if !ok {
+			if r.purgeOthers && candidate.lastUpdateTime.Before(timeToCompare) {
+				tagsToDelete = append(tagsToDelete, candidate.name)
+			}
+			continue
+		}
+		versioned = append(versioned, versionedCandidate{tagCandidate: candidate, version: version})
+	}
+	// The highest versions come first, so the first count tags of a group are the ones a rule keeps.
+	sort.SliceStable(versioned, func(i, j int) bool {
+		return compareSemver(versioned[i].version, versioned[j].version) > 0
+	})
+	kept := map[string]bool{}
+	for _, rule := range r.rules {
+		counts := map[string]int{}
+		for _, candidate := range versioned {
+			key := fmt.Sprint(candidate.version.major)
+			if rule.level == semverLevelMinor {
+				key += "." + fmt.Sprint(candidate.version.minor)
+			}
+			if counts[key] < rule.count {
+				counts[key]++
+				kept[candidate.name] = true
+			}
+		}
+	}
+	for _, candidate := range versioned {
+		if !kept[candidate.name] && candidate.lastUpdateTime.Before(timeToCompare) {
+			tagsToDelete = append(tagsToDelete, candidate.name)
+		}
+	}
+	return tagsToDelete
+}
@@ -0,0 +1,114 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareSemver(t *testing.T) {
+	// The precedence example of the semantic versioning specification, with a v prefix and build metadata.
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "v1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0+build.7", "1.0.1", "1.2.0", "1.10.0", "v2.0.0"}
+	for i := range ordered {
+		for j := range ordered {
+			a, ok := parseSemver(ordered[i])
+			if !ok {
+				t.Fatalf("%s should be a semantic version", ordered[i])
+			}
+			b, _ := parseSemver(ordered[j])
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			if c := compareSemver(a, b); c != expected {
+				t.Fatalf("compareSemver(%s, %s) = %d, expected %d", ordered[i], ordered[j], c, expected)
+			}
+		}
+	}
+	for _, tag := range []string{"latest", "1.2", "1.2.3.4", "01.2.3", "1.2.3-", "1.2.3-01", "V1.2.3", "release-1.2.3"} {
+		if _, ok := parseSemver(tag); ok {
+			t.Fatalf("%s shouldn't be a semantic version", tag)
+		}
+	}
+}
+
+func TestParseSemverKeep(t *testing.T) {
+	retention, err := parseSemverKeep("major:latest, minor:3", false)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []semverKeepRule{{level: semverLevelMajor, count: 1}, {level: semverLevelMinor, count: 3}}
+	if !reflect.DeepEqual(retention.rules, expected) {
+		t.Fatalf("parseSemverKeep incorrect, got %v", retention.rules)
+	}
+	for _, spec := range []string{"", "major", "patch:latest", "minor:0", "minor:-1", "major:newest", "major:1,"} {
+		if _, err := parseSemverKeep(spec, false); exitCode(err) != exitCodeInvalidArguments || !strings.Contains(err.Error(), "--semver-keep") {
+			t.Fatalf("%q: expected an invalid --semver-keep error, got %v", spec, err)
+		}
+	}
+}
+
+func TestPurgeTagsSemverKeep(t *testing.T) {
+	tags := []string{"1.0.0", "1.0.1", "1.1.0", "1.1.1", "1.1.2", "1.2.0-rc.1", "1.2.0-rc.2", "1.2.0",
+		"2.0.0-beta.1", "2.0.0", "2.0.1", "2.1.0", "3.0.0-rc.1", "latest", "main-42"}
+	tests := []struct {
+		spec         string
+		purgeOthers  bool
+		keepPerGroup int
+		kept         []string
+	}{
+		{"minor:latest", false, 0, []string{"1.0.1", "1.1.2", "1.2.0", "2.0.1", "2.1.0", "3.0.0-rc.1", "latest", "main-42"}},
+		{"major:latest", false, 0, []string{"1.2.0", "2.1.0", "3.0.0-rc.1", "latest", "main-42"}},
+		{"major:latest,minor:latest", false, 0, []string{"1.0.1", "1.1.2", "1.2.0", "2.0.1", "2.1.0", "3.0.0-rc.1", "latest", "main-42"}},
+		{"major:2", true, 0, []string{"1.2.0", "1.2.0-rc.2", "2.0.1", "2.1.0", "3.0.0-rc.1"}},
+		{"minor:2,major:latest", true, 0, []string{"1.0.0", "1.0.1", "1.1.1", "1.1.2", "1.2.0", "1.2.0-rc.2", "2.0.0", "2.0.1", "2.1.0", "3.0.0-rc.1"}},
+		// --keep-per-group keeps the 2 newest tags as well, which are the last ones pushed.
+		{"major:latest", true, 2, []string{"1.2.0", "2.1.0", "3.0.0-rc.1", "latest", "main-42"}},
+	}
+	for _, test := range tests {
+		registry := newFakeRegistry()
+		old := time.Now().Add(-72 * time.Hour)
+		for i, tag := range tags {
+			registry.addManifest("repo", testDigest(i), old.Add(time.Duration(i)*time.Minute), tag)
+		}
+		retention, err := parseSemverKeep(test.spec, test.purgeOthers)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		groupRegex := ""
+		if test.keepPerGroup > 0 {
+			groupRegex = policyGroupRegex
+		}
+		results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+		if _, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d", keepPerGroup: test.keepPerGroup, groupRegex: groupRegex, semverKeep: retention}); err != nil {
+			t.Fatalf("%s: unexpected error %v", test.spec, err)
+		}
+		kept := registry.store.Tags("repo")
+		expected := append([]string(nil), test.kept...)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(kept, expected) {
+			t.Fatalf("%s: expected %v to be kept, got %v", test.spec, expected, kept)
+		}
+	}
+
+	// The versions newer than ago aren't deleted even if no rule keeps them.
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now().Add(-72*time.Hour), "1.0.0")
+	registry.addManifest("repo", testDigest(2), time.Now(), "1.0.1")
+	registry.addManifest("repo", testDigest(3), time.Now(), "1.0.2")
+	retention, _ := parseSemverKeep("minor:latest", false)
+	deleted, err := PurgeTags(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), "repo", tagPurgeOptions{ago: "1d", semverKeep: retention})
+	if err != nil || deleted != 1 || !reflect.DeepEqual(registry.deletedTags["repo"], []string{"1.0.0"}) {
+		t.Fatalf("expected only 1.0.0 to be deleted, got %d deleted: %v, %v", deleted, registry.deletedTags["repo"], err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// purgeState is the content of a --state-file, it records when every repository was last purged successfully so
+// the following --since-last-run runs can skip the tags that run already evaluated.
+type purgeState struct {
+	Repositories map[string]repositoryState `json:"repositories"`
+}
+
+// repositoryState is the last successful run on a repository. The skipped tags depend on ago, filter and the
+// --max-age-skew that moved the cutoff of ago, so the state is only used by runs with the same values.
+type repositoryState struct {
+	LastRun time.Time `json:"lastRun"`
+	Ago     string    `json:"ago"`
+	Filter  string    `json:"filter,omitempty"`
+	AgeSkew string    `json:"ageSkew,omitempty"`
+}
+
+// loadPurgeState reads the state file at path, a missing file is an empty state so the first run is a full scan.
+func loadPurgeState(path string) (*purgeState, error) {
+	state := &purgeState{Repositories: map[string]repositoryState{}}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse the state file %s", path)
+	}
+	if state.Repositories == nil {
+		state.Repositories = map[string]repositoryState{}
+	}
+	return state, nil
+}
+
+// save replaces the state file at path, through a rename so an interrupted run never leaves a partial file.
+func (s *purgeState) save(path string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, append(content, '\n'))
+}
+
+// since returns the time before which the tags of repoName were already evaluated by the last successful run with
+// the same ago, filter and skew, it's zero when there's no such run. It's the cutoff of that run, so the tags it
+// kept because skew moved its cutoff back are evaluated again.
+func (s *purgeState) since(loginURL string, repoName string, ago string, filter string, skew time.Duration) time.Time {
+	repoState, ok := s.Repositories[stateKey(loginURL, repoName)]
+	if !ok || repoState.Ago != ago || repoState.Filter != filter || repoState.AgeSkew != formatStateSkew(skew) {
+		return time.Time{}
+	}
+	cutoff, err := cutoffTime(ago, repoState.LastRun.Add(-skew))
+	if err != nil {
+		return time.Time{}
+	}
+	return cutoff
+}
+
+// update records a successful run on repoName that started at lastRun.
+func (s *purgeState) update(loginURL string, repoName string, ago string, filter string, skew time.Duration, lastRun time.Time) {
+	s.Repositories[stateKey(loginURL, repoName)] = repositoryState{LastRun: lastRun.UTC(), Ago: ago, Filter: filter, AgeSkew: formatStateSkew(skew)}
+}
+
+// formatStateSkew is the skew recorded in the state, a zero skew isn't recorded.
+func formatStateSkew(skew time.Duration) string {
+	if skew == 0 {
+		return ""
+	}
+	return skew.String()
+}
+
+func stateKey(loginURL string, repoName string) string {
+	return loginURL + "/" + repoName
+}
@@ -0,0 +1,151 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPurgeState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	state, err := loadPurgeState(path)
+	if err != nil {
+		t.Fatalf("a missing state file should be an empty state, got %v", err)
+	}
+	lastRun := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	state.update("registry.azurecr.io", "repo", "1d", "^v", 0, lastRun)
+	if err := state.save(path); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	loaded, err := loadPurgeState(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(loaded, state) {
+		t.Fatalf("state incorrect after a save, got %+v, expected %+v", loaded, state)
+	}
+
+	tests := []struct {
+		repoName string
+		ago      string
+		filter   string
+		since    time.Time
+	}{
+		{"repo", "1d", "^v", lastRun.Add(-24 * time.Hour)},
+		{"other", "1d", "^v", time.Time{}},
+		{"repo", "2d", "^v", time.Time{}},
+		{"repo", "1d", "", time.Time{}},
+	}
+	for _, test := range tests {
+		if since := loaded.since("registry.azurecr.io", test.repoName, test.ago, test.filter, 0); !since.Equal(test.since) {
+			t.Fatalf("since(%s, %s, %s) incorrect, got %v, expected %v", test.repoName, test.ago, test.filter, since, test.since)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := loadPurgeState(path); err == nil {
+		t.Fatalf("a corrupted state file should be rejected")
+	}
+}
+
+func TestPurgeSinceLastRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	now := time.Now()
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), now.Add(-72*time.Hour), "evaluated")
+	registry.addManifest("repo", testDigest(2), now.Add(-26*time.Hour), "updated")
+	registry.addManifest("repo", testDigest(3), now.Add(-time.Hour), "recent")
+
+	// The previous run, 2 hours ago, already evaluated the tags last updated more than 26 hours ago.
+	state, _ := loadPurgeState(path)
+	state.update("registry.azurecr.io", "repo", "1d", "", 0, now.Add(-2*time.Hour))
+	if err := state.save(path); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", stateFile: path, sinceLastRun: true, output: outputText}
+	if err := runPurge(context.Background(), registry, ioutil.Discard, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(registry.deletedTags["repo"], []string{"updated"}) {
+		t.Fatalf("only the tags updated since the last run should be evaluated, deleted %v", registry.deletedTags["repo"])
+	}
+	state, err = loadPurgeState(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if lastRun := state.Repositories["registry.azurecr.io/repo"].LastRun; lastRun.Before(now) {
+		t.Fatalf("the state should record the new run, got %v", lastRun)
+	}
+
+	// Without a matching state every tag is evaluated.
+	parameters.filter = "^evaluated$"
+	if err := runPurge(context.Background(), registry, ioutil.Discard, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(registry.deletedTags["repo"], []string{"updated", "evaluated"}) {
+		t.Fatalf("a run with another filter should be a full scan, deleted %v", registry.deletedTags["repo"])
+	}
+}
+
+func TestPurgeSinceLastRunAgeSkew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	firstRun := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), firstRun.Add(-48*time.Hour), "old")
+	// Inside the skew band of the first run, too new for it to delete.
+	registry.addManifest("repo", testDigest(2), firstRun.Add(-24*time.Hour-30*time.Second), "band")
+
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "1d", stateFile: path, sinceLastRun: true, ageSkew: time.Minute, output: outputText}
+	restore := pinNow(firstRun)
+	err = runPurge(context.Background(), registry, ioutil.Discard, "registry.azurecr.io", parameters)
+	restore()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(registry.deletedTags["repo"], []string{"old"}) {
+		t.Fatalf("expected only old to be deleted by the first run, got %v", registry.deletedTags["repo"])
+	}
+
+	// The next run evaluates the tag the skew kept instead of skipping it as already evaluated.
+	defer pinNow(firstRun.Add(2 * time.Hour))()
+	if err := runPurge(context.Background(), registry, ioutil.Discard, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(registry.deletedTags["repo"], []string{"old", "band"}) {
+		t.Fatalf("expected band to be deleted by the next run, got %v", registry.deletedTags["repo"])
+	}
+
+	// A run with another skew doesn't use the state.
+	state, err := loadPurgeState(path)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if since := state.since("registry.azurecr.io", "repo", "1d", "", time.Hour); !since.IsZero() {
+		t.Fatalf("a run with another skew should be a full scan, got %v", since)
+	}
+}
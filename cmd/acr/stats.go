@@ -0,0 +1,269 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/AzureCR/acr-cli/cmd/table"
+	"github.com/spf13/cobra"
+)
+
+const (
+	statsLongMessage = `acr stats: summarize the tags and manifests of a repository.
+
+The tag names are grouped by their prefix, the part before the first '-', '_' or '.', so the tags a purge filter
+would select can be estimated. The size is the sum of the unique layers and configs of every manifest, it needs a
+request per manifest so it's only computed with --size. The blobs referenced by several manifests are counted too,
+deleting one of these manifests doesn't free them.`
+	statsExampleMessage = `
+Summarize a repository
+  acr stats -r MyRegistry --repository MyRepository
+
+Summarize a repository with its size, as JSON
+  acr stats -r MyRegistry --repository MyRepository --size --output json`
+
+	// statsTagNameGroups is the number of tag name prefixes shown in text output.
+	statsTagNameGroups = 10
+)
+
+type statsParameters struct {
+	repoName string
+	size     bool
+	output   string
+}
+
+// tagStats identifies a tag in the stats of a repository.
+type tagStats struct {
+	Name           string `json:"name"`
+	LastUpdateTime string `json:"lastUpdateTime"`
+	lastUpdateTime time.Time
+}
+
+// tagNameGroup is the number of tags that share a name prefix.
+type tagNameGroup struct {
+	Prefix string `json:"prefix"`
+	Count  int    `json:"count"`
+}
+
+// repositoryStats are the aggregates of the tags and manifests of a repository. Size and the blob counts are only set
+// when the size was computed and TagNames is sorted by decreasing count.
+type repositoryStats struct {
+	Repository        string         `json:"repository"`
+	Tags              int            `json:"tags"`
+	Manifests         int            `json:"manifests"`
+	DanglingManifests int            `json:"danglingManifests"`
+	Size              *int64         `json:"size,omitempty"`
+	Blobs             *int           `json:"blobs,omitempty"`
+	SharedBlobs       *int           `json:"sharedBlobs,omitempty"`
+	SharedSize        *int64         `json:"sharedSize,omitempty"`
+	OldestTag         *tagStats      `json:"oldestTag,omitempty"`
+	NewestTag         *tagStats      `json:"newestTag,omitempty"`
+	TagNames          []tagNameGroup `json:"tagNames"`
+}
+
+func newStatsCmd(out io.Writer, rootParams *rootParameters) *cobra.Command {
+	var parameters statsParameters
+	cmd := &cobra.Command{
+		Use:     "stats",
+		Short:   "Summarize a repository.",
+		Long:    statsLongMessage,
+		Example: statsExampleMessage,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if parameters.output != outputText && !isDocumentOutput(parameters.output) {
+				return newInvalidArgumentsError("--output must be %s, %s or %s", outputText, outputJSON, outputYAML)
+			}
+			if rootParams.isGeneric() {
+				return newInvalidArgumentsError("acr stats isn't supported with --registry-type %s, it needs the manifest listing of the ACR API", registryTypeGeneric)
+			}
+			ctx, cancel := signalContext()
+			defer cancel()
+			loginURL, err := rootParams.loginURL()
+			if err != nil {
+				return err
+			}
+			acrClient, err := rootParams.newAcrClient(loginURL, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			if err := checkRepositoryExists(ctx, acrClient, loginURL, parameters.repoName); err != nil {
+				return err
+			}
+			stats, err := collectStats(ctx, acrClient, parameters.repoName, parameters.size)
+			if err != nil {
+				return err
+			}
+			return writeStats(out, stats, parameters.output)
+		},
+	}
+
+	cmd.Flags().StringVar(&parameters.repoName, "repository", "", "The repository to summarize")
+	cmd.MarkFlagRequired("repository")
+	markRepositoryCompletion(cmd)
+	cmd.Flags().BoolVar(&parameters.size, "size", false, "Compute the size of the repository, this pulls every manifest")
+	cmd.Flags().StringVarP(&parameters.output, "output", "o", outputText, "Output format, text, json or yaml")
+
+	return cmd
+}
+
+// collectStats lists every tag and manifest of repoName and aggregates them. When includeSize is set every manifest
+// is pulled and the sizes of its config and layers are added up, a blob shared by several manifests is counted once.
+func collectStats(ctx context.Context,
+	acrClient api.AcrCLIClientInterface,
+	repoName string,
+	includeSize bool) (*repositoryStats, error) {
+	stats := &repositoryStats{Repository: repoName, TagNames: []tagNameGroup{}}
+	groups := map[string]int{}
+	err := listTags(ctx, acrClient, repoName, "", func(tag acrapi.TagAttributesBase) error {
+		stats.Tags++
+		groups[tagNamePrefix(*tag.Name)]++
+		lastUpdateTime, err := time.Parse(time.RFC3339Nano, *tag.LastUpdateTime)
+		if err != nil {
+			return err
+		}
+		current := &tagStats{Name: *tag.Name, LastUpdateTime: *tag.LastUpdateTime, lastUpdateTime: lastUpdateTime}
+		if stats.OldestTag == nil || lastUpdateTime.Before(stats.OldestTag.lastUpdateTime) {
+			stats.OldestTag = current
+		}
+		if stats.NewestTag == nil || lastUpdateTime.After(stats.NewestTag.lastUpdateTime) {
+			stats.NewestTag = current
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for prefix, count := range groups {
+		stats.TagNames = append(stats.TagNames, tagNameGroup{Prefix: prefix, Count: count})
+	}
+	sort.Slice(stats.TagNames, func(i, j int) bool {
+		if stats.TagNames[i].Count != stats.TagNames[j].Count {
+			return stats.TagNames[i].Count > stats.TagNames[j].Count
+		}
+		return stats.TagNames[i].Prefix < stats.TagNames[j].Prefix
+	})
+
+	var digests []string
+	err = listManifests(ctx, acrClient, repoName, func(manifest acrapi.ManifestAttributesBase) error {
+		stats.Manifests++
+		if manifest.Tags == nil {
+			stats.DanglingManifests++
+		}
+		if includeSize {
+			digests = append(digests, *manifest.Digest)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if includeSize {
+		usage, err := repositoryBlobs(ctx, acrClient, repoName, digests)
+		if err != nil {
+			return nil, err
+		}
+		stats.Size = &usage.size
+		stats.Blobs = &usage.blobs
+		stats.SharedBlobs = &usage.sharedBlobs
+		stats.SharedSize = &usage.sharedSize
+	}
+	return stats, nil
+}
+
+// blobUsage is the size of the unique blobs of a repository and the part of them referenced by several manifests.
+type blobUsage struct {
+	size        int64
+	blobs       int
+	sharedBlobs int
+	sharedSize  int64
+}
+
+// repositoryBlobs pulls the manifests identified by digests and counts the references to their blobs, a blob shared
+// by several manifests is only counted once in the size. Image indexes don't add anything, the manifests they
+// reference are listed on their own.
+func repositoryBlobs(ctx context.Context, acrClient api.AcrCLIClientInterface, repoName string, digests []string) (blobUsage, error) {
+	sizes := map[string]int64{}
+	references := map[string]int{}
+	for _, digest := range digests {
+		manifest, err := acrClient.AcrGetManifest(ctx, repoName, digest)
+		if err != nil {
+			return blobUsage{}, err
+		}
+		blobs := manifest.Layers
+		if manifest.Config != nil {
+			blobs = append([]api.Descriptor{*manifest.Config}, blobs...)
+		}
+		referenced := map[string]bool{}
+		for _, blob := range blobs {
+			sizes[blob.Digest] = blob.Size
+			// A blob listed twice by the same manifest is still a single reference.
+			if !referenced[blob.Digest] {
+				referenced[blob.Digest] = true
+				references[blob.Digest]++
+			}
+		}
+	}
+	usage := blobUsage{blobs: len(sizes)}
+	for digest, size := range sizes {
+		usage.size += size
+		if references[digest] > 1 {
+			usage.sharedBlobs++
+			usage.sharedSize += size
+		}
+	}
+	return usage, nil
+}
+
+// tagNamePrefix returns the part of a tag name before its first '-', '_' or '.', or the whole name.
+func tagNamePrefix(name string) string {
+	if i := strings.IndexAny(name, "-_."); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// writeStats writes stats as JSON, as YAML or as text, the text output only shows the most common tag name prefixes.
+func writeStats(out io.Writer, stats *repositoryStats, output string) error {
+	switch output {
+	case outputJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	case outputYAML:
+		return writeYAML(out, stats)
+	}
+	fmt.Fprintf(out, "Repository:          %s\n", stats.Repository)
+	fmt.Fprintf(out, "Tags:                %d\n", stats.Tags)
+	fmt.Fprintf(out, "Manifests:           %d\n", stats.Manifests)
+	fmt.Fprintf(out, "Dangling manifests:  %d\n", stats.DanglingManifests)
+	if stats.Size != nil {
+		fmt.Fprintf(out, "Size:                %s\n", table.HumanSize(*stats.Size))
+		fmt.Fprintf(out, "Blobs:               %d, %d shared by several manifests (%s)\n", *stats.Blobs, *stats.SharedBlobs, table.HumanSize(*stats.SharedSize))
+	}
+	if stats.OldestTag != nil {
+		fmt.Fprintf(out, "Oldest tag:          %s, updated %s\n", stats.OldestTag.Name, table.HumanDuration(stats.OldestTag.lastUpdateTime))
+		fmt.Fprintf(out, "Newest tag:          %s, updated %s\n", stats.NewestTag.Name, table.HumanDuration(stats.NewestTag.lastUpdateTime))
+	}
+	if len(stats.TagNames) == 0 {
+		return nil
+	}
+	fmt.Fprintln(out)
+	groups := table.New("PREFIX", "TAGS")
+	for i, group := range stats.TagNames {
+		if i == statsTagNameGroups {
+			groups.AddRow("...", fmt.Sprintf("%d more prefixes", len(stats.TagNames)-i))
+			break
+		}
+		groups.AddRow(group.Prefix, fmt.Sprint(group.Count))
+	}
+	return groups.Write(out)
+}
@@ -0,0 +1,92 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectStats(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.pageSize = 2
+	now := time.Now()
+	registry.addManifest("repo", testDigest(1), now.Add(-72*time.Hour), "v1.0", "v1.1")
+	registry.addManifest("repo", testDigest(2), now.Add(-2*time.Hour), "ci-1", "ci-2", "ci-3")
+	registry.addManifest("repo", testDigest(3), now.Add(-48*time.Hour), "latest")
+	registry.addManifest("repo", testDigest(4), now.Add(-96*time.Hour))
+	registry.addManifest("repo", testDigest(5), now.Add(-96*time.Hour))
+	registry.setLayers("repo", testDigest(1), map[string]int64{"sha256:base": 1000, "sha256:app1": 100})
+	registry.setLayers("repo", testDigest(2), map[string]int64{"sha256:base": 1000, "sha256:app2": 200})
+	registry.setLayers("repo", testDigest(4), map[string]int64{"sha256:base": 1000})
+
+	stats, err := collectStats(context.Background(), registry, "repo", true)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if stats.Tags != 6 || stats.Manifests != 5 || stats.DanglingManifests != 2 {
+		t.Fatalf("counts incorrect, got %d tags, %d manifests and %d dangling", stats.Tags, stats.Manifests, stats.DanglingManifests)
+	}
+	if stats.Size == nil || *stats.Size != 1300 {
+		t.Fatalf("the shared layers should be counted once, got size %v", stats.Size)
+	}
+	if *stats.Blobs != 3 || *stats.SharedBlobs != 1 || *stats.SharedSize != 1000 {
+		t.Fatalf("blob counts incorrect, got %d blobs and %d shared of %d bytes", *stats.Blobs, *stats.SharedBlobs, *stats.SharedSize)
+	}
+	if stats.OldestTag.Name != "v1.0" || stats.NewestTag.Name != "ci-1" {
+		t.Fatalf("oldest and newest tags incorrect, got %s and %s", stats.OldestTag.Name, stats.NewestTag.Name)
+	}
+	expectedGroups := []tagNameGroup{{"ci", 3}, {"v1", 2}, {"latest", 1}}
+	if !reflect.DeepEqual(stats.TagNames, expectedGroups) {
+		t.Fatalf("tag names incorrect, got %v, expected %v", stats.TagNames, expectedGroups)
+	}
+
+	stats, err = collectStats(context.Background(), registry, "repo", false)
+	if err != nil || stats.Size != nil {
+		t.Fatalf("the size should only be computed when requested, got %v and %v", stats.Size, err)
+	}
+}
+
+func TestWriteStats(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now().Add(-72*time.Hour), "v1")
+	registry.addManifest("repo", testDigest(2), time.Now().Add(-72*time.Hour))
+	stats, err := collectStats(context.Background(), registry, "repo", false)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := writeStats(&out, stats, outputText); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, line := range []string{"Tags:                1\n", "Dangling manifests:  1\n", "Oldest tag:          v1, updated 3 days ago\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Fatalf("output %q doesn't contain %q", out.String(), line)
+		}
+	}
+	if strings.Contains(out.String(), "Size:") {
+		t.Fatalf("the size wasn't computed and shouldn't be shown, got %q", out.String())
+	}
+
+	out.Reset()
+	if err := writeStats(&out, stats, outputJSON); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if decoded["danglingManifests"] != float64(1) || decoded["oldestTag"].(map[string]interface{})["name"] != "v1" {
+		t.Fatalf("JSON output incorrect, got %s", out.String())
+	}
+	if _, ok := decoded["size"]; ok {
+		t.Fatalf("the size wasn't computed and shouldn't be in the JSON output, got %s", out.String())
+	}
+}
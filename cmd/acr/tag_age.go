@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+)
+
+const (
+	// tagAgeLastUpdate computes the age of a tag from its last update, which changes when the tag is pushed again.
+	tagAgeLastUpdate = "lastupdate"
+	// tagAgeCreated computes the age of a tag from its creation, which a later push of the tag doesn't change.
+	tagAgeCreated = "created"
+)
+
+// TagAgeResolver returns the time the age of a tag is computed from, PurgeTags deletes the tags whose time is before
+// --ago. Registries expose the age of a tag differently, so the source of the time is selected with --tag-age.
+type TagAgeResolver interface {
+	TagTime(tag acrapi.TagAttributesBase) (time.Time, error)
+}
+
+// tagAgeResolvers are the resolvers --tag-age can select.
+var tagAgeResolvers = map[string]TagAgeResolver{
+	tagAgeLastUpdate: lastUpdateTimeResolver{},
+	tagAgeCreated:    createdTimeResolver{},
+}
+
+// tagAgeSources returns the values accepted by --tag-age, sorted.
+func tagAgeSources() []string {
+	sources := make([]string, 0, len(tagAgeResolvers))
+	for source := range tagAgeResolvers {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// newTagAgeResolver returns the resolver of source, the last update time is used when source is empty.
+func newTagAgeResolver(source string) (TagAgeResolver, bool) {
+	if len(source) == 0 {
+		source = tagAgeLastUpdate
+	}
+	resolver, ok := tagAgeResolvers[source]
+	return resolver, ok
+}
+
+// lastUpdateTimeResolver is the default TagAgeResolver, it reads the last update time of the tag.
+type lastUpdateTimeResolver struct{}
+
+func (lastUpdateTimeResolver) TagTime(tag acrapi.TagAttributesBase) (time.Time, error) {
+	return parseTagTime(tag, "last update time", tag.LastUpdateTime)
+}
+
+// createdTimeResolver reads the creation time of the tag, the generic registries set it to the creation time of the
+// image.
+type createdTimeResolver struct{}
+
+func (createdTimeResolver) TagTime(tag acrapi.TagAttributesBase) (time.Time, error) {
+	return parseTagTime(tag, "creation time", tag.CreatedTime)
+}
+
+// parseTagTime parses the RFC 3339 value of the field of tag, which the registry may not have returned.
+func parseTagTime(tag acrapi.TagAttributesBase, field string, value *string) (time.Time, error) {
+	if value == nil {
+		return time.Time{}, fmt.Errorf("the registry didn't return the %s of tag %s", field, stringValue(tag.Name))
+	}
+	return time.Parse(time.RFC3339Nano, *value)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+)
+
+// pushTimeResolver is a TagAgeResolver with the times of the tags known by the test, like a registry that records the
+// push time of every tag elsewhere.
+type pushTimeResolver map[string]time.Time
+
+func (r pushTimeResolver) TagTime(tag acrapi.TagAttributesBase) (time.Time, error) {
+	return r[*tag.Name], nil
+}
+
+func TestPurgeTagsAgeResolver(t *testing.T) {
+	registry := newFakeRegistry()
+	now := time.Now()
+	// Every tag was last updated long ago, only the resolver decides which ones are old.
+	registry.addManifest("repo", testDigest(1), now.Add(-100*24*time.Hour), "old")
+	registry.addManifest("repo", testDigest(2), now.Add(-100*24*time.Hour), "recent")
+	resolver := pushTimeResolver{"old": now.Add(-10 * 24 * time.Hour), "recent": now.Add(-time.Hour)}
+
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	deleted, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "1d", ageResolver: resolver})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if deleted != 1 || !reflect.DeepEqual(registry.deletedTags["repo"], []string{"old"}) {
+		t.Fatalf("the tags should be selected with the resolver time, deleted %d %v", deleted, registry.deletedTags["repo"])
+	}
+}
+
+func TestPurgeRepositoryTagAgeCreated(t *testing.T) {
+	registry := newFakeRegistry()
+	now := time.Now()
+	registry.addManifest("repo", testDigest(1), now.Add(-time.Hour), "created-long-ago", "created-recently")
+	registry.store.SetCreatedTime("repo", "created-long-ago", now.Add(-30*24*time.Hour))
+
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoName: "repo", ago: "7d", tagAge: tagAgeCreated}
+	deletedTags, _, err := purgeRepository(context.Background(), registry, newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText), nil, parameters)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sort.Strings(registry.deletedTags["repo"])
+	if deletedTags != 1 || !reflect.DeepEqual(registry.deletedTags["repo"], []string{"created-long-ago"}) {
+		t.Fatalf("the tags should be purged by creation time, deleted %d %v", deletedTags, registry.deletedTags["repo"])
+	}
+
+	if _, err := (createdTimeResolver{}).TagTime(acrapi.TagAttributesBase{Name: stringPtr("v1")}); err == nil {
+		t.Fatalf("expected an error for a tag without a creation time")
+	}
+}
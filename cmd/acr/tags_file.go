@@ -0,0 +1,81 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// tagNamePattern is the syntax of a tag name in the Distribution specification.
+var tagNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// explicitTagsConflictingFlags are the purge flags that select tags or manifests, they can't be combined with an
+// explicit list of tags.
+var explicitTagsConflictingFlags = []string{"ago", "filter", "dangling", "dangling-ago", "dangling-any-age", "cascade",
+	"manifest-filter", "include-media-types", "exclude-media-types", "purge-referrers", "keep-per-group", "group-regex",
+	"max-tags", "since-last-run", "orderby", "tag-age", "newer-than", "where", "filter-mode", "max-age-skew",
+	"semver-keep", "semver-purge-others", "preserve-digests-from-file"}
+
+// validateTagName returns an error when name isn't a valid tag name.
+func validateTagName(name string) error {
+	if !tagNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid tag %q, a tag has up to 128 letters, digits, '_', '.' or '-' and doesn't start with '.' or '-'", name)
+	}
+	return nil
+}
+
+// parseTagsFile reads one tag name per line. Blank lines and lines starting with # are ignored.
+func parseTagsFile(r io.Reader) ([]string, error) {
+	var tags []string
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := validateTagName(line); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		tags = append(tags, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// explicitTags returns the tags given with --tags and in the --tags-from-file file, sorted and without duplicates.
+func explicitTags(tags []string, tagsFile string) ([]string, error) {
+	all := append([]string(nil), tags...)
+	if len(tagsFile) > 0 {
+		file, err := os.Open(tagsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		fileTags, err := parseTagsFile(file)
+		if err != nil {
+			return nil, &invalidArgumentsError{err: errors.Wrapf(err, "unable to parse %s", tagsFile)}
+		}
+		all = append(all, fileTags...)
+	}
+	sort.Strings(all)
+	unique := all[:0]
+	for i, tag := range all {
+		if i == 0 || tag != all[i-1] {
+			unique = append(unique, tag)
+		}
+	}
+	return unique, nil
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+func TestParseTagsFile(t *testing.T) {
+	tags, err := parseTagsFile(strings.NewReader("# known CI tags\nci-1\n\n  ci-2  \nv1.0.0\n"))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"ci-1", "ci-2", "v1.0.0"}) {
+		t.Fatalf("parseTagsFile incorrect, got %v", tags)
+	}
+	if _, err := parseTagsFile(strings.NewReader("ci-1\n-invalid\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected an error on line 2, got %v", err)
+	}
+}
+
+func TestExplicitTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tags")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	tagsFile := filepath.Join(dir, "tags.txt")
+	if err := ioutil.WriteFile(tagsFile, []byte("v2\nv3\n"), 0644); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	tags, err := explicitTags([]string{"v3", "v1"}, tagsFile)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"v1", "v2", "v3"}) {
+		t.Fatalf("explicitTags incorrect, got %v", tags)
+	}
+}
+
+func TestDeleteTags(t *testing.T) {
+	registry := newFakeRegistry()
+	// The tags are recent, an explicit list ignores their age.
+	registry.addManifest("repo", testDigest(1), time.Now(), "v1", "v2")
+	registry.addManifest("repo", testDigest(2), time.Now(), "v3", "keep")
+	registry.failOn("AcrDeleteTag repo v3", &api.RegistryError{StatusCode: http.StatusInternalServerError})
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+
+	deleted, err := deleteTags(context.Background(), registry, results, "repo", []string{"missing", "v1", "v2", "v3"}, true, defaultConcurrency)
+	if exitCode(err) != exitCodePartialFailure {
+		t.Fatalf("expected a partial failure, got %v", err)
+	}
+	sort.Strings(registry.deletedTags["repo"])
+	if deleted != 2 || !reflect.DeepEqual(registry.deletedTags["repo"], []string{"v1", "v2"}) {
+		t.Fatalf("deleted tags incorrect, got %d %v", deleted, registry.deletedTags["repo"])
+	}
+	report := results.report(false)
+	if len(report.Deleted) != 2 || len(report.NotFound) != 1 || report.NotFound[0].Tag != "missing" || len(report.Failed) != 1 || report.Failed[0].Tag != "v3" {
+		t.Fatalf("report incorrect, got %+v", report)
+	}
+
+	if _, err := deleteTags(context.Background(), registry, results, "other", []string{"v1"}, true, defaultConcurrency); exitCode(err) != exitCodeInvalidArguments {
+		t.Fatalf("expected a missing repository to be an invalid argument, got %v", err)
+	}
+}
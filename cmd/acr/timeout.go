@@ -0,0 +1,167 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+// operationTimeoutError is returned when a single registry call took longer than --operation-timeout, as opposed to
+// the whole run being canceled.
+type operationTimeoutError struct {
+	operation string
+	timeout   time.Duration
+}
+
+func (e *operationTimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %v", e.operation, e.timeout)
+}
+
+// timeoutClient bounds the duration of every call made through the wrapped client, so a stuck request fails on its
+// own instead of holding a worker until the run is canceled.
+type timeoutClient struct {
+	api.AcrCLIClientInterface
+	timeout time.Duration
+}
+
+func newTimeoutClient(acrClient api.AcrCLIClientInterface, timeout time.Duration) *timeoutClient {
+	return &timeoutClient{AcrCLIClientInterface: acrClient, timeout: timeout}
+}
+
+// call runs operation with a context that expires after the timeout. The error is replaced by an
+// operationTimeoutError when the timeout expired while ctx itself is still alive.
+func (c *timeoutClient) call(ctx context.Context, operation string, call func(ctx context.Context) error) error {
+	operationCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	err := call(operationCtx)
+	if err != nil && ctx.Err() == nil && operationCtx.Err() == context.DeadlineExceeded {
+		return &operationTimeoutError{operation: operation, timeout: c.timeout}
+	}
+	return err
+}
+
+func (c *timeoutClient) AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error) {
+	var tags *acrapi.TagAttributeList
+	err := c.call(ctx, "listing the tags of "+repoName, func(ctx context.Context) error {
+		var err error
+		tags, err = c.AcrCLIClientInterface.AcrListTags(ctx, repoName, orderBy, last)
+		return err
+	})
+	return tags, err
+}
+
+func (c *timeoutClient) AcrDeleteTag(ctx context.Context, repoName string, reference string) error {
+	return c.call(ctx, "deleting "+repoName+":"+reference, func(ctx context.Context) error {
+		return c.AcrCLIClientInterface.AcrDeleteTag(ctx, repoName, reference)
+	})
+}
+
+func (c *timeoutClient) AcrListManifests(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.ManifestAttributeList, error) {
+	var manifests *acrapi.ManifestAttributeList
+	err := c.call(ctx, "listing the manifests of "+repoName, func(ctx context.Context) error {
+		var err error
+		manifests, err = c.AcrCLIClientInterface.AcrListManifests(ctx, repoName, orderBy, last)
+		return err
+	})
+	return manifests, err
+}
+
+func (c *timeoutClient) DeleteManifest(ctx context.Context, repoName string, reference string) error {
+	return c.call(ctx, "deleting "+repoName+"@"+reference, func(ctx context.Context) error {
+		return c.AcrCLIClientInterface.DeleteManifest(ctx, repoName, reference)
+	})
+}
+
+func (c *timeoutClient) AcrListReferrers(ctx context.Context, repoName string, digest string) (*api.ReferrerList, error) {
+	var referrers *api.ReferrerList
+	err := c.call(ctx, "listing the referrers of "+repoName+"@"+digest, func(ctx context.Context) error {
+		var err error
+		referrers, err = c.AcrCLIClientInterface.AcrListReferrers(ctx, repoName, digest)
+		return err
+	})
+	return referrers, err
+}
+
+func (c *timeoutClient) AcrListRepositories(ctx context.Context, last string) (*api.RepositoryList, error) {
+	var repositories *api.RepositoryList
+	err := c.call(ctx, "listing the repositories", func(ctx context.Context) error {
+		var err error
+		repositories, err = c.AcrCLIClientInterface.AcrListRepositories(ctx, last)
+		return err
+	})
+	return repositories, err
+}
+
+func (c *timeoutClient) AcrListRepositoriesV2(ctx context.Context, last string) (*api.RepositoryList, error) {
+	var repositories *api.RepositoryList
+	err := c.call(ctx, "listing the repositories", func(ctx context.Context) error {
+		var err error
+		repositories, err = c.AcrCLIClientInterface.AcrListRepositoriesV2(ctx, last)
+		return err
+	})
+	return repositories, err
+}
+
+func (c *timeoutClient) AcrGetRepositoryAttributes(ctx context.Context, repoName string) (*acrapi.RepositoryAttributes, error) {
+	var attributes *acrapi.RepositoryAttributes
+	err := c.call(ctx, "reading the attributes of "+repoName, func(ctx context.Context) error {
+		var err error
+		attributes, err = c.AcrCLIClientInterface.AcrGetRepositoryAttributes(ctx, repoName)
+		return err
+	})
+	return attributes, err
+}
+
+func (c *timeoutClient) AcrGetRepositoryMetadata(ctx context.Context, repoName string, key string) ([]byte, error) {
+	var value []byte
+	err := c.call(ctx, "reading the metadata of "+repoName, func(ctx context.Context) error {
+		var err error
+		value, err = c.AcrCLIClientInterface.AcrGetRepositoryMetadata(ctx, repoName, key)
+		return err
+	})
+	return value, err
+}
+
+func (c *timeoutClient) AcrCheckDeletePermission(ctx context.Context, repoName string) error {
+	return c.call(ctx, "checking the delete permission on "+repoName, func(ctx context.Context) error {
+		return c.AcrCLIClientInterface.AcrCheckDeletePermission(ctx, repoName)
+	})
+}
+
+func (c *timeoutClient) AcrGetManifest(ctx context.Context, repoName string, reference string) (*api.Manifest, error) {
+	var manifest *api.Manifest
+	err := c.call(ctx, "pulling "+repoName+"@"+reference, func(ctx context.Context) error {
+		var err error
+		manifest, err = c.AcrCLIClientInterface.AcrGetManifest(ctx, repoName, reference)
+		return err
+	})
+	return manifest, err
+}
+
+func (c *timeoutClient) AcrGetManifestContent(ctx context.Context, repoName string, reference string) (*api.ManifestContent, error) {
+	var manifest *api.ManifestContent
+	err := c.call(ctx, "pulling "+repoName+"@"+reference, func(ctx context.Context) error {
+		var err error
+		manifest, err = c.AcrCLIClientInterface.AcrGetManifestContent(ctx, repoName, reference)
+		return err
+	})
+	return manifest, err
+}
+
+func (c *timeoutClient) AcrPutManifest(ctx context.Context, repoName string, reference string, manifest *api.ManifestContent) error {
+	return c.call(ctx, "pushing "+reference+" to "+repoName, func(ctx context.Context) error {
+		return c.AcrCLIClientInterface.AcrPutManifest(ctx, repoName, reference, manifest)
+	})
+}
+
+func (c *timeoutClient) AcrMountBlob(ctx context.Context, repoName string, sourceRepoName string, digest string) error {
+	return c.call(ctx, "mounting "+digest+" into "+repoName, func(ctx context.Context) error {
+		return c.AcrCLIClientInterface.AcrMountBlob(ctx, repoName, sourceRepoName, digest)
+	})
+}
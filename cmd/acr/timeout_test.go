@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hangingRegistry never answers the deletion of the hang tag, until the context of the call is done.
+type hangingRegistry struct {
+	*fakeRegistry
+}
+
+func (h *hangingRegistry) AcrDeleteTag(ctx context.Context, repoName string, reference string) error {
+	if reference == "hang" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return h.fakeRegistry.AcrDeleteTag(ctx, repoName, reference)
+}
+
+func TestPurgeTagsOperationTimeout(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "v1")
+	registry.addManifest("repo", testDigest(2), old, "hang")
+	registry.addManifest("repo", testDigest(3), old, "v3")
+	acrClient := newTimeoutClient(&hangingRegistry{registry}, 50*time.Millisecond)
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+
+	deleted, err := PurgeTags(context.Background(), acrClient, results, "repo", tagPurgeOptions{ago: "1d"})
+	if exitCode(err) != exitCodePartialFailure {
+		t.Fatalf("expected a partial failure, got %v", err)
+	}
+	sort.Strings(registry.deletedTags["repo"])
+	if deleted != 2 || strings.Join(registry.deletedTags["repo"], ",") != "v1,v3" {
+		t.Fatalf("the other tags should be deleted, got %d %v", deleted, registry.deletedTags["repo"])
+	}
+	failed := results.report(false).Failed
+	if len(failed) != 1 || failed[0].Reason != "deleting repo:hang timed out after 50ms" {
+		t.Fatalf("the timed out deletion should be reported, got %+v", failed)
+	}
+}
+
+func TestTimeoutClientCanceled(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now(), "hang")
+	acrClient := newTimeoutClient(&hangingRegistry{registry}, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := acrClient.AcrDeleteTag(ctx, "repo", "hang"); err != context.DeadlineExceeded {
+		t.Fatalf("the expiry of the run shouldn't be reported as an operation timeout, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/AzureCR/acr-cli/cmd/table"
+)
+
+// The phases of a purge timed with --verbose, in the order they're written.
+const (
+	phaseListTags        = "list tags"
+	phaseDeleteTags      = "delete tags"
+	phaseListManifests   = "list manifests"
+	phaseDeleteManifests = "delete manifests"
+)
+
+var timedPhases = []string{phaseListTags, phaseDeleteTags, phaseListManifests, phaseDeleteManifests}
+
+// phaseTiming is the time spent in a phase of a repository. requestTime is the sum of the durations of its requests,
+// which is longer than the elapsed time between its first and last request when they're concurrent.
+type phaseTiming struct {
+	items       int
+	requests    int
+	requestTime time.Duration
+	first, last time.Time
+}
+
+// purgeTimings collects the timings of the requests of a purge run by repository and phase, it's safe to use from the
+// deletion workers. The times are read from now.
+type purgeTimings struct {
+	mu           sync.Mutex
+	now          func() time.Time
+	start        time.Time
+	repositories []string
+	phases       map[string]map[string]*phaseTiming
+}
+
+func newPurgeTimings(now func() time.Time) *purgeTimings {
+	return &purgeTimings{now: now, start: now(), phases: map[string]map[string]*phaseTiming{}}
+}
+
+// record adds a request of phase on repoName that started at start and handled items items.
+func (t *purgeTimings) record(repoName string, phase string, start time.Time, items int) {
+	end := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	phases, ok := t.phases[repoName]
+	if !ok {
+		phases = map[string]*phaseTiming{}
+		t.phases[repoName] = phases
+		t.repositories = append(t.repositories, repoName)
+	}
+	timing, ok := phases[phase]
+	if !ok {
+		timing = &phaseTiming{first: start}
+		phases[phase] = timing
+	}
+	timing.items += items
+	timing.requests++
+	timing.requestTime += end.Sub(start)
+	if end.After(timing.last) {
+		timing.last = end
+	}
+}
+
+// write writes the timings of every phase of every repository in the order they were purged, with the number of
+// items listed or deleted and their rate over the elapsed time of the phase, followed by the totals of the run.
+func (t *purgeTimings) write(out io.Writer) error {
+	end := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timingsTable := table.New("REPOSITORY", "PHASE", "ITEMS", "REQUESTS", "ELAPSED", "REQUEST TIME", "ITEMS/S")
+	deleted := 0
+	requests := 0
+	for _, repoName := range t.repositories {
+		for _, phase := range timedPhases {
+			timing, ok := t.phases[repoName][phase]
+			if !ok {
+				continue
+			}
+			elapsed := timing.last.Sub(timing.first)
+			timingsTable.AddRow(repoName, phase, strconv.Itoa(timing.items), strconv.Itoa(timing.requests),
+				formatTiming(elapsed), formatTiming(timing.requestTime), formatRate(timing.items, elapsed))
+			if phase == phaseDeleteTags || phase == phaseDeleteManifests {
+				deleted += timing.items
+			}
+			requests += timing.requests
+		}
+	}
+	fmt.Fprintln(out, "Timings:")
+	if err := timingsTable.Write(out); err != nil {
+		return err
+	}
+	elapsed := end.Sub(t.start)
+	_, err := fmt.Fprintf(out, "Total: %d repositories in %s, %d requests, %d tags and manifests deleted, %s deletions/s\n",
+		len(t.repositories), formatTiming(elapsed), requests, deleted, formatRate(deleted, elapsed))
+	return err
+}
+
+// formatTiming rounds d to the millisecond, the registry requests don't take less.
+func formatTiming(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// formatRate returns the number of items per second over elapsed with one decimal, or - when nothing elapsed.
+func formatRate(items int, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(float64(items)/elapsed.Seconds(), 'f', 1, 64)
+}
+
+// timingClient records the timings of the listings and deletions made through the wrapped client.
+type timingClient struct {
+	api.AcrCLIClientInterface
+	timings *purgeTimings
+}
+
+func newTimingClient(acrClient api.AcrCLIClientInterface, timings *purgeTimings) *timingClient {
+	return &timingClient{AcrCLIClientInterface: acrClient, timings: timings}
+}
+
+func (c *timingClient) AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error) {
+	start := c.timings.now()
+	tags, err := c.AcrCLIClientInterface.AcrListTags(ctx, repoName, orderBy, last)
+	items := 0
+	if err == nil && tags != nil && tags.Tags != nil {
+		items = len(*tags.Tags)
+	}
+	c.timings.record(repoName, phaseListTags, start, items)
+	return tags, err
+}
+
+func (c *timingClient) AcrDeleteTag(ctx context.Context, repoName string, reference string) error {
+	start := c.timings.now()
+	err := c.AcrCLIClientInterface.AcrDeleteTag(ctx, repoName, reference)
+	c.timings.record(repoName, phaseDeleteTags, start, deletedItems(err))
+	return err
+}
+
+func (c *timingClient) AcrListManifests(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.ManifestAttributeList, error) {
+	start := c.timings.now()
+	manifests, err := c.AcrCLIClientInterface.AcrListManifests(ctx, repoName, orderBy, last)
+	items := 0
+	if err == nil && manifests != nil && manifests.Manifests != nil {
+		items = len(*manifests.Manifests)
+	}
+	c.timings.record(repoName, phaseListManifests, start, items)
+	return manifests, err
+}
+
+func (c *timingClient) DeleteManifest(ctx context.Context, repoName string, reference string) error {
+	start := c.timings.now()
+	err := c.AcrCLIClientInterface.DeleteManifest(ctx, repoName, reference)
+	c.timings.record(repoName, phaseDeleteManifests, start, deletedItems(err))
+	return err
+}
+
+// deletedItems is the number of items a deletion that returned err deleted.
+func deletedItems(err error) int {
+	if err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedClock is a fakeClock that can be read from the deletion workers.
+func lockedClock(step time.Duration) func() time.Time {
+	var mu sync.Mutex
+	clock := fakeClock(step)
+	return func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock()
+	}
+}
+
+func TestPurgeTimings(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.pageSize = 2
+	old := time.Now().Add(-72 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "v1", "v2")
+	registry.addManifest("repo", testDigest(2), old, "v3")
+	registry.addManifest("repo", testDigest(3), old)
+	registry.addManifest("other", testDigest(4), old, "v1")
+	timings := newPurgeTimings(lockedClock(time.Second))
+	acrClient := newTimingClient(registry, timings)
+	parameters := purgeParameters{concurrency: defaultConcurrency, repoGlobs: []string{"*"}, ago: "1d", output: outputText, quiet: true}
+	if err := runPurge(context.Background(), acrClient, ioutil.Discard, "registry.azurecr.io", parameters); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var out bytes.Buffer
+	if err := timings.write(&out); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	expected := []string{
+		`^Timings:$`,
+		`^REPOSITORY +PHASE +ITEMS +REQUESTS +ELAPSED +REQUEST TIME +ITEMS/S$`,
+		`^other +list tags +1 +2 +\d+s +2s +[0-9.]+$`,
+		`^other +delete tags +1 +1 +1s +1s +1\.0$`,
+		`^other +list manifests +1 +2 +\d+s +2s +[0-9.]+$`,
+		`^other +delete manifests +1 +1 +1s +1s +1\.0$`,
+		`^repo +list tags +3 +3 +\d+s +3s +[0-9.]+$`,
+		`^repo +delete tags +3 +3 +\d+s +3s +[0-9.]+$`,
+		`^repo +list manifests +3 +3 +\d+s +3s +[0-9.]+$`,
+		`^repo +delete manifests +3 +3 +\d+s +3s +[0-9.]+$`,
+		`^Total: 2 repositories in \d+s, 18 requests, 8 tags and manifests deleted, [0-9.]+ deletions/s$`,
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got:\n%s", len(expected), out.String())
+	}
+	for i, pattern := range expected {
+		if !regexp.MustCompile(pattern).MatchString(lines[i]) {
+			t.Fatalf("line %d doesn't match %s:\n%s", i+1, pattern, out.String())
+		}
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	if rate := formatRate(10, 4*time.Second); rate != "2.5" {
+		t.Fatalf("expected 2.5, got %s", rate)
+	}
+	if rate := formatRate(10, 0); rate != "-" {
+		t.Fatalf("expected -, got %s", rate)
+	}
+}
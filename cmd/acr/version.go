@@ -12,22 +12,28 @@ import (
 )
 
 const (
-	versionLongMessage = `
-Prints version information
-`
+	versionLongMessage    = `acr version: print the version, the git revision and the build date of the CLI.`
+	versionExampleMessage = `
+Print the version information
+  acr version`
 )
 
 func newVersionCmd(out io.Writer) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "version",
-		Short: "Print version information",
-		Long:  versionLongMessage,
+		Use:     "version",
+		Short:   "Print version information",
+		Long:    versionLongMessage,
+		Example: versionExampleMessage,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Printf(`Version: %s, Revision: %s
-`, version.Version, version.Revision)
+			fmt.Fprintln(out, versionMessage())
 			return nil
 		},
 	}
 
 	return cmd
 }
+
+// versionMessage describes the build, it's printed by the version command and the --version flag.
+func versionMessage() string {
+	return fmt.Sprintf("Version: %s, Revision: %s, Build date: %s", version.Version, version.Revision, version.BuildDate)
+}
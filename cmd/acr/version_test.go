@@ -0,0 +1,44 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/AzureCR/acr-cli/version"
+)
+
+func TestVersion(t *testing.T) {
+	defer func(v string, revision string, buildDate string) {
+		version.Version, version.Revision, version.BuildDate = v, revision, buildDate
+	}(version.Version, version.Revision, version.BuildDate)
+	version.Version = "v1.2.3"
+	version.Revision = "0123abcd"
+	version.BuildDate = "2020-01-02T03:04:05Z"
+	expected := "Version: v1.2.3, Revision: 0123abcd, Build date: 2020-01-02T03:04:05Z\n"
+
+	var out bytes.Buffer
+	cmd := newVersionCmd(&out)
+	cmd.SetArgs(nil)
+	cmd.SetOutput(ioutil.Discard)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if out.String() != expected {
+		t.Fatalf("version output incorrect, got %q, expected %q", out.String(), expected)
+	}
+
+	out.Reset()
+	root := newRootCmd(nil)
+	root.SetArgs([]string{"--version"})
+	root.SetOutput(&out)
+	if err := root.Execute(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if out.String() != expected {
+		t.Fatalf("--version output incorrect, got %q, expected %q", out.String(), expected)
+	}
+}
@@ -0,0 +1,565 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+)
+
+// whereType is the type of a field, a literal or an expression of --where.
+type whereType int
+
+const (
+	whereBool whereType = iota
+	whereString
+	whereDuration
+	whereSize
+	// whereNumber is a number without unit, it's compared as a size in bytes.
+	whereNumber
+)
+
+var whereTypeNames = map[whereType]string{
+	whereBool:     "condition",
+	whereString:   "string",
+	whereDuration: "duration",
+	whereSize:     "size",
+	whereNumber:   "number",
+}
+
+// whereFields are the fields of a tag or a manifest --where can test. The media type of a tag and the size always
+// need the manifest to be pulled, so an expression using them costs a request per evaluated item.
+var whereFields = map[string]whereType{
+	"name":      whereString,
+	"digest":    whereString,
+	"mediaType": whereString,
+	"age":       whereDuration,
+	"size":      whereSize,
+}
+
+// whereSizeUnits are the units of the size literals, like 500MB or 1GiB.
+var whereSizeUnits = map[string]int64{
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+// whereMethods are the methods of the string fields, they take a string literal.
+var whereMethods = map[string]func(value string, argument string) bool{
+	"startsWith": strings.HasPrefix,
+	"endsWith":   strings.HasSuffix,
+	"contains":   strings.Contains,
+}
+
+// whereItem is a tag or a manifest evaluated by a --where expression. The manifest is only pulled the first time the
+// expression needs its media type or its size.
+type whereItem struct {
+	name      string
+	digest    string
+	mediaType string
+	age       time.Duration
+	pull      func() (*api.Manifest, error)
+
+	once     sync.Once
+	manifest *api.Manifest
+	err      error
+}
+
+// pulled returns the manifest of the item, pulling it the first time.
+func (i *whereItem) pulled() (*api.Manifest, error) {
+	i.once.Do(func() {
+		i.manifest, i.err = i.pull()
+	})
+	return i.manifest, i.err
+}
+
+// whereExpression is a parsed --where expression, it's checked when it's parsed so evaluating it only fails when a
+// manifest can't be pulled.
+type whereExpression struct {
+	source string
+	root   whereNode
+}
+
+// matches reports whether item meets the expression.
+func (w *whereExpression) matches(item *whereItem) (bool, error) {
+	value, err := w.root.eval(item)
+	if err != nil {
+		return false, err
+	}
+	return value.(bool), nil
+}
+
+// whereNode is a node of the syntax tree of an expression, eval returns a bool, a string or an int64 depending on
+// its type. Durations are in nanoseconds and sizes in bytes.
+type whereNode interface {
+	typ() whereType
+	eval(item *whereItem) (interface{}, error)
+}
+
+type whereLiteral struct {
+	t     whereType
+	value interface{}
+}
+
+func (n *whereLiteral) typ() whereType { return n.t }
+
+func (n *whereLiteral) eval(item *whereItem) (interface{}, error) { return n.value, nil }
+
+type whereField struct {
+	name string
+}
+
+func (n *whereField) typ() whereType { return whereFields[n.name] }
+
+func (n *whereField) eval(item *whereItem) (interface{}, error) {
+	switch n.name {
+	case "name":
+		return item.name, nil
+	case "digest":
+		return item.digest, nil
+	case "age":
+		return int64(item.age), nil
+	case "mediaType":
+		if len(item.mediaType) > 0 {
+			return item.mediaType, nil
+		}
+		manifest, err := item.pulled()
+		if err != nil {
+			return nil, err
+		}
+		return manifest.MediaType, nil
+	}
+	manifest, err := item.pulled()
+	if err != nil {
+		return nil, err
+	}
+	return sumManifestSize(manifest), nil
+}
+
+type whereNot struct {
+	operand whereNode
+}
+
+func (n *whereNot) typ() whereType { return whereBool }
+
+func (n *whereNot) eval(item *whereItem) (interface{}, error) {
+	value, err := n.operand.eval(item)
+	if err != nil {
+		return nil, err
+	}
+	return !value.(bool), nil
+}
+
+// whereLogical is a && or a ||, the right operand is only evaluated when it's needed so a pull can be avoided by
+// testing the cheap fields first.
+type whereLogical struct {
+	and         bool
+	left, right whereNode
+}
+
+func (n *whereLogical) typ() whereType { return whereBool }
+
+func (n *whereLogical) eval(item *whereItem) (interface{}, error) {
+	left, err := n.left.eval(item)
+	if err != nil {
+		return nil, err
+	}
+	if left.(bool) != n.and {
+		return left, nil
+	}
+	return n.right.eval(item)
+}
+
+type whereComparison struct {
+	operator    string
+	left, right whereNode
+}
+
+func (n *whereComparison) typ() whereType { return whereBool }
+
+func (n *whereComparison) eval(item *whereItem) (interface{}, error) {
+	left, err := n.left.eval(item)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(item)
+	if err != nil {
+		return nil, err
+	}
+	if left, ok := left.(string); ok {
+		return (left == right.(string)) == (n.operator == "=="), nil
+	}
+	l, r := left.(int64), right.(int64)
+	switch n.operator {
+	case "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	}
+	return l >= r, nil
+}
+
+type whereMatch struct {
+	field  whereNode
+	regex  *regexp.Regexp
+	method func(value string, argument string) bool
+	arg    string
+}
+
+func (n *whereMatch) typ() whereType { return whereBool }
+
+func (n *whereMatch) eval(item *whereItem) (interface{}, error) {
+	value, err := n.field.eval(item)
+	if err != nil {
+		return nil, err
+	}
+	if n.regex != nil {
+		return n.regex.MatchString(value.(string)), nil
+	}
+	return n.method(value.(string), n.arg), nil
+}
+
+// parseWhere parses and checks source, the errors are invalid arguments errors naming the position of the problem.
+func parseWhere(source string) (*whereExpression, error) {
+	tokens, err := lexWhere(source)
+	if err != nil {
+		return nil, newInvalidArgumentsError("invalid --where: %v", err)
+	}
+	parser := &whereParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err == nil && parser.peek().kind != whereTokenEnd {
+		err = fmt.Errorf("unexpected %s", parser.peek())
+	}
+	if err == nil && root.typ() != whereBool {
+		err = fmt.Errorf("the expression is a %s, not a condition", whereTypeNames[root.typ()])
+	}
+	if err != nil {
+		return nil, newInvalidArgumentsError("invalid --where: %v", err)
+	}
+	return &whereExpression{source: source, root: root}, nil
+}
+
+type whereTokenKind int
+
+const (
+	whereTokenEnd whereTokenKind = iota
+	whereTokenIdent
+	whereTokenString
+	whereTokenLiteral
+	whereTokenOperator
+)
+
+type whereToken struct {
+	kind     whereTokenKind
+	text     string
+	position int
+}
+
+func (t whereToken) String() string {
+	if t.kind == whereTokenEnd {
+		return "end of the expression"
+	}
+	return fmt.Sprintf("%q at position %d", t.text, t.position+1)
+}
+
+// whereOperators are the operators and punctuation of the expressions, the two characters ones first.
+var whereOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "."}
+
+// lexWhere splits source into tokens, a literal is a number optionally followed by a unit like 30d or 500MB.
+func lexWhere(source string) ([]whereToken, error) {
+	var tokens []whereToken
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(source) && source[end] != '"'; end++ {
+				if source[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			tokens = append(tokens, whereToken{kind: whereTokenString, text: source[i : end+1], position: i})
+			i = end + 1
+		case isWhereLetter(c):
+			end := i
+			for end < len(source) && (isWhereLetter(source[end]) || isWhereDigit(source[end])) {
+				end++
+			}
+			tokens = append(tokens, whereToken{kind: whereTokenIdent, text: source[i:end], position: i})
+			i = end
+		case isWhereDigit(c):
+			end := i
+			for end < len(source) && (isWhereLetter(source[end]) || isWhereDigit(source[end]) || source[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, whereToken{kind: whereTokenLiteral, text: source[i:end], position: i})
+			i = end
+		default:
+			operator := ""
+			for _, candidate := range whereOperators {
+				if strings.HasPrefix(source[i:], candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if len(operator) == 0 {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i+1)
+			}
+			tokens = append(tokens, whereToken{kind: whereTokenOperator, text: operator, position: i})
+			i += len(operator)
+		}
+	}
+	return append(tokens, whereToken{kind: whereTokenEnd, position: len(source)}), nil
+}
+
+func isWhereLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isWhereDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// whereParser is a recursive descent parser of the expressions, from the lowest precedence: ||, &&, !, the
+// comparisons and the operands.
+type whereParser struct {
+	tokens []whereToken
+	next   int
+}
+
+func (p *whereParser) peek() whereToken {
+	return p.tokens[p.next]
+}
+
+// accept consumes the next token when it's the operator text.
+func (p *whereParser) accept(text string) bool {
+	if token := p.peek(); token.kind == whereTokenOperator && token.text == text {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *whereParser) parseOr() (whereNode, error) {
+	return p.parseLogical("||", false, p.parseAnd)
+}
+
+func (p *whereParser) parseAnd() (whereNode, error) {
+	return p.parseLogical("&&", true, p.parseUnary)
+}
+
+func (p *whereParser) parseLogical(operator string, and bool, parseOperand func() (whereNode, error)) (whereNode, error) {
+	left, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		token := p.peek()
+		if !p.accept(operator) {
+			return left, nil
+		}
+		right, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if left.typ() != whereBool || right.typ() != whereBool {
+			return nil, fmt.Errorf("the operands of %s must be conditions", token)
+		}
+		left = &whereLogical{and: and, left: left, right: right}
+	}
+}
+
+func (p *whereParser) parseUnary() (whereNode, error) {
+	token := p.peek()
+	if !p.accept("!") {
+		return p.parseComparison()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if operand.typ() != whereBool {
+		return nil, fmt.Errorf("the operand of %s must be a condition", token)
+	}
+	return &whereNot{operand: operand}, nil
+}
+
+func (p *whereParser) parseComparison() (whereNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	token := p.peek()
+	operator := ""
+	for _, candidate := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(candidate) {
+			operator = candidate
+			break
+		}
+	}
+	if len(operator) == 0 {
+		return left, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	leftType, rightType := comparedType(left.typ()), comparedType(right.typ())
+	switch {
+	case leftType != rightType:
+		return nil, fmt.Errorf("%s compares a %s with a %s", token, whereTypeNames[left.typ()], whereTypeNames[right.typ()])
+	case leftType == whereBool:
+		return nil, fmt.Errorf("%s can't compare conditions", token)
+	case leftType == whereString && operator != "==" && operator != "!=":
+		return nil, fmt.Errorf("%s can't order strings, only == and != can compare them", token)
+	}
+	return &whereComparison{operator: operator, left: left, right: right}, nil
+}
+
+// comparedType is the type a value is compared as, a number is a size in bytes.
+func comparedType(t whereType) whereType {
+	if t == whereNumber {
+		return whereSize
+	}
+	return t
+}
+
+func (p *whereParser) parseOperand() (whereNode, error) {
+	token := p.peek()
+	switch token.kind {
+	case whereTokenOperator:
+		if !p.accept("(") {
+			return nil, fmt.Errorf("unexpected %s", token)
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("expected \")\" instead of %s", p.peek())
+		}
+		return node, nil
+	case whereTokenString:
+		p.next++
+		value, err := strconv.Unquote(token.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", token)
+		}
+		return &whereLiteral{t: whereString, value: value}, nil
+	case whereTokenLiteral:
+		p.next++
+		return parseWhereLiteral(token)
+	case whereTokenIdent:
+		p.next++
+		return p.parseIdent(token)
+	}
+	return nil, fmt.Errorf("unexpected %s", token)
+}
+
+// parseIdent parses a field, with a method call for a string field, or the true and false literals. An identifier
+// like P30D is an ISO 8601 duration.
+func (p *whereParser) parseIdent(token whereToken) (whereNode, error) {
+	switch token.text {
+	case "true", "false":
+		return &whereLiteral{t: whereBool, value: token.text == "true"}, nil
+	case "lastAccess":
+		return nil, fmt.Errorf("%s isn't supported, the registry doesn't record when an image was last pulled", token)
+	}
+	if strings.HasPrefix(token.text, "P") && isoDurationRegex.MatchString(token.text) {
+		duration, err := parseISODuration(token.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %s: %v", token, err)
+		}
+		return &whereLiteral{t: whereDuration, value: int64(duration)}, nil
+	}
+	fieldType, ok := whereFields[token.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %s, the fields are name, digest, mediaType, age and size", token)
+	}
+	field := &whereField{name: token.text}
+	if !p.accept(".") {
+		return field, nil
+	}
+	method := p.peek()
+	if method.kind != whereTokenIdent {
+		return nil, fmt.Errorf("expected a method instead of %s", method)
+	}
+	p.next++
+	if fieldType != whereString {
+		return nil, fmt.Errorf("%s is a %s, only the strings have methods", token, whereTypeNames[fieldType])
+	}
+	if !p.accept("(") {
+		return nil, fmt.Errorf("expected \"(\" instead of %s", p.peek())
+	}
+	argument := p.peek()
+	if argument.kind != whereTokenString {
+		return nil, fmt.Errorf("%s takes a string, got %s", method, argument)
+	}
+	p.next++
+	value, err := strconv.Unquote(argument.text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid string %s", argument)
+	}
+	if !p.accept(")") {
+		return nil, fmt.Errorf("expected \")\" instead of %s", p.peek())
+	}
+	if method.text == "matches" {
+		regex, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %s: %s", argument, describeRegexError(value, err))
+		}
+		return &whereMatch{field: field, regex: regex}, nil
+	}
+	function, ok := whereMethods[method.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %s, the methods are matches, startsWith, endsWith and contains", method)
+	}
+	return &whereMatch{field: field, method: function, arg: value}, nil
+}
+
+// parseWhereLiteral parses a number, a size like 500MB or 1GiB, or a duration like 30d, 12h or 1d12h.
+func parseWhereLiteral(token whereToken) (whereNode, error) {
+	if number, err := strconv.ParseInt(token.text, 10, 64); err == nil {
+		return &whereLiteral{t: whereNumber, value: number}, nil
+	}
+	end := len(token.text)
+	for end > 0 && isWhereLetter(token.text[end-1]) {
+		end--
+	}
+	if unit, ok := whereSizeUnits[token.text[end:]]; ok {
+		number, err := strconv.ParseFloat(token.text[:end], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %s", token)
+		}
+		return &whereLiteral{t: whereSize, value: int64(number * float64(unit))}, nil
+	}
+	duration, err := ParseDuration(token.text)
+	if err != nil || duration > 0 {
+		return nil, fmt.Errorf("invalid literal %s, expected a number, a size like 500MB or a duration like 30d", token)
+	}
+	return &whereLiteral{t: whereDuration, value: int64(-duration)}, nil
+}
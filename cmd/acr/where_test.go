@@ -0,0 +1,160 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	"github.com/pkg/errors"
+)
+
+func TestWhereMatches(t *testing.T) {
+	pulls := 0
+	item := func() *whereItem {
+		return &whereItem{
+			name:   "dev-42",
+			digest: testDigest(1),
+			age:    40 * 24 * time.Hour,
+			pull: func() (*api.Manifest, error) {
+				pulls++
+				return &api.Manifest{
+					MediaType: "application/vnd.oci.image.manifest.v1+json",
+					Config:    &api.Descriptor{Size: 1000},
+					Layers:    []api.Descriptor{{Size: 600 * 1000 * 1000}},
+				}, nil
+			},
+		}
+	}
+	tests := []struct {
+		expression string
+		matches    bool
+	}{
+		{`age > 30d && !name.matches("^release-") && size > 500MB`, true},
+		{`age > 30d && name.matches("^release-")`, false},
+		{`age < P30D || name == "dev-42"`, true},
+		{`age >= 40d && age <= 960h`, true},
+		{`size > 1GiB`, false},
+		{`size == 600001000`, true},
+		{`size < 600.5MB`, true},
+		{`name.startsWith("dev-") && name.endsWith("42") && !name.contains("rc")`, true},
+		{`name != "dev-42"`, false},
+		{`digest == "` + testDigest(1) + `"`, true},
+		{`mediaType == "application/vnd.oci.image.manifest.v1+json"`, true},
+		{`!(age > 30d && size > 500MB)`, false},
+		{`true && !false`, true},
+	}
+	for _, test := range tests {
+		where, err := parseWhere(test.expression)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.expression, err)
+		}
+		matched, err := where.matches(item())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.expression, err)
+		}
+		if matched != test.matches {
+			t.Fatalf("%s: expected %t, got %t", test.expression, test.matches, matched)
+		}
+	}
+
+	// The manifest is pulled once per item, and only when the expression needs it.
+	pulls = 0
+	where, _ := parseWhere(`size > 1MB && mediaType != "" && size < 1GB`)
+	if _, err := where.matches(item()); err != nil || pulls != 1 {
+		t.Fatalf("expected a single pull, got %d pulls and %v", pulls, err)
+	}
+	pulls = 0
+	where, _ = parseWhere(`age < 1d && size > 1MB`)
+	if _, err := where.matches(item()); err != nil || pulls != 0 {
+		t.Fatalf("expected no pull, got %d pulls and %v", pulls, err)
+	}
+}
+
+func TestWhereErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		message    string
+	}{
+		{``, "unexpected end of the expression"},
+		{`age > `, "unexpected end of the expression"},
+		{`age > 30d &&`, "unexpected end of the expression"},
+		{`(age > 30d`, `expected ")"`},
+		{`age > 30d)`, `unexpected ")" at position 10`},
+		{`name = "x"`, `unexpected '=' at position 6`},
+		{`name == "x`, "unterminated string at position 9"},
+		{`size > 30d`, `">" at position 6 compares a size with a duration`},
+		{`name > "a"`, "can't order strings"},
+		{`age`, "the expression is a duration, not a condition"},
+		{`age && true`, "must be conditions"},
+		{`!name`, "must be a condition"},
+		{`tag == "x"`, `unknown field "tag" at position 1`},
+		{`lastAccess > 30d`, "last pulled"},
+		{`name.matches("(")`, "invalid regular expression"},
+		{`name.equals("x")`, `unknown method "equals"`},
+		{`name.matches(1)`, "takes a string"},
+		{`size.matches("1")`, "only the strings have methods"},
+		{`age > 30x`, `invalid literal "30x" at position 7`},
+		{`size > 1.5.2MB`, "invalid size"},
+	}
+	for _, test := range tests {
+		_, err := parseWhere(test.expression)
+		if err == nil {
+			t.Fatalf("%s: expected an error", test.expression)
+		}
+		if exitCode(err) != exitCodeInvalidArguments {
+			t.Fatalf("%s: expected an invalid arguments error, got %v", test.expression, err)
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Fatalf("%s: expected an error containing %q, got %v", test.expression, test.message, err)
+		}
+	}
+}
+
+func TestPurgeWhere(t *testing.T) {
+	registry := newFakeRegistry()
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	registry.addManifest("repo", testDigest(1), old, "dev-1")
+	registry.addManifest("repo", testDigest(2), old, "release-1")
+	registry.addManifest("repo", testDigest(3), old, "dev-2")
+	registry.addManifest("repo", testDigest(4), time.Now(), "dev-3")
+	registry.addManifest("repo", testDigest(5), old)
+	registry.addManifest("repo", testDigest(6), old)
+	for _, digest := range []string{testDigest(1), testDigest(2), testDigest(4), testDigest(5)} {
+		registry.setLayers("repo", digest, map[string]int64{"sha256:layer": 600 * 1000 * 1000})
+	}
+	where, err := parseWhere(`age > 30d && !name.matches("^release-") && size > 500MB`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputText)
+	deleted, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "0d", where: where})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(registry.deletedTags["repo"], ","); deleted != 1 || got != "dev-1" {
+		t.Fatalf("expected dev-1 to be the only deleted tag, got %d deleted: %s", deleted, got)
+	}
+
+	// A dangling manifest has no name, so the expression selects it by its age and size.
+	deleted, err = PurgeDanglingManifests(context.Background(), registry, results, "repo", manifestPurgeOptions{ago: "0d", where: where})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deletedManifests := append([]string(nil), registry.deletedManifests["repo"]...)
+	sort.Strings(deletedManifests)
+	if got := strings.Join(deletedManifests, ","); deleted != 2 || got != testDigest(1)+","+testDigest(5) {
+		t.Fatalf("expected the large dangling manifests to be deleted, got %d deleted: %s", deleted, got)
+	}
+
+	registry.failOn("AcrGetManifest repo "+testDigest(3), errors.New("unavailable"))
+	if _, err := PurgeTags(context.Background(), registry, results, "repo", tagPurgeOptions{ago: "0d", where: where}); err == nil {
+		t.Fatalf("expected the failed pull to fail the purge")
+	}
+}
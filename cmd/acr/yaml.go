@@ -0,0 +1,80 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// writeYAML writes value as a YAML document. The value is encoded with encoding/json first so the YAML output has
+// the keys, the omitted fields and the field order of the JSON output.
+func writeYAML(out io.Writer, value interface{}) error {
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(&encoded)
+	decoder.UseNumber()
+	ordered, err := decodeOrdered(decoder)
+	if err != nil {
+		return err
+	}
+	document, err := yaml.Marshal(ordered)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(document)
+	return err
+}
+
+// decodeOrdered decodes the next JSON value of decoder, objects are decoded as yaml.MapSlice to keep their key order.
+func decodeOrdered(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '{' {
+			object := yaml.MapSlice{}
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeOrdered(decoder)
+				if err != nil {
+					return nil, err
+				}
+				object = append(object, yaml.MapItem{Key: key, Value: value})
+			}
+			// The closing delimiter.
+			_, err := decoder.Token()
+			return object, err
+		}
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err := decoder.Token()
+		return array, err
+	case json.Number:
+		if integer, err := token.Int64(); err == nil {
+			return integer, nil
+		}
+		return token.Float64()
+	default:
+		return token, nil
+	}
+}
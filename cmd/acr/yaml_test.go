@@ -0,0 +1,149 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// jsonValue converts a value decoded by gopkg.in/yaml.v2 into the values encoding/json decodes, so a document can be
+// compared with the JSON output.
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		object := map[string]interface{}{}
+		for key, item := range value {
+			object[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return object
+	case []interface{}:
+		array := []interface{}{}
+		for _, item := range value {
+			array = append(array, jsonValue(item))
+		}
+		return array
+	case int:
+		return float64(value)
+	case int64:
+		return float64(value)
+	default:
+		return value
+	}
+}
+
+// assertYAMLRoundTrip checks that the YAML document of value decodes to the same values as its JSON encoding.
+func assertYAMLRoundTrip(t *testing.T, value interface{}) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := writeYAML(&out, value); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var expected interface{}
+	if err := json.Unmarshal(encoded, &expected); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var decoded interface{}
+	if err := yaml.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("unexpected error %v decoding\n%s", err, out.String())
+	}
+	if decoded := jsonValue(decoded); !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("the YAML document doesn't round-trip, got %#v from\n%s\nexpected %#v", decoded, out.String(), expected)
+	}
+	return out.String()
+}
+
+func TestYAMLStrings(t *testing.T) {
+	assertYAMLRoundTrip(t, []string{
+		"hello-world", "team/repo", "sha256:abc", "v1.0", "", "1.0", "0x1F", "1e3", "true", "No", "y", "null", "~",
+		"key:", "a: b", "- item", "#comment", "2024-03-10T00:00:00", "say \"hi\" <now>", "line\nbreak", " padded ",
+	})
+}
+
+func TestPurgeSummaryYAML(t *testing.T) {
+	registry := newFakeRegistry()
+	now := time.Now()
+	registry.addManifest("repo", testDigest(1), now.Add(-72*time.Hour), "v1")
+	registry.addManifest("repo", testDigest(2), now, "latest")
+	registry.addManifest("repo", testDigest(3), now.Add(-72*time.Hour), "true")
+	registry.failOn("AcrDeleteTag repo true", &api.RegistryError{StatusCode: http.StatusServiceUnavailable, Code: "UNAVAILABLE", Message: "retry: later"})
+	results := newPurgeResults(ioutil.Discard, "registry.azurecr.io", outputYAML)
+	parameters := purgeParameters{concurrency: 1, repoName: "repo", ago: "1d"}
+	if _, _, err := purgeRepository(context.Background(), registry, results, nil, parameters); exitCode(err) != exitCodePartialFailure {
+		t.Fatalf("expected a partial failure, got %v", err)
+	}
+	results.recordRemaining(remainingItems{Repository: "repo", Tags: []string{"latest", "true"}, Manifests: []string{testDigest(2), testDigest(3)}})
+	var out bytes.Buffer
+	if err := results.writeSummary(&out, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	report := results.report(false)
+	if document := assertYAMLRoundTrip(t, report); out.String() != document {
+		t.Fatalf("the summary isn't the YAML document of the report, got\n%s\nexpected\n%s", out.String(), document)
+	}
+	expected := `deleted:
+- repository: repo
+  tag: v1
+  lastUpdateTime: "` + report.Deleted[0].LastUpdateTime + `"
+- repository: repo
+  digest: ` + testDigest(1) + `
+  lastUpdateTime: "` + report.Deleted[1].LastUpdateTime + `"
+notFound: []
+failed:
+- repository: repo
+  tag: "true"
+  reason: '` + report.Failed[0].Reason + `'
+  lastUpdateTime: "` + report.Failed[0].LastUpdateTime + `"
+remaining:
+- repository: repo
+  tags:
+  - latest
+  - "true"
+  manifests:
+  - ` + testDigest(2) + `
+  - ` + testDigest(3) + `
+`
+	if out.String() != expected {
+		t.Fatalf("YAML summary incorrect, got\n%s\nexpected\n%s", out.String(), expected)
+	}
+}
+
+func TestStatsYAML(t *testing.T) {
+	registry := newFakeRegistry()
+	registry.addManifest("repo", testDigest(1), time.Now().Add(-72*time.Hour), "v1", "v2")
+	registry.addManifest("repo", testDigest(2), time.Now())
+	stats, err := collectStats(context.Background(), registry, "repo", true)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var out bytes.Buffer
+	if err := writeStats(&out, stats, outputYAML); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if document := assertYAMLRoundTrip(t, stats); out.String() != document {
+		t.Fatalf("the stats aren't written as YAML, got\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "\noldestTag:\n  name: v1\n") || !strings.Contains(out.String(), "\ntagNames:\n- prefix: v1\n  count: 1\n") {
+		t.Fatalf("YAML stats incorrect, got\n%s", out.String())
+	}
+
+	// The empty and nested values.
+	assertYAMLRoundTrip(t, map[string]interface{}{"empty": map[string]interface{}{}, "none": []string{}, "nested": [][]int{{1, 2}, {}}, "null": nil})
+	assertYAMLRoundTrip(t, []string{})
+}
@@ -6,13 +6,13 @@ package api
 import (
 	"context"
 	"encoding/base64"
-	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 
+	"github.com/Azure/go-autorest/autorest"
 	acrapi "github.com/AzureCR/acr-cli/acr"
 	"github.com/mitchellh/mapstructure"
-	"github.com/pkg/errors"
 )
 
 const (
@@ -20,6 +20,86 @@ const (
 	registryURL = ".azurecr.io"
 )
 
+// AcrCLIClientInterface defines the registry operations used by the CLI commands.
+type AcrCLIClientInterface interface {
+	AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error)
+	AcrDeleteTag(ctx context.Context, repoName string, reference string) error
+	AcrListManifests(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.ManifestAttributeList, error)
+	DeleteManifest(ctx context.Context, repoName string, reference string) error
+	AcrListReferrers(ctx context.Context, repoName string, digest string) (*ReferrerList, error)
+	AcrListRepositories(ctx context.Context, last string) (*RepositoryList, error)
+	AcrListRepositoriesV2(ctx context.Context, last string) (*RepositoryList, error)
+	AcrGetRepositoryAttributes(ctx context.Context, repoName string) (*acrapi.RepositoryAttributes, error)
+	AcrGetRepositoryMetadata(ctx context.Context, repoName string, key string) ([]byte, error)
+	AcrCheckDeletePermission(ctx context.Context, repoName string) error
+	AcrGetManifest(ctx context.Context, repoName string, reference string) (*Manifest, error)
+	AcrGetManifestContent(ctx context.Context, repoName string, reference string) (*ManifestContent, error)
+	AcrPutManifest(ctx context.Context, repoName string, reference string, manifest *ManifestContent) error
+	AcrMountBlob(ctx context.Context, repoName string, sourceRepoName string, digest string) error
+}
+
+// AcrCLIClient is the AcrCLIClientInterface implementation that talks to a registry, it's safe to use from
+// concurrent goroutines.
+type AcrCLIClient struct {
+	loginURL       string
+	autorestClient autorest.Client
+
+	mu            sync.Mutex
+	auth          string
+	tokens        *TokenCredential
+	authenticated bool
+}
+
+// NewAcrCLIClient creates a client for the registry identified by loginURL, auth is sent as the authorization header.
+// The requests are sent with httpClient, the autorest default client is used when it's nil.
+func NewAcrCLIClient(loginURL string, auth string, httpClient *http.Client) *AcrCLIClient {
+	return &AcrCLIClient{
+		loginURL:       loginURL,
+		autorestClient: newAutorestClient(httpClient),
+		auth:           auth,
+	}
+}
+
+// newAutorestClient returns the autorest client shared by the generated clients of a registry client, it sends the
+// requests with httpClient when it isn't nil. Creating an autorest client sets a package-level transport of autorest,
+// so it's done once per registry client and not for every request, where the concurrent workers would race on it.
+func newAutorestClient(httpClient *http.Client) autorest.Client {
+	client := autorest.NewClientWithUserAgent(acrapi.UserAgent())
+	if httpClient != nil {
+		client.Sender = httpClient
+	}
+	return client
+}
+
+// newBaseClient returns a generated client like acrapi.NewWithBaseURI, sending its requests through client.
+func newBaseClient(client autorest.Client,
+	baseURI string,
+	name string,
+	reference string,
+	digest string,
+	metadata string,
+	property string,
+	authorization string,
+	orderby string,
+	n string,
+	last string,
+	digest1 string) acrapi.BaseClient {
+	return acrapi.BaseClient{
+		Client:        client,
+		BaseURI:       baseURI,
+		Name:          name,
+		Reference:     reference,
+		Digest:        digest,
+		Metadata:      metadata,
+		Property:      property,
+		Authorization: authorization,
+		Orderby:       orderby,
+		N:             n,
+		Last:          last,
+		Digest1:       digest1,
+	}
+}
+
 // BasicAuth returns the username and the passwrod encoded in base 64.
 func BasicAuth(username string, password string) string {
 	auth := username + ":" + password
@@ -29,7 +109,8 @@ func BasicAuth(username string, password string) string {
 // LoginURL returns the FQDN for a registry.
 func LoginURL(registryName string) string {
 	// TODO: if the registry is in another cloud (i.e. dogfood) a full FQDN for the registry should be specified.
-	if strings.Contains(registryName, ".") {
+	// A name with a port, like localhost:5000, is a full hostname as well.
+	if strings.ContainsAny(registryName, ".:") {
 		return registryName
 	}
 	return registryName + registryURL
@@ -45,14 +126,23 @@ func LoginURLWithPrefix(loginURL string) string {
 }
 
 // AcrListTags list the tags of a repository with their attributes.
-func AcrListTags(ctx context.Context,
-	loginURL string,
+func (c *AcrCLIClient) AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error) {
+	var result *acrapi.TagAttributeList
+	err := c.withAuthorization(ctx, RepositoryScope(repoName), func(auth string) error {
+		var err error
+		result, err = c.acrListTags(ctx, auth, repoName, orderBy, last)
+		return err
+	})
+	return result, err
+}
+
+func (c *AcrCLIClient) acrListTags(ctx context.Context,
 	auth string,
 	repoName string,
 	orderBy string,
 	last string) (*acrapi.TagAttributeList, error) {
-	hostname := LoginURLWithPrefix(loginURL)
-	client := acrapi.NewWithBaseURI(hostname,
+	hostname := LoginURLWithPrefix(c.loginURL)
+	client := newBaseClient(c.autorestClient, hostname,
 		repoName,
 		"",
 		"",
@@ -65,7 +155,7 @@ func AcrListTags(ctx context.Context,
 		"")
 	tags, err := client.AcrListTags(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fromAutorestError(err)
 	}
 	var listTagResult acrapi.TagAttributeList
 	switch tags.StatusCode {
@@ -76,28 +166,26 @@ func AcrListTags(ctx context.Context,
 		return &listTagResult, nil
 
 	case http.StatusUnauthorized, http.StatusNotFound:
-		var apiError acrapi.Error
-		if err = mapstructure.Decode(tags.Value, &apiError); err != nil {
-			return nil, errors.Wrap(err, "unable to decode error")
-		}
-		if apiError.Errors != nil && len(*apiError.Errors) > 0 {
-			return nil, fmt.Errorf("%s %s", *(*apiError.Errors)[0].Code, *(*apiError.Errors)[0].Message)
-		}
-		return nil, errors.New("unable to decode apiError")
+		return nil, newRegistryError(tags.StatusCode, tags.Value)
 
 	default:
-		return nil, fmt.Errorf("unexpected response code: %v", tags.StatusCode)
+		return nil, &RegistryError{StatusCode: tags.StatusCode}
 	}
 }
 
 // AcrDeleteTag deletes the tag by reference.
-func AcrDeleteTag(ctx context.Context,
-	loginURL string,
+func (c *AcrCLIClient) AcrDeleteTag(ctx context.Context, repoName string, reference string) error {
+	return c.withAuthorization(ctx, RepositoryScope(repoName), func(auth string) error {
+		return c.acrDeleteTag(ctx, auth, repoName, reference)
+	})
+}
+
+func (c *AcrCLIClient) acrDeleteTag(ctx context.Context,
 	auth string,
 	repoName string,
 	reference string) error {
-	hostname := LoginURLWithPrefix(loginURL)
-	client := acrapi.NewWithBaseURI(hostname,
+	hostname := LoginURLWithPrefix(c.loginURL)
+	client := newBaseClient(c.autorestClient, hostname,
 		repoName,
 		reference,
 		"",
@@ -110,35 +198,38 @@ func AcrDeleteTag(ctx context.Context,
 		"")
 	tag, err := client.AcrDeleteTag(ctx)
 	if err != nil {
-		return err
+		// The generated client doesn't expect a 405, the answer to a deletion disabled by a policy.
+		return deletionError(fromAutorestError(err), repoName, reference)
 	}
 	switch tag.StatusCode {
 	case http.StatusAccepted:
 		return nil
 	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusMethodNotAllowed:
-		var apiError acrapi.Error
-		if err = mapstructure.Decode(tag, &apiError); err != nil {
-			return errors.Wrap(err, "unable to decode error")
-		}
-		if apiError.Errors != nil && len(*apiError.Errors) > 0 {
-			return fmt.Errorf("%s %s", *(*apiError.Errors)[0].Code, *(*apiError.Errors)[0].Message)
-		}
-		return errors.New("unable to decode apiError")
+		return deletionError(newRegistryError(tag.StatusCode, tag.Value), repoName, reference)
 
 	default:
-		return fmt.Errorf("unexpected response code: %v", tag.StatusCode)
+		return &RegistryError{StatusCode: tag.StatusCode}
 	}
 }
 
 // AcrListManifests list all the manifest in a repository with their attributes.
-func AcrListManifests(ctx context.Context,
-	loginURL string,
+func (c *AcrCLIClient) AcrListManifests(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.ManifestAttributeList, error) {
+	var result *acrapi.ManifestAttributeList
+	err := c.withAuthorization(ctx, RepositoryScope(repoName), func(auth string) error {
+		var err error
+		result, err = c.acrListManifests(ctx, auth, repoName, orderBy, last)
+		return err
+	})
+	return result, err
+}
+
+func (c *AcrCLIClient) acrListManifests(ctx context.Context,
 	auth string,
 	repoName string,
 	orderBy string,
 	last string) (*acrapi.ManifestAttributeList, error) {
-	hostname := LoginURLWithPrefix(loginURL)
-	client := acrapi.NewWithBaseURI(hostname,
+	hostname := LoginURLWithPrefix(c.loginURL)
+	client := newBaseClient(c.autorestClient, hostname,
 		repoName,
 		"",
 		"",
@@ -151,7 +242,7 @@ func AcrListManifests(ctx context.Context,
 		"")
 	manifests, err := client.AcrListManifests(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fromAutorestError(err)
 	}
 	switch manifests.StatusCode {
 	case http.StatusOK:
@@ -162,28 +253,26 @@ func AcrListManifests(ctx context.Context,
 		return &acrListManifestsAttributesResult, nil
 
 	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusMethodNotAllowed:
-		var apiError acrapi.Error
-		if err = mapstructure.Decode(manifests.Value, &apiError); err != nil {
-			return nil, errors.Wrap(err, "unable to decode error")
-		}
-		if apiError.Errors != nil && len(*apiError.Errors) > 0 {
-			return nil, fmt.Errorf("%s %s", *(*apiError.Errors)[0].Code, *(*apiError.Errors)[0].Message)
-		}
-		return nil, errors.New("unable to decode apiError")
+		return nil, newRegistryError(manifests.StatusCode, manifests.Value)
 
 	default:
-		return nil, fmt.Errorf("unexpected response code: %v", manifests.StatusCode)
+		return nil, &RegistryError{StatusCode: manifests.StatusCode}
 	}
 }
 
 // DeleteManifest deletes a manifest using the digest as a reference.
-func DeleteManifest(ctx context.Context,
-	loginURL string,
+func (c *AcrCLIClient) DeleteManifest(ctx context.Context, repoName string, reference string) error {
+	return c.withAuthorization(ctx, RepositoryScope(repoName), func(auth string) error {
+		return c.deleteManifest(ctx, auth, repoName, reference)
+	})
+}
+
+func (c *AcrCLIClient) deleteManifest(ctx context.Context,
 	auth string,
 	repoName string,
 	reference string) error {
-	hostname := LoginURLWithPrefix(loginURL)
-	client := acrapi.NewWithBaseURI(hostname,
+	hostname := LoginURLWithPrefix(c.loginURL)
+	client := newBaseClient(c.autorestClient, hostname,
 		repoName,
 		reference,
 		"",
@@ -196,23 +285,17 @@ func DeleteManifest(ctx context.Context,
 		"")
 	deleteManifest, err := client.DeleteManifest(ctx)
 	if err != nil {
-		return err
+		// The generated client doesn't expect a 405, the answer to a deletion disabled by a policy.
+		return deletionError(fromAutorestError(err), repoName, reference)
 	}
 	switch deleteManifest.StatusCode {
 	case http.StatusAccepted:
 		return nil
 
 	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusMethodNotAllowed:
-		var apiError acrapi.Error
-		if err = mapstructure.Decode(deleteManifest, &apiError); err != nil {
-			return errors.Wrap(err, "unable to decode error")
-		}
-		if apiError.Errors != nil && len(*apiError.Errors) > 0 {
-			return fmt.Errorf("%s %s", *(*apiError.Errors)[0].Code, *(*apiError.Errors)[0].Message)
-		}
-		return errors.New("unable to decode apiError")
+		return deletionError(newRegistryError(deleteManifest.StatusCode, deleteManifest.Value), repoName, reference)
 
 	default:
-		return fmt.Errorf("unexpected response code: %v", deleteManifest.StatusCode)
+		return &RegistryError{StatusCode: deleteManifest.StatusCode}
 	}
 }
@@ -3,7 +3,15 @@
 
 package api
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api/acrtest"
+)
 
 func TestBasicAuth(t *testing.T) {
 	expectedReturn := "Basic cmVnaXN0cnl1c2VyOnJlZ2lzdHJ5dXNlcnBhc3N3b3Jk"
@@ -49,4 +57,59 @@ func TestLoginURL(t *testing.T) {
 	if loginURL != expectedReturn {
 		t.Fatalf("LoginURL of %s incorrect, got %s, expected %s", registryName, loginURL, expectedReturn)
 	}
+
+	expectedReturn = "localhost:5000"
+	registryName = "localhost:5000"
+	loginURL = LoginURL(registryName)
+
+	if loginURL != expectedReturn {
+		t.Fatalf("LoginURL of %s incorrect, got %s, expected %s", registryName, loginURL, expectedReturn)
+	}
+}
+
+func TestAcrListTags(t *testing.T) {
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	for i := 0; i < 150; i++ {
+		registry.AddImage("team/repo", time.Now(), fmt.Sprintf("v%03d", i))
+	}
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	acrClient := NewAcrCLIClient(registry.LoginURL(), BasicAuth("user", "password"), httpClient)
+
+	var tags []string
+	last := ""
+	for {
+		page, err := acrClient.AcrListTags(context.Background(), "team/repo", "", last)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if page.Tags == nil || len(*page.Tags) == 0 {
+			break
+		}
+		for _, tag := range *page.Tags {
+			tags = append(tags, *tag.Name)
+		}
+		last = tags[len(tags)-1]
+	}
+	if len(tags) != 150 || tags[0] != "v000" || tags[149] != "v149" {
+		t.Fatalf("expected the 150 tags in two pages, got %d tags", len(tags))
+	}
+
+	// A throttled listing is retried.
+	registry.Fail(http.MethodGet, "/acr/v1/team/repo/_tags", http.StatusTooManyRequests)
+	before := registry.Requests(http.MethodGet, "/acr/v1/team/repo/_tags")
+	if _, err := acrClient.AcrListTags(context.Background(), "team/repo", "", ""); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if requests := registry.Requests(http.MethodGet, "/acr/v1/team/repo/_tags") - before; requests != 2 {
+		t.Fatalf("expected the throttled listing to be sent again, got %d requests", requests)
+	}
+
+	_, err = acrClient.AcrListTags(context.Background(), "missing", "", "")
+	if registryError, ok := err.(*RegistryError); !ok || registryError.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a 404 registry error, got %v", err)
+	}
 }
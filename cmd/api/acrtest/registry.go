@@ -0,0 +1,651 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+// Package acrtest provides an in-memory registry that answers the ACR and Distribution API requests of the CLI, so the
+// API client and the commands can be tested end to end without a registry. The registry is served over HTTPS, or its
+// operations are called in process by a fake client, and both answer the same way.
+package acrtest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+)
+
+const (
+	imageMediaType = "application/vnd.oci.image.manifest.v1+json"
+	indexMediaType = "application/vnd.oci.image.index.v1+json"
+	// defaultPageSize is the number of items in a page when the request doesn't set n.
+	defaultPageSize = 100
+)
+
+// Registry is an in-memory registry. The repositories, tags, manifests and blobs are seeded with AddImage, AddIndex
+// and AddManifest, and the requests change them like a registry would. Any credentials are accepted, a request
+// without an Authorization header is rejected.
+type Registry struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	repositories map[string]*repository
+	failures     map[string][]int
+	requests     map[string]int
+	images       int
+	uploads      int
+	maxPageSize  int
+}
+
+type repository struct {
+	tags      map[string]*tag
+	manifests map[string]*manifest
+	blobs     map[string][]byte
+	metadata  map[string]json.RawMessage
+}
+
+type tag struct {
+	digest         string
+	createdTime    time.Time
+	lastUpdateTime time.Time
+	locked         bool
+}
+
+type manifest struct {
+	mediaType      string
+	content        []byte
+	subject        string
+	artifactType   string
+	annotations    map[string]string
+	lastUpdateTime time.Time
+	locked         bool
+}
+
+// Descriptor is the part of an OCI descriptor the registry needs.
+type Descriptor struct {
+	MediaType    string            `json:"mediaType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// document is the part of a manifest or an index the registry needs.
+type document struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        *Descriptor       `json:"config,omitempty"`
+	Layers        []Descriptor      `json:"layers,omitempty"`
+	Manifests     []Descriptor      `json:"manifests,omitempty"`
+	Subject       *Descriptor       `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Error is returned by the operations of the registry, the HTTP requests are answered with its status code and code.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s", e.Code, e.Message)
+}
+
+// NewRegistry starts a registry served over HTTPS, it must be closed with Close. The clients must skip the
+// verification of its certificate, like the ones created with api.TransportOptions{Insecure: true}.
+func NewRegistry() *Registry {
+	r := NewMemoryRegistry()
+	r.server = httptest.NewTLSServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+// NewMemoryRegistry returns a registry that isn't served, its operations are only called in process.
+func NewMemoryRegistry() *Registry {
+	return &Registry{
+		repositories: map[string]*repository{},
+		failures:     map[string][]int{},
+		requests:     map[string]int{},
+	}
+}
+
+// SetMaxPageSize caps the number of items in a page served over HTTP, like registries that ignore a larger n, so the
+// paging of the clients is exercised with few items.
+func (r *Registry) SetMaxPageSize(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maxPageSize = n
+}
+
+// Close shuts the registry down.
+func (r *Registry) Close() {
+	if r.server != nil {
+		r.server.Close()
+	}
+}
+
+// LoginURL returns the host and port of the registry, to use as the login URL of a client.
+func (r *Registry) LoginURL() string {
+	return strings.TrimPrefix(r.server.URL, "https://")
+}
+
+// AddImage adds an image manifest with a config and a layer of its own to repoName, tagged with tags, and returns its
+// digest. An image added without tags is dangling.
+func (r *Registry) AddImage(repoName string, lastUpdateTime time.Time, tags ...string) string {
+	r.mu.Lock()
+	r.images++
+	image := r.images
+	r.mu.Unlock()
+	config := r.PutBlob(repoName, []byte(fmt.Sprintf("config %d", image)))
+	layer := r.PutBlob(repoName, []byte(fmt.Sprintf("layer %d", image)))
+	encoded, _ := json.Marshal(document{
+		SchemaVersion: 2,
+		MediaType:     imageMediaType,
+		Config:        &Descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: config, Size: 100},
+		Layers:        []Descriptor{{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: layer, Size: 1000}},
+	})
+	return r.AddManifest(repoName, encoded, lastUpdateTime, tags...)
+}
+
+// AddIndex adds a multi-arch image index referencing the manifests of repoName identified by children, tagged with
+// tags, and returns its digest.
+func (r *Registry) AddIndex(repoName string, children []string, lastUpdateTime time.Time, tags ...string) string {
+	index := document{SchemaVersion: 2, MediaType: indexMediaType}
+	r.mu.Lock()
+	for _, child := range children {
+		child := Descriptor{MediaType: imageMediaType, Digest: child}
+		if repo, ok := r.repositories[repoName]; ok && repo.manifests[child.Digest] != nil {
+			child.MediaType = repo.manifests[child.Digest].mediaType
+			child.Size = int64(len(repo.manifests[child.Digest].content))
+		}
+		index.Manifests = append(index.Manifests, child)
+	}
+	r.mu.Unlock()
+	encoded, _ := json.Marshal(index)
+	return r.AddManifest(repoName, encoded, lastUpdateTime, tags...)
+}
+
+// AddManifest adds the manifest encoded in data to repoName, tagged with tags, and returns its digest. The media type
+// and the subject are read from data.
+func (r *Registry) AddManifest(repoName string, data []byte, lastUpdateTime time.Time, tags ...string) string {
+	digest := blobDigest(data)
+	r.AddManifestDigest(repoName, digest, data, lastUpdateTime, tags...)
+	return digest
+}
+
+// AddManifestDigest adds the manifest encoded in data to repoName like AddManifest, under the given digest rather
+// than the digest of data.
+func (r *Registry) AddManifestDigest(repoName string, digest string, data []byte, lastUpdateTime time.Time, tags ...string) {
+	var decoded document
+	json.Unmarshal(data, &decoded)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo := r.repository(repoName)
+	stored := &manifest{mediaType: decoded.MediaType, lastUpdateTime: lastUpdateTime.UTC()}
+	stored.setContent(data, decoded)
+	repo.manifests[digest] = stored
+	for _, name := range tags {
+		repo.tags[name] = &tag{digest: digest, createdTime: lastUpdateTime.UTC(), lastUpdateTime: lastUpdateTime.UTC()}
+	}
+}
+
+// setContent replaces the content of m with data, decoded in decoded.
+func (m *manifest) setContent(data []byte, decoded document) {
+	m.content = data
+	m.subject = ""
+	if decoded.Subject != nil {
+		m.subject = decoded.Subject.Digest
+	}
+	m.annotations = decoded.Annotations
+	m.artifactType = decoded.ArtifactType
+	if len(m.artifactType) == 0 && decoded.Config != nil {
+		m.artifactType = decoded.Config.MediaType
+	}
+}
+
+// AddBlob makes the blob identified by digest present in repoName, so it can be mounted from there.
+func (r *Registry) AddBlob(repoName string, digest string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.repository(repoName).blobs[digest] = nil
+}
+
+// PutBlob stores data as a blob of repoName and returns its digest.
+func (r *Registry) PutBlob(repoName string, data []byte) string {
+	digest := blobDigest(data)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.repository(repoName).blobs[digest] = data
+	return digest
+}
+
+// SetMetadata stores value, encoded in JSON, under key in the metadata of repoName.
+func (r *Registry) SetMetadata(repoName string, key string, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.repository(repoName).metadata[key] = json.RawMessage(value)
+}
+
+// SetMediaType changes the media type the manifest identified by digest is listed and served with.
+func (r *Registry) SetMediaType(repoName string, digest string, mediaType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.repository(repoName).manifests[digest]; ok {
+		m.mediaType = mediaType
+	}
+}
+
+// SetContent replaces the content of the manifest identified by digest, its digest and media type are kept.
+func (r *Registry) SetContent(repoName string, digest string, data []byte) {
+	var decoded document
+	json.Unmarshal(data, &decoded)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.repository(repoName).manifests[digest]; ok {
+		m.setContent(data, decoded)
+	}
+}
+
+// SetLastUpdateTime changes the last update time of a tag of repoName, or of a manifest when reference is a digest.
+// The time is listed with the offset of its location.
+func (r *Registry) SetLastUpdateTime(repoName string, reference string, lastUpdateTime time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo := r.repository(repoName)
+	if t, ok := repo.tags[reference]; ok {
+		t.lastUpdateTime = lastUpdateTime
+	}
+	if m, ok := repo.manifests[reference]; ok {
+		m.lastUpdateTime = lastUpdateTime
+	}
+}
+
+// SetCreatedTime changes the creation time of a tag of repoName.
+func (r *Registry) SetCreatedTime(repoName string, name string, createdTime time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.repository(repoName).tags[name]; ok {
+		t.createdTime = createdTime.UTC()
+	}
+}
+
+// Lock disables deleting a tag of repoName, or a manifest when reference is a digest.
+func (r *Registry) Lock(repoName string, reference string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo := r.repository(repoName)
+	if t, ok := repo.tags[reference]; ok {
+		t.locked = true
+	}
+	if m, ok := repo.manifests[reference]; ok {
+		m.locked = true
+	}
+}
+
+// Tags returns the tags of repoName sorted by name.
+func (r *Registry) Tags(repoName string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tags []string
+	if repo, ok := r.repositories[repoName]; ok {
+		for name := range repo.tags {
+			tags = append(tags, name)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Manifests returns the digests of the manifests of repoName sorted.
+func (r *Registry) Manifests(repoName string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var digests []string
+	if repo, ok := r.repositories[repoName]; ok {
+		for digest := range repo.manifests {
+			digests = append(digests, digest)
+		}
+	}
+	sort.Strings(digests)
+	return digests
+}
+
+// ListRepositories returns the names of the repositories sorting after last, at most n of them.
+func (r *Registry) ListRepositories(last string, n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for name := range r.repositories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return page(names, last, n)
+}
+
+// RepositoryAttributes returns the attributes of repoName.
+func (r *Registry) RepositoryAttributes(repoName string) (*acrapi.RepositoryAttributes, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.lookup(repoName)
+	if err != nil {
+		return nil, err
+	}
+	tagCount, manifestCount := float64(len(repo.tags)), float64(len(repo.manifests))
+	return &acrapi.RepositoryAttributes{ImageName: &repoName, TagCount: &tagCount, ManifestCount: &manifestCount}, nil
+}
+
+// ListTags returns the tags of repoName sorted by name, or by time with orderBy timedesc or timeasc, after the tag
+// named last and at most n of them. Like the registry, the tags are omitted once the listing is over.
+func (r *Registry) ListTags(repoName string, orderBy string, last string, n int) (*acrapi.TagAttributeList, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.lookup(repoName)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range repo.tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	switch orderBy {
+	case "timedesc":
+		sort.SliceStable(names, func(i, j int) bool {
+			return repo.tags[names[i]].lastUpdateTime.After(repo.tags[names[j]].lastUpdateTime)
+		})
+	case "timeasc":
+		sort.SliceStable(names, func(i, j int) bool {
+			return repo.tags[names[i]].lastUpdateTime.Before(repo.tags[names[j]].lastUpdateTime)
+		})
+	}
+	var tags []acrapi.TagAttributesBase
+	for _, name := range page(names, last, n) {
+		name, t := name, repo.tags[name]
+		digest := t.digest
+		createdTime := t.createdTime.Format(time.RFC3339Nano)
+		lastUpdateTime := t.lastUpdateTime.Format(time.RFC3339Nano)
+		attributes := &acrapi.TagAttributesBaseChangeableAttributes{DeleteEnabled: boolPtr(!t.locked), WriteEnabled: boolPtr(!t.locked)}
+		tags = append(tags, acrapi.TagAttributesBase{
+			Name:                 &name,
+			Digest:               &digest,
+			CreatedTime:          &createdTime,
+			LastUpdateTime:       &lastUpdateTime,
+			ChangeableAttributes: attributes,
+		})
+	}
+	list := &acrapi.TagAttributeList{ImageName: &repoName}
+	if len(tags) > 0 {
+		list.Tags = &tags
+	}
+	return list, nil
+}
+
+// ListManifests returns the manifests of repoName with their tags, sorted by digest, after the digest last and at
+// most n of them.
+func (r *Registry) ListManifests(repoName string, last string, n int) (*acrapi.ManifestAttributeList, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.lookup(repoName)
+	if err != nil {
+		return nil, err
+	}
+	var digests []string
+	for digest := range repo.manifests {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+	var manifests []acrapi.ManifestAttributesBase
+	for _, digest := range page(digests, last, n) {
+		digest, m := digest, repo.manifests[digest]
+		lastUpdateTime := m.lastUpdateTime.Format(time.RFC3339Nano)
+		attributes := &acrapi.ManifestAttributesBaseChangeableAttributes{DeleteEnabled: boolPtr(!m.locked), WriteEnabled: boolPtr(!m.locked)}
+		listed := acrapi.ManifestAttributesBase{
+			Digest:               &digest,
+			CreatedTime:          &lastUpdateTime,
+			LastUpdateTime:       &lastUpdateTime,
+			ChangeableAttributes: attributes,
+		}
+		if len(m.mediaType) > 0 {
+			mediaType := m.mediaType
+			listed.MediaType = &mediaType
+		}
+		var tags []string
+		for name, t := range repo.tags {
+			if t.digest == digest {
+				tags = append(tags, name)
+			}
+		}
+		if len(tags) > 0 {
+			sort.Strings(tags)
+			listed.Tags = &tags
+		}
+		manifests = append(manifests, listed)
+	}
+	list := &acrapi.ManifestAttributeList{ImageName: &repoName}
+	if len(manifests) > 0 {
+		list.Manifests = &manifests
+	}
+	return list, nil
+}
+
+// DeleteTag deletes a tag of repoName, the manifest it references is kept.
+func (r *Registry) DeleteTag(repoName string, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.lookup(repoName)
+	if err != nil {
+		return err
+	}
+	return deleteTag(repo, repoName, name)
+}
+
+// deleteTag deletes a tag of repo. r.mu must be held.
+func deleteTag(repo *repository, repoName string, name string) error {
+	t, ok := repo.tags[name]
+	switch {
+	case !ok:
+		return &Error{StatusCode: http.StatusNotFound, Code: "TAG_UNKNOWN", Message: fmt.Sprintf("tag %s not found in %s", name, repoName)}
+	case t.locked:
+		return &Error{StatusCode: http.StatusMethodNotAllowed, Code: "OPERATION_DISALLOWED", Message: "the tag is locked"}
+	}
+	delete(repo.tags, name)
+	return nil
+}
+
+// DeleteManifest deletes a manifest of repoName and its tags. Like the Distribution API allows, a reference that is
+// a tag deletes the tag only.
+func (r *Registry) DeleteManifest(repoName string, reference string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.lookup(repoName)
+	if err != nil {
+		return err
+	}
+	if _, ok := repo.tags[reference]; ok {
+		return deleteTag(repo, repoName, reference)
+	}
+	m, ok := repo.manifests[reference]
+	switch {
+	case !ok:
+		return &Error{StatusCode: http.StatusNotFound, Code: "MANIFEST_UNKNOWN", Message: fmt.Sprintf("manifest %s not found in %s", reference, repoName)}
+	case m.locked:
+		return &Error{StatusCode: http.StatusMethodNotAllowed, Code: "OPERATION_DISALLOWED", Message: "the manifest is locked"}
+	}
+	delete(repo.manifests, reference)
+	for name, t := range repo.tags {
+		if t.digest == reference {
+			delete(repo.tags, name)
+		}
+	}
+	return nil
+}
+
+// Metadata returns the value stored under key in the metadata of repoName, encoded in JSON.
+func (r *Registry) Metadata(repoName string, key string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.lookup(repoName)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := repo.metadata[key]
+	if !ok {
+		return nil, &Error{StatusCode: http.StatusNotFound, Code: "METADATA_UNKNOWN", Message: fmt.Sprintf("metadata %s not found", key)}
+	}
+	return value, nil
+}
+
+// Manifest returns the digest, the media type and the content of the manifest of repoName identified by reference,
+// a tag or a digest.
+func (r *Registry) Manifest(repoName string, reference string) (string, string, []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.lookup(repoName)
+	if err != nil {
+		return "", "", nil, err
+	}
+	digest := reference
+	if t, ok := repo.tags[reference]; ok {
+		digest = t.digest
+	}
+	m, ok := repo.manifests[digest]
+	if !ok {
+		return "", "", nil, &Error{StatusCode: http.StatusNotFound, Code: "MANIFEST_UNKNOWN", Message: fmt.Sprintf("manifest %s not found in %s", reference, repoName)}
+	}
+	return digest, m.mediaType, m.content, nil
+}
+
+// PutManifest stores the manifest encoded in data under digest in repoName, tagged with reference unless it's the
+// digest. A tag that referenced another manifest is moved.
+func (r *Registry) PutManifest(repoName string, reference string, digest string, mediaType string, data []byte) {
+	var decoded document
+	json.Unmarshal(data, &decoded)
+	now := time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo := r.repository(repoName)
+	stored := &manifest{mediaType: mediaType, lastUpdateTime: now}
+	stored.setContent(data, decoded)
+	repo.manifests[digest] = stored
+	if reference != digest {
+		repo.tags[reference] = &tag{digest: digest, createdTime: now, lastUpdateTime: now}
+	}
+}
+
+// Referrers returns the manifests of repoName whose subject is digest, sorted by digest.
+func (r *Registry) Referrers(repoName string, digest string) ([]Descriptor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.lookup(repoName)
+	if err != nil {
+		return nil, err
+	}
+	referrers := []Descriptor{}
+	for referrer, m := range repo.manifests {
+		if m.subject == digest {
+			referrers = append(referrers, Descriptor{
+				MediaType:    m.mediaType,
+				Digest:       referrer,
+				Size:         int64(len(m.content)),
+				ArtifactType: m.artifactType,
+				Annotations:  m.annotations,
+			})
+		}
+	}
+	sort.Slice(referrers, func(i, j int) bool { return referrers[i].Digest < referrers[j].Digest })
+	return referrers, nil
+}
+
+// MountBlob makes the blob identified by digest present in repoName when it's in sourceRepoName, and reports whether
+// it was.
+func (r *Registry) MountBlob(repoName string, sourceRepoName string, digest string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	source, ok := r.repositories[sourceRepoName]
+	if !ok {
+		return false
+	}
+	data, ok := source.blobs[digest]
+	if ok {
+		r.repository(repoName).blobs[digest] = data
+	}
+	return ok
+}
+
+// Blob returns the content of a blob of repoName.
+func (r *Registry) Blob(repoName string, digest string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.lookup(repoName)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := repo.blobs[digest]
+	if !ok {
+		return nil, &Error{StatusCode: http.StatusNotFound, Code: "BLOB_UNKNOWN", Message: fmt.Sprintf("blob %s not found", digest)}
+	}
+	return data, nil
+}
+
+// repository returns repoName, creating it when it doesn't exist. r.mu must be held.
+func (r *Registry) repository(repoName string) *repository {
+	repo, ok := r.repositories[repoName]
+	if !ok {
+		repo = &repository{tags: map[string]*tag{}, manifests: map[string]*manifest{}, blobs: map[string][]byte{}, metadata: map[string]json.RawMessage{}}
+		r.repositories[repoName] = repo
+	}
+	return repo
+}
+
+// lookup returns repoName, or a 404 error when it doesn't exist. r.mu must be held.
+func (r *Registry) lookup(repoName string) (*repository, error) {
+	repo, ok := r.repositories[repoName]
+	if !ok {
+		return nil, &Error{StatusCode: http.StatusNotFound, Code: "NAME_UNKNOWN", Message: fmt.Sprintf("repository %s not found", repoName)}
+	}
+	return repo, nil
+}
+
+// page returns the names after last, at most n of them, or defaultPageSize when n isn't positive. When last was
+// deleted since the previous page, the names sorting after it are returned.
+func page(names []string, last string, n int) []string {
+	if len(last) > 0 {
+		found := false
+		for i, name := range names {
+			if name == last {
+				names, found = names[i+1:], true
+				break
+			}
+		}
+		if !found {
+			var after []string
+			for _, name := range names {
+				if name > last {
+					after = append(after, name)
+				}
+			}
+			names = after
+		}
+	}
+	if n <= 0 {
+		n = defaultPageSize
+	}
+	if len(names) > n {
+		names = names[:n]
+	}
+	return names
+}
+
+func blobDigest(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
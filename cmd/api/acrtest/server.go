@@ -0,0 +1,279 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package acrtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Fail makes the registry answer the next requests with method to path, like /acr/v1/repo/_tags, with the status
+// codes in order before answering them normally again. A 429 is sent with a Retry-After of one second.
+func (r *Registry) Fail(method string, path string, statusCodes ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := method + " " + path
+	r.failures[key] = append(r.failures[key], statusCodes...)
+}
+
+// Requests returns the number of requests received with method to path, including the failed ones.
+func (r *Registry) Requests(method string, path string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests[method+" "+path]
+}
+
+// injectedFailure counts the request and returns the status code it has to be answered with, or 0 when it's answered
+// normally.
+func (r *Registry) injectedFailure(req *http.Request) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := req.Method + " " + req.URL.Path
+	r.requests[key]++
+	statusCodes := r.failures[key]
+	if len(statusCodes) == 0 {
+		return 0
+	}
+	r.failures[key] = statusCodes[1:]
+	return statusCodes[0]
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if statusCode := r.injectedFailure(req); statusCode != 0 {
+		if statusCode == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		writeError(w, &Error{StatusCode: statusCode, Code: "INJECTED", Message: "injected failure"})
+		return
+	}
+	if len(req.Header.Get("Authorization")) == 0 {
+		writeError(w, &Error{StatusCode: http.StatusUnauthorized, Code: "UNAUTHORIZED", Message: "authentication required"})
+		return
+	}
+
+	path := req.URL.Path
+	switch {
+	case (path == "/acr/v1/_catalog" || path == "/v2/_catalog") && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string][]string{"repositories": r.ListRepositories(req.URL.Query().Get("last"), r.pageSize(req))})
+	case strings.HasPrefix(path, "/acr/v1/"):
+		r.serveACR(w, req, strings.TrimPrefix(path, "/acr/v1/"))
+	case strings.HasPrefix(path, "/v2/"):
+		r.serveDistribution(w, req, strings.TrimPrefix(path, "/v2/"))
+	default:
+		writeError(w, &Error{StatusCode: http.StatusNotFound, Code: "NAME_UNKNOWN", Message: "not found"})
+	}
+}
+
+// serveACR answers the requests of the ACR API, path is the part after /acr/v1/.
+func (r *Registry) serveACR(w http.ResponseWriter, req *http.Request, path string) {
+	if i := strings.LastIndex(path, "/_tags"); i >= 0 {
+		switch name := strings.TrimPrefix(path[i+len("/_tags"):], "/"); {
+		case len(name) == 0 && req.Method == http.MethodGet:
+			tags, err := r.ListTags(path[:i], req.URL.Query().Get("orderby"), req.URL.Query().Get("last"), r.pageSize(req))
+			writeResult(w, http.StatusOK, tags, err)
+		case len(name) > 0 && req.Method == http.MethodDelete:
+			writeResult(w, http.StatusAccepted, nil, r.DeleteTag(path[:i], name))
+		default:
+			writeUnsupported(w)
+		}
+		return
+	}
+	if i := strings.LastIndex(path, "/_manifests"); i >= 0 && req.Method == http.MethodGet && len(path[i+len("/_manifests"):]) == 0 {
+		manifests, err := r.ListManifests(path[:i], req.URL.Query().Get("last"), r.pageSize(req))
+		writeResult(w, http.StatusOK, manifests, err)
+		return
+	}
+	if i := strings.LastIndex(path, "/_metadata/"); i >= 0 && req.Method == http.MethodGet {
+		value, err := r.Metadata(path[:i], path[i+len("/_metadata/"):])
+		writeResult(w, http.StatusOK, json.RawMessage(value), err)
+		return
+	}
+	if req.Method == http.MethodGet && !strings.Contains(path, "/_") {
+		attributes, err := r.RepositoryAttributes(path)
+		writeResult(w, http.StatusOK, attributes, err)
+		return
+	}
+	writeUnsupported(w)
+}
+
+// serveDistribution answers the requests of the Distribution API, path is the part after /v2/.
+func (r *Registry) serveDistribution(w http.ResponseWriter, req *http.Request, path string) {
+	switch {
+	case strings.HasSuffix(path, "/tags/list") && req.Method == http.MethodGet:
+		repoName := strings.TrimSuffix(path, "/tags/list")
+		tags, err := r.ListTags(repoName, "", req.URL.Query().Get("last"), r.pageSize(req))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		names := []string{}
+		if tags.Tags != nil {
+			for _, t := range *tags.Tags {
+				names = append(names, *t.Name)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": repoName, "tags": names})
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		repoName, reference := path[:i], path[i+len("/manifests/"):]
+		switch req.Method {
+		case http.MethodGet:
+			r.getManifest(w, req, repoName, reference)
+		case http.MethodPut:
+			r.putManifest(w, req, repoName, reference)
+		case http.MethodDelete:
+			writeResult(w, http.StatusAccepted, nil, r.DeleteManifest(repoName, reference))
+		default:
+			writeUnsupported(w)
+		}
+	case strings.Contains(path, "/referrers/") && req.Method == http.MethodGet:
+		i := strings.LastIndex(path, "/referrers/")
+		referrers, err := r.Referrers(path[:i], path[i+len("/referrers/"):])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", indexMediaType)
+		writeJSON(w, http.StatusOK, document{SchemaVersion: 2, MediaType: indexMediaType, Manifests: referrers})
+	case strings.HasSuffix(path, "/blobs/uploads/") && req.Method == http.MethodPost:
+		r.mountBlob(w, req, strings.TrimSuffix(path, "/blobs/uploads/"))
+	case strings.Contains(path, "/blobs/uploads/") && req.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	case strings.Contains(path, "/blobs/") && req.Method == http.MethodGet:
+		i := strings.LastIndex(path, "/blobs/")
+		data, err := r.Blob(path[:i], path[i+len("/blobs/"):])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Write(data)
+	default:
+		writeUnsupported(w)
+	}
+}
+
+// getManifest answers with a manifest, like the registry a manifest whose media type isn't accepted isn't found.
+func (r *Registry) getManifest(w http.ResponseWriter, req *http.Request, repoName string, reference string) {
+	digest, mediaType, data, err := r.Manifest(repoName, reference)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !strings.Contains(req.Header.Get("Accept"), mediaType) {
+		writeError(w, &Error{StatusCode: http.StatusNotFound, Code: "MANIFEST_UNKNOWN", Message: fmt.Sprintf("manifest %s not found in %s", reference, repoName)})
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Write(data)
+}
+
+// putManifest stores a pushed manifest, tagged with reference unless it's a digest. Its media type is the content
+// type of the request, and every blob and manifest it references must be in the repository.
+func (r *Registry) putManifest(w http.ResponseWriter, req *http.Request, repoName string, reference string) {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		writeError(w, &Error{StatusCode: http.StatusBadRequest, Code: "MANIFEST_INVALID", Message: err.Error()})
+		return
+	}
+	var decoded document
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		writeError(w, &Error{StatusCode: http.StatusBadRequest, Code: "MANIFEST_INVALID", Message: err.Error()})
+		return
+	}
+	blobs := decoded.Layers
+	if decoded.Config != nil {
+		blobs = append([]Descriptor{*decoded.Config}, blobs...)
+	}
+	for _, blob := range blobs {
+		if _, err := r.Blob(repoName, blob.Digest); err != nil {
+			writeError(w, &Error{StatusCode: http.StatusBadRequest, Code: "BLOB_UNKNOWN", Message: fmt.Sprintf("blob %s not found", blob.Digest)})
+			return
+		}
+	}
+	for _, child := range decoded.Manifests {
+		if _, _, _, err := r.Manifest(repoName, child.Digest); err != nil {
+			writeError(w, &Error{StatusCode: http.StatusBadRequest, Code: "MANIFEST_BLOB_UNKNOWN", Message: fmt.Sprintf("manifest %s not found", child.Digest)})
+			return
+		}
+	}
+	mediaType := req.Header.Get("Content-Type")
+	if len(decoded.MediaType) > 0 && decoded.MediaType != mediaType {
+		writeError(w, &Error{StatusCode: http.StatusBadRequest, Code: "MANIFEST_INVALID", Message: fmt.Sprintf("media type %s pushed as %s", decoded.MediaType, mediaType)})
+		return
+	}
+	digest := blobDigest(data)
+	r.PutManifest(repoName, reference, digest, mediaType, data)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// mountBlob answers a cross repository blob mount, a blob that isn't in the source repository starts an upload
+// instead like the registry does.
+func (r *Registry) mountBlob(w http.ResponseWriter, req *http.Request, repoName string) {
+	digest := req.URL.Query().Get("mount")
+	if !r.MountBlob(repoName, req.URL.Query().Get("from"), digest) {
+		r.mu.Lock()
+		r.uploads++
+		upload := r.uploads
+		r.mu.Unlock()
+		w.Header().Set("Location", "/v2/"+repoName+"/blobs/uploads/"+strconv.Itoa(upload))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Location", "/v2/"+repoName+"/blobs/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// pageSize returns the query parameter n of req capped by SetMaxPageSize, or 0 when it isn't a number.
+func (r *Registry) pageSize(req *http.Request) int {
+	n, _ := strconv.Atoi(req.URL.Query().Get("n"))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxPageSize > 0 && (n <= 0 || n > r.maxPageSize) {
+		n = r.maxPageSize
+	}
+	return n
+}
+
+// writeResult answers with err when it isn't nil, and with value and statusCode otherwise. A nil value is answered
+// without a body.
+func writeResult(w http.ResponseWriter, statusCode int, value interface{}, err error) {
+	switch {
+	case err != nil:
+		writeError(w, err)
+	case value == nil:
+		w.WriteHeader(statusCode)
+	default:
+		writeJSON(w, statusCode, value)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	if len(w.Header().Get("Content-Type")) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError answers with the status code and the code of err, any error that isn't an *Error is a 500.
+func writeError(w http.ResponseWriter, err error) {
+	registryError, ok := err.(*Error)
+	if !ok {
+		registryError = &Error{StatusCode: http.StatusInternalServerError, Code: "UNKNOWN", Message: err.Error()}
+	}
+	writeJSON(w, registryError.StatusCode, map[string]interface{}{
+		"errors": []map[string]string{{"code": registryError.Code, "message": registryError.Message}},
+	})
+}
+
+func writeUnsupported(w http.ResponseWriter) {
+	writeError(w, &Error{StatusCode: http.StatusMethodNotAllowed, Code: "UNSUPPORTED", Message: "unsupported"})
+}
@@ -0,0 +1,115 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AuthenticationExpiredError is returned when the registry rejects credentials it accepted earlier in the run and
+// they couldn't be refreshed.
+type AuthenticationExpiredError struct {
+	Err *RegistryError
+}
+
+func (e *AuthenticationExpiredError) Error() string {
+	return "authentication expired: " + e.Err.Error()
+}
+
+// Cause returns the registry error.
+func (e *AuthenticationExpiredError) Cause() error {
+	return e.Err
+}
+
+// SetTokenCredential makes the client authenticate with access tokens of credential scoped to the repository of
+// each request, instead of the authorization header it was created with.
+func (c *AcrCLIClient) SetTokenCredential(credential *TokenCredential) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens = credential
+}
+
+// withAuthorization runs request with the authorization header for scope. When the registry rejects an access token
+// of the token credential, a new one is obtained and request is retried once. A rejection after earlier requests
+// succeeded is reported as an AuthenticationExpiredError.
+func (c *AcrCLIClient) withAuthorization(ctx context.Context, scope string, request func(auth string) error) error {
+	auth, err := c.authorization(ctx, scope)
+	if err != nil {
+		return err
+	}
+	err = request(auth)
+	if !isUnauthorizedStatus(err) {
+		c.setAuthenticated(err)
+		return err
+	}
+	newAuth, refreshed, refreshErr := c.refreshAuthorization(ctx, scope, auth)
+	if refreshErr != nil {
+		return errors.Wrap(refreshErr, "unable to refresh the registry credentials")
+	}
+	if refreshed {
+		err = request(newAuth)
+		if !isUnauthorizedStatus(err) {
+			c.setAuthenticated(err)
+			return err
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.authenticated {
+		return &AuthenticationExpiredError{Err: err.(*RegistryError)}
+	}
+	return err
+}
+
+// authorization returns the authorization header for the requests within scope, like RepositoryScope(repoName).
+func (c *AcrCLIClient) authorization(ctx context.Context, scope string) (string, error) {
+	c.mu.Lock()
+	auth, tokens := c.auth, c.tokens
+	c.mu.Unlock()
+	if tokens == nil {
+		return auth, nil
+	}
+	token, err := tokens.GetAccessToken(ctx, scope)
+	if err != nil {
+		return "", err
+	}
+	return "Bearer " + token, nil
+}
+
+// refreshAuthorization returns a new authorization header for scope after failed was rejected, the access token is
+// dropped from the cache of the token credential so a new one is obtained, unless a concurrent request already
+// replaced it. It returns false when the client was created with a fixed authorization header, like basic
+// credentials, since there is no way to get new ones.
+func (c *AcrCLIClient) refreshAuthorization(ctx context.Context, scope string, failed string) (string, bool, error) {
+	c.mu.Lock()
+	tokens := c.tokens
+	c.mu.Unlock()
+	if tokens == nil {
+		return "", false, nil
+	}
+	tokens.invalidate(scope, strings.TrimPrefix(failed, "Bearer "))
+	auth, err := c.authorization(ctx, scope)
+	return auth, err == nil, err
+}
+
+// setAuthenticated remembers that the registry accepted the credentials once err is nil.
+func (c *AcrCLIClient) setAuthenticated(err error) {
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authenticated = true
+}
+
+// isUnauthorizedStatus reports whether err is a 401 from the registry, a 403 means the credentials are valid but
+// lack permissions so refreshing them doesn't help.
+func isUnauthorizedStatus(err error) bool {
+	registryError, ok := err.(*RegistryError)
+	return ok && registryError.StatusCode == http.StatusUnauthorized
+}
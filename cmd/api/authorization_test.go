@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newExpiringRegistry starts a TLS registry that accepts "Bearer first" for a single request and "Bearer second"
+// afterwards, like a token expiring during a run.
+func newExpiringRegistry() *httptest.Server {
+	var mu sync.Mutex
+	firstUsed := false
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auth := r.Header.Get("Authorization")
+		if (auth == "Bearer first" && !firstUsed) || auth == "Bearer second" {
+			firstUsed = firstUsed || auth == "Bearer first"
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`))
+	}))
+}
+
+func TestWithAuthorizationRefresh(t *testing.T) {
+	registry := &oauthRegistry{tokenLifetime: time.Hour, scopes: map[string]string{}}
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	loginURL := strings.TrimPrefix(server.URL, "https://")
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	acrClient := NewAcrCLIClient(loginURL, "", httpClient)
+	acrClient.SetTokenCredential(NewTokenCredential(loginURL, "tenant", "aad-token", httpClient))
+	if err := acrClient.AcrDeleteTag(context.Background(), "repo", "v1"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// The registry stops accepting the cached access token before it expires, like when it's revoked mid-run.
+	registry.mu.Lock()
+	registry.scopes = map[string]string{}
+	registry.mu.Unlock()
+	for _, tag := range []string{"v2", "v3"} {
+		if err := acrClient.AcrDeleteTag(context.Background(), "repo", tag); err != nil {
+			t.Fatalf("deleting %s failed: %v", tag, err)
+		}
+	}
+	if registry.exchanges != 1 || registry.tokens != 2 {
+		t.Fatalf("expected a single new access token, got %d exchanges and %d access tokens", registry.exchanges, registry.tokens)
+	}
+}
+
+func TestWithAuthorizationExpired(t *testing.T) {
+	server := newExpiringRegistry()
+	defer server.Close()
+	loginURL := strings.TrimPrefix(server.URL, "https://")
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Rejected from the start.
+	err = NewAcrCLIClient(loginURL, "Bearer wrong", httpClient).AcrDeleteTag(context.Background(), "repo", "v1")
+	if registryError, ok := err.(*RegistryError); !ok || !registryError.IsUnauthorized() {
+		t.Fatalf("expected an unauthorized registry error, got %T %v", err, err)
+	}
+
+	// Rejected after the first request, without a way to refresh.
+	acrClient := NewAcrCLIClient(loginURL, "Bearer first", httpClient)
+	if err := acrClient.AcrDeleteTag(context.Background(), "repo", "v1"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = acrClient.AcrDeleteTag(context.Background(), "repo", "v2")
+	expired, ok := err.(*AuthenticationExpiredError)
+	if !ok || !expired.Err.IsUnauthorized() {
+		t.Fatalf("expected an authentication expired error, got %T %v", err, err)
+	}
+	if err.Error() != "authentication expired: UNAUTHORIZED authentication required" {
+		t.Fatalf("error message incorrect, got %s", err.Error())
+	}
+}
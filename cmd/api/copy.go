@@ -0,0 +1,194 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	acrapi "github.com/AzureCR/acr-cli/acr"
+)
+
+// ManifestContent is a manifest as the registry stores it. Digest is the digest of Content, which must be pushed
+// unchanged for a copy to keep the same digest.
+type ManifestContent struct {
+	MediaType string
+	Digest    string
+	Content   []byte
+}
+
+// BlobNotMountedError is returned when the registry answers a blob mount by starting an upload instead, because the
+// blob isn't in the source repository or the credentials can't read it there. Pushing a manifest that references the
+// blob would fail.
+type BlobNotMountedError struct {
+	Digest           string
+	SourceRepository string
+	Repository       string
+}
+
+func (e *BlobNotMountedError) Error() string {
+	return fmt.Sprintf("blob %s isn't present in %s for mount into %s, or the credentials can't read it there", e.Digest, e.SourceRepository, e.Repository)
+}
+
+// AcrGetManifestContent pulls the manifest of repoName identified by reference, a tag or a digest, without decoding
+// it.
+func (c *AcrCLIClient) AcrGetManifestContent(ctx context.Context, repoName string, reference string) (*ManifestContent, error) {
+	var manifest *ManifestContent
+	err := c.withAuthorization(ctx, RepositoryScope(repoName), func(auth string) error {
+		var err error
+		manifest, err = getManifestContent(ctx, c.newClient(auth, repoName, reference))
+		return err
+	})
+	return manifest, err
+}
+
+// AcrPutManifest pushes manifest to repoName with reference, a tag or the digest of the manifest. Every blob and
+// manifest it references must already be in repoName.
+func (c *AcrCLIClient) AcrPutManifest(ctx context.Context, repoName string, reference string, manifest *ManifestContent) error {
+	return c.withAuthorization(ctx, PushScope(repoName, ""), func(auth string) error {
+		return putManifest(ctx, c.newClient(auth, repoName, reference), manifest)
+	})
+}
+
+// AcrMountBlob makes the blob identified by digest in sourceRepoName available in repoName without uploading it
+// again, both repositories must be in the registry.
+func (c *AcrCLIClient) AcrMountBlob(ctx context.Context, repoName string, sourceRepoName string, digest string) error {
+	return c.withAuthorization(ctx, PushScope(repoName, sourceRepoName), func(auth string) error {
+		return mountBlob(ctx, c.newClient(auth, repoName, ""), sourceRepoName, digest)
+	})
+}
+
+// newClient returns the autorest client for the requests about reference in repoName sent with auth.
+func (c *AcrCLIClient) newClient(auth string, repoName string, reference string) acrapi.BaseClient {
+	client := newBaseClient(c.autorestClient, LoginURLWithPrefix(c.loginURL),
+		repoName,
+		reference,
+		"",
+		"",
+		"",
+		auth,
+		"",
+		"",
+		"",
+		"")
+	return client
+}
+
+// AcrGetManifestContent pulls the manifest of repoName identified by reference, a tag or a digest, without decoding
+// it.
+func (c *GenericClient) AcrGetManifestContent(ctx context.Context, repoName string, reference string) (*ManifestContent, error) {
+	return getManifestContent(ctx, c.client(repoName, reference))
+}
+
+// AcrPutManifest pushes manifest to repoName with reference, a tag or the digest of the manifest.
+func (c *GenericClient) AcrPutManifest(ctx context.Context, repoName string, reference string, manifest *ManifestContent) error {
+	return putManifest(ctx, c.client(repoName, reference), manifest)
+}
+
+// AcrMountBlob makes the blob identified by digest in sourceRepoName available in repoName without uploading it
+// again.
+func (c *GenericClient) AcrMountBlob(ctx context.Context, repoName string, sourceRepoName string, digest string) error {
+	return mountBlob(ctx, c.client(repoName, ""), sourceRepoName, digest)
+}
+
+// getManifestContent sends the manifest request for client.Name and client.Reference. The content is checked
+// against the digest the registry returned, so a copy never pushes a manifest that was altered on the way.
+func getManifestContent(ctx context.Context, client acrapi.BaseClient) (*ManifestContent, error) {
+	resp, err := sendRequest(ctx, client, "api.AcrCLIClient", "AcrGetManifestContent", []autorest.PrepareDecorator{
+		autorest.AsGet(),
+		autorest.WithPathParameters("/v2/{name}/manifests/{reference}", map[string]interface{}{
+			"name":      autorest.Encode("path", client.Name),
+			"reference": autorest.Encode("path", client.Reference),
+		}),
+		autorest.WithHeader("accept", strings.Join(manifestMediaTypes, ", "))}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &ManifestContent{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(content)),
+		Content:   content,
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); strings.HasPrefix(digest, "sha256:") && digest != manifest.Digest {
+		return nil, fmt.Errorf("the content of manifest %s doesn't match its digest %s", client.Reference, digest)
+	}
+	// The media type in the manifest is preferred, the Content-Type header is only needed for the OCI manifests
+	// that omit it.
+	var decoded Manifest
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return nil, err
+	}
+	if len(decoded.MediaType) > 0 {
+		manifest.MediaType = decoded.MediaType
+	}
+	return manifest, nil
+}
+
+// putManifest sends the manifest upload request for client.Name and client.Reference.
+func putManifest(ctx context.Context, client acrapi.BaseClient, manifest *ManifestContent) error {
+	resp, err := sendRequest(ctx, client, "api.AcrCLIClient", "AcrPutManifest", []autorest.PrepareDecorator{
+		autorest.AsPut(),
+		autorest.WithPathParameters("/v2/{name}/manifests/{reference}", map[string]interface{}{
+			"name":      autorest.Encode("path", client.Name),
+			"reference": autorest.Encode("path", client.Reference),
+		}),
+		autorest.AsContentType(manifest.MediaType),
+		autorest.WithFile(ioutil.NopCloser(bytes.NewReader(manifest.Content)))}, http.StatusCreated)
+	if err != nil {
+		return err
+	}
+	return autorest.Respond(resp, autorest.ByClosing())
+}
+
+// mountBlob sends the cross repository blob mount request of the Distribution API. A registry that can't mount the
+// blob starts an upload instead and answers with a 202 rather than a 201, the upload is canceled and a
+// BlobNotMountedError is returned.
+func mountBlob(ctx context.Context, client acrapi.BaseClient, sourceRepoName string, digest string) error {
+	resp, err := sendRequest(ctx, client, "api.AcrCLIClient", "AcrMountBlob", []autorest.PrepareDecorator{
+		autorest.AsPost(),
+		autorest.WithPathParameters("/v2/{name}/blobs/uploads/", map[string]interface{}{
+			"name": autorest.Encode("path", client.Name),
+		}),
+		autorest.WithQueryParameters(map[string]interface{}{
+			"mount": autorest.Encode("query", digest),
+			"from":  autorest.Encode("query", sourceRepoName),
+		})}, http.StatusCreated, http.StatusAccepted)
+	if err != nil {
+		return err
+	}
+	autorest.Respond(resp, autorest.ByClosing())
+	if resp.StatusCode == http.StatusCreated {
+		return nil
+	}
+	cancelUpload(ctx, client, resp.Header.Get("Location"))
+	return &BlobNotMountedError{Digest: digest, SourceRepository: sourceRepoName, Repository: client.Name}
+}
+
+// cancelUpload deletes the upload session at location, an absolute URL or a path on the registry. It's best effort,
+// an upload that isn't canceled expires on its own.
+func cancelUpload(ctx context.Context, client acrapi.BaseClient, location string) {
+	session, err := url.Parse(location)
+	if err != nil || len(location) == 0 {
+		return
+	}
+	resp, err := sendRequest(ctx, client, "api.AcrCLIClient", "CancelBlobUpload", []autorest.PrepareDecorator{
+		autorest.AsDelete(),
+		autorest.WithPath(session.RequestURI())}, http.StatusNoContent, http.StatusOK, http.StatusAccepted)
+	if err == nil {
+		autorest.Respond(resp, autorest.ByClosing())
+	}
+}
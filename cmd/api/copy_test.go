@@ -0,0 +1,79 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api/acrtest"
+)
+
+func TestGetAndPutManifestContent(t *testing.T) {
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	content := `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`
+	digest := registry.AddManifest("repo", []byte(content), time.Now(), "v1")
+	// A manifest served under a digest that isn't the one of its content.
+	registry.AddManifestDigest("repo", testDigest, []byte(content), time.Now(), "altered")
+
+	client := newTestBaseClient(t, registry, "repo", "v1")
+	manifest, err := getManifestContent(context.Background(), client)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if manifest.Digest != digest || string(manifest.Content) != content || manifest.MediaType != "application/vnd.oci.image.index.v1+json" {
+		t.Fatalf("manifest incorrect, got %+v", manifest)
+	}
+
+	client.Reference = "altered"
+	if _, err := getManifestContent(context.Background(), client); err == nil {
+		t.Fatalf("expected an error when the content doesn't match the digest")
+	}
+
+	client.Name, client.Reference = "mirror", "v1"
+	if err := putManifest(context.Background(), client, manifest); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	pushedDigest, pushedType, pushed, err := registry.Manifest("mirror", "v1")
+	if err != nil || pushedDigest != digest || string(pushed) != content || pushedType != manifest.MediaType {
+		t.Fatalf("the manifest should be pushed unchanged, got %q as %q and %v", pushed, pushedType, err)
+	}
+}
+
+func TestMountBlob(t *testing.T) {
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	registry.AddBlob("team/repo", "sha256:l1")
+
+	client := newTestBaseClient(t, registry, "mirror", "")
+	if err := mountBlob(context.Background(), client, "team/repo", "sha256:l1"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := registry.Blob("mirror", "sha256:l1"); err != nil {
+		t.Fatalf("the blob should be mounted, got %v", err)
+	}
+	// The blob isn't in the source repository, the registry starts an upload instead.
+	err := mountBlob(context.Background(), client, "team/repo", "sha256:unknown")
+	if notMounted, ok := err.(*BlobNotMountedError); !ok || notMounted.Digest != "sha256:unknown" || notMounted.SourceRepository != "team/repo" {
+		t.Fatalf("expected a BlobNotMountedError, got %v", err)
+	}
+	if err.Error() != "blob sha256:unknown isn't present in team/repo for mount into mirror, or the credentials can't read it there" {
+		t.Fatalf("error message incorrect, got %s", err)
+	}
+	if requests := registry.Requests(http.MethodDelete, "/v2/mirror/blobs/uploads/1"); requests != 1 {
+		t.Fatalf("the upload started instead of the mount should be canceled, got %d requests", requests)
+	}
+}
+
+func TestPushScope(t *testing.T) {
+	if scope := PushScope("mirror", "repo"); scope != "repository:mirror:pull,push repository:repo:pull" {
+		t.Fatalf("scope incorrect, got %s", scope)
+	}
+	if scope := PushScope("repo", "repo"); scope != "repository:repo:pull,push" {
+		t.Fatalf("scope incorrect, got %s", scope)
+	}
+}
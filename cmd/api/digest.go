@@ -0,0 +1,35 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// digestHexLengths are the hex encoded lengths of the digest algorithms supported by the registry.
+var digestHexLengths = map[string]int{
+	"sha256": 64,
+	"sha512": 128,
+}
+
+var lowerHexPattern = regexp.MustCompile(`^[a-f0-9]+$`)
+
+// ValidateDigest checks that digest is <algorithm>:<hex> with a supported algorithm and the hex length it produces.
+func ValidateDigest(digest string) error {
+	separator := strings.Index(digest, ":")
+	if separator < 0 {
+		return fmt.Errorf("invalid digest %q, expected <algorithm>:<hex> like sha256:<64 hex characters>", digest)
+	}
+	algorithm, encoded := digest[:separator], digest[separator+1:]
+	length, ok := digestHexLengths[algorithm]
+	if !ok {
+		return fmt.Errorf("invalid digest %q, unsupported algorithm %q, expected sha256 or sha512", digest, algorithm)
+	}
+	if len(encoded) != length || !lowerHexPattern.MatchString(encoded) {
+		return fmt.Errorf("invalid digest %q, a %s digest has %d lowercase hexadecimal characters", digest, algorithm, length)
+	}
+	return nil
+}
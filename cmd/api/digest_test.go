@@ -0,0 +1,37 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateDigest(t *testing.T) {
+	for _, digest := range []string{
+		"sha256:" + strings.Repeat("a", 64),
+		"sha512:" + strings.Repeat("0", 128),
+	} {
+		if err := ValidateDigest(digest); err != nil {
+			t.Fatalf("ValidateDigest(%q) unexpected error %v", digest, err)
+		}
+	}
+	for _, digest := range []string{
+		"",
+		"sha256",
+		"sha256" + strings.Repeat("a", 64),
+		"sha256abc:" + strings.Repeat("a", 64),
+		"md5:" + strings.Repeat("a", 32),
+		"sha256:" + strings.Repeat("a", 63),
+		"sha256:" + strings.Repeat("a", 65),
+		"sha256:" + strings.Repeat("a", 128),
+		"sha512:" + strings.Repeat("a", 64),
+		"sha256:" + strings.Repeat("A", 64),
+		"sha256:" + strings.Repeat("g", 64),
+	} {
+		if err := ValidateDigest(digest); err == nil {
+			t.Fatalf("ValidateDigest(%q) should fail", digest)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/go-autorest/autorest"
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// RegistryError is returned when the registry answers a request with an error status code.
+type RegistryError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *RegistryError) Error() string {
+	if len(e.Code) == 0 {
+		return fmt.Sprintf("unexpected response code: %v", e.StatusCode)
+	}
+	return fmt.Sprintf("%s %s", e.Code, e.Message)
+}
+
+// IsUnauthorized reports whether the registry rejected the credentials or their permissions.
+func (e *RegistryError) IsUnauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// DeletionDisabledError is returned when the registry answers the deletion of a tag or a manifest with a 405, which
+// usually means deleting is disabled by a policy of the registry or of the repository rather than by a lock.
+type DeletionDisabledError struct {
+	Repository string
+	Reference  string
+	Err        *RegistryError
+}
+
+func (e *DeletionDisabledError) Error() string {
+	return fmt.Sprintf("unable to delete %s from %s, deletion is disabled for this registry or repository: enable delete or check the delete-enabled policy (%v)",
+		e.Reference, e.Repository, e.Err)
+}
+
+// Cause returns the registry error.
+func (e *DeletionDisabledError) Cause() error {
+	return e.Err
+}
+
+// deletionError returns a DeletionDisabledError when err is a 405 answered to the deletion of reference from repoName,
+// any other error is returned as is.
+func deletionError(err error, repoName string, reference string) error {
+	if registryError, ok := err.(*RegistryError); ok && registryError.StatusCode == http.StatusMethodNotAllowed {
+		return &DeletionDisabledError{Repository: repoName, Reference: reference, Err: registryError}
+	}
+	return err
+}
+
+// newRegistryError builds the error for a response with an error status code, value is the decoded response body.
+func newRegistryError(statusCode int, value interface{}) error {
+	var apiError acrapi.Error
+	if err := mapstructure.Decode(value, &apiError); err != nil {
+		return errors.Wrap(err, "unable to decode error")
+	}
+	registryError := &RegistryError{StatusCode: statusCode}
+	if apiError.Errors != nil && len(*apiError.Errors) > 0 {
+		if code := (*apiError.Errors)[0].Code; code != nil {
+			registryError.Code = *code
+		}
+		if message := (*apiError.Errors)[0].Message; message != nil {
+			registryError.Message = *message
+		}
+	}
+	return registryError
+}
+
+// fromAutorestError converts the errors the generated client returns for status codes it doesn't expect (like 403 or
+// 500) into a RegistryError, any other error is returned as is.
+func fromAutorestError(err error) error {
+	if detailedError, ok := err.(autorest.DetailedError); ok && detailedError.Response != nil && detailedError.Response.StatusCode >= http.StatusBadRequest {
+		return &RegistryError{StatusCode: detailedError.Response.StatusCode}
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api/acrtest"
+	"github.com/pkg/errors"
+)
+
+func TestNewRegistryError(t *testing.T) {
+	body := map[string]interface{}{
+		"errors": []interface{}{
+			map[string]interface{}{"code": "UNAUTHORIZED", "message": "authentication required"},
+		},
+	}
+	err := newRegistryError(http.StatusUnauthorized, body)
+	registryError, ok := err.(*RegistryError)
+	if !ok {
+		t.Fatalf("newRegistryError returned %T, expected *RegistryError", err)
+	}
+	if !registryError.IsUnauthorized() {
+		t.Fatalf("401 should be reported as unauthorized")
+	}
+	if err.Error() != "UNAUTHORIZED authentication required" {
+		t.Fatalf("error message incorrect, got %s", err.Error())
+	}
+
+	err = newRegistryError(http.StatusNotFound, nil)
+	if err.Error() != "unexpected response code: 404" {
+		t.Fatalf("error message without body incorrect, got %s", err.Error())
+	}
+	if err.(*RegistryError).IsUnauthorized() {
+		t.Fatalf("404 shouldn't be reported as unauthorized")
+	}
+}
+
+func TestDeletionDisabledError(t *testing.T) {
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	registry.AddImage("repo", time.Now(), "v1")
+	registry.Lock("repo", "v1")
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	acrClient := NewAcrCLIClient(registry.LoginURL(), "Basic auth", httpClient)
+	genericClient := newTestGenericClient(t, registry.LoginURL())
+
+	for _, err := range []error{
+		acrClient.AcrDeleteTag(context.Background(), "repo", "v1"),
+		acrClient.DeleteManifest(context.Background(), "repo", "v1"),
+		genericClient.AcrDeleteTag(context.Background(), "repo", "v1"),
+		genericClient.DeleteManifest(context.Background(), "repo", "v1"),
+	} {
+		if _, ok := err.(*DeletionDisabledError); !ok {
+			t.Fatalf("expected a deletion disabled error, got %T %v", err, err)
+		}
+		expected := "unable to delete v1 from repo, deletion is disabled for this registry or repository: enable delete or check the delete-enabled policy"
+		if !strings.HasPrefix(err.Error(), expected) {
+			t.Fatalf("error message incorrect, got %s", err.Error())
+		}
+		if registryError, ok := errors.Cause(err).(*RegistryError); !ok || registryError.StatusCode != http.StatusMethodNotAllowed {
+			t.Fatalf("expected the registry error as the cause, got %v", errors.Cause(err))
+		}
+	}
+}
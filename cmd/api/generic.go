@@ -0,0 +1,302 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	acrapi "github.com/AzureCR/acr-cli/acr"
+)
+
+// genericPageSize is the number of tags or repositories requested per page from a generic registry.
+const genericPageSize = "100"
+
+// imageCreatedAnnotation is the OCI annotation with the creation time of an image, it's preferred over the config
+// because it's set on artifacts and image indexes too.
+const imageCreatedAnnotation = "org.opencontainers.image.created"
+
+// UnsupportedError is returned by the GenericClient for the operations the Distribution API doesn't define.
+type UnsupportedError struct {
+	Operation string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s isn't part of the Distribution API, it's only supported with --registry-type acr", e.Operation)
+}
+
+// GenericClient is the AcrCLIClientInterface implementation for the registries that only implement the Distribution
+// API, like Harbor or GHCR. The API has no timestamps so the last update time of a tag is the creation time of its
+// image, read from the manifest annotations or the image config, which costs up to two requests per listed tag. The
+// manifests of a repository can't be listed, AcrListManifests returns an UnsupportedError.
+type GenericClient struct {
+	loginURL       string
+	auth           string
+	autorestClient autorest.Client
+}
+
+// NewGenericClient creates a client for the Distribution registry identified by loginURL, auth is sent as the
+// authorization header. The requests are sent with httpClient, the autorest default client is used when it's nil.
+func NewGenericClient(loginURL string, auth string, httpClient *http.Client) *GenericClient {
+	return &GenericClient{
+		loginURL:       loginURL,
+		auth:           auth,
+		autorestClient: newAutorestClient(httpClient),
+	}
+}
+
+// client returns the autorest client for the requests about reference in repoName.
+func (c *GenericClient) client(repoName string, reference string) acrapi.BaseClient {
+	return newBaseClient(c.autorestClient, LoginURLWithPrefix(c.loginURL),
+		repoName,
+		reference,
+		"",
+		"",
+		"",
+		c.auth,
+		"",
+		"",
+		"",
+		"")
+}
+
+// send sends a request to path and returns the response when its status code is one of expected, the response body
+// must be closed by the caller. Any other status code is returned as a RegistryError.
+func (c *GenericClient) send(ctx context.Context,
+	client acrapi.BaseClient,
+	operation string,
+	decorators []autorest.PrepareDecorator,
+	expected ...int) (*http.Response, error) {
+	return sendRequest(ctx, client, "api.GenericClient", operation, decorators, expected...)
+}
+
+// sendRequest sends the request prepared with decorators through the autorest pipeline of client and returns the
+// response when its status code is one of expected, the response body must be closed by the caller. Any other status
+// code is returned as a RegistryError. component and operation identify the request in the autorest errors.
+func sendRequest(ctx context.Context,
+	client acrapi.BaseClient,
+	component string,
+	operation string,
+	decorators []autorest.PrepareDecorator,
+	expected ...int) (*http.Response, error) {
+	decorators = append([]autorest.PrepareDecorator{
+		autorest.WithBaseURL(client.BaseURI),
+		autorest.WithHeader("authorization", client.Authorization)}, decorators...)
+	req, err := autorest.CreatePreparer(decorators...).Prepare((&http.Request{}).WithContext(ctx))
+	if err != nil {
+		return nil, autorest.NewErrorWithError(err, component, operation, nil, "Failure preparing request")
+	}
+	resp, err := autorest.SendWithSender(client, req,
+		autorest.DoRetryForStatusCodes(client.RetryAttempts, client.RetryDuration, autorest.StatusCodesForRetry...))
+	if err != nil {
+		return nil, autorest.NewErrorWithError(err, component, operation, resp, "Failure sending request")
+	}
+	for _, statusCode := range expected {
+		if resp.StatusCode == statusCode {
+			return resp, nil
+		}
+	}
+	var value interface{}
+	autorest.Respond(resp, autorest.ByUnmarshallingJSON(&value), autorest.ByClosing())
+	return nil, newRegistryError(resp.StatusCode, value)
+}
+
+// AcrListTags lists a page of the tags of a repository, by name as the Distribution API doesn't support orderBy. The
+// digest and the last update time of every tag are read from its manifest.
+func (c *GenericClient) AcrListTags(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.TagAttributeList, error) {
+	names, err := c.listTagNames(ctx, repoName, genericPageSize, last)
+	if err != nil {
+		return nil, err
+	}
+	tags := []acrapi.TagAttributesBase{}
+	for _, name := range names {
+		name := name
+		digest, created, err := c.imageCreated(ctx, repoName, name)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, acrapi.TagAttributesBase{
+			Name:           &name,
+			Digest:         &digest,
+			CreatedTime:    &created,
+			LastUpdateTime: &created,
+		})
+	}
+	return &acrapi.TagAttributeList{ImageName: &repoName, Tags: &tags}, nil
+}
+
+// listTagNames sends the tags/list request of the Distribution API.
+func (c *GenericClient) listTagNames(ctx context.Context, repoName string, n string, last string) ([]string, error) {
+	client := c.client(repoName, "")
+	queryParameters := map[string]interface{}{"n": autorest.Encode("query", n)}
+	if len(last) > 0 {
+		queryParameters["last"] = autorest.Encode("query", last)
+	}
+	resp, err := c.send(ctx, client, "AcrListTags", []autorest.PrepareDecorator{
+		autorest.AsGet(),
+		autorest.WithPathParameters("/v2/{name}/tags/list", map[string]interface{}{"name": autorest.Encode("path", repoName)}),
+		autorest.WithQueryParameters(queryParameters)}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := autorest.Respond(resp, autorest.ByUnmarshallingJSON(&list), autorest.ByClosing()); err != nil {
+		return nil, err
+	}
+	return list.Tags, nil
+}
+
+// imageCreated returns the digest of the manifest tagged reference and the creation time of its image. The time is
+// the current time when neither the manifest nor its config have one, so an image of unknown age is never old enough
+// to be purged.
+func (c *GenericClient) imageCreated(ctx context.Context, repoName string, reference string) (string, string, error) {
+	client := c.client(repoName, reference)
+	resp, err := c.send(ctx, client, "AcrGetManifest", []autorest.PrepareDecorator{
+		autorest.AsGet(),
+		autorest.WithPathParameters("/v2/{name}/manifests/{reference}", map[string]interface{}{
+			"name":      autorest.Encode("path", repoName),
+			"reference": autorest.Encode("path", reference),
+		}),
+		autorest.WithHeader("accept", strings.Join(manifestMediaTypes, ", "))}, http.StatusOK)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if len(digest) == 0 {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return "", "", err
+	}
+	if created, ok := manifest.Annotations[imageCreatedAnnotation]; ok {
+		return digest, created, nil
+	}
+	if manifest.Config != nil {
+		created, err := c.configCreated(ctx, repoName, manifest.Config.Digest)
+		if err != nil {
+			return "", "", err
+		}
+		if len(created) > 0 {
+			return digest, created, nil
+		}
+	}
+	return digest, time.Now().UTC().Format(time.RFC3339Nano), nil
+}
+
+// configCreated returns the created field of the image config identified by digest, empty when it isn't set.
+func (c *GenericClient) configCreated(ctx context.Context, repoName string, digest string) (string, error) {
+	client := c.client(repoName, digest)
+	resp, err := c.send(ctx, client, "GetBlob", []autorest.PrepareDecorator{
+		autorest.AsGet(),
+		autorest.WithPathParameters("/v2/{name}/blobs/{digest}", map[string]interface{}{
+			"name":   autorest.Encode("path", repoName),
+			"digest": autorest.Encode("path", digest),
+		})}, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	var config struct {
+		Created string `json:"created"`
+	}
+	if err := autorest.Respond(resp, autorest.ByUnmarshallingJSON(&config), autorest.ByClosing()); err != nil {
+		return "", err
+	}
+	return config.Created, nil
+}
+
+// AcrDeleteTag deletes a tag with the manifest delete request of the Distribution API, the registries that only
+// allow deleting manifests by digest reject it.
+func (c *GenericClient) AcrDeleteTag(ctx context.Context, repoName string, reference string) error {
+	return c.deleteManifest(ctx, repoName, reference, "AcrDeleteTag")
+}
+
+// AcrListManifests returns an UnsupportedError, the Distribution API can't list the manifests of a repository.
+func (c *GenericClient) AcrListManifests(ctx context.Context, repoName string, orderBy string, last string) (*acrapi.ManifestAttributeList, error) {
+	return nil, &UnsupportedError{Operation: "listing the manifests of a repository"}
+}
+
+// DeleteManifest deletes a manifest using the digest as a reference.
+func (c *GenericClient) DeleteManifest(ctx context.Context, repoName string, reference string) error {
+	return c.deleteManifest(ctx, repoName, reference, "DeleteManifest")
+}
+
+func (c *GenericClient) deleteManifest(ctx context.Context, repoName string, reference string, operation string) error {
+	client := c.client(repoName, reference)
+	resp, err := c.send(ctx, client, operation, []autorest.PrepareDecorator{
+		autorest.AsDelete(),
+		autorest.WithPathParameters("/v2/{name}/manifests/{reference}", map[string]interface{}{
+			"name":      autorest.Encode("path", repoName),
+			"reference": autorest.Encode("path", reference),
+		})}, http.StatusAccepted, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return deletionError(err, repoName, reference)
+	}
+	return autorest.Respond(resp, autorest.ByClosing())
+}
+
+// AcrListReferrers lists the artifacts that reference the manifest identified by digest through their subject.
+func (c *GenericClient) AcrListReferrers(ctx context.Context, repoName string, digest string) (*ReferrerList, error) {
+	return listReferrers(ctx, c.client(repoName, digest))
+}
+
+// AcrListRepositories lists a page of the repositories with the catalog of the Distribution API.
+func (c *GenericClient) AcrListRepositories(ctx context.Context, last string) (*RepositoryList, error) {
+	return c.AcrListRepositoriesV2(ctx, last)
+}
+
+// AcrListRepositoriesV2 lists a page of the repositories with the catalog of the Distribution API.
+func (c *GenericClient) AcrListRepositoriesV2(ctx context.Context, last string) (*RepositoryList, error) {
+	client := c.client("", "")
+	queryParameters := map[string]interface{}{"n": autorest.Encode("query", genericPageSize)}
+	if len(last) > 0 {
+		queryParameters["last"] = autorest.Encode("query", last)
+	}
+	resp, err := c.send(ctx, client, "ListRepositories", []autorest.PrepareDecorator{
+		autorest.AsGet(),
+		autorest.WithPath("/v2/_catalog"),
+		autorest.WithQueryParameters(queryParameters)}, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	var repositories RepositoryList
+	if err := autorest.Respond(resp, autorest.ByUnmarshallingJSON(&repositories), autorest.ByClosing()); err != nil {
+		return nil, err
+	}
+	return &repositories, nil
+}
+
+// AcrGetRepositoryAttributes returns the name of a repository, the Distribution API has no other attributes. The
+// existence of the repository is checked by listing one of its tags, the registry answers with a 404 registry error
+// when it doesn't exist.
+func (c *GenericClient) AcrGetRepositoryAttributes(ctx context.Context, repoName string) (*acrapi.RepositoryAttributes, error) {
+	if _, err := c.listTagNames(ctx, repoName, "1", ""); err != nil {
+		return nil, err
+	}
+	return &acrapi.RepositoryAttributes{ImageName: &repoName}, nil
+}
+
+// AcrGetRepositoryMetadata returns an UnsupportedError, the Distribution API has no repository metadata.
+func (c *GenericClient) AcrGetRepositoryMetadata(ctx context.Context, repoName string, key string) ([]byte, error) {
+	return nil, &UnsupportedError{Operation: "reading the metadata of a repository"}
+}
+
+// AcrGetManifest pulls the manifest of repoName identified by reference, a tag or a digest.
+func (c *GenericClient) AcrGetManifest(ctx context.Context, repoName string, reference string) (*Manifest, error) {
+	return getManifest(ctx, c.client(repoName, reference))
+}
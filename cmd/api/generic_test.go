@@ -0,0 +1,140 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api/acrtest"
+)
+
+// newGenericRegistry returns a registry with the repository team/app for the GenericClient tests and the digests of
+// its tags. The creation time of annotated is in its annotations, the one of config in its config blob, and unknown
+// has neither.
+func newGenericRegistry() (*acrtest.Registry, map[string]string) {
+	registry := acrtest.NewRegistry()
+	configs := []string{
+		`{"created":"2000-01-01T00:00:00Z"}`,
+		`{"created":"2019-06-07T08:09:10Z","architecture":"amd64"}`,
+		`{"mediaType":"application/vnd.cncf.helm.config.v1+json"}`,
+	}
+	digests := map[string]string{}
+	for i, name := range []string{"annotated", "config", "unknown"} {
+		manifest := &Manifest{
+			SchemaVersion: 2,
+			MediaType:     "application/vnd.oci.image.manifest.v1+json",
+			Config:        &Descriptor{Digest: registry.PutBlob("team/app", []byte(configs[i]))},
+		}
+		if name == "annotated" {
+			manifest.Annotations = map[string]string{"org.opencontainers.image.created": "2019-01-02T03:04:05Z"}
+		}
+		content, _ := json.Marshal(manifest)
+		digests[name] = registry.AddManifest("team/app", content, time.Now(), name)
+	}
+	return registry, digests
+}
+
+func newTestGenericClient(t *testing.T, loginURL string) *GenericClient {
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return NewGenericClient(loginURL, "Basic auth", httpClient)
+}
+
+func TestGenericClientListTags(t *testing.T) {
+	registry, digests := newGenericRegistry()
+	defer registry.Close()
+	client := newTestGenericClient(t, registry.LoginURL())
+
+	start := time.Now().UTC()
+	tags, err := client.AcrListTags(context.Background(), "team/app", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(*tags.Tags) != 3 {
+		t.Fatalf("expected 3 tags, got %d", len(*tags.Tags))
+	}
+	expected := map[string]string{
+		"annotated": "2019-01-02T03:04:05Z",
+		"config":    "2019-06-07T08:09:10Z",
+	}
+	for _, tag := range *tags.Tags {
+		if *tag.Digest != digests[*tag.Name] {
+			t.Fatalf("digest of %s incorrect, got %s", *tag.Name, *tag.Digest)
+		}
+		if created, ok := expected[*tag.Name]; ok {
+			if *tag.LastUpdateTime != created {
+				t.Fatalf("last update time of %s incorrect, got %s, expected %s", *tag.Name, *tag.LastUpdateTime, created)
+			}
+			continue
+		}
+		// Without a creation time the tag is considered just updated, so it's never purged by age.
+		lastUpdateTime, err := time.Parse(time.RFC3339Nano, *tag.LastUpdateTime)
+		if err != nil || lastUpdateTime.Before(start) {
+			t.Fatalf("expected the last update time of %s to be the current time, got %s", *tag.Name, *tag.LastUpdateTime)
+		}
+	}
+
+	tags, err = client.AcrListTags(context.Background(), "team/app", "", "unknown")
+	if err != nil || len(*tags.Tags) != 0 {
+		t.Fatalf("expected the last page to be empty, got %v and %v", tags, err)
+	}
+}
+
+func TestGenericClientDelete(t *testing.T) {
+	registry, digests := newGenericRegistry()
+	defer registry.Close()
+	client := newTestGenericClient(t, registry.LoginURL())
+
+	if err := client.AcrDeleteTag(context.Background(), "team/app", "config"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := client.DeleteManifest(context.Background(), "team/app", digests["unknown"]); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if tags := registry.Tags("team/app"); !reflect.DeepEqual(tags, []string{"annotated"}) {
+		t.Fatalf("remaining tags incorrect, got %v", tags)
+	}
+	if manifests := registry.Manifests("team/app"); len(manifests) != 2 {
+		t.Fatalf("the manifest of config should be kept, got %v", manifests)
+	}
+	err := client.AcrDeleteTag(context.Background(), "team/app", "config")
+	if registryError, ok := err.(*RegistryError); !ok || registryError.StatusCode != http.StatusNotFound || registryError.Code != "MANIFEST_UNKNOWN" {
+		t.Fatalf("expected a 404 registry error, got %v", err)
+	}
+}
+
+func TestGenericClientRepositories(t *testing.T) {
+	registry, _ := newGenericRegistry()
+	defer registry.Close()
+	client := newTestGenericClient(t, registry.LoginURL())
+
+	repositories, err := client.AcrListRepositories(context.Background(), "")
+	if err != nil || !reflect.DeepEqual(repositories.Repositories, []string{"team/app"}) {
+		t.Fatalf("expected the catalog repositories, got %v and %v", repositories, err)
+	}
+	if _, err := client.AcrGetRepositoryAttributes(context.Background(), "team/app"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	_, err = client.AcrGetRepositoryAttributes(context.Background(), "missing")
+	if registryError, ok := err.(*RegistryError); !ok || registryError.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a 404 registry error, got %v", err)
+	}
+	if _, err := client.AcrListManifests(context.Background(), "team/app", "", ""); err == nil {
+		t.Fatalf("expected listing the manifests to be unsupported")
+	} else if _, ok := err.(*UnsupportedError); !ok {
+		t.Fatalf("expected an UnsupportedError, got %v", err)
+	}
+	registry.Fail(http.MethodGet, "/v2/_catalog", http.StatusUnauthorized)
+	_, err = client.AcrListRepositories(context.Background(), "")
+	if registryError, ok := err.(*RegistryError); !ok || !registryError.IsUnauthorized() {
+		t.Fatalf("expected a 401 registry error, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	acrapi "github.com/AzureCR/acr-cli/acr"
+)
+
+// manifestMediaTypes are the manifest formats accepted when pulling a manifest, without them the registry converts
+// the manifest to the legacy schema 1 format.
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// Manifest is an image manifest or an image index. Config and Layers are only set for image manifests and Manifests
+// only for image indexes.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        *Descriptor       `json:"config,omitempty"`
+	Layers        []Descriptor      `json:"layers,omitempty"`
+	Manifests     []Descriptor      `json:"manifests,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// AcrGetManifest pulls the manifest of repoName identified by reference, a tag or a digest. The generated client
+// doesn't send the accepted media types so the request is built here with the same autorest pipeline.
+func (c *AcrCLIClient) AcrGetManifest(ctx context.Context, repoName string, reference string) (*Manifest, error) {
+	var manifest *Manifest
+	err := c.withAuthorization(ctx, RepositoryScope(repoName), func(auth string) error {
+		var err error
+		manifest, err = c.acrGetManifest(ctx, auth, repoName, reference)
+		return err
+	})
+	return manifest, err
+}
+
+func (c *AcrCLIClient) acrGetManifest(ctx context.Context,
+	auth string,
+	repoName string,
+	reference string) (*Manifest, error) {
+	hostname := LoginURLWithPrefix(c.loginURL)
+	client := newBaseClient(c.autorestClient, hostname,
+		repoName,
+		reference,
+		"",
+		"",
+		"",
+		auth,
+		"",
+		"",
+		"",
+		"")
+	return getManifest(ctx, client)
+}
+
+// getManifest sends the manifest request for client.Name and client.Reference.
+func getManifest(ctx context.Context, client acrapi.BaseClient) (*Manifest, error) {
+	pathParameters := map[string]interface{}{
+		"name":      autorest.Encode("path", client.Name),
+		"reference": autorest.Encode("path", client.Reference),
+	}
+	preparer := autorest.CreatePreparer(
+		autorest.AsGet(),
+		autorest.WithBaseURL(client.BaseURI),
+		autorest.WithPathParameters("/v2/{name}/manifests/{reference}", pathParameters),
+		autorest.WithHeader("accept", strings.Join(manifestMediaTypes, ", ")),
+		autorest.WithHeader("authorization", client.Authorization))
+	req, err := preparer.Prepare((&http.Request{}).WithContext(ctx))
+	if err != nil {
+		return nil, autorest.NewErrorWithError(err, "api.AcrCLIClient", "AcrGetManifest", nil, "Failure preparing request")
+	}
+	resp, err := autorest.SendWithSender(client, req,
+		autorest.DoRetryForStatusCodes(client.RetryAttempts, client.RetryDuration, autorest.StatusCodesForRetry...))
+	if err != nil {
+		return nil, autorest.NewErrorWithError(err, "api.AcrCLIClient", "AcrGetManifest", resp, "Failure sending request")
+	}
+	if resp.StatusCode != http.StatusOK {
+		var value interface{}
+		autorest.Respond(resp, autorest.ByUnmarshallingJSON(&value), autorest.ByClosing())
+		return nil, newRegistryError(resp.StatusCode, value)
+	}
+	var manifest Manifest
+	err = autorest.Respond(
+		resp,
+		client.ByInspecting(),
+		azure.WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByUnmarshallingJSON(&manifest),
+		autorest.ByClosing())
+	if err != nil {
+		return nil, fromAutorestError(err)
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/AzureCR/acr-cli/cmd/api/acrtest"
+)
+
+// newTestBaseClient returns a generated client for reference in repoName of registry.
+func newTestBaseClient(t *testing.T, registry *acrtest.Registry, repoName string, reference string) acrapi.BaseClient {
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	client := acrapi.NewWithBaseURI("https://"+registry.LoginURL(), repoName, reference, "", "", "", "Basic auth", "", "", "", "")
+	client.Sender = httpClient
+	return client
+}
+
+func TestGetManifest(t *testing.T) {
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	content := []byte(`{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.manifest.v1+json",
+  "config": {"mediaType": "application/vnd.oci.image.config.v1+json", "digest": "sha256:c0", "size": 512},
+  "layers": [
+    {"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": "sha256:l1", "size": 1024},
+    {"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": "sha256:l2", "size": 2048}
+  ]
+}`)
+	// The registry only answers with an OCI manifest when the request accepts its media type.
+	digest := registry.AddManifest("repo", content, time.Now())
+
+	client := newTestBaseClient(t, registry, "repo", digest)
+	manifest, err := getManifest(context.Background(), client)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if manifest.Config == nil || manifest.Config.Size != 512 || len(manifest.Layers) != 2 || manifest.Layers[1].Digest != "sha256:l2" {
+		t.Fatalf("manifest incorrect, got %+v", manifest)
+	}
+
+	client.Reference = "sha256:missing"
+	_, err = getManifest(context.Background(), client)
+	if registryError, ok := err.(*RegistryError); !ok || registryError.StatusCode != http.StatusNotFound || registryError.Code != "MANIFEST_UNKNOWN" {
+		t.Fatalf("expected a 404 registry error, got %v", err)
+	}
+}
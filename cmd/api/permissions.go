@@ -0,0 +1,86 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// permissionProbeDigest is the digest deleted to probe the delete permission, no manifest can have it so the
+// deletion never deletes anything.
+const permissionProbeDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+// PermissionError is returned when the credentials were accepted but don't allow Action on Repository.
+type PermissionError struct {
+	Repository string
+	Action     string
+	Err        *RegistryError
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("credentials lack %s permission on repository %s (%v)", e.Action, e.Repository, e.Err)
+}
+
+// Cause returns the registry error.
+func (e *PermissionError) Cause() error {
+	return e.Err
+}
+
+// AcrCheckDeletePermission returns a PermissionError when the credentials can't delete from repoName, it's meant to
+// be called once the repository was read with the same credentials. With a token credential the actions granted by
+// the access token of the repository are checked, otherwise a manifest that can't exist is deleted and the answer of
+// the registry tells whether the deletion was allowed.
+func (c *AcrCLIClient) AcrCheckDeletePermission(ctx context.Context, repoName string) error {
+	c.mu.Lock()
+	tokens := c.tokens
+	c.mu.Unlock()
+	if tokens != nil {
+		token, err := tokens.GetAccessToken(ctx, RepositoryScope(repoName))
+		if err != nil {
+			return err
+		}
+		if actions, ok := grantedActions(token, "repository", repoName); ok {
+			for _, action := range actions {
+				if action == "delete" || action == "*" {
+					return nil
+				}
+			}
+			return &PermissionError{Repository: repoName, Action: "delete", Err: &RegistryError{
+				StatusCode: http.StatusForbidden,
+				Code:       "DENIED",
+				Message:    "the access token doesn't grant the delete action",
+			}}
+		}
+	}
+	return probeDeletePermission(repoName, c.DeleteManifest(ctx, repoName, permissionProbeDigest))
+}
+
+// AcrCheckDeletePermission returns a PermissionError when the credentials can't delete from repoName, a manifest
+// that can't exist is deleted and the answer of the registry tells whether the deletion was allowed.
+func (c *GenericClient) AcrCheckDeletePermission(ctx context.Context, repoName string) error {
+	return probeDeletePermission(repoName, c.DeleteManifest(ctx, repoName, permissionProbeDigest))
+}
+
+// probeDeletePermission interprets err, the answer to the deletion of permissionProbeDigest from repoName. A manifest
+// not found means the credentials are allowed to delete. A 405 means deleting is disabled for the registry or the
+// repository, every deletion of the run would fail so a DeletionDisabledError is returned.
+func probeDeletePermission(repoName string, err error) error {
+	registryError, ok := errors.Cause(err).(*RegistryError)
+	if !ok {
+		return err
+	}
+	switch {
+	case registryError.IsUnauthorized():
+		return &PermissionError{Repository: repoName, Action: "delete", Err: registryError}
+	case registryError.StatusCode == http.StatusMethodNotAllowed:
+		return &DeletionDisabledError{Repository: repoName, Reference: "manifests", Err: registryError}
+	case registryError.StatusCode == http.StatusNotFound:
+		return nil
+	}
+	return err
+}
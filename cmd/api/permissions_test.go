@@ -0,0 +1,105 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api/acrtest"
+	"github.com/pkg/errors"
+)
+
+func TestAcrCheckDeletePermission(t *testing.T) {
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	registry.AddImage("repo", time.Now(), "v1")
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	probe := "/v2/repo/manifests/" + permissionProbeDigest
+	clients := map[string]AcrCLIClientInterface{
+		"acr":     NewAcrCLIClient(registry.LoginURL(), BasicAuth("user", "password"), httpClient),
+		"generic": NewGenericClient(registry.LoginURL(), BasicAuth("user", "password"), httpClient),
+	}
+	for name, client := range clients {
+		if err := client.AcrCheckDeletePermission(context.Background(), "repo"); err != nil {
+			t.Fatalf("%s: expected the permission to be granted, got %v", name, err)
+		}
+		registry.Fail(http.MethodDelete, probe, http.StatusMethodNotAllowed)
+		err := client.AcrCheckDeletePermission(context.Background(), "repo")
+		if _, ok := err.(*DeletionDisabledError); !ok {
+			t.Fatalf("%s: expected a DeletionDisabledError when deleting is disabled, got %T %v", name, err, err)
+		}
+		if !strings.HasPrefix(err.Error(), "unable to delete manifests from repo, deletion is disabled") {
+			t.Fatalf("%s: unexpected message %q", name, err.Error())
+		}
+		registry.Fail(http.MethodDelete, probe, http.StatusForbidden)
+		err = client.AcrCheckDeletePermission(context.Background(), "repo")
+		if _, ok := err.(*PermissionError); !ok {
+			t.Fatalf("%s: expected a PermissionError, got %v", name, err)
+		}
+		if !strings.HasPrefix(err.Error(), "credentials lack delete permission on repository repo") {
+			t.Fatalf("%s: unexpected message %q", name, err.Error())
+		}
+		if registryError, ok := errors.Cause(err).(*RegistryError); !ok || !registryError.IsUnauthorized() {
+			t.Fatalf("%s: expected the registry error as the cause, got %v", name, errors.Cause(err))
+		}
+	}
+	if requests := registry.Requests(http.MethodDelete, probe); requests != 6 {
+		t.Fatalf("expected 6 probes, got %d", requests)
+	}
+	if tags := registry.Tags("repo"); len(tags) != 1 {
+		t.Fatalf("the probe shouldn't delete anything, got %v", tags)
+	}
+}
+
+func TestAcrCheckDeletePermissionToken(t *testing.T) {
+	encode := base64.RawURLEncoding.EncodeToString
+	probes := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/oauth2/exchange":
+			fmt.Fprint(w, `{"refresh_token":"refresh"}`)
+		case "/oauth2/token":
+			// The registry only grants pull on the readonly repository.
+			repoName := strings.Split(req.FormValue("scope"), ":")[1]
+			actions := `["pull","delete","metadata_read"]`
+			if repoName == "readonly" {
+				actions = `["pull"]`
+			}
+			payload := fmt.Sprintf(`{"exp":%d,"access":[{"type":"repository","name":%q,"actions":%s}]}`, time.Now().Add(time.Hour).Unix(), repoName, actions)
+			fmt.Fprintf(w, `{"access_token":%q}`, encode([]byte(`{"alg":"none"}`))+"."+encode([]byte(payload))+".")
+		default:
+			probes++
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	loginURL := strings.TrimPrefix(server.URL, "https://")
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	acrClient := NewAcrCLIClient(loginURL, "", httpClient)
+	acrClient.SetTokenCredential(NewTokenCredential(loginURL, "", "aad-token", httpClient))
+
+	if err := acrClient.AcrCheckDeletePermission(context.Background(), "repo"); err != nil {
+		t.Fatalf("expected the permission to be granted, got %v", err)
+	}
+	err = acrClient.AcrCheckDeletePermission(context.Background(), "readonly")
+	if _, ok := err.(*PermissionError); !ok {
+		t.Fatalf("expected a PermissionError, got %v", err)
+	}
+	if probes != 0 {
+		t.Fatalf("the granted scopes should be checked without probing, got %d probes", probes)
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import "regexp"
+
+// redactedValue replaces the credentials removed by Redact.
+const redactedValue = "[redacted]"
+
+// credentialPatterns match the credentials that can end up in an error message: authorization header values, the
+// tokens of the token exchange as form values or JSON fields, and the password of a URL.
+var credentialPatterns = []struct {
+	regex       *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\b(basic|bearer)(\s+)[A-Za-z0-9+/=._~-]{8,}`), "${1}${2}" + redactedValue},
+	{regexp.MustCompile(`(?i)\b((?:access_token|refresh_token|password)=)[^&\s"]+`), "${1}" + redactedValue},
+	{regexp.MustCompile(`(?i)("(?:access_token|refresh_token)"\s*:\s*")[^"]*`), "${1}" + redactedValue},
+	{regexp.MustCompile(`(\b[a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]*:)[^@/\s]+@`), "${1}" + redactedValue + "@"},
+}
+
+// Redact returns s with the credentials it contains replaced, it's applied to every error message and log line
+// before they're written.
+func Redact(s string) string {
+	for _, pattern := range credentialPatterns {
+		s = pattern.regex.ReplaceAllString(s, pattern.replacement)
+	}
+	return s
+}
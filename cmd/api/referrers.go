@@ -0,0 +1,99 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	acrapi "github.com/AzureCR/acr-cli/acr"
+)
+
+// Descriptor describes an artifact returned by the referrers API, like a signature or an SBOM.
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// ReferrerList is the image index returned by the referrers API.
+type ReferrerList struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// AcrListReferrers lists the artifacts that reference the manifest identified by digest through their subject. The
+// generated client doesn't cover the OCI referrers API so the request is built here with the same autorest pipeline.
+func (c *AcrCLIClient) AcrListReferrers(ctx context.Context, repoName string, digest string) (*ReferrerList, error) {
+	var referrers *ReferrerList
+	err := c.withAuthorization(ctx, RepositoryScope(repoName), func(auth string) error {
+		var err error
+		referrers, err = c.acrListReferrers(ctx, auth, repoName, digest)
+		return err
+	})
+	return referrers, err
+}
+
+func (c *AcrCLIClient) acrListReferrers(ctx context.Context,
+	auth string,
+	repoName string,
+	digest string) (*ReferrerList, error) {
+	hostname := LoginURLWithPrefix(c.loginURL)
+	client := newBaseClient(c.autorestClient, hostname,
+		repoName,
+		digest,
+		"",
+		"",
+		"",
+		auth,
+		"",
+		"",
+		"",
+		"")
+	return listReferrers(ctx, client)
+}
+
+// listReferrers sends the referrers request for client.Name and client.Reference.
+func listReferrers(ctx context.Context, client acrapi.BaseClient) (*ReferrerList, error) {
+	pathParameters := map[string]interface{}{
+		"name":   autorest.Encode("path", client.Name),
+		"digest": autorest.Encode("path", client.Reference),
+	}
+	preparer := autorest.CreatePreparer(
+		autorest.AsGet(),
+		autorest.WithBaseURL(client.BaseURI),
+		autorest.WithPathParameters("/v2/{name}/referrers/{digest}", pathParameters),
+		autorest.WithHeader("accept", "application/vnd.oci.image.index.v1+json"),
+		autorest.WithHeader("authorization", client.Authorization))
+	req, err := preparer.Prepare((&http.Request{}).WithContext(ctx))
+	if err != nil {
+		return nil, autorest.NewErrorWithError(err, "api.AcrCLIClient", "AcrListReferrers", nil, "Failure preparing request")
+	}
+	resp, err := autorest.SendWithSender(client, req,
+		autorest.DoRetryForStatusCodes(client.RetryAttempts, client.RetryDuration, autorest.StatusCodesForRetry...))
+	if err != nil {
+		return nil, autorest.NewErrorWithError(err, "api.AcrCLIClient", "AcrListReferrers", resp, "Failure sending request")
+	}
+	if resp.StatusCode != http.StatusOK {
+		var value interface{}
+		autorest.Respond(resp, autorest.ByUnmarshallingJSON(&value), autorest.ByClosing())
+		return nil, newRegistryError(resp.StatusCode, value)
+	}
+	var referrers ReferrerList
+	err = autorest.Respond(
+		resp,
+		client.ByInspecting(),
+		azure.WithErrorUnlessStatusCode(http.StatusOK),
+		autorest.ByUnmarshallingJSON(&referrers),
+		autorest.ByClosing())
+	if err != nil {
+		return nil, fromAutorestError(err)
+	}
+	return &referrers, nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api/acrtest"
+)
+
+const testDigest = "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+func TestListReferrers(t *testing.T) {
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	subject := registry.AddImage("repo", time.Now(), "v1")
+	signature, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"artifactType":  "application/vnd.dev.cosign.artifact.sig.v1+json",
+		"subject":       Descriptor{Digest: subject},
+	})
+	sbom, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"artifactType":  "application/spdx+json",
+		"subject":       Descriptor{Digest: subject},
+		"annotations":   map[string]string{"org.opencontainers.image.created": "2020-01-01T00:00:00Z"},
+	})
+	signatureDigest := registry.AddManifest("repo", signature, time.Now())
+	sbomDigest := registry.AddManifest("repo", sbom, time.Now())
+
+	client := newTestBaseClient(t, registry, "repo", subject)
+	referrers, err := listReferrers(context.Background(), client)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(referrers.Manifests) != 2 {
+		t.Fatalf("expected 2 referrers, got %v", referrers.Manifests)
+	}
+	found := map[string]Descriptor{}
+	for _, referrer := range referrers.Manifests {
+		found[referrer.Digest] = referrer
+	}
+	if found[signatureDigest].ArtifactType != "application/vnd.dev.cosign.artifact.sig.v1+json" {
+		t.Fatalf("signature referrer incorrect, got %+v", found[signatureDigest])
+	}
+	if found[sbomDigest].Size != int64(len(sbom)) || found[sbomDigest].Annotations["org.opencontainers.image.created"] != "2020-01-01T00:00:00Z" {
+		t.Fatalf("sbom referrer incorrect, got %+v", found[sbomDigest])
+	}
+
+	client = newTestBaseClient(t, registry, "missing", subject)
+	_, err = listReferrers(context.Background(), client)
+	if registryError, ok := err.(*RegistryError); !ok || registryError.StatusCode != http.StatusNotFound || registryError.Code != "NAME_UNKNOWN" {
+		t.Fatalf("expected a 404 registry error, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	acrapi "github.com/AzureCR/acr-cli/acr"
+	"github.com/mitchellh/mapstructure"
+)
+
+// RepositoryList is a page of the repositories of a registry, sorted by name.
+type RepositoryList struct {
+	Repositories []string `json:"repositories"`
+}
+
+// AcrListRepositories lists the repositories whose name comes after last, 100 at a time. The list is empty once every
+// repository was returned.
+func (c *AcrCLIClient) AcrListRepositories(ctx context.Context, last string) (*RepositoryList, error) {
+	return c.listRepositories(ctx, last, false)
+}
+
+// AcrListRepositoriesV2 lists the repositories like AcrListRepositories through the /v2/_catalog endpoint of the
+// Distribution API, for the registries that don't have the ACR endpoint.
+func (c *AcrCLIClient) AcrListRepositoriesV2(ctx context.Context, last string) (*RepositoryList, error) {
+	return c.listRepositories(ctx, last, true)
+}
+
+func (c *AcrCLIClient) listRepositories(ctx context.Context, last string, v2 bool) (*RepositoryList, error) {
+	var result *RepositoryList
+	err := c.withAuthorization(ctx, CatalogScope, func(auth string) error {
+		var err error
+		result, err = c.acrListRepositories(ctx, auth, last, v2)
+		return err
+	})
+	return result, err
+}
+
+func (c *AcrCLIClient) acrListRepositories(ctx context.Context, auth string, last string, v2 bool) (*RepositoryList, error) {
+	hostname := LoginURLWithPrefix(c.loginURL)
+	client := newBaseClient(c.autorestClient, hostname,
+		"",
+		"",
+		"",
+		"",
+		"",
+		auth,
+		"",
+		"100",
+		last,
+		"")
+	list := client.AcrListRepositories
+	if v2 {
+		list = client.ListRepositories
+	}
+	repositories, err := list(ctx)
+	if err != nil {
+		return nil, fromAutorestError(err)
+	}
+	switch repositories.StatusCode {
+	case http.StatusOK:
+		var result RepositoryList
+		if err = mapstructure.Decode(repositories.Value, &result); err != nil {
+			return nil, err
+		}
+		return &result, nil
+
+	case http.StatusBadRequest, http.StatusUnauthorized:
+		return nil, newRegistryError(repositories.StatusCode, repositories.Value)
+
+	default:
+		return nil, &RegistryError{StatusCode: repositories.StatusCode}
+	}
+}
+
+// AcrGetRepositoryAttributes returns the attributes of a repository, the registry answers with a 404 registry error
+// when the repository doesn't exist.
+func (c *AcrCLIClient) AcrGetRepositoryAttributes(ctx context.Context, repoName string) (*acrapi.RepositoryAttributes, error) {
+	var result *acrapi.RepositoryAttributes
+	err := c.withAuthorization(ctx, RepositoryScope(repoName), func(auth string) error {
+		var err error
+		result, err = c.acrGetRepositoryAttributes(ctx, auth, repoName)
+		return err
+	})
+	return result, err
+}
+
+func (c *AcrCLIClient) acrGetRepositoryAttributes(ctx context.Context, auth string, repoName string) (*acrapi.RepositoryAttributes, error) {
+	hostname := LoginURLWithPrefix(c.loginURL)
+	client := newBaseClient(c.autorestClient, hostname,
+		repoName,
+		"",
+		"",
+		"",
+		"",
+		auth,
+		"",
+		"",
+		"",
+		"")
+	attributes, err := client.AcrGetRepositoryAttributes(ctx)
+	if err != nil {
+		return nil, fromAutorestError(err)
+	}
+	switch attributes.StatusCode {
+	case http.StatusOK:
+		var result acrapi.RepositoryAttributes
+		if err = mapstructure.Decode(attributes.Value, &result); err != nil {
+			return nil, err
+		}
+		return &result, nil
+
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound:
+		return nil, newRegistryError(attributes.StatusCode, attributes.Value)
+
+	default:
+		return nil, &RegistryError{StatusCode: attributes.StatusCode}
+	}
+}
+
+// AcrGetRepositoryMetadata returns the value stored under key in the metadata of a repository, encoded in JSON. The
+// registry answers with a 404 registry error when the repository or the key doesn't exist.
+func (c *AcrCLIClient) AcrGetRepositoryMetadata(ctx context.Context, repoName string, key string) ([]byte, error) {
+	var result []byte
+	err := c.withAuthorization(ctx, RepositoryScope(repoName), func(auth string) error {
+		var err error
+		result, err = c.acrGetRepositoryMetadata(ctx, auth, repoName, key)
+		return err
+	})
+	return result, err
+}
+
+func (c *AcrCLIClient) acrGetRepositoryMetadata(ctx context.Context, auth string, repoName string, key string) ([]byte, error) {
+	hostname := LoginURLWithPrefix(c.loginURL)
+	client := newBaseClient(c.autorestClient, hostname,
+		repoName,
+		"",
+		"",
+		key,
+		"",
+		auth,
+		"",
+		"",
+		"",
+		"")
+	metadata, err := client.AcrGetRepositoryMetadata(ctx)
+	if err != nil {
+		return nil, fromAutorestError(err)
+	}
+	switch metadata.StatusCode {
+	case http.StatusOK:
+		return json.Marshal(metadata.Value)
+
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound:
+		return nil, newRegistryError(metadata.StatusCode, metadata.Value)
+
+	default:
+		return nil, &RegistryError{StatusCode: metadata.StatusCode}
+	}
+}
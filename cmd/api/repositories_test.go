@@ -0,0 +1,137 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AzureCR/acr-cli/cmd/api/acrtest"
+)
+
+func TestAcrListRepositories(t *testing.T) {
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	registry.AddImage("hello-world", time.Now(), "latest")
+	registry.AddImage("nginx", time.Now(), "latest")
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	acrClient := NewAcrCLIClient(registry.LoginURL(), "Basic auth", httpClient)
+	repositories, err := acrClient.AcrListRepositories(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(repositories.Repositories, []string{"hello-world", "nginx"}) {
+		t.Fatalf("repositories incorrect, got %v", repositories.Repositories)
+	}
+	if repositories, err = acrClient.AcrListRepositories(context.Background(), "nginx"); err != nil || len(repositories.Repositories) != 0 {
+		t.Fatalf("expected the last page to be empty, got %v and %v", repositories, err)
+	}
+
+	// A client whose credentials were never accepted gets the registry error, not an AuthenticationExpiredError.
+	registry.Fail(http.MethodGet, "/acr/v1/_catalog", http.StatusUnauthorized)
+	_, err = NewAcrCLIClient(registry.LoginURL(), "Basic wrong", httpClient).AcrListRepositories(context.Background(), "")
+	if registryError, ok := err.(*RegistryError); !ok || !registryError.IsUnauthorized() {
+		t.Fatalf("expected a 401 registry error, got %v", err)
+	}
+}
+
+func TestAcrGetRepositoryAttributes(t *testing.T) {
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	registry.AddImage("hello-world", time.Now(), "v1", "v2")
+	registry.AddImage("hello-world", time.Now())
+	registry.AddImage("hello-world", time.Now())
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	acrClient := NewAcrCLIClient(registry.LoginURL(), "Basic auth", httpClient)
+	attributes, err := acrClient.AcrGetRepositoryAttributes(context.Background(), "hello-world")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if attributes.ImageName == nil || *attributes.ImageName != "hello-world" || attributes.TagCount == nil || *attributes.TagCount != 2 ||
+		attributes.ManifestCount == nil || *attributes.ManifestCount != 3 {
+		t.Fatalf("attributes incorrect, got %+v", attributes)
+	}
+
+	_, err = acrClient.AcrGetRepositoryAttributes(context.Background(), "missing")
+	if registryError, ok := err.(*RegistryError); !ok || registryError.StatusCode != http.StatusNotFound || registryError.Code != "NAME_UNKNOWN" {
+		t.Fatalf("expected a 404 registry error, got %v", err)
+	}
+}
+
+func TestAcrListRepositoriesV2(t *testing.T) {
+	catalog := []string{"a", "b", "c", "d", "e"}
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	for _, repoName := range catalog {
+		registry.AddImage(repoName, time.Now(), "latest")
+	}
+	// A small page size, like a registry that caps n, so the pagination is exercised.
+	registry.SetMaxPageSize(2)
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	acrClient := NewAcrCLIClient(registry.LoginURL(), "Basic auth", httpClient)
+
+	registry.Fail(http.MethodGet, "/acr/v1/_catalog", http.StatusNotFound)
+	if _, err := acrClient.AcrListRepositories(context.Background(), ""); err == nil || err.(*RegistryError).StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the ACR catalog to be missing, got %v", err)
+	}
+	var repositories []string
+	last := ""
+	for {
+		page, err := acrClient.AcrListRepositoriesV2(context.Background(), last)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if len(page.Repositories) == 0 {
+			break
+		}
+		repositories = append(repositories, page.Repositories...)
+		last = page.Repositories[len(page.Repositories)-1]
+	}
+	if !reflect.DeepEqual(repositories, catalog) {
+		t.Fatalf("repositories incorrect, got %v, expected %v", repositories, catalog)
+	}
+	if requests := registry.Requests(http.MethodGet, "/v2/_catalog"); requests != 4 {
+		t.Fatalf("expected 4 pages of the catalog, got %d", requests)
+	}
+}
+
+func TestAcrGetRepositoryMetadata(t *testing.T) {
+	registry := acrtest.NewRegistry()
+	defer registry.Close()
+	registry.AddImage("team/repo", time.Now(), "v1")
+	registry.SetMetadata("team/repo", "policy", `{"ago":"30d","keep":5}`)
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	acrClient := NewAcrCLIClient(registry.LoginURL(), BasicAuth("user", "password"), httpClient)
+
+	value, err := acrClient.AcrGetRepositoryMetadata(context.Background(), "team/repo", "policy")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(value, &decoded); err != nil || !reflect.DeepEqual(decoded, map[string]interface{}{"ago": "30d", "keep": float64(5)}) {
+		t.Fatalf("metadata incorrect, got %s and %v", value, err)
+	}
+	_, err = acrClient.AcrGetRepositoryMetadata(context.Background(), "team/repo", "missing")
+	if registryError, ok := err.(*RegistryError); !ok || registryError.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a 404 registry error, got %v", err)
+	}
+}
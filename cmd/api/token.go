@@ -0,0 +1,218 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RepositoryScope returns the token scope needed to list and delete the tags and manifests of repoName.
+func RepositoryScope(repoName string) string {
+	return fmt.Sprintf("repository:%s:pull,delete,metadata_read", repoName)
+}
+
+// PushScope returns the token scope needed to push manifests to repoName, mounting the blobs of sourceRepoName when
+// it's another repository.
+func PushScope(repoName string, sourceRepoName string) string {
+	scope := fmt.Sprintf("repository:%s:pull,push", repoName)
+	if len(sourceRepoName) > 0 && sourceRepoName != repoName {
+		scope += fmt.Sprintf(" repository:%s:pull", sourceRepoName)
+	}
+	return scope
+}
+
+// CatalogScope is the token scope needed to list the repositories of a registry.
+const CatalogScope = "registry:catalog:*"
+
+// TokenCredential obtains scoped registry access tokens by exchanging an AAD access token for an ACR refresh token,
+// the access tokens are cached and refreshed before they expire. It's safe to use from concurrent goroutines.
+type TokenCredential struct {
+	loginURL   string
+	tenant     string
+	aadToken   string
+	httpClient *http.Client
+	cache      *tokenCache
+
+	mu           sync.Mutex
+	refreshToken string
+}
+
+// NewTokenCredential creates the credential for the registry identified by loginURL, tenant can be empty when the
+// AAD token was issued by the tenant of the registry. The requests are sent with httpClient, http.DefaultClient is
+// used when it's nil.
+func NewTokenCredential(loginURL string, tenant string, aadToken string, httpClient *http.Client) *TokenCredential {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &TokenCredential{
+		loginURL:   loginURL,
+		tenant:     tenant,
+		aadToken:   aadToken,
+		httpClient: httpClient,
+		cache:      newTokenCache(),
+	}
+}
+
+// ExchangeAADToken exchanges the AAD token for an ACR refresh token through /oauth2/exchange.
+func (c *TokenCredential) ExchangeAADToken(ctx context.Context) (string, error) {
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {c.loginURL},
+		"access_token": {c.aadToken},
+	}
+	if len(c.tenant) > 0 {
+		form.Set("tenant", c.tenant)
+	}
+	var result struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.postForm(ctx, "/oauth2/exchange", form, &result); err != nil {
+		return "", errors.Wrap(err, "unable to exchange the AAD token")
+	}
+	if len(result.RefreshToken) == 0 {
+		return "", errors.New("the registry didn't return a refresh token")
+	}
+	return result.RefreshToken, nil
+}
+
+// GetAccessToken returns an access token for scope, the AAD token is exchanged the first time and again when the
+// refresh token is rejected.
+func (c *TokenCredential) GetAccessToken(ctx context.Context, scope string) (string, error) {
+	return c.cache.get(scope, func() (string, time.Time, error) {
+		refreshToken, err := c.getRefreshToken(ctx, "")
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		token, err := c.fetchAccessToken(ctx, scope, refreshToken)
+		if registryError, ok := errors.Cause(err).(*RegistryError); ok && registryError.IsUnauthorized() {
+			// The refresh token expired or was revoked, a new one is needed.
+			if refreshToken, err = c.getRefreshToken(ctx, refreshToken); err != nil {
+				return "", time.Time{}, err
+			}
+			token, err = c.fetchAccessToken(ctx, scope, refreshToken)
+		}
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return token, tokenExpiry(token), nil
+	})
+}
+
+// getRefreshToken returns the refresh token, exchanging the AAD token when there's none yet or when the current one
+// is rejected, unless a concurrent caller already replaced it.
+func (c *TokenCredential) getRefreshToken(ctx context.Context, rejected string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.refreshToken) > 0 && c.refreshToken != rejected {
+		return c.refreshToken, nil
+	}
+	refreshToken, err := c.ExchangeAADToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.refreshToken = refreshToken
+	return refreshToken, nil
+}
+
+// fetchAccessToken gets an access token for scope through /oauth2/token.
+func (c *TokenCredential) fetchAccessToken(ctx context.Context, scope string, refreshToken string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"service":       {c.loginURL},
+		"scope":         {scope},
+		"refresh_token": {refreshToken},
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := c.postForm(ctx, "/oauth2/token", form, &result); err != nil {
+		return "", errors.Wrapf(err, "unable to get an access token for %s", scope)
+	}
+	if len(result.AccessToken) == 0 {
+		return "", errors.New("the registry didn't return an access token")
+	}
+	return result.AccessToken, nil
+}
+
+// invalidate drops the cached token of scope after the registry rejected it.
+func (c *TokenCredential) invalidate(scope string, token string) {
+	c.cache.invalidate(scope, token)
+}
+
+// postForm posts form to the oauth2 endpoint at path and decodes the JSON answer into result.
+func (c *TokenCredential) postForm(ctx context.Context, path string, form url.Values, result interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, LoginURLWithPrefix(c.loginURL)+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var value interface{}
+		json.NewDecoder(resp.Body).Decode(&value)
+		return newRegistryError(resp.StatusCode, value)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// tokenExpiry reads the expiry of a JWT access token, a token that can't be decoded is considered expired so it's
+// only used once.
+func tokenExpiry(token string) time.Time {
+	var claims struct {
+		Expiry int64 `json:"exp"`
+	}
+	if !decodeClaims(token, &claims) || claims.Expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Expiry, 0)
+}
+
+// grantedActions reads the actions a JWT access token grants on the resource of type resourceType named name, like
+// the pull and delete actions on a repository. It returns false when the token doesn't list what it grants.
+func grantedActions(token string, resourceType string, name string) ([]string, bool) {
+	var claims struct {
+		Access []struct {
+			Type    string   `json:"type"`
+			Name    string   `json:"name"`
+			Actions []string `json:"actions"`
+		} `json:"access"`
+	}
+	if !decodeClaims(token, &claims) || claims.Access == nil {
+		return nil, false
+	}
+	var actions []string
+	for _, access := range claims.Access {
+		if access.Type == resourceType && access.Name == name {
+			actions = append(actions, access.Actions...)
+		}
+	}
+	return actions, true
+}
+
+// decodeClaims decodes the payload of a JWT into claims, without checking its signature.
+func decodeClaims(token string, claims interface{}) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(payload, claims) == nil
+}
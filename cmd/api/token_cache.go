@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenRefreshWindow is how long before their expiry cached access tokens are replaced, a token that can't be
+// replaced is still used until tokenExpiryMargin before it expires.
+const tokenRefreshWindow = 5 * time.Minute
+
+// tokenExpiryMargin is how long before their expiry access tokens stop being used, so a token doesn't expire while
+// a request is in flight.
+const tokenExpiryMargin = 30 * time.Second
+
+// tokenCache holds the access tokens of each scope, it's shared by the concurrent workers. Concurrent requests for
+// the same scope wait for a single fetch while the other scopes aren't blocked.
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]*tokenCacheEntry
+	now     func() time.Time
+}
+
+type tokenCacheEntry struct {
+	mu        sync.Mutex
+	token     string
+	expiresOn time.Time
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{entries: map[string]*tokenCacheEntry{}, now: time.Now}
+}
+
+// get returns the cached token of scope, fetch is called when there's none or it expires within tokenRefreshWindow.
+func (c *tokenCache) get(scope string, fetch func() (string, time.Time, error)) (string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[scope]
+	if !ok {
+		entry = &tokenCacheEntry{}
+		c.entries[scope] = entry
+	}
+	c.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	now := c.now()
+	if len(entry.token) > 0 && now.Add(tokenRefreshWindow).Before(entry.expiresOn) {
+		return entry.token, nil
+	}
+	token, expiresOn, err := fetch()
+	if err != nil {
+		if len(entry.token) > 0 && now.Add(tokenExpiryMargin).Before(entry.expiresOn) {
+			return entry.token, nil
+		}
+		return "", err
+	}
+	entry.token, entry.expiresOn = token, expiresOn
+	return token, nil
+}
+
+// invalidate drops the cached token of scope when it's still token, the registry rejected it.
+func (c *tokenCache) invalidate(scope string, token string) {
+	c.mu.Lock()
+	entry, ok := c.entries[scope]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.token == token {
+		entry.token, entry.expiresOn = "", time.Time{}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenCacheSingleFetch(t *testing.T) {
+	cache := newTokenCache()
+	var mu sync.Mutex
+	fetches := 0
+	fetch := func() (string, time.Time, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		time.Sleep(10 * time.Millisecond)
+		return "token", time.Now().Add(time.Hour), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := cache.get("scope", fetch); err != nil || token != "token" {
+				t.Errorf("unexpected token %q, error %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 1 {
+		t.Fatalf("expected a single fetch, got %d", fetches)
+	}
+}
+
+func TestTokenCacheRefresh(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	cache := newTokenCache()
+	cache.now = func() time.Time { return now }
+	fetches := 0
+	var fetchErr error
+	fetch := func() (string, time.Time, error) {
+		if fetchErr != nil {
+			return "", time.Time{}, fetchErr
+		}
+		fetches++
+		return "token", now.Add(time.Hour), nil
+	}
+	get := func(expected int) {
+		if _, err := cache.get("scope", fetch); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if fetches != expected {
+			t.Fatalf("expected %d fetches, got %d", expected, fetches)
+		}
+	}
+	get(1)
+	now = now.Add(50 * time.Minute)
+	get(1)
+	// Within the refresh window the token is replaced before it expires.
+	now = now.Add(6 * time.Minute)
+	get(2)
+
+	// A token that can't be replaced is used until it's about to expire.
+	fetchErr = errors.New("unavailable")
+	now = now.Add(57 * time.Minute)
+	if token, err := cache.get("scope", fetch); err != nil || token != "token" {
+		t.Fatalf("expected the cached token, got %q and %v", token, err)
+	}
+	now = now.Add(3 * time.Minute)
+	if _, err := cache.get("scope", fetch); err != fetchErr {
+		t.Fatalf("expected the fetch error, got %v", err)
+	}
+
+	fetchErr = nil
+	cache.invalidate("scope", "other")
+	get(3)
+	cache.invalidate("scope", "token")
+	get(4)
+}
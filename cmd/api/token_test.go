@@ -0,0 +1,143 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testJWT returns an unsigned JWT expiring at expiry, the subject makes it unique.
+func testJWT(subject string, expiry time.Time) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	payload := fmt.Sprintf(`{"sub":%q,"exp":%d}`, subject, expiry.Unix())
+	return encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(payload)) + "."
+}
+
+// oauthRegistry is a stub registry with the oauth2 endpoints, it issues access tokens valid for tokenLifetime and
+// only accepts them for the repository of their scope.
+type oauthRegistry struct {
+	mu            sync.Mutex
+	tokenLifetime time.Duration
+	exchanges     int
+	tokens        int
+	scopes        map[string]string
+}
+
+func (r *oauthRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch req.URL.Path {
+	case "/oauth2/exchange":
+		if req.FormValue("grant_type") != "access_token" || req.FormValue("access_token") != "aad-token" || req.FormValue("tenant") != "tenant" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.exchanges++
+		fmt.Fprintf(w, `{"refresh_token":"refresh-%d"}`, r.exchanges)
+	case "/oauth2/token":
+		if req.FormValue("grant_type") != "refresh_token" || req.FormValue("refresh_token") != fmt.Sprintf("refresh-%d", r.exchanges) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.tokens++
+		token := testJWT(fmt.Sprintf("token-%d", r.tokens), time.Now().Add(r.tokenLifetime))
+		r.scopes[token] = req.FormValue("scope")
+		fmt.Fprintf(w, `{"access_token":%q}`, token)
+	default:
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		repoName := strings.Split(strings.TrimPrefix(req.URL.Path, "/acr/v1/"), "/")[0]
+		if r.scopes[token] != RepositoryScope(repoName) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func TestTokenCredential(t *testing.T) {
+	registry := &oauthRegistry{tokenLifetime: time.Hour, scopes: map[string]string{}}
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	loginURL := strings.TrimPrefix(server.URL, "https://")
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	acrClient := NewAcrCLIClient(loginURL, "", httpClient)
+	acrClient.SetTokenCredential(NewTokenCredential(loginURL, "tenant", "aad-token", httpClient))
+	for _, repoName := range []string{"repo1", "repo1", "repo2", "repo1"} {
+		if err := acrClient.AcrDeleteTag(context.Background(), repoName, "latest"); err != nil {
+			t.Fatalf("deleting from %s failed: %v", repoName, err)
+		}
+	}
+	if registry.exchanges != 1 || registry.tokens != 2 {
+		t.Fatalf("expected 1 exchange and 2 access tokens, got %d and %d", registry.exchanges, registry.tokens)
+	}
+
+	_, err = NewTokenCredential(loginURL, "other", "aad-token", httpClient).GetAccessToken(context.Background(), RepositoryScope("repo1"))
+	if err == nil || !strings.Contains(err.Error(), "unable to exchange the AAD token") {
+		t.Fatalf("expected the exchange to fail, got %v", err)
+	}
+}
+
+func TestTokenCredentialReuse(t *testing.T) {
+	registry := &oauthRegistry{tokenLifetime: time.Hour, scopes: map[string]string{}}
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	loginURL := strings.TrimPrefix(server.URL, "https://")
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	acrClient := NewAcrCLIClient(loginURL, "", httpClient)
+	acrClient.SetTokenCredential(NewTokenCredential(loginURL, "tenant", "aad-token", httpClient))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := acrClient.AcrDeleteTag(context.Background(), "repo1", "latest"); err != nil {
+				t.Errorf("deleting failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if registry.exchanges != 1 || registry.tokens != 1 {
+		t.Fatalf("expected 1 exchange and 1 access token, got %d and %d", registry.exchanges, registry.tokens)
+	}
+
+	// Access tokens about to expire are refreshed, a rejected refresh token is replaced through a new exchange.
+	registry.tokenLifetime = time.Minute
+	registry.exchanges++
+	for i := 0; i < 2; i++ {
+		if err := acrClient.AcrDeleteTag(context.Background(), "repo2", "latest"); err != nil {
+			t.Fatalf("deleting failed: %v", err)
+		}
+	}
+	if registry.exchanges != 3 || registry.tokens != 3 {
+		t.Fatalf("expected 3 exchanges and 3 access tokens, got %d and %d", registry.exchanges, registry.tokens)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	expiry := time.Unix(1600000000, 0)
+	if got := tokenExpiry(testJWT("token", expiry)); !got.Equal(expiry) {
+		t.Fatalf("tokenExpiry incorrect, got %v, expected %v", got, expiry)
+	}
+	for _, token := range []string{"", "opaque", "a.b.c", testJWT("token", time.Unix(0, 0))} {
+		if got := tokenExpiry(token); !got.IsZero() {
+			t.Fatalf("tokenExpiry(%q) should be zero, got %v", token, got)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TransportOptions configure the HTTP client used to talk to the registry.
+type TransportOptions struct {
+	// Insecure skips the verification of the registry certificate, it's only meant for test registries.
+	Insecure bool
+	// CACertFile is a PEM file with certificate authorities trusted in addition to the system ones.
+	CACertFile string
+	// Proxy is the URL of an http, https or socks5 proxy for every request, when empty the proxy comes from the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	Proxy string
+	// UserAgent replaces the User-Agent header of every request when it's not empty.
+	UserAgent string
+}
+
+// NewHTTPClient creates the HTTP client for options, its transport has the same settings as http.DefaultTransport.
+func NewHTTPClient(options TransportOptions) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: options.Insecure,
+	}
+	if len(options.CACertFile) > 0 {
+		pemCerts, err := ioutil.ReadFile(options.CACertFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read the CA certificates")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", options.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	proxy := http.ProxyFromEnvironment
+	if len(options.Proxy) > 0 {
+		proxyURL, err := url.Parse(options.Proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid proxy URL")
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("invalid proxy URL %q, the scheme must be http, https or socks5", options.Proxy)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	if len(options.UserAgent) > 0 {
+		return &http.Client{Transport: &userAgentTransport{base: transport, userAgent: options.UserAgent}}, nil
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// userAgentTransport sets the User-Agent header of the requests, the generated client sets its own before sending
+// them so it can't be done with a preparer.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+// RoundTrip sends a copy of req with the User-Agent header replaced, a RoundTripper must not modify the request.
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	withUserAgent := new(http.Request)
+	*withUserAgent = *req
+	withUserAgent.Header = make(http.Header, len(req.Header)+1)
+	for key, values := range req.Header {
+		withUserAgent.Header[key] = values
+	}
+	withUserAgent.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(withUserAgent)
+}
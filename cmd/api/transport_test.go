@@ -0,0 +1,167 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package api
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestRegistry starts a TLS server answering the tag listing of repo with a single tag.
+func newTestRegistry(t *testing.T) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/acr/v1/repo/_tags" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"imageName":"repo","tags":[{"name":"latest","digest":"sha256:a1","lastUpdateTime":"2020-01-01T00:00:00Z"}]}`))
+	}))
+}
+
+func TestNewHTTPClientTLS(t *testing.T) {
+	server := newTestRegistry(t)
+	defer server.Close()
+	loginURL := strings.TrimPrefix(server.URL, "https://")
+
+	dir, err := ioutil.TempDir("", "acr-transport")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	caCertFile := filepath.Join(dir, "ca.pem")
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caCertFile, caCert, 0600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	invalidCertFile := filepath.Join(dir, "invalid.pem")
+	if err := ioutil.WriteFile(invalidCertFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		options TransportOptions
+		succeed bool
+	}{
+		{"system roots", TransportOptions{}, false},
+		{"custom CA", TransportOptions{CACertFile: caCertFile}, true},
+		{"insecure", TransportOptions{Insecure: true}, true},
+	}
+	for _, test := range tests {
+		httpClient, err := NewHTTPClient(test.options)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", test.name, err)
+		}
+		tags, err := NewAcrCLIClient(loginURL, "Basic auth", httpClient).AcrListTags(context.Background(), "repo", "", "")
+		if test.succeed && (err != nil || tags.Tags == nil || *(*tags.Tags)[0].Name != "latest") {
+			t.Fatalf("%s: listing tags failed, got %v", test.name, err)
+		}
+		if !test.succeed && err == nil {
+			t.Fatalf("%s: the certificate of the test server shouldn't be trusted", test.name)
+		}
+	}
+
+	for _, file := range []string{invalidCertFile, filepath.Join(dir, "missing.pem")} {
+		if _, err := NewHTTPClient(TransportOptions{CACertFile: file}); err == nil {
+			t.Fatalf("NewHTTPClient with %s should fail", file)
+		}
+	}
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	server := newTestRegistry(t)
+	defer server.Close()
+	loginURL := strings.TrimPrefix(server.URL, "https://")
+
+	// The stub proxy tunnels the CONNECT requests to the test registry and remembers their targets.
+	var mu sync.Mutex
+	var tunneled []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		mu.Lock()
+		tunneled = append(tunneled, r.Host)
+		mu.Unlock()
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	defer proxy.Close()
+
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true, Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := NewAcrCLIClient(loginURL, "Basic auth", httpClient).AcrListTags(context.Background(), "repo", "", ""); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(tunneled) != 1 || tunneled[0] != loginURL {
+		t.Fatalf("the request didn't go through the proxy, tunneled %v", tunneled)
+	}
+
+	for _, proxyURL := range []string{"ftp://proxy:21", "://proxy"} {
+		if _, err := NewHTTPClient(TransportOptions{Proxy: proxyURL}); err == nil {
+			t.Fatalf("NewHTTPClient with proxy %q should fail", proxyURL)
+		}
+	}
+}
+
+func TestNewHTTPClientUserAgent(t *testing.T) {
+	var mu sync.Mutex
+	var userAgents []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		userAgents = append(userAgents, r.UserAgent())
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	loginURL := strings.TrimPrefix(server.URL, "https://")
+
+	httpClient, err := NewHTTPClient(TransportOptions{Insecure: true, UserAgent: "acr-cli/1.2.3"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	acrClient := NewAcrCLIClient(loginURL, "Basic auth", httpClient)
+	if err := acrClient.AcrDeleteTag(context.Background(), "repo", "latest"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := acrClient.DeleteManifest(context.Background(), "repo", "sha256:a1"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(userAgents) != 2 || userAgents[0] != "acr-cli/1.2.3" || userAgents[1] != "acr-cli/1.2.3" {
+		t.Fatalf("User-Agent incorrect, got %v", userAgents)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package table
+
+import (
+	"fmt"
+	"time"
+)
+
+// HumanDuration returns how long ago t was, like "3 days ago", or how long until t for a time in the future, like
+// "in 2 hours". It's empty for the zero time.
+func HumanDuration(t time.Time) string {
+	return humanDuration(t, time.Now())
+}
+
+func humanDuration(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	elapsed := now.Sub(t)
+	format := "%s ago"
+	if elapsed < 0 {
+		elapsed = -elapsed
+		format = "in %s"
+	}
+	if elapsed < time.Second {
+		return "just now"
+	}
+	units := []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+		{"second", time.Second},
+	}
+	for _, unit := range units {
+		if count := int64(elapsed / unit.duration); count > 0 {
+			return fmt.Sprintf(format, plural(count, unit.name))
+		}
+	}
+	return "just now"
+}
+
+func plural(count int64, unit string) string {
+	if count == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", count, unit)
+}
+
+// HumanSize returns a number of bytes with binary units, like "512 B" or "1.4 GiB".
+func HumanSize(n int64) string {
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	prefixes := "KMGTPE"
+	i := -1
+	for (value >= unit || value <= -unit) && i < len(prefixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, prefixes[i])
+}
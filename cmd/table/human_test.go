@@ -0,0 +1,56 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package table
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHumanDuration(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		t        time.Time
+		expected string
+	}{
+		{time.Time{}, ""},
+		{now, "just now"},
+		{now.Add(-500 * time.Millisecond), "just now"},
+		{now.Add(-time.Second), "1 second ago"},
+		{now.Add(-59 * time.Second), "59 seconds ago"},
+		{now.Add(-time.Minute), "1 minute ago"},
+		{now.Add(-3*time.Hour - 59*time.Minute), "3 hours ago"},
+		{now.Add(-72 * time.Hour), "3 days ago"},
+		{now.Add(-45 * 24 * time.Hour), "1 month ago"},
+		{now.Add(-800 * 24 * time.Hour), "2 years ago"},
+		{now.Add(2 * time.Hour), "in 2 hours"},
+		{now.Add(30 * time.Second), "in 30 seconds"},
+	}
+	for _, test := range tests {
+		if got := humanDuration(test.t, now); got != test.expected {
+			t.Fatalf("humanDuration(%v) incorrect, got %q, expected %q", test.t, got, test.expected)
+		}
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		n        int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{1503238554, "1.4 GiB"},
+		{3 << 40, "3.0 TiB"},
+		{1 << 62, "4.0 EiB"},
+		{-2048, "-2.0 KiB"},
+	}
+	for _, test := range tests {
+		if got := HumanSize(test.n); got != test.expected {
+			t.Fatalf("HumanSize(%d) incorrect, got %q, expected %q", test.n, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+// Package table renders rows in aligned columns for the interactive outputs of the commands.
+package table
+
+import (
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// shortDigestLength is the number of characters of the encoded part kept by ShortDigest.
+const shortDigestLength = 12
+
+// Table is a list of rows with a header, the columns are aligned when it's written.
+type Table struct {
+	headers []string
+	rows    [][]string
+}
+
+// New returns an empty table with the given column headers.
+func New(headers ...string) *Table {
+	return &Table{headers: headers}
+}
+
+// AddRow adds a row, an empty cell is written as "-".
+func (t *Table) AddRow(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// Write writes the header and the rows to w with the columns separated by at least 3 spaces.
+func (t *Table) Write(w io.Writer) error {
+	writer := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	writeRow(writer, t.headers)
+	for _, row := range t.rows {
+		writeRow(writer, row)
+	}
+	return writer.Flush()
+}
+
+func writeRow(w io.Writer, cells []string) {
+	line := make([]string, len(cells))
+	for i, cell := range cells {
+		if len(cell) == 0 {
+			cell = "-"
+		}
+		// Tabs and line breaks would break the alignment.
+		line[i] = strings.Join(strings.Fields(cell), " ")
+	}
+	io.WriteString(w, strings.Join(line, "\t")+"\n")
+}
+
+// ShortDigest returns digest with its encoded part truncated to 12 characters, like sha256:2c26b46b68ff, unless
+// noTrunc is set.
+func ShortDigest(digest string, noTrunc bool) string {
+	separator := strings.Index(digest, ":")
+	if noTrunc || separator < 0 || len(digest)-separator-1 <= shortDigestLength {
+		return digest
+	}
+	return digest[:separator+1+shortDigestLength]
+}
@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License.
+
+package table
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	table := New("TAG", "DIGEST", "REASON")
+	table.AddRow("latest", ShortDigest("sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", false), "")
+	table.AddRow("v1.0.0-rc1", "sha256:fcde2b2edba5", "line\nbreak\tand  tab")
+	var out bytes.Buffer
+	if err := table.Write(&out); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := "" +
+		"TAG          DIGEST                REASON\n" +
+		"latest       sha256:2c26b46b68ff   -\n" +
+		"v1.0.0-rc1   sha256:fcde2b2edba5   line break and tab\n"
+	if out.String() != expected {
+		t.Fatalf("table incorrect, got\n%s\nexpected\n%s", out.String(), expected)
+	}
+}
+
+func TestShortDigest(t *testing.T) {
+	digest := "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	tests := []struct {
+		digest   string
+		noTrunc  bool
+		expected string
+	}{
+		{digest, false, "sha256:2c26b46b68ff"},
+		{digest, true, digest},
+		{"sha256:2c26", false, "sha256:2c26"},
+		{"", false, ""},
+		{"not-a-digest", false, "not-a-digest"},
+	}
+	for _, test := range tests {
+		if got := ShortDigest(test.digest, test.noTrunc); got != test.expected {
+			t.Fatalf("ShortDigest(%q, %v) incorrect, got %q, expected %q", test.digest, test.noTrunc, got, test.expected)
+		}
+	}
+}
@@ -7,4 +7,6 @@ require (
 	github.com/mitchellh/mapstructure v1.1.2
 	github.com/pkg/errors v0.8.0
 	github.com/spf13/cobra v0.0.5
+	github.com/spf13/pflag v1.0.3
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -9,4 +9,7 @@ var (
 
 	// Revision is filled with the VCS revision. Filled in at linking time.
 	Revision = ""
+
+	// BuildDate is the UTC time of the build in RFC 3339. Filled in at linking time.
+	BuildDate = ""
 )